@@ -30,14 +30,66 @@ type ListenerConfig struct {
 	Kind    string
 	TLSCert string `json:"tls_cert"`
 	TLSKey  string `json:"tls_key"`
+	// TLSClientCA PEM file of CAs that issue client certificates. Listener requests client certificates
+	// and verifies given ones, so they can be used by mtls authn.
+	TLSClientCA string `json:"tls_client_ca"`
+
+	// Hosts virtual host names served by service, when several services share Address.
+	// Service without Hosts receives connections that did not match any other service.
+	Hosts []string
 }
 
 // HTTPConfig configuration for HTTP protocol
 type HTTPConfig struct {
-	Kind    string
-	Authn   *Parameters
-	Authz   *Parameters
-	LogBody bool
+	Kind string
+	// KindParams parameters of operation resolver of Kind
+	KindParams map[string]interface{}
+	// Errors format of errors generated by proxy: json, soap, problem, grpc or graphql.
+	// Defaults to format of Kind, or json for kinds without own format.
+	Errors string
+	// PublicURL of service as seen by clients, used in WSDL documents of soap service.
+	// Defaults to scheme and host of request, set it behind caches or TLS terminators.
+	PublicURL string
+
+	Authn *Parameters
+	// AuthnChain authentication methods in order of precedence, replaces Authn. Request is authenticated
+	// by the first method whose credentials it carries.
+	AuthnChain []Parameters
+	// AnonymousOperations operations allowed without credentials
+	AnonymousOperations []string
+	Authz               *Parameters
+	LogBody             bool
+
+	// LogBodyLimit max number of bytes logged from beginning of request and response bodies
+	LogBodyLimit int
+	// FlushInterval of response body to client while streaming, negative value flushes after each write.
+	// Responses with text/event-stream content type are flushed immediately.
+	FlushInterval time.Duration
+	// IdleTimeout max time without reading data from client or upstream, once request has started
+	IdleTimeout time.Duration
+
+	// HTTP2 protocol settings towards clients and upstream service
+	HTTP2         HTTP2Config
+	UpstreamHTTP2 HTTP2Config
+
+	WebSocket WebSocketConfig
+
+	// Token endpoint, that exchanges credentials of authn for short-lived proxy tokens
+	Token TokenConfig
+}
+
+// HTTP2Config configuration of HTTP/2 protocol
+type HTTP2Config struct {
+	// Enabled allows HTTP/2 over TLS, negotiated with ALPN
+	Enabled bool
+	// Cleartext allows HTTP/2 over plain TCP with prior knowledge (h2c)
+	Cleartext bool
+
+	// MaxConcurrentStreams limits number of streams client may open on connection
+	MaxConcurrentStreams int
+	// ConnectionWindow and StreamWindow set size of flow-control windows for received data
+	ConnectionWindow int
+	StreamWindow     int
 }
 
 // Parameters of config
@@ -45,3 +97,32 @@ type Parameters struct {
 	Kind   string
 	Params map[string]interface{}
 }
+
+// WebSocketConfig configuration of WebSocket proxying
+type WebSocketConfig struct {
+	// Enabled relays WebSocket connections, after upgrade request passes middlewares
+	Enabled bool
+
+	// LogMessages logs every message, with up to LogMessageLimit bytes of payload
+	LogMessages     bool
+	LogMessageLimit int
+
+	// IdleTimeout closes connection, when no messages were relayed in either direction
+	IdleTimeout time.Duration
+	// PingInterval of pings sent to client, connection is closed if pong is not received within PongTimeout
+	PingInterval time.Duration
+	PongTimeout  time.Duration
+}
+
+// TokenConfig configuration of token endpoint
+type TokenConfig struct {
+	// Enabled serves token endpoint and accepts issued tokens as Bearer credentials
+	Enabled bool
+	// Path of token endpoint, defaults to /.nprxy/token
+	Path string
+	// TTL lifetime of issued tokens, defaults to 15m
+	TTL time.Duration
+	// Secrets path of file with secrets that sign tokens. Secret is generated at startup if empty,
+	// so tokens are accepted only by the same process.
+	Secrets string
+}
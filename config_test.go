@@ -73,6 +73,16 @@ services:
       kind: api-key
       params:
         path: example-keys.yaml
+    authnChain:
+    - kind: mtls
+    - kind: jwt
+      params:
+        jwks: keys.json
+    anonymousOperations: [getStatus]
+    token:
+      enabled: true
+      ttl: 5m
+      secrets: token-secrets.yaml
     authz:
       kind: casbin
       params:
@@ -108,4 +118,13 @@ services:
 	if c.Services[0].HTTP.Authz.Params["parameters"].([]interface{})[0].(string) != "client" {
 		t.Errorf("listen endpoint is incorrect: %v, expected client\n%+v", c.Services[0].HTTP.Authz.Params["parameters"].([]interface{})[0], viper.Get("service"))
 	}
+	if chain := c.Services[0].HTTP.AuthnChain; len(chain) != 2 || chain[1].Kind != "jwt" || chain[1].Params["jwks"] != "keys.json" {
+		t.Errorf("authn chain is incorrect: %+v", chain)
+	}
+	if ops := c.Services[0].HTTP.AnonymousOperations; len(ops) != 1 || ops[0] != "getStatus" {
+		t.Errorf("anonymous operations are incorrect: %v", ops)
+	}
+	if tc := c.Services[0].HTTP.Token; !tc.Enabled || tc.TTL != 5*time.Minute || tc.Secrets != "token-secrets.yaml" {
+		t.Errorf("token config is incorrect: %+v", tc)
+	}
 }
@@ -0,0 +1,190 @@
+package graphql
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ListArguments arguments of field, that multiply complexity of its selection set by their value
+var ListArguments = []string{"first", "last", "limit"}
+
+// Stats shape of operation with fragments expanded
+type Stats struct {
+	// Depth max nesting of fields, top-level fields are at depth 1
+	Depth int
+	// Aliases number of aliased fields
+	Aliases int
+	// Complexity estimated cost of operation. Each field costs 1,
+	// cost of selection set of field is multiplied by value of its list argument.
+	Complexity int
+	// Fields sorted unique names of top-level fields
+	Fields []string
+}
+
+// Analyze computes stats of operation of document. Variables are used to resolve list arguments.
+// Fragment cycles are reported as error.
+func (d *Document) Analyze(op *Operation, variables map[string]interface{}) (Stats, error) {
+	a := &analyzer{doc: d, variables: variables, fragments: map[string]*Stats{}, visiting: map[string]bool{}}
+
+	s, err := a.selectionSet(op.SelectionSet)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	names := map[string]bool{}
+	if err := a.topLevelFields(op.SelectionSet, names, map[string]bool{}); err != nil {
+		return Stats{}, err
+	}
+	for n := range names {
+		s.Fields = append(s.Fields, n)
+	}
+	sort.Strings(s.Fields)
+	return s, nil
+}
+
+type analyzer struct {
+	doc       *Document
+	variables map[string]interface{}
+	// fragments memoizes stats of fragments, so documents repeating fragment spreads are analyzed in linear time
+	fragments map[string]*Stats
+	visiting  map[string]bool
+}
+
+func (a *analyzer) selectionSet(ss []Selection) (Stats, error) {
+	var s Stats
+	for _, sel := range ss {
+		var sub Stats
+		switch sel := sel.(type) {
+		case *Field:
+			child, err := a.selectionSet(sel.SelectionSet)
+			if err != nil {
+				return Stats{}, err
+			}
+			sub = Stats{
+				Depth:      child.Depth + 1,
+				Aliases:    child.Aliases,
+				Complexity: add(1, mul(child.Complexity, a.multiplier(sel))),
+			}
+			if sel.Alias != "" {
+				sub.Aliases = add(sub.Aliases, 1)
+			}
+		case *InlineFragment:
+			var err error
+			if sub, err = a.selectionSet(sel.SelectionSet); err != nil {
+				return Stats{}, err
+			}
+		case *FragmentSpread:
+			f, err := a.fragment(sel.Name)
+			if err != nil {
+				return Stats{}, err
+			}
+			sub = *f
+		}
+
+		if sub.Depth > s.Depth {
+			s.Depth = sub.Depth
+		}
+		s.Aliases = add(s.Aliases, sub.Aliases)
+		s.Complexity = add(s.Complexity, sub.Complexity)
+	}
+	return s, nil
+}
+
+func (a *analyzer) fragment(name string) (*Stats, error) {
+	if s, ok := a.fragments[name]; ok {
+		return s, nil
+	}
+	f, ok := a.doc.Fragments[name]
+	if !ok {
+		return nil, fmt.Errorf("fragment %s is not defined", name)
+	}
+	if a.visiting[name] {
+		return nil, fmt.Errorf("fragment %s spreads itself", name)
+	}
+
+	a.visiting[name] = true
+	s, err := a.selectionSet(f.SelectionSet)
+	delete(a.visiting, name)
+	if err != nil {
+		return nil, err
+	}
+	a.fragments[name] = &s
+	return &s, nil
+}
+
+// topLevelFields collects names of fields selected by selection set, including fields of fragments
+func (a *analyzer) topLevelFields(ss []Selection, names map[string]bool, seen map[string]bool) error {
+	for _, sel := range ss {
+		switch sel := sel.(type) {
+		case *Field:
+			names[sel.Name] = true
+		case *InlineFragment:
+			if err := a.topLevelFields(sel.SelectionSet, names, seen); err != nil {
+				return err
+			}
+		case *FragmentSpread:
+			if seen[sel.Name] {
+				continue
+			}
+			seen[sel.Name] = true
+			if err := a.topLevelFields(a.doc.Fragments[sel.Name].SelectionSet, names, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// multiplier returns value of the largest list argument of field or 1
+func (a *analyzer) multiplier(f *Field) int {
+	m := 1
+	for _, n := range ListArguments {
+		v, ok := f.Arguments[n]
+		if !ok {
+			continue
+		}
+		if name, ok := v.(Variable); ok {
+			v = a.variables[string(name)]
+		}
+
+		var i int
+		switch v := v.(type) {
+		case int64:
+			i = clamp(float64(v))
+		case float64:
+			// Variables decoded from JSON are float64
+			i = clamp(v)
+		}
+		if i > m {
+			m = i
+		}
+	}
+	return m
+}
+
+// Complexity arithmetic saturates instead of overflowing
+
+func add(x, y int) int {
+	if x > math.MaxInt32-y {
+		return math.MaxInt32
+	}
+	return x + y
+}
+
+func mul(x, y int) int {
+	if y != 0 && x > math.MaxInt32/y {
+		return math.MaxInt32
+	}
+	return x * y
+}
+
+func clamp(v float64) int {
+	if v > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	if v < 0 {
+		return 0
+	}
+	return int(v)
+}
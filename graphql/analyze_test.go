@@ -0,0 +1,100 @@
+package graphql
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	type testCase struct {
+		name      string
+		query     string
+		operation string
+		variables map[string]interface{}
+		opType    string
+		stats     Stats
+		err       bool
+	}
+
+	cases := []testCase{
+		testCase{name: "shorthand", query: `{ user { name } }`, opType: "query",
+			stats: Stats{Depth: 2, Complexity: 2, Fields: []string{"user"}}},
+		testCase{name: "named", query: `query Q($id: ID!) { b: user(id: $id) { name } a: me { id } }`, opType: "query",
+			stats: Stats{Depth: 2, Aliases: 2, Complexity: 4, Fields: []string{"me", "user"}}},
+		testCase{name: "list argument", query: `{ users(first: 10) { name posts(last: $n) { title } } }`,
+			variables: map[string]interface{}{"n": float64(5)}, opType: "query",
+			stats: Stats{Depth: 3, Complexity: 71, Fields: []string{"users"}}},
+		testCase{name: "fragments", query: `query { ...Top } fragment Top on Query { me { ...F ... on User { id } } } fragment F on User { x: name }`, opType: "query",
+			stats: Stats{Depth: 2, Aliases: 1, Complexity: 3, Fields: []string{"me"}}},
+		testCase{name: "select operation", query: `query A { a } mutation B { b(input: {x: [1, 2.5, "s", true, null, E]}) }`, operation: "B", opType: "mutation",
+			stats: Stats{Depth: 1, Complexity: 1, Fields: []string{"b"}}},
+		testCase{name: "ambiguous operation", query: `query A { a } query B { b }`, err: true},
+		testCase{name: "unknown operation", query: `query A { a }`, operation: "B", err: true},
+		testCase{name: "fragment cycle", query: `{ ...A } fragment A on Q { a { ...B } } fragment B on Q { b { ...A } }`, err: true},
+		testCase{name: "undefined fragment", query: `{ ...A }`, err: true},
+		testCase{name: "syntax error", query: `{ user( }`, err: true},
+		testCase{name: "schema definition", query: `type Query { a: Int }`, err: true},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			d, err := Parse(cs.query)
+			var op *Operation
+			if err == nil {
+				op, err = d.Operation(cs.operation)
+			}
+			var s Stats
+			if err == nil {
+				s, err = d.Analyze(op, cs.variables)
+			}
+
+			if cs.err {
+				if err == nil {
+					t.Errorf("expected error, got stats: %+v", s)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if op.Type != cs.opType {
+				t.Errorf("expected operation type %s, got: %s", cs.opType, op.Type)
+			}
+			if !reflect.DeepEqual(s, cs.stats) {
+				t.Errorf("expected stats %+v, got: %+v", cs.stats, s)
+			}
+		})
+	}
+}
+
+func TestAnalyzeFragmentBomb(t *testing.T) {
+	// Every fragment spreads the next one twice, expanded document has 2^30 fields
+	q := `{ ...F0 }`
+	for i := 0; i < 30; i++ {
+		q += " fragment F" + strconv.Itoa(i) + " on Q { a { ...F" + strconv.Itoa(i+1) + " } b { ...F" + strconv.Itoa(i+1) + " } }"
+	}
+	q += " fragment F30 on Q { c }"
+
+	d, err := Parse(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := d.Analyze(d.Operations[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Depth != 31 || s.Complexity < 1<<30 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+}
+
+func TestParseNesting(t *testing.T) {
+	q := ""
+	for i := 0; i < 1000; i++ {
+		q += "a {"
+	}
+	if _, err := Parse("{" + q); err == nil {
+		t.Error("expected error for deeply nested document")
+	}
+}
@@ -0,0 +1,480 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+)
+
+type (
+	// Document parsed GraphQL executable document
+	Document struct {
+		Operations []*Operation
+		Fragments  map[string]*Fragment
+	}
+
+	// Operation query, mutation or subscription of document
+	Operation struct {
+		// Type one of "query", "mutation" or "subscription"
+		Type         string
+		Name         string
+		SelectionSet []Selection
+	}
+
+	// Fragment named fragment definition
+	Fragment struct {
+		Name          string
+		TypeCondition string
+		SelectionSet  []Selection
+	}
+
+	// Selection is one of *Field, *FragmentSpread or *InlineFragment
+	Selection interface {
+		selection()
+	}
+
+	// Field selected field
+	Field struct {
+		Alias        string
+		Name         string
+		Arguments    map[string]interface{}
+		SelectionSet []Selection
+	}
+
+	// FragmentSpread reference to named fragment
+	FragmentSpread struct {
+		Name string
+	}
+
+	// InlineFragment selection set with optional type condition
+	InlineFragment struct {
+		TypeCondition string
+		SelectionSet  []Selection
+	}
+
+	// Variable reference to operation variable in argument value
+	Variable string
+
+	// Enum enum value in argument value
+	Enum string
+)
+
+func (*Field) selection()          {}
+func (*FragmentSpread) selection() {}
+func (*InlineFragment) selection() {}
+
+// Parse parses GraphQL executable document. Type system definitions are rejected.
+func Parse(src string) (*Document, error) {
+	p := &parser{lexer: lexer{src: src}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	d := &Document{Fragments: map[string]*Fragment{}}
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.peek(tokenPunct, "{"):
+			ss, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			d.Operations = append(d.Operations, &Operation{Type: "query", SelectionSet: ss})
+		case p.peek(tokenName, "query"), p.peek(tokenName, "mutation"), p.peek(tokenName, "subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			d.Operations = append(d.Operations, op)
+		case p.peek(tokenName, "fragment"):
+			f, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := d.Fragments[f.Name]; ok {
+				return nil, fmt.Errorf("fragment %s defined more than once", f.Name)
+			}
+			d.Fragments[f.Name] = f
+		default:
+			return nil, p.unexpected()
+		}
+	}
+
+	if len(d.Operations) == 0 {
+		return nil, fmt.Errorf("document has no operations")
+	}
+	return d, nil
+}
+
+// Operation selects operation to execute by name. Name may be empty only for document with single operation.
+func (d *Document) Operation(name string) (*Operation, error) {
+	if name == "" {
+		if len(d.Operations) > 1 {
+			return nil, fmt.Errorf("operation name is required for document with multiple operations")
+		}
+		return d.Operations[0], nil
+	}
+	for _, op := range d.Operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("operation %s not found", name)
+}
+
+// maxNesting limits nesting of selection sets and values, so parsing hostile document does not exhaust stack
+const maxNesting = 512
+
+type parser struct {
+	lexer
+	tok   token
+	depth int
+}
+
+// nest tracks nesting level, returned func must be called on leaving nested structure
+func (p *parser) nest() (func(), error) {
+	p.depth++
+	if p.depth > maxNesting {
+		return nil, fmt.Errorf("document is nested deeper than %d levels", maxNesting)
+	}
+	return func() { p.depth-- }, nil
+}
+
+func (p *parser) advance() (err error) {
+	p.tok, err = p.next()
+	return err
+}
+
+func (p *parser) peek(kind tokenKind, value string) bool {
+	return p.tok.kind == kind && p.tok.value == value
+}
+
+// skip advances past expected token and reports whether it was there
+func (p *parser) skip(kind tokenKind, value string) (bool, error) {
+	if !p.peek(kind, value) {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+func (p *parser) expect(kind tokenKind, value string) error {
+	if !p.peek(kind, value) {
+		return p.unexpected()
+	}
+	return p.advance()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokenName {
+		return "", p.unexpected()
+	}
+	n := p.tok.value
+	return n, p.advance()
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokenEOF {
+		return fmt.Errorf("unexpected end of document")
+	}
+	return fmt.Errorf("unexpected %q at %d", p.tok.value, p.tok.pos)
+}
+
+func (p *parser) operation() (*Operation, error) {
+	op := &Operation{Type: p.tok.value}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if p.tok.kind == tokenName {
+		if op.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if err = p.variableDefinitions(); err != nil {
+		return nil, err
+	}
+	if err = p.directives(); err != nil {
+		return nil, err
+	}
+	if op.SelectionSet, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func (p *parser) fragment() (*Fragment, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	f := &Fragment{}
+	var err error
+	if f.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if f.Name == "on" {
+		return nil, fmt.Errorf("invalid fragment name on")
+	}
+	if err = p.expect(tokenName, "on"); err != nil {
+		return nil, err
+	}
+	if f.TypeCondition, err = p.name(); err != nil {
+		return nil, err
+	}
+	if err = p.directives(); err != nil {
+		return nil, err
+	}
+	if f.SelectionSet, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// variableDefinitions skips variable definitions, only their use in arguments matters for analysis
+func (p *parser) variableDefinitions() error {
+	if ok, err := p.skip(tokenPunct, "("); !ok || err != nil {
+		return err
+	}
+	for !p.peek(tokenPunct, ")") {
+		if err := p.expect(tokenPunct, "$"); err != nil {
+			return err
+		}
+		if _, err := p.name(); err != nil {
+			return err
+		}
+		if err := p.expect(tokenPunct, ":"); err != nil {
+			return err
+		}
+		if err := p.typeRef(); err != nil {
+			return err
+		}
+		if ok, err := p.skip(tokenPunct, "="); err != nil {
+			return err
+		} else if ok {
+			if _, err := p.value(true); err != nil {
+				return err
+			}
+		}
+		if err := p.directives(); err != nil {
+			return err
+		}
+	}
+	return p.advance()
+}
+
+func (p *parser) typeRef() error {
+	leave, err := p.nest()
+	if err != nil {
+		return err
+	}
+	defer leave()
+
+	if ok, err := p.skip(tokenPunct, "["); err != nil {
+		return err
+	} else if ok {
+		if err := p.typeRef(); err != nil {
+			return err
+		}
+		if err := p.expect(tokenPunct, "]"); err != nil {
+			return err
+		}
+	} else if _, err := p.name(); err != nil {
+		return err
+	}
+	_, err = p.skip(tokenPunct, "!")
+	return err
+}
+
+// directives skips directives, they do not change shape of the document
+func (p *parser) directives() error {
+	for p.peek(tokenPunct, "@") {
+		if err := p.advance(); err != nil {
+			return err
+		}
+		if _, err := p.name(); err != nil {
+			return err
+		}
+		if _, err := p.arguments(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *parser) selectionSet() ([]Selection, error) {
+	leave, err := p.nest()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	if err = p.expect(tokenPunct, "{"); err != nil {
+		return nil, err
+	}
+
+	var ss []Selection
+	for !p.peek(tokenPunct, "}") {
+		s, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		ss = append(ss, s)
+	}
+	if len(ss) == 0 {
+		return nil, fmt.Errorf("empty selection set at %d", p.tok.pos)
+	}
+	return ss, p.advance()
+}
+
+func (p *parser) selection() (Selection, error) {
+	if ok, err := p.skip(tokenPunct, "..."); err != nil {
+		return nil, err
+	} else if ok {
+		if p.tok.kind == tokenName && p.tok.value != "on" {
+			s := &FragmentSpread{Name: p.tok.value}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			return s, p.directives()
+		}
+
+		s := &InlineFragment{}
+		if ok, err := p.skip(tokenName, "on"); err != nil {
+			return nil, err
+		} else if ok {
+			if s.TypeCondition, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.directives(); err != nil {
+			return nil, err
+		}
+		var err error
+		s.SelectionSet, err = p.selectionSet()
+		return s, err
+	}
+
+	f := &Field{}
+	var err error
+	if f.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if ok, err := p.skip(tokenPunct, ":"); err != nil {
+		return nil, err
+	} else if ok {
+		f.Alias = f.Name
+		if f.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if f.Arguments, err = p.arguments(); err != nil {
+		return nil, err
+	}
+	if err = p.directives(); err != nil {
+		return nil, err
+	}
+	if p.peek(tokenPunct, "{") {
+		if f.SelectionSet, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (p *parser) arguments() (map[string]interface{}, error) {
+	if ok, err := p.skip(tokenPunct, "("); !ok || err != nil {
+		return nil, err
+	}
+
+	args := map[string]interface{}{}
+	for !p.peek(tokenPunct, ")") {
+		n, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(tokenPunct, ":"); err != nil {
+			return nil, err
+		}
+		if args[n], err = p.value(false); err != nil {
+			return nil, err
+		}
+	}
+	return args, p.advance()
+}
+
+// value parses argument value. Variables are not allowed in constant values.
+func (p *parser) value(constant bool) (interface{}, error) {
+	leave, err := p.nest()
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	t := p.tok
+	switch {
+	case t.kind == tokenPunct && t.value == "$" && !constant:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		n, err := p.name()
+		return Variable(n), err
+	case t.kind == tokenInt:
+		v, err := strconv.ParseInt(t.value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %s at %d", t.value, t.pos)
+		}
+		return v, p.advance()
+	case t.kind == tokenFloat:
+		v, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float %s at %d", t.value, t.pos)
+		}
+		return v, p.advance()
+	case t.kind == tokenString:
+		return t.value, p.advance()
+	case t.kind == tokenName:
+		var v interface{}
+		switch t.value {
+		case "true":
+			v = true
+		case "false":
+			v = false
+		case "null":
+			v = nil
+		default:
+			v = Enum(t.value)
+		}
+		return v, p.advance()
+	case t.kind == tokenPunct && t.value == "[":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		list := []interface{}{}
+		for !p.peek(tokenPunct, "]") {
+			v, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, p.advance()
+	case t.kind == tokenPunct && t.value == "{":
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		obj := map[string]interface{}{}
+		for !p.peek(tokenPunct, "}") {
+			n, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect(tokenPunct, ":"); err != nil {
+				return nil, err
+			}
+			if obj[n], err = p.value(constant); err != nil {
+				return nil, err
+			}
+		}
+		return obj, p.advance()
+	}
+	return nil, p.unexpected()
+}
@@ -0,0 +1,128 @@
+// Package jsonschema validates JSON values against subset of JSON Schema draft 7, with nullable of OpenAPI 3.0.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// maxRefDepth bounds chains of references that do not descend into value, like {"$ref": "#"}
+const maxRefDepth = 64
+
+// Schema JSON Schema, references are resolved against root document of schema
+type Schema struct {
+	root interface{}
+	node interface{}
+
+	// patterns compiled regular expressions shared by schemas of document
+	patterns *sync.Map
+}
+
+// Violation of schema by part of validated value
+type Violation struct {
+	// Pointer JSON pointer to invalid value
+	Pointer string `json:"pointer"`
+	Message string `json:"message"`
+}
+
+// Parse reads schema document in JSON or YAML. JSON is tried first, as YAML 1.1 reads keys like n and yes as booleans.
+func Parse(doc []byte) (*Schema, error) {
+	var v interface{}
+	if err := json.Unmarshal(doc, &v); err != nil {
+		if err := yaml.Unmarshal(doc, &v); err != nil {
+			return nil, err
+		}
+		v = normalize(v)
+	}
+	switch v.(type) {
+	case bool, map[string]interface{}:
+	default:
+		return nil, fmt.Errorf("schema must be an object or a boolean")
+	}
+	return &Schema{root: v, node: v, patterns: &sync.Map{}}, nil
+}
+
+// Ref returns schema at JSON pointer of document, like /components/schemas/Order of OpenAPI document
+func (s *Schema) Ref(pointer string) (*Schema, error) {
+	node, ok := resolve(s.root, pointer)
+	if !ok {
+		return nil, fmt.Errorf("schema %s not found", pointer)
+	}
+	return &Schema{root: s.root, node: node, patterns: s.patterns}, nil
+}
+
+// normalize converts YAML values to values of encoding/json: maps with string keys and float64 numbers
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = normalize(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range v {
+			v[i] = normalize(e)
+		}
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	}
+	return v
+}
+
+// resolve finds value of document at JSON pointer, with or without leading #
+func resolve(doc interface{}, pointer string) (interface{}, bool) {
+	pointer = strings.TrimPrefix(pointer, "#")
+	if pointer == "" {
+		return doc, true
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, false
+	}
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+		switch d := doc.(type) {
+		case map[string]interface{}:
+			v, ok := d[token]
+			if !ok {
+				return nil, false
+			}
+			doc = v
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(d) {
+				return nil, false
+			}
+			doc = d[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// Escape escapes reference token of JSON pointer
+func Escape(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+}
+
+func (s *Schema) pattern(p string) *regexp.Regexp {
+	if re, ok := s.patterns.Load(p); ok {
+		return re.(*regexp.Regexp)
+	}
+	// Patterns Go does not support are not checked
+	re, _ := regexp.Compile(p)
+	s.patterns.Store(p, re)
+	return re
+}
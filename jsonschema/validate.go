@@ -0,0 +1,351 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxViolations bounds violations reported for single value
+const maxViolations = 100
+
+var formats = map[string]func(string) bool{
+	"date-time": func(s string) bool { _, err := time.Parse(time.RFC3339Nano, s); return err == nil },
+	"date":      func(s string) bool { _, err := time.Parse("2006-01-02", s); return err == nil },
+	"email":     regexp.MustCompile(`^[^@\s]+@[^@\s]+$`).MatchString,
+	"uuid":      regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`).MatchString,
+	"ipv4": func(s string) bool {
+		ip := net.ParseIP(s)
+		return ip != nil && ip.To4() != nil && !strings.Contains(s, ":")
+	},
+	"ipv6": func(s string) bool { return net.ParseIP(s) != nil && strings.Contains(s, ":") },
+}
+
+// Validate validates value decoded by encoding/json and returns violations sorted by pointer
+func (s *Schema) Validate(v interface{}) []Violation {
+	c := &checker{schema: s}
+	c.check(s.node, v, "", 0)
+	sort.SliceStable(c.violations, func(i, j int) bool {
+		return c.violations[i].Pointer < c.violations[j].Pointer
+	})
+	return c.violations
+}
+
+type checker struct {
+	schema     *Schema
+	violations []Violation
+}
+
+func (c *checker) add(pointer, format string, args ...interface{}) {
+	if len(c.violations) < maxViolations {
+		c.violations = append(c.violations, Violation{Pointer: pointer, Message: fmt.Sprintf(format, args...)})
+	}
+}
+
+// valid reports whether value matches schema, violations of subschemas are not reported
+func (c *checker) valid(node, v interface{}, pointer string, refs int) bool {
+	sub := &checker{schema: c.schema}
+	sub.check(node, v, pointer, refs)
+	return len(sub.violations) == 0
+}
+
+// check validates value at pointer, refs counts references followed without descending into value
+func (c *checker) check(node, v interface{}, pointer string, refs int) {
+	var n map[string]interface{}
+	switch s := node.(type) {
+	case bool:
+		if !s {
+			c.add(pointer, "value is not allowed")
+		}
+		return
+	case map[string]interface{}:
+		n = s
+	default:
+		return
+	}
+
+	if ref, ok := n["$ref"].(string); ok {
+		target, ok := resolve(c.schema.root, ref)
+		if !ok || !strings.HasPrefix(ref, "#") {
+			c.add(pointer, "unresolved schema reference %s", ref)
+			return
+		}
+		if refs >= maxRefDepth {
+			c.add(pointer, "schema references are too deep")
+			return
+		}
+		// Keywords next to reference are ignored, as in draft 7
+		c.check(target, v, pointer, refs+1)
+		return
+	}
+
+	if nullable, _ := n["nullable"].(bool); nullable && v == nil {
+		return
+	}
+	if t, ok := n["type"]; ok && !matchesType(t, v) {
+		c.add(pointer, "must be of type %s", typeName(t))
+		return
+	}
+	if enum, ok := n["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			found = found || equal(e, v)
+		}
+		if !found {
+			c.add(pointer, "must be one of enumeration")
+		}
+	}
+	if cv, ok := n["const"]; ok && !equal(cv, v) {
+		c.add(pointer, "must be equal to constant")
+	}
+
+	switch v := v.(type) {
+	case string:
+		c.string(n, v, pointer)
+	case float64:
+		c.number(n, v, pointer)
+	case []interface{}:
+		c.array(n, v, pointer)
+	case map[string]interface{}:
+		c.object(n, v, pointer)
+	}
+
+	if allOf, ok := n["allOf"].([]interface{}); ok {
+		for _, s := range allOf {
+			c.check(s, v, pointer, refs)
+		}
+	}
+	if anyOf, ok := n["anyOf"].([]interface{}); ok {
+		matched := false
+		for _, s := range anyOf {
+			if c.valid(s, v, pointer, refs) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			c.add(pointer, "must match at least one schema of anyOf")
+		}
+	}
+	if oneOf, ok := n["oneOf"].([]interface{}); ok {
+		matched := 0
+		for _, s := range oneOf {
+			if c.valid(s, v, pointer, refs) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			c.add(pointer, "must match exactly one schema of oneOf, matched %d", matched)
+		}
+	}
+	if not, ok := n["not"]; ok && c.valid(not, v, pointer, refs) {
+		c.add(pointer, "must not match schema of not")
+	}
+	if cond, ok := n["if"]; ok {
+		if c.valid(cond, v, pointer, refs) {
+			if then, ok := n["then"]; ok {
+				c.check(then, v, pointer, refs)
+			}
+		} else if els, ok := n["else"]; ok {
+			c.check(els, v, pointer, refs)
+		}
+	}
+}
+
+func (c *checker) string(n map[string]interface{}, v, pointer string) {
+	length := float64(utf8.RuneCountInString(v))
+	if min, ok := n["minLength"].(float64); ok && length < min {
+		c.add(pointer, "must be at least %v characters long", min)
+	}
+	if max, ok := n["maxLength"].(float64); ok && length > max {
+		c.add(pointer, "must be at most %v characters long", max)
+	}
+	if p, ok := n["pattern"].(string); ok {
+		if re := c.schema.pattern(p); re != nil && !re.MatchString(v) {
+			c.add(pointer, "must match pattern %s", p)
+		}
+	}
+	if f, ok := n["format"].(string); ok {
+		if valid, ok := formats[f]; ok && !valid(v) {
+			c.add(pointer, "must be valid %s", f)
+		}
+	}
+}
+
+func (c *checker) number(n map[string]interface{}, v float64, pointer string) {
+	// Draft 4 and OpenAPI 3.0 use boolean exclusiveMinimum and exclusiveMaximum modifying minimum and maximum
+	exclusiveMin, _ := n["exclusiveMinimum"].(bool)
+	exclusiveMax, _ := n["exclusiveMaximum"].(bool)
+	if min, ok := n["minimum"].(float64); ok {
+		if exclusiveMin && v <= min {
+			c.add(pointer, "must be greater than %v", min)
+		} else if v < min {
+			c.add(pointer, "must be greater than or equal to %v", min)
+		}
+	}
+	if max, ok := n["maximum"].(float64); ok {
+		if exclusiveMax && v >= max {
+			c.add(pointer, "must be less than %v", max)
+		} else if v > max {
+			c.add(pointer, "must be less than or equal to %v", max)
+		}
+	}
+	if min, ok := n["exclusiveMinimum"].(float64); ok && v <= min {
+		c.add(pointer, "must be greater than %v", min)
+	}
+	if max, ok := n["exclusiveMaximum"].(float64); ok && v >= max {
+		c.add(pointer, "must be less than %v", max)
+	}
+	if m, ok := n["multipleOf"].(float64); ok && m > 0 {
+		if q := v / m; math.Abs(q-math.Round(q)) > 1e-9 {
+			c.add(pointer, "must be multiple of %v", m)
+		}
+	}
+}
+
+func (c *checker) array(n map[string]interface{}, v []interface{}, pointer string) {
+	length := float64(len(v))
+	if min, ok := n["minItems"].(float64); ok && length < min {
+		c.add(pointer, "must have at least %v items", min)
+	}
+	if max, ok := n["maxItems"].(float64); ok && length > max {
+		c.add(pointer, "must have at most %v items", max)
+	}
+	if unique, _ := n["uniqueItems"].(bool); unique {
+		// Marshaled values are comparable, as encoding/json sorts keys of maps
+		seen := map[string]bool{}
+		for _, e := range v {
+			b, _ := json.Marshal(e)
+			if seen[string(b)] {
+				c.add(pointer, "must have unique items")
+				break
+			}
+			seen[string(b)] = true
+		}
+	}
+
+	switch items := n["items"].(type) {
+	case []interface{}:
+		for i, e := range v {
+			p := fmt.Sprintf("%s/%d", pointer, i)
+			if i < len(items) {
+				c.check(items[i], e, p, 0)
+			} else if additional, ok := n["additionalItems"]; ok {
+				c.check(additional, e, p, 0)
+			}
+		}
+	case nil:
+	default:
+		for i, e := range v {
+			c.check(items, e, fmt.Sprintf("%s/%d", pointer, i), 0)
+		}
+	}
+}
+
+func (c *checker) object(n map[string]interface{}, v map[string]interface{}, pointer string) {
+	length := float64(len(v))
+	if min, ok := n["minProperties"].(float64); ok && length < min {
+		c.add(pointer, "must have at least %v properties", min)
+	}
+	if max, ok := n["maxProperties"].(float64); ok && length > max {
+		c.add(pointer, "must have at most %v properties", max)
+	}
+	if required, ok := n["required"].([]interface{}); ok {
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				if _, ok := v[name]; !ok {
+					c.add(pointer+"/"+Escape(name), "is required")
+				}
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	properties, _ := n["properties"].(map[string]interface{})
+	patterns, _ := n["patternProperties"].(map[string]interface{})
+	additional, hasAdditional := n["additionalProperties"]
+	for _, k := range keys {
+		p := pointer + "/" + Escape(k)
+		matched := false
+		if s, ok := properties[k]; ok {
+			c.check(s, v[k], p, 0)
+			matched = true
+		}
+		for pattern, s := range patterns {
+			if re := c.schema.pattern(pattern); re != nil && re.MatchString(k) {
+				c.check(s, v[k], p, 0)
+				matched = true
+			}
+		}
+		if !matched && hasAdditional {
+			if allowed, ok := additional.(bool); ok && !allowed {
+				c.add(p, "is not allowed")
+				continue
+			}
+			c.check(additional, v[k], p, 0)
+		}
+	}
+}
+
+func matchesType(t, v interface{}) bool {
+	if types, ok := t.([]interface{}); ok {
+		for _, e := range types {
+			if matchesType(e, v) {
+				return true
+			}
+		}
+		return false
+	}
+	switch t {
+	case "null":
+		return v == nil
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		n, ok := v.(float64)
+		return ok && n == math.Trunc(n)
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	}
+	// Unknown types do not constrain value
+	return true
+}
+
+func typeName(t interface{}) string {
+	if types, ok := t.([]interface{}); ok {
+		var names []string
+		for _, e := range types {
+			names = append(names, fmt.Sprint(e))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+func equal(a, b interface{}) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
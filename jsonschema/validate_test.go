@@ -0,0 +1,78 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	type testCase struct {
+		name     string
+		schema   string
+		value    string
+		pointers []string
+	}
+
+	order := `{
+		"type": "object",
+		"required": ["id", "items"],
+		"additionalProperties": false,
+		"properties": {
+			"id": {"type": "integer", "minimum": 1},
+			"email": {"type": "string", "format": "email"},
+			"note": {"type": "string", "nullable": true, "maxLength": 5},
+			"a/b": {"type": "boolean"},
+			"items": {"type": "array", "minItems": 1, "items": {"$ref": "#/definitions/item"}}
+		},
+		"definitions": {
+			"item": {
+				"type": "object",
+				"required": ["sku"],
+				"properties": {
+					"sku": {"type": "string", "pattern": "^[A-Z]{3}-\\d+$"},
+					"qty": {"type": "number", "exclusiveMinimum": 0, "multipleOf": 0.5}
+				}
+			}
+		}
+	}`
+
+	cases := []testCase{
+		testCase{name: "valid", schema: order, value: `{"id": 1, "note": null, "items": [{"sku": "ABC-1", "qty": 1.5}]}`},
+		testCase{name: "missing required", schema: order, value: `{"items": [{}]}`, pointers: []string{"/id", "/items/0/sku"}},
+		testCase{
+			name: "invalid values", schema: order,
+			value:    `{"id": 1.5, "email": "x", "note": "too long", "a/b": 1, "items": [{"sku": "abc", "qty": 0.7}], "extra": 1}`,
+			pointers: []string{"/a~1b", "/email", "/extra", "/id", "/items/0/qty", "/items/0/sku", "/note"},
+		},
+		testCase{name: "wrong type", schema: order, value: `[]`, pointers: []string{""}},
+		testCase{name: "anyOf", schema: `{"anyOf": [{"type": "string"}, {"type": "integer"}]}`, value: `true`, pointers: []string{""}},
+		testCase{name: "oneOf", schema: `{"oneOf": [{"minimum": 1}, {"maximum": 10}]}`, value: `5`, pointers: []string{""}},
+		testCase{name: "enum", schema: `{"enum": ["a", {"b": 1}]}`, value: `{"b": 1}`},
+		testCase{name: "unique items", schema: `{"uniqueItems": true}`, value: `[{"a": 1, "b": 2}, {"b": 2, "a": 1}]`, pointers: []string{""}},
+		testCase{name: "recursive reference", schema: `{"$ref": "#"}`, value: `1`, pointers: []string{""}},
+		testCase{name: "false schema", schema: `{"properties": {"x": false}}`, value: `{"x": 1}`, pointers: []string{"/x"}},
+		testCase{name: "yaml", schema: "type: object\nproperties:\n  qty: {type: integer, maximum: 3}\n", value: `{"qty": 4}`, pointers: []string{"/qty"}},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			s, err := Parse([]byte(cs.schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var v interface{}
+			if err := json.Unmarshal([]byte(cs.value), &v); err != nil {
+				t.Fatal(err)
+			}
+
+			var pointers []string
+			for _, v := range s.Validate(v) {
+				pointers = append(pointers, v.Pointer)
+			}
+			if !reflect.DeepEqual(pointers, cs.pointers) {
+				t.Errorf("expected violations at %v, got %v", cs.pointers, s.Validate(v))
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package mw
+
+import (
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+type (
+	// AuthnChainConfig defines the config for AuthnChain middleware.
+	AuthnChainConfig struct {
+		// Skipper defines a function to skip middleware.
+		Skipper middleware.Skipper
+
+		// Methods authentication methods in order of precedence
+		Methods []AuthnMethod
+
+		// Anonymous operations allowed without credentials
+		Anonymous []string
+
+		// AnonymousClient client set for requests of anonymous operations without credentials
+		AnonymousClient string
+
+		// OperationKey key of operation set by OperationResolver
+		OperationKey string
+
+		// MethodKey key to output name of method that authenticated client
+		MethodKey string
+
+		// ContextKey key to output client if authenticated
+		ContextKey string
+	}
+
+	// AuthnMethod authentication method of AuthnChain
+	AuthnMethod struct {
+		// Name of method, e.g. jwt
+		Name string
+
+		// Present reports whether request carries credentials of method
+		Present func(c echo.Context) bool
+
+		// Middleware authenticates client and sets it in context
+		Middleware echo.MiddlewareFunc
+	}
+)
+
+var (
+	// defaultAuthnChainConfig is the default AuthnChain middleware config.
+	defaultAuthnChainConfig = AuthnChainConfig{
+		Skipper:         middleware.DefaultSkipper,
+		AnonymousClient: "anonymous",
+		OperationKey:    "operation",
+		MethodKey:       "authn",
+		ContextKey:      "client",
+	}
+)
+
+// AuthnChain returns an AuthnChain middleware.
+//
+// Request is authenticated by the first method whose credentials it carries, that method must succeed, other
+// methods are not tried. Requests without credentials of anonymous operations get anonymous client, other
+// requests without credentials are rejected by the first method. Name of method is set with MethodKey.
+func AuthnChain(methods ...AuthnMethod) echo.MiddlewareFunc {
+	c := defaultAuthnChainConfig
+	c.Methods = methods
+	return AuthnChainWithConfig(c)
+}
+
+// AuthnChainWithConfig returns an AuthnChain middleware with config.
+// See `AuthnChain()`.
+func AuthnChainWithConfig(config AuthnChainConfig) echo.MiddlewareFunc {
+	// Defaults
+	if config.Skipper == nil {
+		config.Skipper = defaultAuthnChainConfig.Skipper
+	}
+	if config.AnonymousClient == "" {
+		config.AnonymousClient = defaultAuthnChainConfig.AnonymousClient
+	}
+	if config.OperationKey == "" {
+		config.OperationKey = defaultAuthnChainConfig.OperationKey
+	}
+	if config.MethodKey == "" {
+		config.MethodKey = defaultAuthnChainConfig.MethodKey
+	}
+	if config.ContextKey == "" {
+		config.ContextKey = defaultAuthnChainConfig.ContextKey
+	}
+	if len(config.Methods) == 0 {
+		panic("echo: authn-chain middleware requires methods")
+	}
+
+	anonymous := map[string]bool{}
+	for _, op := range config.Anonymous {
+		anonymous[op] = true
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		handlers := make([]echo.HandlerFunc, len(config.Methods))
+		for i, m := range config.Methods {
+			handlers[i] = m.Middleware(next)
+		}
+
+		return func(c echo.Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			for i, m := range config.Methods {
+				if m.Present(c) {
+					c.Set(config.MethodKey, m.Name)
+					return handlers[i](c)
+				}
+			}
+
+			if op, _ := c.Get(config.OperationKey).(string); anonymous[op] {
+				c.Set(config.MethodKey, "anonymous")
+				c.Set(config.ContextKey, config.AnonymousClient)
+				return next(c)
+			}
+			c.Set(config.MethodKey, config.Methods[0].Name)
+			return handlers[0](c)
+		}
+	}
+}
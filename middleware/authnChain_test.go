@@ -0,0 +1,129 @@
+package mw
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+func TestAuthnChain(t *testing.T) {
+	type testCase struct {
+		name      string
+		operation string
+		header    map[string]string
+		cert      string
+		result    int
+		client    string
+		method    string
+	}
+
+	cases := []testCase{
+		testCase{name: "certificate", cert: "cert-system", result: 200, client: "cert-system", method: "mtls"},
+		testCase{name: "token", header: map[string]string{"Authorization": "Bearer token-system"}, result: 200, client: "token-system", method: "token"},
+		testCase{
+			name: "first present method wins", cert: "cert-system", header: map[string]string{"Authorization": "Bearer token-system"},
+			result: 200, client: "cert-system", method: "mtls",
+		},
+		testCase{name: "present method must succeed", header: map[string]string{"Authorization": "Bearer wrong"}, result: 401},
+		testCase{
+			name: "no fallback after failure", header: map[string]string{"Authorization": "Bearer wrong", "X-Client": "header-system"},
+			result: 401,
+		},
+		testCase{name: "last method", header: map[string]string{"X-Client": "header-system"}, result: 200, client: "header-system", method: "header"},
+		testCase{name: "no credentials", result: 401},
+		testCase{name: "anonymous", operation: "getStatus", result: 200, client: "anonymous", method: "anonymous"},
+		testCase{name: "credentials of anonymous operation", operation: "getStatus", cert: "cert-system", result: 200, client: "cert-system", method: "mtls"},
+		testCase{name: "invalid credentials of anonymous operation", operation: "getStatus", header: map[string]string{"Authorization": "Bearer wrong"}, result: 401},
+	}
+
+	token := AuthnMethod{
+		Name:    "token",
+		Present: func(c echo.Context) bool { return bearerToken(c.Request()) != "" },
+		Middleware: func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(c echo.Context) error {
+				if t := bearerToken(c.Request()); t != "token-system" {
+					return echo.ErrUnauthorized
+				}
+				c.Set("client", "token-system")
+				return next(c)
+			}
+		},
+	}
+	header := AuthnMethod{
+		Name:    "header",
+		Present: func(c echo.Context) bool { return c.Request().Header.Get("X-Client") != "" },
+		Middleware: func(next echo.HandlerFunc) echo.HandlerFunc {
+			return func(c echo.Context) error {
+				c.Set("client", c.Request().Header.Get("X-Client"))
+				return next(c)
+			}
+		},
+	}
+	mtls := AuthnMethod{
+		Name:       "mtls",
+		Present:    func(c echo.Context) bool { return c.Request().TLS != nil },
+		Middleware: TLSClientAuth(),
+	}
+
+	e := echo.New()
+	h := AuthnChainWithConfig(AuthnChainConfig{
+		Methods:   []AuthnMethod{mtls, token, header},
+		Anonymous: []string{"getStatus"},
+	})(func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Get("client").(string)+"|"+c.Get("authn").(string))
+	})
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			for k, v := range cs.header {
+				req.Header.Set(k, v)
+			}
+			if cs.cert != "" {
+				cert := &x509.Certificate{Subject: pkix.Name{CommonName: cs.cert}}
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+			}
+			res := httptest.NewRecorder()
+			c := e.NewContext(req, res)
+			c.Set("operation", cs.operation)
+
+			err := h(c)
+			if he, ok := err.(*echo.HTTPError); ok {
+				res.Code = he.Code
+			}
+			if res.Code != cs.result {
+				t.Fatalf("expected %d, got %d", cs.result, res.Code)
+			}
+			if cs.result == 200 && res.Body.String() != cs.client+"|"+cs.method {
+				t.Errorf("expected %s|%s, got %s", cs.client, cs.method, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestTLSClientAuth(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "cert-system"}}
+	states := map[string]*tls.ConnectionState{
+		"no TLS":         nil,
+		"not verified":   &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}},
+		"no common name": &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{&x509.Certificate{}}}},
+	}
+
+	e := echo.New()
+	h := TLSClientAuth()(func(c echo.Context) error {
+		return c.String(http.StatusOK, "test")
+	})
+	for name, state := range states {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.TLS = state
+		c := e.NewContext(req, httptest.NewRecorder())
+		if err := h(c); err != echo.ErrUnauthorized {
+			t.Errorf("%s: expected unauthorized, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package mw
+
+import (
+	"strconv"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+type (
+	// BasicAuthConfig defines the config for BasicAuth middleware.
+	BasicAuthConfig struct {
+		// Skipper defines a function to skip middleware.
+		Skipper middleware.Skipper
+
+		// Users htpasswd users to check against
+		// Required.
+		Users *Htpasswd
+
+		// Realm of challenge
+		Realm string
+
+		// ContextKey key to output client if authenticated
+		ContextKey string
+	}
+)
+
+var (
+	// defaultBasicAuthConfig is the default BasicAuth middleware config.
+	defaultBasicAuthConfig = BasicAuthConfig{
+		Skipper:    middleware.DefaultSkipper,
+		Realm:      "nprxy",
+		ContextKey: "client",
+	}
+)
+
+// BasicAuth returns a BasicAuth middleware.
+//
+// Client is authenticated by HTTP Basic credentials checked against htpasswd users. Requests without valid
+// credentials are rejected with "401 - Unauthorized" and `WWW-Authenticate` challenge of realm.
+func BasicAuth(users *Htpasswd) echo.MiddlewareFunc {
+	c := defaultBasicAuthConfig
+	c.Users = users
+	return BasicAuthWithConfig(c)
+}
+
+// BasicAuthWithConfig returns a BasicAuth middleware with config.
+// See `BasicAuth()`.
+func BasicAuthWithConfig(config BasicAuthConfig) echo.MiddlewareFunc {
+	// Defaults
+	if config.Skipper == nil {
+		config.Skipper = defaultBasicAuthConfig.Skipper
+	}
+	if config.Realm == "" {
+		config.Realm = defaultBasicAuthConfig.Realm
+	}
+	if config.ContextKey == "" {
+		config.ContextKey = defaultBasicAuthConfig.ContextKey
+	}
+	if config.Users == nil {
+		panic("echo: basic-auth middleware requires users")
+	}
+
+	challenge := "Basic realm=" + strconv.Quote(config.Realm) + `, charset="UTF-8"`
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			user, password, ok := c.Request().BasicAuth()
+			if ok && config.Users.Authenticate(user, password) {
+				c.Set(config.ContextKey, user)
+				return next(c)
+			}
+
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, challenge)
+			return echo.ErrUnauthorized
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package mw
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasicAuth(t *testing.T) {
+	type testCase struct {
+		name     string
+		user     string
+		password string
+		result   int
+	}
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	dir, err := ioutil.TempDir("", "htpasswd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".htpasswd")
+	ioutil.WriteFile(path, []byte("# clients\n"+
+		"bcrypt:"+string(hash)+"\n"+
+		"sha:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"+
+		"apr:$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0\n"+
+		"crypt:rqXexS6ZhobKA\n"), 0600)
+
+	cases := []testCase{
+		testCase{name: "bcrypt", user: "bcrypt", password: "secret", result: 200},
+		testCase{name: "sha1", user: "sha", password: "secret", result: 200},
+		testCase{name: "apr1", user: "apr", password: "secret", result: 200},
+		testCase{name: "wrong password", user: "apr", password: "Secret", result: 401},
+		testCase{name: "unknown user", user: "other", password: "secret", result: 401},
+		testCase{name: "unsupported hash", user: "crypt", password: "secret", result: 401},
+		testCase{name: "no credentials", result: 401},
+	}
+
+	users, err := NewHtpasswd(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	h := BasicAuthWithConfig(BasicAuthConfig{Users: users, Realm: "orders"})(func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Get("client").(string))
+	})
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if cs.user != "" {
+				req.SetBasicAuth(cs.user, cs.password)
+			}
+			res := httptest.NewRecorder()
+			c := e.NewContext(req, res)
+
+			err := h(c)
+			if he, ok := err.(*echo.HTTPError); ok {
+				res.Code = he.Code
+			}
+			if res.Code != cs.result {
+				t.Fatalf("expected %d, got %d", cs.result, res.Code)
+			}
+			if cs.result == 200 && res.Body.String() != cs.user {
+				t.Errorf("expected client %s, got %s", cs.user, res.Body.String())
+			}
+			if ch := res.Header().Get("WWW-Authenticate"); cs.result == 401 && ch != `Basic realm="orders", charset="UTF-8"` {
+				t.Errorf("unexpected challenge %s", ch)
+			}
+		})
+	}
+}
+
+func TestHtpasswdWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "htpasswd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".htpasswd")
+	ioutil.WriteFile(path, []byte("sha:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n"), 0600)
+
+	users, err := NewHtpasswd(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := users.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// file is replaced by rename, like editors and config management do
+	tmp := filepath.Join(dir, ".htpasswd.tmp")
+	ioutil.WriteFile(tmp, []byte("apr:$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0\n"), 0600)
+	os.Rename(tmp, path)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !users.Authenticate("apr", "secret") {
+		if time.Now().After(deadline) {
+			t.Fatal("expected users to be reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if users.Authenticate("sha", "secret") {
+		t.Error("expected removed user to be rejected")
+	}
+}
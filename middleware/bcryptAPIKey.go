@@ -1,6 +1,16 @@
 package mw
 
 import (
+	"crypto/rand"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 	"golang.org/x/crypto/bcrypt"
@@ -15,6 +25,9 @@ type (
 		// Keys system - hashed key pairs to check against
 		Keys map[string]string
 
+		// Store of clients with several keys, expiry, networks, scopes and roles, replaces Keys
+		Store *KeyStore
+
 		// ClientHeader header that carries system name
 		ClientHeader string
 
@@ -23,6 +36,30 @@ type (
 
 		// ContextKey key to output client if authenticated
 		ContextKey string
+
+		// ScopeKey and RolesKey keys to output scopes and roles of client of Store, separated with spaces
+		ScopeKey string
+		RolesKey string
+
+		// FingerprintKey key to output fingerprint of verified key, see KeyFingerprint
+		FingerprintKey string
+
+		// Cache of verified keys, so bcrypt is not computed on every request. Negative CacheTTL disables cache.
+		Cache     *CredentialCache
+		CacheTTL  time.Duration
+		CacheSize int
+
+		// ClientLockout and IPLockout lock out client names at address and addresses after repeated failures, with
+		// duration doubled on each next lockout. Negative MaxClientFailures or MaxIPFailures disables lockout.
+		ClientLockout      *Lockout
+		IPLockout          *Lockout
+		MaxClientFailures  int
+		MaxIPFailures      int
+		LockoutDuration    time.Duration
+		MaxLockoutDuration time.Duration
+
+		// Logger for lockout events
+		Logger logrus.FieldLogger
 	}
 )
 
@@ -34,6 +71,17 @@ var (
 		ClientHeader: "X-NPRXY-Client",
 		KeyHeader:    "X-NPRXY-Key",
 		ContextKey:   "client",
+		ScopeKey:     "claim.scope",
+		RolesKey:     "claim.roles",
+		CacheTTL:     5 * time.Minute,
+		CacheSize:    10000,
+
+		FingerprintKey: "client.key",
+
+		MaxClientFailures:  5,
+		MaxIPFailures:      20,
+		LockoutDuration:    time.Minute,
+		MaxLockoutDuration: time.Hour,
 	}
 )
 
@@ -55,6 +103,67 @@ func BCryptAPIKeyWithConfig(config BCryptAPIKeyConfig) echo.MiddlewareFunc {
 	if config.Keys == nil {
 		config.Keys = map[string]string{}
 	}
+	if config.ClientHeader == "" {
+		config.ClientHeader = defaultBCryptAPIKey.ClientHeader
+	}
+	if config.KeyHeader == "" {
+		config.KeyHeader = defaultBCryptAPIKey.KeyHeader
+	}
+	if config.ContextKey == "" {
+		config.ContextKey = defaultBCryptAPIKey.ContextKey
+	}
+	if config.ScopeKey == "" {
+		config.ScopeKey = defaultBCryptAPIKey.ScopeKey
+	}
+	if config.RolesKey == "" {
+		config.RolesKey = defaultBCryptAPIKey.RolesKey
+	}
+	if config.FingerprintKey == "" {
+		config.FingerprintKey = defaultBCryptAPIKey.FingerprintKey
+	}
+	if config.CacheTTL == 0 {
+		config.CacheTTL = defaultBCryptAPIKey.CacheTTL
+	}
+	if config.CacheSize == 0 {
+		config.CacheSize = defaultBCryptAPIKey.CacheSize
+	}
+	if config.Cache == nil && config.CacheTTL > 0 {
+		config.Cache = NewCredentialCache(config.CacheTTL, config.CacheSize)
+	}
+	if config.MaxClientFailures == 0 {
+		config.MaxClientFailures = defaultBCryptAPIKey.MaxClientFailures
+	}
+	if config.MaxIPFailures == 0 {
+		config.MaxIPFailures = defaultBCryptAPIKey.MaxIPFailures
+	}
+	if config.LockoutDuration == 0 {
+		config.LockoutDuration = defaultBCryptAPIKey.LockoutDuration
+	}
+	if config.MaxLockoutDuration == 0 {
+		config.MaxLockoutDuration = defaultBCryptAPIKey.MaxLockoutDuration
+	}
+	if config.ClientLockout == nil && config.MaxClientFailures > 0 {
+		config.ClientLockout = NewLockout(config.MaxClientFailures, config.LockoutDuration, config.MaxLockoutDuration)
+	}
+	if config.IPLockout == nil && config.MaxIPFailures > 0 {
+		config.IPLockout = NewLockout(config.MaxIPFailures, config.LockoutDuration, config.MaxLockoutDuration)
+	}
+	if config.Logger == nil {
+		config.Logger = logrus.StandardLogger()
+	}
+
+	// Failed attempts are checked against hashes of random key with cost of known keys, up to the number of keys
+	// of client with most keys, so response time does not reveal which clients exist or how many keys they have
+	var dummy []byte
+	var dummyOnce sync.Once
+	dummyHash := func() []byte {
+		dummyOnce.Do(func() {
+			key := make([]byte, 32)
+			rand.Read(key)
+			dummy, _ = bcrypt.GenerateFromPassword(key, keysCost(config))
+		})
+		return dummy
+	}
 
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
@@ -64,17 +173,119 @@ func BCryptAPIKeyWithConfig(config BCryptAPIKeyConfig) echo.MiddlewareFunc {
 
 			system := c.Request().Header.Get(config.ClientHeader)
 			key := c.Request().Header.Get(config.KeyHeader)
-			hash, ok := config.Keys[system]
+			now := time.Now()
+			ip := c.Request().RemoteAddr
+			if addr := remoteIP(c.Request()); addr != nil {
+				ip = addr.String()
+			}
 
-			if ok {
-				err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(key))
-				if err == nil {
+			// client failures are counted per address, so failures from other addresses do not lock client out
+			clientKey := system + "\x00" + ip
+			if d := locked(config, clientKey, ip, now); d > 0 {
+				c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+				return echo.ErrTooManyRequests
+			}
+
+			var hashes []string
+			var client *KeyClient
+			if config.Store != nil {
+				if client = config.Store.Client(system, remoteIP(c.Request())); client != nil {
+					for _, k := range client.ActiveKeys(now) {
+						hashes = append(hashes, k.Hash)
+					}
+				}
+			} else if hash, ok := config.Keys[system]; ok {
+				hashes = []string{hash}
+			}
+
+			for _, hash := range hashes {
+				verified := config.Cache != nil && config.Cache.Verified(system, key, hash, now)
+				if !verified && bcrypt.CompareHashAndPassword([]byte(hash), []byte(key)) == nil {
+					verified = true
+					if config.Cache != nil {
+						config.Cache.Add(system, key, hash, now)
+					}
+				}
+				if verified {
+					if config.ClientLockout != nil {
+						config.ClientLockout.Reset(clientKey)
+					}
+					if client != nil {
+						c.Set(config.ScopeKey, strings.Join(client.Scopes, " "))
+						c.Set(config.RolesKey, strings.Join(client.Roles, " "))
+					}
+					c.Set(config.FingerprintKey, KeyFingerprint(hash))
 					c.Set(config.ContextKey, system)
 					return next(c)
 				}
 			}
+			comparisons := 1
+			if config.Store != nil {
+				comparisons = config.Store.MaxKeys()
+			}
+			for i := len(hashes); i < comparisons || i == 0; i++ {
+				bcrypt.CompareHashAndPassword(dummyHash(), []byte(key))
+			}
 
+			fail(config, clientKey, system, ip, now)
 			return echo.ErrUnauthorized
 		}
 	}
 }
+
+// locked returns time left until lockout of client at address or of address ends
+func locked(config BCryptAPIKeyConfig, clientKey, ip string, now time.Time) time.Duration {
+	var d time.Duration
+	if config.ClientLockout != nil {
+		d = config.ClientLockout.Locked(clientKey, now)
+	}
+	if config.IPLockout != nil {
+		if ipd := config.IPLockout.Locked(ip, now); ipd > d {
+			d = ipd
+		}
+	}
+	return d
+}
+
+// fail counts failure of client at address and of address and logs lockouts
+func fail(config BCryptAPIKeyConfig, clientKey, system, ip string, now time.Time) {
+	if config.ClientLockout != nil {
+		if d := config.ClientLockout.Fail(clientKey, now); d > 0 {
+			config.Logger.WithFields(logrus.Fields{"event": "authn_lockout", "lockout": "client", "client": system, "remote_ip": ip, "duration": d.String()}).
+				Warn("client is locked out after failed authentication attempts")
+		}
+	}
+	if config.IPLockout != nil {
+		if d := config.IPLockout.Fail(ip, now); d > 0 {
+			config.Logger.WithFields(logrus.Fields{"event": "authn_lockout", "lockout": "ip", "client": system, "remote_ip": ip, "duration": d.String()}).
+				Warn("address is locked out after failed authentication attempts")
+		}
+	}
+}
+
+// keysCost returns the highest bcrypt cost of keys
+func keysCost(config BCryptAPIKeyConfig) int {
+	hashes := config.Keys
+	if config.Store != nil {
+		hashes = config.Store.Hashes(time.Now())
+	}
+	cost := 0
+	for _, h := range hashes {
+		if c, err := bcrypt.Cost([]byte(h)); err == nil && c > cost {
+			cost = c
+		}
+	}
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	return cost
+}
+
+// remoteIP returns address of connection of request, headers set by clients are not trusted
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
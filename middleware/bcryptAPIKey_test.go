@@ -3,7 +3,9 @@ package mw
 import (
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/labstack/echo"
 )
@@ -56,3 +58,70 @@ func TestBcryptAPIKey(t *testing.T) {
 	}
 
 }
+
+func TestCredentialCache(t *testing.T) {
+	type testCase struct {
+		name     string
+		client   string
+		key      string
+		hash     string
+		after    time.Duration
+		verified bool
+	}
+
+	cases := []testCase{
+		testCase{name: "verified", client: "test-system", key: "api-key", hash: "hash", verified: true},
+		testCase{name: "other key", client: "test-system", key: "api-key-2", hash: "hash"},
+		testCase{name: "other client", client: "other-system", key: "api-key", hash: "hash"},
+		testCase{name: "changed hash", client: "test-system", key: "api-key", hash: "rotated"},
+		testCase{name: "expired", client: "test-system", key: "api-key", hash: "hash", after: time.Minute},
+	}
+
+	now := time.Now()
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			cc := NewCredentialCache(time.Minute, 10)
+			cc.Add("test-system", "api-key", "hash", now)
+			if v := cc.Verified(cs.client, cs.key, cs.hash, now.Add(cs.after)); v != cs.verified {
+				t.Errorf("expected %v, got %v", cs.verified, v)
+			}
+		})
+	}
+
+	cc := NewCredentialCache(time.Minute, 2)
+	for i := 0; i < 3; i++ {
+		cc.Add(strconv.Itoa(i), "api-key", "hash", now)
+	}
+	if len(cc.entries) != 2 || !cc.Verified("2", "api-key", "hash", now) {
+		t.Errorf("expected cache to be bounded and keep the last entry, got %d entries", len(cc.entries))
+	}
+	cc.Purge()
+	if cc.Verified("2", "api-key", "hash", now) {
+		t.Error("expected purged entry to be rejected")
+	}
+}
+
+func BenchmarkBCryptAPIKey(b *testing.B) {
+	keys := map[string]string{"test-system": /*api-key*/ "$2a$10$0ZYFiKcYonvy.y/P4jAzJOr79AQoeO1LGO2hyj27QS5pTx/1nyzRm"}
+	configs := map[string]BCryptAPIKeyConfig{
+		"uncached": BCryptAPIKeyConfig{Keys: keys, CacheTTL: -1},
+		"cached":   BCryptAPIKeyConfig{Keys: keys},
+	}
+
+	e := echo.New()
+	for name, config := range configs {
+		h := BCryptAPIKeyWithConfig(config)(func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		})
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				req := httptest.NewRequest("GET", "/", nil)
+				req.Header.Add("X-NPRXY-Client", "test-system")
+				req.Header.Add("X-NPRXY-Key", "api-key")
+				if err := h(e.NewContext(req, httptest.NewRecorder())); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
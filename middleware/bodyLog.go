@@ -0,0 +1,126 @@
+package mw
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+type (
+	// BodyLogConfig defines the config for BodyLog middleware.
+	BodyLogConfig struct {
+		// Skipper defines a function to skip middleware.
+		Skipper middleware.Skipper
+
+		// Limit max number of bytes of request and response bodies passed to Handler
+		Limit int
+
+		// Handler receives beginning of request and response bodies
+		// Required.
+		Handler middleware.BodyDumpHandler
+	}
+)
+
+var (
+	// defaultBodyLogConfig is the default BodyLog middleware config.
+	defaultBodyLogConfig = BodyLogConfig{
+		Skipper: middleware.DefaultSkipper,
+		Limit:   20000,
+	}
+)
+
+// BodyLog returns a BodyLog middleware.
+//
+// Unlike BodyDump, bodies are streamed and only first Limit bytes are kept for Handler,
+// so it is safe for large and long-lived bodies.
+func BodyLog(limit int, handler middleware.BodyDumpHandler) echo.MiddlewareFunc {
+	c := defaultBodyLogConfig
+	c.Limit = limit
+	c.Handler = handler
+	return BodyLogWithConfig(c)
+}
+
+// BodyLogWithConfig returns a BodyLog middleware with config.
+// See `BodyLog()`.
+func BodyLogWithConfig(config BodyLogConfig) echo.MiddlewareFunc {
+	// Defaults
+	if config.Skipper == nil {
+		config.Skipper = defaultBodyLogConfig.Skipper
+	}
+	if config.Limit == 0 {
+		config.Limit = defaultBodyLogConfig.Limit
+	}
+	if config.Handler == nil {
+		panic("echo: body-log middleware requires a handler function")
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			req := c.Request()
+			reqB := &limitedBuffer{limit: config.Limit}
+			if req.Body != nil {
+				req.Body = &teeReadCloser{Reader: io.TeeReader(req.Body, reqB), Closer: req.Body}
+			}
+
+			resB := &limitedBuffer{limit: config.Limit}
+			res := c.Response()
+			res.Writer = &bodyLogResponseWriter{Writer: io.MultiWriter(res.Writer, resB), ResponseWriter: res.Writer}
+
+			err := next(c)
+
+			config.Handler(c, reqB.buf, resB.buf)
+
+			return err
+		}
+	}
+}
+
+// limitedBuffer keeps first limit bytes written to it
+type limitedBuffer struct {
+	buf   []byte
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if rest := b.limit - len(b.buf); rest > 0 {
+		if len(p) < rest {
+			rest = len(p)
+		}
+		b.buf = append(b.buf, p[:rest]...)
+	}
+	return len(p), nil
+}
+
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type bodyLogResponseWriter struct {
+	io.Writer
+	http.ResponseWriter
+}
+
+func (w *bodyLogResponseWriter) WriteHeader(code int) {
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *bodyLogResponseWriter) Write(b []byte) (int, error) {
+	return w.Writer.Write(b)
+}
+
+func (w *bodyLogResponseWriter) Flush() {
+	w.ResponseWriter.(http.Flusher).Flush()
+}
+
+func (w *bodyLogResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
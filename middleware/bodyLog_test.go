@@ -0,0 +1,60 @@
+package mw
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+func TestBodyLog(t *testing.T) {
+	type testCase struct {
+		name  string
+		limit int
+		req   string
+		res   string
+	}
+
+	cases := []testCase{
+		testCase{name: "short", limit: 10, req: "request", res: "response"},
+		testCase{name: "truncated", limit: 4, req: "requ", res: "resp"},
+	}
+
+	e := echo.New()
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader("request"))
+			res := httptest.NewRecorder()
+
+			c := e.NewContext(req, res)
+
+			var reqB, resB []byte
+			h := BodyLog(cs.limit, func(c echo.Context, q []byte, s []byte) {
+				reqB, resB = q, s
+			})(func(c echo.Context) error {
+				b, _ := ioutil.ReadAll(c.Request().Body)
+				if string(b) != "request" {
+					t.Errorf("expected full request body, got: %s", string(b))
+				}
+				return c.String(http.StatusOK, "response")
+			})
+
+			if err := h(c); err != nil {
+				t.Fatal(err)
+			}
+			if res.Body.String() != "response" {
+				t.Errorf("expected full response body, got: %s", res.Body.String())
+			}
+			if string(reqB) != cs.req {
+				t.Errorf("expected logged request '%s', got: '%s'", cs.req, string(reqB))
+			}
+			if string(resB) != cs.res {
+				t.Errorf("expected logged response '%s', got: '%s'", cs.res, string(resB))
+			}
+		})
+	}
+}
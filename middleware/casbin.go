@@ -18,6 +18,9 @@ type (
 
 		// ParamGetters functions to source parameters for Casbin evaluation
 		ParamGetters []func(echo.Context) interface{}
+
+		// BatchKey key of JSON-RPC batch in context, calls of batch are evaluated one by one
+		BatchKey string
 	}
 )
 
@@ -31,7 +34,8 @@ func ValueFromContext(key string) func(echo.Context) interface{} {
 var (
 	// defaultCasbinEnforcerConfig is the default CasbinEnforcer middleware config.
 	defaultCasbinEnforcerConfig = CasbinEnforcerConfig{
-		Skipper: middleware.DefaultSkipper,
+		Skipper:  middleware.DefaultSkipper,
+		BatchKey: "batch",
 	}
 )
 
@@ -39,6 +43,7 @@ var (
 //
 // For successful policy evaluation it calls the next handler.
 // For failed evaluation, it sends "403 - Fobidden" response.
+// Calls of JSON-RPC batch are evaluated one by one, denied calls get JSON-RPC error instead.
 func CasbinEnforcer(ce *casbin.Enforcer, g ...func(echo.Context) interface{}) echo.MiddlewareFunc {
 	c := defaultCasbinEnforcerConfig
 	c.Enforcer = ce
@@ -53,10 +58,23 @@ func CasbinEnforcerWithConfig(config CasbinEnforcerConfig) echo.MiddlewareFunc {
 	if config.Skipper == nil {
 		config.Skipper = defaultCasbinEnforcerConfig.Skipper
 	}
+	if config.BatchKey == "" {
+		config.BatchKey = defaultCasbinEnforcerConfig.BatchKey
+	}
 
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
-			if config.Skipper(c) || check(c, config) {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			if b, ok := c.Get(config.BatchKey).(*JSONRPCBatch); ok {
+				return b.AuthorizeEach(c, next, func(c echo.Context) bool {
+					return check(c, config)
+				})
+			}
+
+			if check(c, config) {
 				return next(c)
 			}
 
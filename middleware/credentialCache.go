@@ -0,0 +1,97 @@
+package mw
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// CredentialCache bounded cache of verified credentials, so expensive hashes are not computed on every request.
+// Credentials are stored as HMAC-SHA256 with random per-process secret, so plain keys are never kept. Each entry
+// is bound to hash it was verified against: it is not used once hash of client changes or client is removed.
+type CredentialCache struct {
+	// TTL of entries
+	TTL time.Duration
+
+	// MaxEntries max number of entries
+	MaxEntries int
+
+	mu      sync.Mutex
+	secret  []byte
+	entries map[[sha256.Size]byte]credentialEntry
+}
+
+type credentialEntry struct {
+	hash    string
+	expires time.Time
+}
+
+// NewCredentialCache returns empty cache
+func NewCredentialCache(ttl time.Duration, maxEntries int) *CredentialCache {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return &CredentialCache{TTL: ttl, MaxEntries: maxEntries, secret: secret, entries: map[[sha256.Size]byte]credentialEntry{}}
+}
+
+func (cc *CredentialCache) key(client, key string) [sha256.Size]byte {
+	var k [sha256.Size]byte
+	m := hmac.New(sha256.New, cc.secret)
+	m.Write([]byte(client))
+	m.Write([]byte{0})
+	m.Write([]byte(key))
+	copy(k[:], m.Sum(nil))
+	return k
+}
+
+// Verified reports whether key of client was verified against hash within TTL
+func (cc *CredentialCache) Verified(client, key, hash string, now time.Time) bool {
+	k := cc.key(client, key)
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	e, ok := cc.entries[k]
+	if !ok {
+		return false
+	}
+	if e.hash != hash || !now.Before(e.expires) {
+		delete(cc.entries, k)
+		return false
+	}
+	return true
+}
+
+// Add remembers key of client verified against hash. When cache is full, expired entries are removed and,
+// if it is still full, random entry is evicted.
+func (cc *CredentialCache) Add(client, key, hash string, now time.Time) {
+	k := cc.key(client, key)
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if _, ok := cc.entries[k]; !ok && len(cc.entries) >= cc.MaxEntries {
+		for ek, e := range cc.entries {
+			if !now.Before(e.expires) {
+				delete(cc.entries, ek)
+			}
+		}
+		for ek := range cc.entries {
+			if len(cc.entries) < cc.MaxEntries {
+				break
+			}
+			delete(cc.entries, ek)
+		}
+	}
+	cc.entries[k] = credentialEntry{hash: hash, expires: now.Add(cc.TTL)}
+}
+
+// Purge removes all entries
+func (cc *CredentialCache) Purge() {
+	cc.mu.Lock()
+	cc.entries = map[[sha256.Size]byte]credentialEntry{}
+	cc.mu.Unlock()
+}
@@ -0,0 +1,121 @@
+package mw
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/artyomturkin/nprxy/graphql"
+	"github.com/labstack/echo"
+)
+
+// graphQLRequest GraphQL over HTTP request parameters
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// parseGraphQLRequest reads GraphQL request from query string of GET request or from body of POST request
+// with application/json or application/graphql content. Body is restored for upstream.
+func parseGraphQLRequest(r *http.Request, limit int64) (*graphQLRequest, error) {
+	g := &graphQLRequest{}
+	if r.Method == http.MethodGet {
+		q := r.URL.Query()
+		g.Query = q.Get("query")
+		g.OperationName = q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &g.Variables); err != nil {
+				return nil, fmt.Errorf("invalid variables: %v", err)
+			}
+		}
+		return g, nil
+	}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, fmt.Errorf("GraphQL request body is empty")
+	}
+	body, err := ioutil.ReadAll(&limitedReader{R: r.Body, N: limit})
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get(echo.HeaderContentType)); mt == "application/graphql" {
+		g.Query = string(body)
+		g.OperationName = r.URL.Query().Get("operationName")
+		return g, nil
+	}
+	if err := json.Unmarshal(body, g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// resolveGraphQL parses GraphQL request, selects operation and checks its shape against limits of config.
+// Operation type and top-level fields are set in context.
+func resolveGraphQL(c echo.Context, config OperationResolverConfig) (string, error) {
+	g, err := parseGraphQLRequest(c.Request(), config.MaxBodyBytes)
+	if err != nil {
+		return "", err
+	}
+	if g.Query == "" {
+		return "", echo.NewHTTPError(http.StatusBadRequest, "query is not set")
+	}
+
+	d, err := graphql.Parse(g.Query)
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("invalid query: %v", err))
+	}
+	op, err := d.Operation(g.OperationName)
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if op.Type != "query" && c.Request().Method == http.MethodGet {
+		return "", echo.NewHTTPError(http.StatusMethodNotAllowed, fmt.Sprintf("%s is not allowed with GET", op.Type))
+	}
+
+	s, err := d.Analyze(op, g.Variables)
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if config.MaxDepth > 0 && s.Depth > config.MaxDepth {
+		return "", echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("query depth %d exceeds limit %d", s.Depth, config.MaxDepth))
+	}
+	if config.MaxAliases > 0 && s.Aliases > config.MaxAliases {
+		return "", echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("query has %d aliases, limit is %d", s.Aliases, config.MaxAliases))
+	}
+	if config.MaxComplexity > 0 && s.Complexity > config.MaxComplexity {
+		return "", echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("query complexity %d exceeds limit %d", s.Complexity, config.MaxComplexity))
+	}
+
+	c.Set(config.OperationTypeKey, op.Type)
+	c.Set(config.FieldsKey, strings.Join(s.Fields, ","))
+	return op.Name, nil
+}
+
+// GraphQLErrorHandler renders error as GraphQL response with errors list, keeping HTTP status code of error.
+func GraphQLErrorHandler(err error, c echo.Context) {
+	code := http.StatusInternalServerError
+	msg := http.StatusText(code)
+	if he, ok := err.(*echo.HTTPError); ok {
+		code = he.Code
+		msg = fmt.Sprintf("%v", he.Message)
+	}
+
+	if c.Response().Committed {
+		return
+	}
+	if c.Request().Method == http.MethodHead {
+		c.NoContent(code)
+		return
+	}
+	c.JSON(code, map[string]interface{}{
+		"errors": []map[string]interface{}{{"message": msg}},
+	})
+}
@@ -0,0 +1,103 @@
+package mw
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+func TestOperationResolverGraphQL(t *testing.T) {
+	type testCase struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		query       string
+		result      int
+		op          string
+		opType      string
+		fields      string
+	}
+
+	cases := []testCase{
+		testCase{name: "json", method: "POST", contentType: "application/json",
+			body:   `{"query":"query Q { b { id } a { id } }","operationName":"Q"}`,
+			result: 200, op: "Q", opType: "query", fields: "a,b"},
+		testCase{name: "graphql body", method: "POST", contentType: "application/graphql",
+			body:   `mutation M { add(x: 1) { id } }`,
+			result: 200, op: "M", opType: "mutation", fields: "add"},
+		testCase{name: "get", method: "GET", query: "{ me { id } }", result: 200, op: "", opType: "query", fields: "me"},
+		testCase{name: "mutation with get", method: "GET", query: "mutation { add }", result: 405},
+		testCase{name: "too deep", method: "POST", contentType: "application/json",
+			body: `{"query":"{ a { b { c { d } } } }"}`, result: 400},
+		testCase{name: "too many aliases", method: "POST", contentType: "application/json",
+			body: `{"query":"{ x: a y: a z: a }"}`, result: 400},
+		testCase{name: "too complex", method: "POST", contentType: "application/json",
+			body: `{"query":"query($n: Int) { a(first: $n) { b } }","variables":{"n":1000}}`, result: 400},
+		testCase{name: "syntax error", method: "POST", contentType: "application/json",
+			body: `{"query":"{ a "}`, result: 400},
+		testCase{name: "not json", method: "POST", contentType: "application/json", body: `{ a }`, result: 400},
+	}
+
+	e := echo.New()
+	m := OperationResolverWithConfig(OperationResolverConfig{Kind: "graphql", MaxDepth: 3, MaxAliases: 2, MaxComplexity: 100})
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			req := httptest.NewRequest(cs.method, "/?query="+url.QueryEscape(cs.query), strings.NewReader(cs.body))
+			req.Header.Set(echo.HeaderContentType, cs.contentType)
+			res := httptest.NewRecorder()
+
+			c := e.NewContext(req, res)
+
+			var op, opType, fields, body string
+			h := m(func(c echo.Context) error {
+				op = c.Get("operation").(string)
+				opType = c.Get("operationType").(string)
+				fields = c.Get("fields").(string)
+				b, _ := ioutil.ReadAll(c.Request().Body)
+				body = string(b)
+				return c.NoContent(http.StatusOK)
+			})
+
+			err := h(c)
+
+			if err != nil {
+				if errObj, ok := err.(*echo.HTTPError); ok {
+					if errObj.Code != cs.result {
+						t.Errorf("expected %d, got: %d", cs.result, errObj.Code)
+					}
+				} else {
+					t.Error(err)
+				}
+			} else if c.Response().Status != cs.result {
+				t.Errorf("expected %d, got: %d", cs.result, c.Response().Status)
+			} else if op != cs.op || opType != cs.opType || fields != cs.fields {
+				t.Errorf("expected operation '%s' '%s' '%s', got : '%s' '%s' '%s'", cs.op, cs.opType, cs.fields, op, opType, fields)
+			} else if body != cs.body {
+				t.Errorf("expected request body to be restored, got: %s", body)
+			}
+		})
+	}
+}
+
+func TestGraphQLErrorHandler(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest("POST", "/", nil)
+	res := httptest.NewRecorder()
+	c := e.NewContext(req, res)
+
+	GraphQLErrorHandler(echo.NewHTTPError(http.StatusBadRequest, "query depth 5 exceeds limit 3"), c)
+
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got: %d", res.Code)
+	}
+	if b := strings.TrimSpace(res.Body.String()); b != `{"errors":[{"message":"query depth 5 exceeds limit 3"}]}` {
+		t.Errorf("unexpected body: %s", b)
+	}
+}
@@ -0,0 +1,71 @@
+package mw
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+)
+
+// gRPC status codes, see https://github.com/grpc/grpc/blob/master/doc/statuscodes.md
+const (
+	GRPCUnknown           = 2
+	GRPCPermissionDenied  = 7
+	GRPCResourceExhausted = 8
+	GRPCUnimplemented     = 12
+	GRPCInternal          = 13
+	GRPCUnavailable       = 14
+	GRPCUnauthenticated   = 16
+)
+
+// GRPCStatus maps HTTP status code to gRPC status code, as defined by gRPC HTTP to gRPC status code mapping
+func GRPCStatus(code int) int {
+	switch code {
+	case http.StatusBadRequest:
+		return GRPCInternal
+	case http.StatusUnauthorized:
+		return GRPCUnauthenticated
+	case http.StatusForbidden:
+		return GRPCPermissionDenied
+	case http.StatusNotFound:
+		return GRPCUnimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return GRPCUnavailable
+	}
+	return GRPCUnknown
+}
+
+// GRPCErrorHandler renders error as gRPC status. Status is sent in Trailers-Only response,
+// that carries grpc-status and grpc-message in the only HEADERS frame of response.
+func GRPCErrorHandler(err error, c echo.Context) {
+	code := http.StatusInternalServerError
+	msg := http.StatusText(code)
+	if he, ok := err.(*echo.HTTPError); ok {
+		code = he.Code
+		msg = fmt.Sprintf("%v", he.Message)
+	}
+
+	res := c.Response()
+	if res.Committed {
+		return
+	}
+	res.Header().Set(echo.HeaderContentType, "application/grpc")
+	res.Header().Set("Grpc-Status", strconv.Itoa(GRPCStatus(code)))
+	res.Header().Set("Grpc-Message", encodeGRPCMessage(msg))
+	res.WriteHeader(http.StatusOK)
+}
+
+// encodeGRPCMessage percent-encodes message, as required for grpc-message header
+func encodeGRPCMessage(msg string) string {
+	const hex = "0123456789ABCDEF"
+	b := make([]byte, 0, len(msg))
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c < ' ' || c > '~' || c == '%' {
+			b = append(b, '%', hex[c>>4], hex[c&15])
+		} else {
+			b = append(b, c)
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,49 @@
+package mw
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+func TestGRPCErrorHandler(t *testing.T) {
+	type testCase struct {
+		name    string
+		err     error
+		status  int
+		message string
+	}
+
+	cases := []testCase{
+		testCase{name: "unauthenticated", err: echo.ErrUnauthorized, status: GRPCUnauthenticated, message: "Unauthorized"},
+		testCase{name: "permission denied", err: echo.ErrForbidden, status: GRPCPermissionDenied, message: "Forbidden"},
+		testCase{name: "encoded message", err: echo.NewHTTPError(502, "upstream 100% down"), status: GRPCUnavailable, message: "upstream 100%25 down"},
+	}
+
+	e := echo.New()
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/helloworld.Greeter/SayHello", nil)
+			res := httptest.NewRecorder()
+
+			c := e.NewContext(req, res)
+			GRPCErrorHandler(cs.err, c)
+
+			if res.Code != 200 {
+				t.Errorf("expected HTTP status 200, got: %d", res.Code)
+			}
+			if s := res.Header().Get("Grpc-Status"); s != strconv.Itoa(cs.status) {
+				t.Errorf("expected grpc-status %d, got: %s", cs.status, s)
+			}
+			if m := res.Header().Get("Grpc-Message"); m != cs.message {
+				t.Errorf("expected grpc-message '%s', got: '%s'", cs.message, m)
+			}
+			if res.Body.Len() != 0 {
+				t.Errorf("expected empty body, got: %d bytes", res.Body.Len())
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package mw
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+type (
+	// HMACAuthConfig defines the config for HMACAuth middleware.
+	HMACAuthConfig struct {
+		// Skipper defines a function to skip middleware.
+		Skipper middleware.Skipper
+
+		// Secrets system - shared secret pairs to check signatures against
+		Secrets map[string]string
+
+		// Store of clients with HMAC secrets, replaces Secrets
+		Store *KeyStore
+
+		// RequiredHeaders headers that must be signed, e.g. host
+		RequiredHeaders []string
+
+		// MaxClockSkew max difference between local time and timestamp of request
+		MaxClockSkew time.Duration
+
+		// MaxBodyBytes limits size of request body
+		MaxBodyBytes int64
+
+		// ContextKey key to output client if authenticated
+		ContextKey string
+
+		// FingerprintKey key to output fingerprint of secret of client, see KeyFingerprint
+		FingerprintKey string
+	}
+)
+
+// HMAC signature scheme and headers
+const (
+	HMACScheme          = "NPRXY-HMAC-SHA256"
+	HMACTimestampHeader = "X-NPRXY-Timestamp"
+	HMACNonceHeader     = "X-NPRXY-Nonce"
+)
+
+var (
+	// defaultHMACAuthConfig is the default HMACAuth middleware config.
+	defaultHMACAuthConfig = HMACAuthConfig{
+		Skipper:      middleware.DefaultSkipper,
+		Secrets:      map[string]string{},
+		MaxClockSkew: 5 * time.Minute,
+		MaxBodyBytes: 1 << 20,
+		ContextKey:   "client",
+
+		FingerprintKey: "client.key",
+	}
+)
+
+// HMACAuth returns a HMACAuth middleware.
+//
+// Client signs request with shared secret and sends signature in Authorization header:
+//
+//	Authorization: NPRXY-HMAC-SHA256 Client=<client>, SignedHeaders=<header;...>, Signature=<base64>
+//
+// Signature is HMAC-SHA256 of string to sign, lines separated with `\n`: scheme, method, escaped path with query,
+// X-NPRXY-Timestamp (unix seconds), X-NPRXY-Nonce, `name:value` of each signed header in lower case and sorted
+// by name, hex SHA-256 of body. Timestamp must be within clock skew and each nonce is accepted once.
+func HMACAuth(secrets map[string]string) echo.MiddlewareFunc {
+	c := defaultHMACAuthConfig
+	c.Secrets = secrets
+	return HMACAuthWithConfig(c)
+}
+
+// HMACAuthWithConfig returns a HMACAuth middleware with config.
+// See `HMACAuth()`.
+func HMACAuthWithConfig(config HMACAuthConfig) echo.MiddlewareFunc {
+	// Defaults
+	if config.Skipper == nil {
+		config.Skipper = defaultHMACAuthConfig.Skipper
+	}
+	if config.Secrets == nil {
+		config.Secrets = map[string]string{}
+	}
+	if config.MaxClockSkew == 0 {
+		config.MaxClockSkew = defaultHMACAuthConfig.MaxClockSkew
+	}
+	if config.MaxBodyBytes == 0 {
+		config.MaxBodyBytes = defaultHMACAuthConfig.MaxBodyBytes
+	}
+	if config.ContextKey == "" {
+		config.ContextKey = defaultHMACAuthConfig.ContextKey
+	}
+	if config.FingerprintKey == "" {
+		config.FingerprintKey = defaultHMACAuthConfig.FingerprintKey
+	}
+
+	// Request is accepted within skew of its timestamp, nonce must be remembered for both sides of the window
+	nonces := newNonceCache(2 * config.MaxClockSkew)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			req := c.Request()
+			var body []byte
+			if req.Body != nil && req.Body != http.NoBody {
+				var err error
+				body, err = ioutil.ReadAll(&limitedReader{R: req.Body, N: config.MaxBodyBytes})
+				req.Body.Close()
+				if err != nil {
+					return echo.NewHTTPError(http.StatusRequestEntityTooLarge)
+				}
+				req.Body = ioutil.NopCloser(bytes.NewReader(body))
+			}
+
+			client, secret, err := verifyHMAC(req, body, config, nonces, time.Now())
+			if err != nil {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, HMACScheme)
+				return echo.ErrUnauthorized
+			}
+			c.Set(config.FingerprintKey, KeyFingerprint(secret))
+			c.Set(config.ContextKey, client)
+			return next(c)
+		}
+	}
+}
+
+// verifyHMAC checks signature, timestamp and nonce of request and returns client and its secret
+func verifyHMAC(req *http.Request, body []byte, config HMACAuthConfig, nonces *nonceCache, now time.Time) (string, string, error) {
+	params, ok := hmacParams(req.Header.Get(echo.HeaderAuthorization))
+	if !ok {
+		return "", "", fmt.Errorf("no %s authorization", HMACScheme)
+	}
+	client := params["Client"]
+	secret := config.Secrets[client]
+	if config.Store != nil {
+		secret = ""
+		if k := config.Store.Client(client, remoteIP(req)); k != nil {
+			secret = k.HMAC
+		}
+	}
+	if secret == "" {
+		return "", "", fmt.Errorf("unknown client %s", client)
+	}
+	signature, err := base64.StdEncoding.DecodeString(params["Signature"])
+	if err != nil {
+		return "", "", fmt.Errorf("invalid signature encoding")
+	}
+
+	var headers []string
+	if params["SignedHeaders"] != "" {
+		headers = strings.Split(strings.ToLower(params["SignedHeaders"]), ";")
+	}
+	for _, h := range config.RequiredHeaders {
+		if !contains(headers, strings.ToLower(h)) {
+			return "", "", fmt.Errorf("header %s is not signed", h)
+		}
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(hmacStringToSign(req, headers, body)))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return "", "", fmt.Errorf("signature mismatch")
+	}
+
+	ts, err := strconv.ParseInt(req.Header.Get(HMACTimestampHeader), 10, 64)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid timestamp")
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > config.MaxClockSkew || d < -config.MaxClockSkew {
+		return "", "", fmt.Errorf("timestamp is outside of clock skew")
+	}
+	nonce := req.Header.Get(HMACNonceHeader)
+	if nonce == "" {
+		return "", "", fmt.Errorf("no nonce")
+	}
+	if !nonces.add(client+"\x00"+nonce, now) {
+		return "", "", fmt.Errorf("nonce was already used")
+	}
+	return client, secret, nil
+}
+
+// hmacParams parses comma separated key=value parameters of Authorization header with HMAC scheme,
+// scheme is case-insensitive
+func hmacParams(auth string) (map[string]string, bool) {
+	parts := strings.SplitN(auth, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], HMACScheme) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for _, p := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) != 2 {
+			return nil, false
+		}
+		params[kv[0]] = kv[1]
+	}
+	return params, true
+}
+
+// hmacStringToSign builds canonical string of request that is signed by client
+func hmacStringToSign(req *http.Request, headers []string, body []byte) string {
+	path := req.URL.EscapedPath()
+	if req.URL.RawQuery != "" {
+		path += "?" + req.URL.RawQuery
+	}
+	lines := []string{HMACScheme, req.Method, path, req.Header.Get(HMACTimestampHeader), req.Header.Get(HMACNonceHeader)}
+
+	sorted := append([]string(nil), headers...)
+	sort.Strings(sorted)
+	for _, h := range sorted {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.Host
+		}
+		lines = append(lines, h+":"+strings.TrimSpace(v))
+	}
+
+	digest := sha256.Sum256(body)
+	lines = append(lines, hex.EncodeToString(digest[:]))
+	return strings.Join(lines, "\n")
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package mw
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+func signHMAC(req *http.Request, client, secret string, headers []string, body string, ts time.Time, nonce string) {
+	req.Header.Set(HMACTimestampHeader, strconv.FormatInt(ts.Unix(), 10))
+	req.Header.Set(HMACNonceHeader, nonce)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(hmacStringToSign(req, headers, []byte(body))))
+	req.Header.Set(echo.HeaderAuthorization, HMACScheme+" Client="+client+", SignedHeaders="+strings.Join(headers, ";")+
+		", Signature="+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+func TestHMACAuth(t *testing.T) {
+	type testCase struct {
+		name   string
+		sign   func(req *http.Request)
+		body   string
+		result int
+	}
+
+	now := time.Now()
+	headers := []string{"host", "content-type"}
+	sign := func(client, secret, body string, ts time.Time, nonce string) func(req *http.Request) {
+		return func(req *http.Request) {
+			signHMAC(req, client, secret, headers, body, ts, nonce)
+		}
+	}
+
+	cases := []testCase{
+		testCase{name: "valid", body: `{"id": 1}`, sign: sign("test-system", "secret", `{"id": 1}`, now, "1"), result: 200},
+		testCase{name: "replay", body: `{"id": 1}`, sign: sign("test-system", "secret", `{"id": 1}`, now, "1"), result: 401},
+		testCase{name: "nonce of other client", body: `{"id": 1}`, sign: sign("other-system", "other", `{"id": 1}`, now, "1"), result: 200},
+		testCase{name: "within skew", sign: sign("test-system", "secret", "", now.Add(-4*time.Minute), "2"), result: 200},
+		testCase{name: "old", sign: sign("test-system", "secret", "", now.Add(-6*time.Minute), "3"), result: 401},
+		testCase{name: "future", sign: sign("test-system", "secret", "", now.Add(6*time.Minute), "4"), result: 401},
+		testCase{name: "wrong secret", sign: sign("test-system", "other", "", now, "5"), result: 401},
+		testCase{name: "unknown client", sign: sign("unknown", "secret", "", now, "6"), result: 401},
+		testCase{name: "modified body", body: `{"id": 2}`, sign: sign("test-system", "secret", `{"id": 1}`, now, "7"), result: 401},
+		testCase{
+			name: "modified path", result: 401,
+			sign: func(req *http.Request) {
+				signHMAC(req, "test-system", "secret", headers, "", now, "8")
+				req.URL.Path = "/admin"
+			},
+		},
+		testCase{
+			name: "modified signed header", result: 401,
+			sign: func(req *http.Request) {
+				signHMAC(req, "test-system", "secret", headers, "", now, "9")
+				req.Header.Set(echo.HeaderContentType, echo.MIMETextPlain)
+			},
+		},
+		testCase{
+			name: "required header not signed", result: 401,
+			sign: func(req *http.Request) {
+				signHMAC(req, "test-system", "secret", []string{"content-type"}, "", now, "10")
+			},
+		},
+		testCase{
+			name: "no nonce", result: 401,
+			sign: func(req *http.Request) {
+				signHMAC(req, "test-system", "secret", headers, "", now, "")
+			},
+		},
+		testCase{
+			name: "lower case scheme", result: 200,
+			sign: func(req *http.Request) {
+				signHMAC(req, "test-system", "secret", headers, "", now, "12")
+				auth := req.Header.Get(echo.HeaderAuthorization)
+				req.Header.Set(echo.HeaderAuthorization, strings.ToLower(HMACScheme)+auth[len(HMACScheme):])
+			},
+		},
+		testCase{name: "no signature", sign: func(req *http.Request) {}, result: 401},
+		testCase{name: "too large", body: strings.Repeat("x", 101), sign: sign("test-system", "secret", strings.Repeat("x", 101), now, "11"), result: 413},
+	}
+
+	e := echo.New()
+	h := HMACAuthWithConfig(HMACAuthConfig{
+		Secrets:         map[string]string{"test-system": "secret", "other-system": "other"},
+		RequiredHeaders: []string{"Host"},
+		MaxBodyBytes:    100,
+	})(func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Get("client").(string))
+	})
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/orders?limit=1", strings.NewReader(cs.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			cs.sign(req)
+			res := httptest.NewRecorder()
+			c := e.NewContext(req, res)
+
+			err := h(c)
+			if he, ok := err.(*echo.HTTPError); ok {
+				res.Code = he.Code
+			}
+			if res.Code != cs.result {
+				t.Errorf("expected %d, got %d", cs.result, res.Code)
+			}
+		})
+	}
+}
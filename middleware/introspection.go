@@ -0,0 +1,282 @@
+package mw
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+type (
+	// IntrospectionConfig defines the config for Introspection middleware.
+	IntrospectionConfig struct {
+		// Skipper defines a function to skip middleware.
+		Skipper middleware.Skipper
+
+		// Endpoint URL of RFC 7662 introspection endpoint
+		// Required.
+		Endpoint string
+
+		// ClientID id of proxy at authorization server
+		ClientID string
+
+		// ClientSecret secret of proxy at authorization server
+		ClientSecret string
+
+		// Client HTTP client to call endpoint
+		Client *http.Client
+
+		// CacheTTL max time active token is cached, so revoked tokens are rejected eventually
+		CacheTTL time.Duration
+
+		// NegativeCacheTTL time inactive token is cached
+		NegativeCacheTTL time.Duration
+
+		// CacheSize max number of cached results
+		CacheSize int
+
+		// ScopeKey key to output scope of token
+		ScopeKey string
+
+		// ContextKey key to output client if authenticated
+		ContextKey string
+
+		// Logger for failed calls of endpoint
+		Logger logrus.FieldLogger
+	}
+
+	// introspection result of token
+	introspection struct {
+		Active   bool    `json:"active"`
+		Sub      string  `json:"sub"`
+		ClientID string  `json:"client_id"`
+		Scope    string  `json:"scope"`
+		Exp      float64 `json:"exp"`
+		Nbf      float64 `json:"nbf"`
+
+		expires time.Time
+	}
+)
+
+var (
+	// defaultIntrospectionConfig is the default Introspection middleware config.
+	defaultIntrospectionConfig = IntrospectionConfig{
+		Skipper:          middleware.DefaultSkipper,
+		Client:           &http.Client{Timeout: 10 * time.Second},
+		CacheTTL:         5 * time.Minute,
+		NegativeCacheTTL: time.Minute,
+		CacheSize:        10000,
+		ScopeKey:         "claim.scope",
+		ContextKey:       "client",
+	}
+)
+
+// Introspection returns an Introspection middleware.
+//
+// Opaque `Authorization: Bearer` token is checked by introspection endpoint, authenticated with client credentials.
+// Client is `sub` of active token or `client_id` if token has no subject, scope is set with ScopeKey.
+// Results are cached until token expires, but no longer than cache TTL, inactive tokens for negative cache TTL.
+// When cache is full, inactive results are evicted before active ones. Requests are rejected with
+// "503 - Service Unavailable" when endpoint fails, such results are not cached.
+func Introspection(endpoint, clientID, clientSecret string) echo.MiddlewareFunc {
+	c := defaultIntrospectionConfig
+	c.Endpoint = endpoint
+	c.ClientID = clientID
+	c.ClientSecret = clientSecret
+	return IntrospectionWithConfig(c)
+}
+
+// IntrospectionWithConfig returns an Introspection middleware with config.
+// See `Introspection()`.
+func IntrospectionWithConfig(config IntrospectionConfig) echo.MiddlewareFunc {
+	// Defaults
+	if config.Skipper == nil {
+		config.Skipper = defaultIntrospectionConfig.Skipper
+	}
+	if config.Client == nil {
+		config.Client = defaultIntrospectionConfig.Client
+	}
+	if config.CacheTTL == 0 {
+		config.CacheTTL = defaultIntrospectionConfig.CacheTTL
+	}
+	if config.NegativeCacheTTL == 0 {
+		config.NegativeCacheTTL = defaultIntrospectionConfig.NegativeCacheTTL
+	}
+	if config.CacheSize == 0 {
+		config.CacheSize = defaultIntrospectionConfig.CacheSize
+	}
+	if config.ScopeKey == "" {
+		config.ScopeKey = defaultIntrospectionConfig.ScopeKey
+	}
+	if config.ContextKey == "" {
+		config.ContextKey = defaultIntrospectionConfig.ContextKey
+	}
+	if config.Logger == nil {
+		config.Logger = logrus.StandardLogger()
+	}
+	if config.Endpoint == "" {
+		panic("echo: introspection middleware requires endpoint")
+	}
+
+	cache := &introspectionCache{max: config.CacheSize, results: map[[sha256.Size]byte]*introspection{}}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			token := bearerToken(c.Request())
+			if token == "" {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+				return echo.ErrUnauthorized
+			}
+
+			now := time.Now()
+			// tokens are cached by hash, so cache does not hold credentials
+			key := sha256.Sum256([]byte(token))
+			r := cache.get(key, now)
+			if r == nil {
+				var err error
+				if r, err = introspect(config, token); err != nil {
+					config.Logger.WithError(err).Error("token introspection failed")
+					return echo.NewHTTPError(http.StatusServiceUnavailable)
+				}
+				r.expires = now.Add(config.NegativeCacheTTL)
+				if r.valid(now) {
+					r.expires = now.Add(config.CacheTTL)
+					if exp := time.Unix(int64(r.Exp), 0); r.Exp > 0 && exp.Before(r.expires) {
+						r.expires = exp
+					}
+				}
+				cache.put(key, r, now)
+			}
+
+			if !r.valid(now) {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+				return echo.ErrUnauthorized
+			}
+			c.Set(config.ScopeKey, r.Scope)
+			c.Set(config.ContextKey, r.client())
+			return next(c)
+		}
+	}
+}
+
+// introspect calls endpoint with token
+func introspect(config IntrospectionConfig, token string) (*introspection, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequest(http.MethodPost, config.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+	if config.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(config.ClientID), url.QueryEscape(config.ClientSecret))
+	}
+
+	res, err := config.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("endpoint returned %s", res.Status)
+	}
+	body, err := ioutil.ReadAll(&limitedReader{R: res.Body, N: 1 << 20})
+	if err != nil {
+		return nil, err
+	}
+	r := &introspection{}
+	if err := json.Unmarshal(body, r); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+	return r, nil
+}
+
+// valid reports whether token is active and within its lifetime
+func (r *introspection) valid(now time.Time) bool {
+	unix := float64(now.Unix())
+	if !r.Active || r.client() == "" {
+		return false
+	}
+	return (r.Exp == 0 || unix < r.Exp) && (r.Nbf == 0 || unix >= r.Nbf)
+}
+
+func (r *introspection) client() string {
+	if r.Sub != "" {
+		return r.Sub
+	}
+	return r.ClientID
+}
+
+// introspectionCache remembers results of introspection until they expire
+type introspectionCache struct {
+	sync.Mutex
+	max     int
+	results map[[sha256.Size]byte]*introspection
+	// purge time of next removal of expired results
+	purge time.Time
+}
+
+func (i *introspectionCache) get(key [sha256.Size]byte, now time.Time) *introspection {
+	i.Lock()
+	defer i.Unlock()
+
+	if r, ok := i.results[key]; ok && now.Before(r.expires) {
+		return r
+	}
+	return nil
+}
+
+func (i *introspectionCache) put(key [sha256.Size]byte, r *introspection, now time.Time) {
+	i.Lock()
+	defer i.Unlock()
+
+	if now.After(i.purge) {
+		for k, r := range i.results {
+			if !now.Before(r.expires) {
+				delete(i.results, k)
+			}
+		}
+		i.purge = now.Add(time.Minute)
+	}
+	if _, ok := i.results[key]; !ok && len(i.results) >= i.max {
+		i.evict(now)
+	}
+	i.results[key] = r
+}
+
+// evict removes expired results and, if cache is still full, inactive results and then random results,
+// so flood of unknown tokens does not push out active ones
+func (i *introspectionCache) evict(now time.Time) {
+	for k, r := range i.results {
+		if !now.Before(r.expires) {
+			delete(i.results, k)
+		}
+	}
+	for k, r := range i.results {
+		if len(i.results) < i.max {
+			return
+		}
+		if !r.valid(now) {
+			delete(i.results, k)
+		}
+	}
+	for k := range i.results {
+		if len(i.results) < i.max {
+			return
+		}
+		delete(i.results, k)
+	}
+}
@@ -0,0 +1,132 @@
+package mw
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+func TestIntrospection(t *testing.T) {
+	type testCase struct {
+		name   string
+		token  string
+		result int
+		client string
+		scope  string
+		calls  int32
+	}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	responses := map[string]string{
+		"user":     fmt.Sprintf(`{"active": true, "sub": "alice", "client_id": "app", "scope": "orders:read", "exp": %d}`, exp),
+		"service":  `{"active": true, "client_id": "test-system", "scope": "orders:read orders:write"}`,
+		"inactive": `{"active": false}`,
+		"expired":  fmt.Sprintf(`{"active": true, "sub": "alice", "exp": %d}`, time.Now().Add(-time.Minute).Unix()),
+		"invalid":  `{"active": "yes"}`,
+	}
+
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if id, secret, _ := r.BasicAuth(); id != "nprxy" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		res, ok := responses[r.PostFormValue("token")]
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		w.Write([]byte(res))
+	}))
+	defer ts.Close()
+
+	cases := []testCase{
+		testCase{name: "user", token: "user", result: 200, client: "alice", scope: "orders:read", calls: 1},
+		testCase{name: "cached", token: "user", result: 200, client: "alice", scope: "orders:read", calls: 0},
+		testCase{name: "client credentials", token: "service", result: 200, client: "test-system", scope: "orders:read orders:write", calls: 1},
+		testCase{name: "no token", result: 401, calls: 0},
+		testCase{name: "inactive", token: "inactive", result: 401, calls: 1},
+		testCase{name: "inactive cached", token: "inactive", result: 401, calls: 0},
+		testCase{name: "expired", token: "expired", result: 401, calls: 1},
+		testCase{name: "endpoint error", token: "unknown", result: 503, calls: 1},
+		testCase{name: "endpoint error not cached", token: "unknown", result: 503, calls: 1},
+		testCase{name: "invalid response", token: "invalid", result: 503, calls: 1},
+	}
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	e := echo.New()
+	h := IntrospectionWithConfig(IntrospectionConfig{
+		Endpoint:     ts.URL,
+		ClientID:     "nprxy",
+		ClientSecret: "secret",
+		Logger:       logger,
+	})(func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Get("client").(string)+"|"+c.Get("claim.scope").(string))
+	})
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if cs.token != "" {
+				req.Header.Set("Authorization", "Bearer "+cs.token)
+			}
+			res := httptest.NewRecorder()
+			c := e.NewContext(req, res)
+
+			before := atomic.LoadInt32(&calls)
+			err := h(c)
+			if he, ok := err.(*echo.HTTPError); ok {
+				res.Code = he.Code
+			}
+			if res.Code != cs.result {
+				t.Fatalf("expected %d, got %d", cs.result, res.Code)
+			}
+			if n := atomic.LoadInt32(&calls) - before; n != cs.calls {
+				t.Errorf("expected %d calls of endpoint, got %d", cs.calls, n)
+			}
+			if cs.client != "" && res.Body.String() != cs.client+"|"+cs.scope {
+				t.Errorf("expected %s|%s, got %s", cs.client, cs.scope, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestIntrospectionCache(t *testing.T) {
+	now := time.Now()
+	active := func() *introspection {
+		return &introspection{Active: true, Sub: "alice", expires: now.Add(time.Minute)}
+	}
+	inactive := func() *introspection {
+		return &introspection{expires: now.Add(time.Minute)}
+	}
+
+	cache := &introspectionCache{max: 2, results: map[[sha256.Size]byte]*introspection{}}
+	cache.put([sha256.Size]byte{1}, active(), now)
+	for i := byte(2); i < 10; i++ {
+		cache.put([sha256.Size]byte{i}, inactive(), now)
+	}
+	if len(cache.results) != 2 {
+		t.Errorf("expected cache to be bounded, got %d results", len(cache.results))
+	}
+	if cache.get([sha256.Size]byte{1}, now) == nil {
+		t.Error("expected inactive results to be evicted before active one")
+	}
+
+	cache.put([sha256.Size]byte{10}, active(), now)
+	cache.put([sha256.Size]byte{11}, active(), now)
+	if len(cache.results) != 2 || cache.get([sha256.Size]byte{11}, now) == nil {
+		t.Errorf("expected the last result to be cached, got %d results", len(cache.results))
+	}
+}
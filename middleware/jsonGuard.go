@@ -0,0 +1,262 @@
+package mw
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/artyomturkin/nprxy/jsonschema"
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+type (
+	// JSONGuardConfig defines the config for JSONGuard middleware.
+	JSONGuardConfig struct {
+		// Skipper defines a function to skip middleware.
+		Skipper middleware.Skipper
+
+		// MaxBytes max size of document
+		MaxBytes int64
+
+		// MaxDepth max nesting of objects and arrays
+		MaxDepth int
+
+		// MaxArrayLength max number of items of array
+		MaxArrayLength int
+
+		// MaxStringLength max size of string value or key, bytes
+		MaxStringLength int
+
+		// MaxKeys max number of keys of object
+		MaxKeys int
+
+		// Schemas JSON Schemas of request body by operation
+		Schemas map[string]*jsonschema.Schema
+
+		// OperationKey key of operation set by OperationResolver
+		OperationKey string
+
+		// UncheckedMediaTypes media types of request bodies passed without checks, e.g. multipart/form-data
+		// of uploads. Bodies of operations with schema must be JSON.
+		UncheckedMediaTypes []string
+	}
+
+	// JSONError error of invalid JSON request with pointers to invalid values
+	JSONError struct {
+		Message string                 `json:"message"`
+		Errors  []jsonschema.Violation `json:"errors"`
+	}
+)
+
+var (
+	// defaultJSONGuardConfig is the default JSONGuard middleware config.
+	defaultJSONGuardConfig = JSONGuardConfig{
+		Skipper:         middleware.DefaultSkipper,
+		MaxBytes:        4 << 20,
+		MaxDepth:        64,
+		MaxArrayLength:  10000,
+		MaxStringLength: 1 << 20,
+		MaxKeys:         1000,
+		OperationKey:    "operation",
+	}
+)
+
+func (e *JSONError) String() string {
+	return e.Message
+}
+
+// JSONGuard returns a JSONGuard middleware.
+//
+// JSON request body is streamed to check that it is single JSON value within limits, then it is validated
+// against schema of operation, if there is one. Violations are rejected with "400 - Bad Request" and JSONError,
+// that lists JSON pointers to invalid values. Requests without body are not checked, bodies of other content
+// types are rejected with "415 - Unsupported Media Type", unless they are listed in UncheckedMediaTypes.
+func JSONGuard(schemas map[string]*jsonschema.Schema) echo.MiddlewareFunc {
+	c := defaultJSONGuardConfig
+	c.Schemas = schemas
+	return JSONGuardWithConfig(c)
+}
+
+// JSONGuardWithConfig returns a JSONGuard middleware with config.
+// See `JSONGuard()`.
+func JSONGuardWithConfig(config JSONGuardConfig) echo.MiddlewareFunc {
+	// Defaults
+	if config.Skipper == nil {
+		config.Skipper = defaultJSONGuardConfig.Skipper
+	}
+	if config.MaxBytes == 0 {
+		config.MaxBytes = defaultJSONGuardConfig.MaxBytes
+	}
+	if config.MaxDepth == 0 {
+		config.MaxDepth = defaultJSONGuardConfig.MaxDepth
+	}
+	if config.MaxArrayLength == 0 {
+		config.MaxArrayLength = defaultJSONGuardConfig.MaxArrayLength
+	}
+	if config.MaxStringLength == 0 {
+		config.MaxStringLength = defaultJSONGuardConfig.MaxStringLength
+	}
+	if config.MaxKeys == 0 {
+		config.MaxKeys = defaultJSONGuardConfig.MaxKeys
+	}
+	if config.OperationKey == "" {
+		config.OperationKey = defaultJSONGuardConfig.OperationKey
+	}
+	unchecked := map[string]bool{}
+	for _, mt := range config.UncheckedMediaTypes {
+		unchecked[strings.ToLower(mt)] = true
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			req := c.Request()
+			if req.Body == nil || req.Body == http.NoBody {
+				return next(c)
+			}
+			op, _ := c.Get(config.OperationKey).(string)
+			mt, _, _ := mime.ParseMediaType(req.Header.Get(echo.HeaderContentType))
+			if unchecked[mt] && config.Schemas[op] == nil {
+				return next(c)
+			}
+			body, err := ioutil.ReadAll(&limitedReader{R: req.Body, N: config.MaxBytes})
+			req.Body.Close()
+			if err != nil {
+				return echo.NewHTTPError(http.StatusRequestEntityTooLarge)
+			}
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+			if len(bytes.TrimSpace(body)) == 0 {
+				return next(c)
+			}
+			if mt != echo.MIMEApplicationJSON && !strings.HasSuffix(mt, "+json") {
+				return echo.NewHTTPError(http.StatusUnsupportedMediaType, "request body must be JSON")
+			}
+
+			if v := checkJSON(body, config); v != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, &JSONError{Message: "invalid JSON", Errors: []jsonschema.Violation{*v}})
+			}
+
+			if s := config.Schemas[op]; s != nil {
+				var v interface{}
+				if err := json.Unmarshal(body, &v); err != nil {
+					return echo.NewHTTPError(http.StatusBadRequest, "invalid JSON: "+err.Error())
+				}
+				if vs := s.Validate(v); len(vs) > 0 {
+					return echo.NewHTTPError(http.StatusBadRequest, &JSONError{Message: "invalid request", Errors: vs})
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+// checkJSON streams tokens of document and returns first violation of limits of config
+func checkJSON(doc []byte, config JSONGuardConfig) *jsonschema.Violation {
+	// frame is object or array being read, key and index point to its current member
+	type frame struct {
+		array     bool
+		index     int
+		key       string
+		keys      int
+		expectKey bool
+	}
+	var stack []*frame
+	pointer := func(frames []*frame) string {
+		var b strings.Builder
+		for _, f := range frames {
+			if f.array {
+				b.WriteString("/" + strconv.Itoa(f.index))
+			} else {
+				b.WriteString("/" + jsonschema.Escape(f.key))
+			}
+		}
+		return b.String()
+	}
+	violation := func(p, format string, args ...interface{}) *jsonschema.Violation {
+		return &jsonschema.Violation{Pointer: p, Message: fmt.Sprintf(format, args...)}
+	}
+	// done advances parent past completed value
+	done := func() {
+		if len(stack) == 0 {
+			return
+		}
+		if top := stack[len(stack)-1]; top.array {
+			top.index++
+		} else {
+			top.expectKey = true
+		}
+	}
+
+	d := json.NewDecoder(bytes.NewReader(doc))
+	d.UseNumber()
+	values := 0
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return violation(pointer(stack), "%v", err)
+		}
+
+		var top *frame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+		if top != nil && !top.array && top.expectKey {
+			if t == json.Delim('}') {
+				stack = stack[:len(stack)-1]
+				done()
+				continue
+			}
+			key, _ := t.(string)
+			top.key, top.keys, top.expectKey = key, top.keys+1, false
+			if top.keys > config.MaxKeys {
+				return violation(pointer(stack[:len(stack)-1]), "object has more than %d keys", config.MaxKeys)
+			}
+			if len(key) > config.MaxStringLength {
+				return violation(pointer(stack), "key exceeds %d bytes", config.MaxStringLength)
+			}
+			continue
+		}
+		if t == json.Delim(']') {
+			stack = stack[:len(stack)-1]
+			done()
+			continue
+		}
+
+		if top == nil {
+			if values++; values > 1 {
+				return violation("", "unexpected data after JSON value")
+			}
+		} else if top.array && top.index >= config.MaxArrayLength {
+			return violation(pointer(stack[:len(stack)-1]), "array has more than %d items", config.MaxArrayLength)
+		}
+		p := pointer(stack)
+		switch t := t.(type) {
+		case json.Delim:
+			if len(stack)+1 > config.MaxDepth {
+				return violation(p, "depth exceeds %d", config.MaxDepth)
+			}
+			stack = append(stack, &frame{array: t == json.Delim('['), expectKey: true})
+		case string:
+			if len(t) > config.MaxStringLength {
+				return violation(p, "string exceeds %d bytes", config.MaxStringLength)
+			}
+			done()
+		default:
+			done()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package mw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+const jsonGuardOpenAPI = `openapi: 3.0.0
+paths:
+  /orders:
+    parameters: []
+    post:
+      operationId: createOrder
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/Order'
+  /orders/{id}:
+    put:
+      operationId: updateOrder
+      requestBody:
+        $ref: '#/components/requestBodies/Order'
+components:
+  requestBodies:
+    Order:
+      content:
+        application/json:
+          schema:
+            $ref: '#/components/schemas/Order'
+  schemas:
+    Order:
+      type: object
+      required: [sku]
+      properties:
+        sku: {type: string}
+        qty: {type: integer, minimum: 1}
+`
+
+func TestJSONGuard(t *testing.T) {
+	type testCase struct {
+		name        string
+		operation   string
+		contentType string
+		body        string
+		result      int
+		errors      string
+	}
+
+	cases := []testCase{
+		testCase{name: "valid", body: `{"a": [1, 2, {"b": "text"}]}`, result: 200},
+		testCase{name: "not json", contentType: "text/plain", body: `{{{`, result: 415},
+		testCase{name: "form", contentType: echo.MIMEApplicationForm, body: `a=1`, result: 415},
+		testCase{name: "no content type", contentType: "none", body: `[[[[[[1]]]]]]`, result: 415},
+		testCase{name: "empty body of other type", contentType: "text/plain", result: 200},
+		testCase{name: "unchecked type", contentType: "multipart/form-data; boundary=b", body: `--b--`, result: 200},
+		testCase{name: "unchecked type of operation with schema", operation: "createOrder", contentType: "multipart/form-data; boundary=b", body: `--b--`, result: 415},
+		testCase{name: "syntax", body: `{"a": [1, }`, result: 400, errors: `"pointer":"/a/1"`},
+		testCase{name: "depth", body: `{"a": [[{"b": 1}]]}`, result: 400, errors: `"pointer":"/a/0/0","message":"depth exceeds 3"`},
+		testCase{name: "array length", body: `{"a/b": [1, 2, 3, 4]}`, result: 400, errors: `"pointer":"/a~1b","message":"array has more than 3 items"`},
+		testCase{name: "string length", body: `{"a": ["x", "0123456789"]}`, result: 400, errors: `"pointer":"/a/1","message":"string exceeds 8 bytes"`},
+		testCase{name: "key length", body: `{"0123456789": 1}`, result: 400, errors: `"pointer":"/0123456789","message":"key exceeds 8 bytes"`},
+		testCase{name: "keys", body: `[{"a": 1, "b": 2, "c": 3, "d": 4}]`, result: 400, errors: `"pointer":"/0","message":"object has more than 3 keys"`},
+		testCase{name: "trailing data", contentType: "application/merge-patch+json", body: `{} {}`, result: 400, errors: `"pointer":""`},
+		testCase{name: "too large", body: `[` + strings.Repeat(`"x",`, 50) + `"x"]`, result: 413},
+		testCase{name: "schema", operation: "createOrder", body: `{"sku": "A-1", "qty": 1}`, result: 200},
+		testCase{
+			name: "schema violation", operation: "createOrder", body: `{"qty": 0}`, result: 400,
+			errors: `"errors":[{"pointer":"/qty","message":"must be greater than or equal to 1"},{"pointer":"/sku","message":"is required"}]`,
+		},
+		testCase{name: "schema of request body component", operation: "updateOrder", body: `{"sku": 1}`, result: 400, errors: `"pointer":"/sku"`},
+		testCase{name: "operation without schema", operation: "getOrder", body: `{"qty": 0}`, result: 200},
+	}
+
+	schemas, err := OpenAPISchemas([]byte(jsonGuardOpenAPI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schemas) != 2 {
+		t.Fatalf("expected schemas of 2 operations, got %v", schemas)
+	}
+
+	e := echo.New()
+	h := JSONGuardWithConfig(JSONGuardConfig{
+		MaxBytes:            200,
+		MaxDepth:            3,
+		MaxArrayLength:      3,
+		MaxStringLength:     8,
+		MaxKeys:             3,
+		Schemas:             schemas,
+		UncheckedMediaTypes: []string{"multipart/form-data"},
+	})(func(c echo.Context) error {
+		return c.String(http.StatusOK, "test")
+	})
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(cs.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+			if cs.contentType != "" {
+				req.Header.Set(echo.HeaderContentType, cs.contentType)
+			}
+			if cs.contentType == "none" {
+				req.Header.Del(echo.HeaderContentType)
+			}
+			res := httptest.NewRecorder()
+			c := e.NewContext(req, res)
+			c.Set("operation", cs.operation)
+
+			if err := h(c); err != nil {
+				e.DefaultHTTPErrorHandler(err, c)
+			}
+			if res.Code != cs.result {
+				t.Errorf("expected %d, got %d: %s", cs.result, res.Code, res.Body.String())
+			}
+			if !strings.Contains(res.Body.String(), cs.errors) {
+				t.Errorf("expected body to contain %s, got %s", cs.errors, res.Body.String())
+			}
+		})
+	}
+}
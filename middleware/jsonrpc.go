@@ -0,0 +1,210 @@
+package mw
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+)
+
+// JSON-RPC error codes returned by proxy
+const (
+	// JSONRPCForbidden is returned for calls of batch denied by authorization
+	JSONRPCForbidden = -32003
+)
+
+type (
+	// JSONRPCCall single call of JSON-RPC 2.0 request
+	JSONRPCCall struct {
+		// ID of call, nil for notifications
+		ID     json.RawMessage
+		Method string
+		// Raw call as received
+		Raw json.RawMessage
+	}
+
+	// JSONRPCBatch JSON-RPC 2.0 batch request, stored in context by OperationResolver of jsonrpc kind
+	JSONRPCBatch struct {
+		Calls []JSONRPCCall
+
+		single       bool
+		operationKey string
+	}
+)
+
+// parseJSONRPC reads JSON-RPC 2.0 request or batch from request body and restores body for upstream
+func parseJSONRPC(r *http.Request, limit int64) (*JSONRPCBatch, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, fmt.Errorf("JSON-RPC request body is empty")
+	}
+
+	body, err := ioutil.ReadAll(&limitedReader{R: r.Body, N: limit})
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	b := &JSONRPCBatch{}
+	var raws []json.RawMessage
+	if body = bytes.TrimSpace(body); len(body) > 0 && body[0] == '[' {
+		if err := json.Unmarshal(body, &raws); err != nil {
+			return nil, err
+		}
+		if len(raws) == 0 {
+			return nil, fmt.Errorf("JSON-RPC batch is empty")
+		}
+	} else {
+		raws = []json.RawMessage{body}
+		b.single = true
+	}
+
+	for _, raw := range raws {
+		var call map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &call); err != nil {
+			return nil, err
+		}
+
+		var version, method string
+		if err := json.Unmarshal(call["jsonrpc"], &version); err != nil || version != "2.0" {
+			return nil, fmt.Errorf("not a JSON-RPC 2.0 request")
+		}
+		if err := json.Unmarshal(call["method"], &method); err != nil || method == "" {
+			return nil, fmt.Errorf("JSON-RPC method not set")
+		}
+		b.Calls = append(b.Calls, JSONRPCCall{ID: call["id"], Method: method, Raw: raw})
+	}
+
+	return b, nil
+}
+
+// AuthorizeEach authorizes calls of batch one by one with allow, that evaluates operation of call set in context.
+// Allowed calls are passed to next handler, denied calls get JSON-RPC error merged into response.
+func (b *JSONRPCBatch) AuthorizeEach(c echo.Context, next echo.HandlerFunc, allow func(echo.Context) bool) error {
+	operation := c.Get(b.operationKey)
+
+	var allowed, denied []JSONRPCCall
+	for _, call := range b.Calls {
+		c.Set(b.operationKey, call.Method)
+		if allow(c) {
+			allowed = append(allowed, call)
+		} else {
+			denied = append(denied, call)
+		}
+	}
+	c.Set(b.operationKey, operation)
+
+	if len(denied) == 0 {
+		return next(c)
+	}
+
+	var errs []json.RawMessage
+	for _, call := range denied {
+		// Notifications are never answered
+		if call.ID == nil {
+			continue
+		}
+		e, _ := json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      call.ID,
+			"error": map[string]interface{}{
+				"code":    JSONRPCForbidden,
+				"message": http.StatusText(http.StatusForbidden),
+			},
+		})
+		errs = append(errs, e)
+	}
+
+	if len(allowed) == 0 {
+		if len(errs) == 0 {
+			return c.NoContent(http.StatusNoContent)
+		}
+		return c.JSON(http.StatusOK, errs)
+	}
+
+	// Forward only allowed calls, response is buffered to merge errors in
+	req := c.Request()
+	var raws []json.RawMessage
+	for _, call := range allowed {
+		raws = append(raws, call.Raw)
+	}
+	body, _ := json.Marshal(raws)
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set(echo.HeaderContentLength, strconv.Itoa(len(body)))
+	req.Header.Del(echo.HeaderAcceptEncoding)
+
+	res := c.Response()
+	w := res.Writer
+	buf := &bufferedResponseWriter{ResponseWriter: w, code: http.StatusOK}
+	res.Writer = buf
+	err := next(c)
+	res.Writer = w
+	if err != nil {
+		return err
+	}
+
+	var responses []json.RawMessage
+	if buf.body.Len() > 0 {
+		if json.Unmarshal(buf.body.Bytes(), &responses) != nil {
+			// Not a batch response, return it as is
+			w.WriteHeader(buf.code)
+			_, err := w.Write(buf.body.Bytes())
+			return err
+		}
+	}
+
+	w.Header().Del(echo.HeaderContentLength)
+	responses = append(responses, errs...)
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	merged, _ := json.Marshal(responses)
+	w.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+	if buf.code == http.StatusNoContent {
+		buf.code = http.StatusOK
+	}
+	w.WriteHeader(buf.code)
+	_, err = w.Write(merged)
+	res.Size = int64(len(merged))
+	return err
+}
+
+// bufferedResponseWriter keeps status and body to be written later
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	code int
+	body bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) WriteHeader(code int) {
+	w.code = code
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *bufferedResponseWriter) Flush() {}
+
+// limitedReader fails with error, when more than N bytes are read
+type limitedReader struct {
+	R io.Reader
+	N int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.R.Read(p)
+	l.N -= int64(n)
+	if l.N < 0 {
+		return n, fmt.Errorf("request body too large")
+	}
+	return n, err
+}
@@ -0,0 +1,141 @@
+package mw
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/casbin/casbin"
+	"github.com/labstack/echo"
+)
+
+func TestOperationResolverJSONRPC(t *testing.T) {
+	type testCase struct {
+		name   string
+		body   string
+		result int
+		op     string
+	}
+
+	cases := []testCase{
+		testCase{name: "single", body: `{"jsonrpc":"2.0","method":"data1","id":1}`, result: 200, op: "data1"},
+		testCase{name: "batch", body: `[{"jsonrpc":"2.0","method":"data1","id":1},{"jsonrpc":"2.0","method":"data2"}]`, result: 200, op: "data1,data2"},
+		testCase{name: "no method", body: `{"jsonrpc":"2.0","id":1}`, result: 400},
+		testCase{name: "old version", body: `{"method":"data1","id":1}`, result: 400},
+		testCase{name: "empty batch", body: `[]`, result: 400},
+	}
+
+	e := echo.New()
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(cs.body))
+			res := httptest.NewRecorder()
+
+			c := e.NewContext(req, res)
+
+			var op, body string
+			h := OperationResolver("jsonrpc")(func(c echo.Context) error {
+				op = c.Get("operation").(string)
+				b, _ := ioutil.ReadAll(c.Request().Body)
+				body = string(b)
+				return c.NoContent(http.StatusOK)
+			})
+
+			err := h(c)
+
+			if err != nil {
+				if errObj, ok := err.(*echo.HTTPError); ok {
+					if errObj.Code != cs.result {
+						t.Errorf("expected %d, got: %d", cs.result, errObj.Code)
+					}
+				} else {
+					t.Error(err)
+				}
+			} else if c.Response().Status != cs.result {
+				t.Errorf("expected %d, got: %d", cs.result, c.Response().Status)
+			} else if op != cs.op {
+				t.Errorf("expected operation to be '%s', got : '%s'", cs.op, op)
+			} else if body != cs.body {
+				t.Errorf("expected request body to be restored, got: %s", body)
+			}
+		})
+	}
+}
+
+func TestCasbinJSONRPCBatch(t *testing.T) {
+	type testCase struct {
+		name      string
+		system    string
+		body      string
+		forwarded []string
+		result    int
+		response  string
+	}
+
+	cases := []testCase{
+		testCase{name: "allowed", system: "alice",
+			body:      `[{"jsonrpc":"2.0","method":"data1","id":1},{"jsonrpc":"2.0","method":"data2","id":2}]`,
+			forwarded: []string{"data1", "data2"}, result: 200,
+			response: `[{"id":1,"jsonrpc":"2.0","result":"data1"},{"id":2,"jsonrpc":"2.0","result":"data2"}]`},
+		testCase{name: "partial", system: "bob",
+			body:      `[{"jsonrpc":"2.0","method":"data1","id":1},{"jsonrpc":"2.0","method":"data2","id":2},{"jsonrpc":"2.0","method":"data1"}]`,
+			forwarded: []string{"data2"}, result: 200,
+			response: `[{"id":2,"jsonrpc":"2.0","result":"data2"},{"error":{"code":-32003,"message":"Forbidden"},"id":1,"jsonrpc":"2.0"}]`},
+		testCase{name: "denied", system: "bob",
+			body:   `[{"jsonrpc":"2.0","method":"data1","id":"a"}]`,
+			result: 200, response: `[{"error":{"code":-32003,"message":"Forbidden"},"id":"a","jsonrpc":"2.0"}]`},
+		testCase{name: "denied notifications", system: "bob",
+			body:   `[{"jsonrpc":"2.0","method":"data1"}]`,
+			result: 204, response: ``},
+	}
+
+	ce := casbin.NewEnforcer("casbin_model.conf", "casbin_policy.csv")
+	e := echo.New()
+
+	// upstream answers every call with its method
+	upstream := func(forwarded *[]string) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			var calls []map[string]interface{}
+			json.NewDecoder(c.Request().Body).Decode(&calls)
+
+			var responses []map[string]interface{}
+			for _, call := range calls {
+				*forwarded = append(*forwarded, call["method"].(string))
+				if id, ok := call["id"]; ok {
+					responses = append(responses, map[string]interface{}{"jsonrpc": "2.0", "id": id, "result": call["method"]})
+				}
+			}
+			return c.JSON(http.StatusOK, responses)
+		}
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(cs.body))
+			res := httptest.NewRecorder()
+
+			c := e.NewContext(req, res)
+			c.Set("system", cs.system)
+
+			var forwarded []string
+			h := OperationResolver("jsonrpc")(CasbinEnforcer(ce, ValueFromContext("system"), ValueFromContext("operation"))(upstream(&forwarded)))
+
+			if err := h(c); err != nil {
+				t.Fatal(err)
+			}
+			if res.Code != cs.result {
+				t.Errorf("expected %d, got: %d", cs.result, res.Code)
+			}
+			if strings.Join(forwarded, ",") != strings.Join(cs.forwarded, ",") {
+				t.Errorf("expected forwarded calls %v, got: %v", cs.forwarded, forwarded)
+			}
+			if strings.TrimSpace(res.Body.String()) != cs.response {
+				t.Errorf("expected response %s, got: %s", cs.response, res.Body.String())
+			}
+		})
+	}
+}
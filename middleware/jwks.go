@@ -0,0 +1,229 @@
+package mw
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWKS JSON Web Key Set of local file or URL. Keys of URL are cached, they are fetched again after refresh interval
+// or when token is signed with unknown key, so keys can be rotated by issuer. Keys are fetched without blocking
+// requests, that are verified by cached keys, and concurrent requests share one fetch.
+type JWKS struct {
+	// Location path of file or http(s) URL of key set
+	Location string
+
+	// Refresh interval to fetch keys of URL again
+	Refresh time.Duration
+
+	// MinRefresh min interval between fetches of keys of URL for unknown keys
+	MinRefresh time.Duration
+
+	// Client HTTP client to fetch keys
+	Client *http.Client
+
+	mu      sync.Mutex
+	keys    []jwk
+	fetched time.Time
+	// fetching is closed when fetch in progress completes, nil if there is no fetch
+	fetching chan struct{}
+}
+
+// jwk verification key of key set
+type jwk struct {
+	kid string
+	alg string
+	// key *rsa.PublicKey, *ecdsa.PublicKey or []byte of symmetric key
+	key interface{}
+}
+
+// NewJWKS returns key set of location. Keys of file are loaded immediately, keys of URL on first use.
+func NewJWKS(location string) (*JWKS, error) {
+	s := &JWKS{
+		Location:   location,
+		Refresh:    time.Hour,
+		MinRefresh: time.Minute,
+		Client:     &http.Client{Timeout: 10 * time.Second},
+	}
+	if !s.remote() {
+		doc, err := ioutil.ReadFile(location)
+		if err != nil {
+			return nil, err
+		}
+		if s.keys, err = parseJWKS(doc); err != nil {
+			return nil, fmt.Errorf("failed to load JWKS %s: %v", location, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *JWKS) remote() bool {
+	return strings.HasPrefix(s.Location, "http://") || strings.HasPrefix(s.Location, "https://")
+}
+
+// Key returns key with id, that is suitable for algorithm. Empty id matches any key.
+func (s *JWKS) Key(kid, alg string) (interface{}, error) {
+	s.mu.Lock()
+	keys, fetched := s.keys, !s.fetched.IsZero()
+	s.mu.Unlock()
+
+	if s.remote() {
+		// Keys are fetched in background after refresh interval, the first request waits for them
+		keys = s.refresh(s.Refresh, !fetched)
+	}
+	if k := findJWK(keys, kid, alg); k != nil {
+		return k, nil
+	}
+	if s.remote() {
+		if k := findJWK(s.refresh(s.MinRefresh, true), kid, alg); k != nil {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("no key %q for algorithm %s", kid, alg)
+}
+
+// refresh starts fetch, if keys were fetched longer than interval ago and no fetch is in progress, and returns
+// keys. With wait keys are returned after fetch in progress completes.
+func (s *JWKS) refresh(interval time.Duration, wait bool) []jwk {
+	s.mu.Lock()
+	now := time.Now()
+	if s.fetching == nil && now.Sub(s.fetched) > interval {
+		s.fetched = now
+		s.fetching = make(chan struct{})
+		go func(done chan struct{}) {
+			keys := s.fetch()
+			s.mu.Lock()
+			if keys != nil {
+				s.keys = keys
+			}
+			s.fetching = nil
+			s.mu.Unlock()
+			close(done)
+		}(s.fetching)
+	}
+	done := s.fetching
+	s.mu.Unlock()
+
+	if wait && done != nil {
+		<-done
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys
+}
+
+// fetch returns keys of URL, nil if fetch fails
+func (s *JWKS) fetch() []jwk {
+	res, err := s.Client.Get(s.Location)
+	if err != nil {
+		return nil
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil
+	}
+	doc, err := ioutil.ReadAll(&limitedReader{R: res.Body, N: 1 << 20})
+	if err != nil {
+		return nil
+	}
+	keys, err := parseJWKS(doc)
+	if err != nil {
+		return nil
+	}
+	return keys
+}
+
+func findJWK(keys []jwk, kid, alg string) interface{} {
+	for _, k := range keys {
+		if (kid != "" && k.kid != kid) || (k.alg != "" && k.alg != alg) {
+			continue
+		}
+		switch k.key.(type) {
+		case *rsa.PublicKey:
+			if strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS") {
+				return k.key
+			}
+		case *ecdsa.PublicKey:
+			if strings.HasPrefix(alg, "ES") {
+				return k.key
+			}
+		case []byte:
+			if strings.HasPrefix(alg, "HS") {
+				return k.key
+			}
+		}
+	}
+	return nil
+}
+
+// parseJWKS reads signature keys of key set, keys of unsupported types are skipped
+func parseJWKS(doc []byte) ([]jwk, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Alg string `json:"alg"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(doc, &set); err != nil {
+		return nil, err
+	}
+
+	var keys []jwk
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key := jwk{kid: k.Kid, alg: k.Alg}
+		switch k.Kty {
+		case "RSA":
+			n, e := decodeBigInt(k.N), decodeBigInt(k.E)
+			if n == nil || e == nil || !e.IsInt64() {
+				return nil, fmt.Errorf("invalid RSA key %s", k.Kid)
+			}
+			key.key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+			curve, ok := curves[k.Crv]
+			x, y := decodeBigInt(k.X), decodeBigInt(k.Y)
+			if !ok || x == nil || y == nil || !curve.IsOnCurve(x, y) {
+				return nil, fmt.Errorf("invalid EC key %s", k.Kid)
+			}
+			key.key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		case "oct":
+			b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(k.K, "="))
+			if err != nil || len(b) == 0 {
+				return nil, fmt.Errorf("invalid symmetric key %s", k.Kid)
+			}
+			key.key = b
+		default:
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func decodeBigInt(s string) *big.Int {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil || len(b) == 0 {
+		return nil
+	}
+	return new(big.Int).SetBytes(b)
+}
@@ -0,0 +1,202 @@
+package mw
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+type (
+	// JWTConfig defines the config for JWT middleware.
+	JWTConfig struct {
+		// Skipper defines a function to skip middleware.
+		Skipper middleware.Skipper
+
+		// Keys key set to verify signatures of tokens
+		// Required.
+		Keys *JWKS
+
+		// Algorithms accepted signing algorithms
+		Algorithms []string
+
+		// Issuer expected iss claim, not checked if empty
+		Issuer string
+
+		// Audience expected in aud claim, not checked if empty
+		Audience string
+
+		// Leeway allowed clock skew for exp and nbf claims
+		Leeway time.Duration
+
+		// ClientClaim claim that identifies client
+		ClientClaim string
+
+		// Claims output to context with ClaimPrefix, for casbin parameters
+		Claims []string
+
+		// ClaimPrefix prefix of context keys of claims: "claim.scope"
+		ClaimPrefix string
+
+		// ContextKey key to output client if authenticated
+		ContextKey string
+	}
+)
+
+var (
+	// defaultJWTConfig is the default JWT middleware config.
+	defaultJWTConfig = JWTConfig{
+		Skipper:     middleware.DefaultSkipper,
+		Algorithms:  []string{"HS256", "RS256", "ES256"},
+		ClientClaim: "sub",
+		ClaimPrefix: "claim.",
+		ContextKey:  "client",
+	}
+)
+
+// JWT returns a JWT middleware.
+//
+// Client is authenticated by `Authorization: Bearer` token signed by key of key set. Tokens must have exp claim,
+// nbf, iss and aud claims are checked when present or configured. Value of client claim is set as client.
+func JWT(keys *JWKS) echo.MiddlewareFunc {
+	c := defaultJWTConfig
+	c.Keys = keys
+	return JWTWithConfig(c)
+}
+
+// JWTWithConfig returns a JWT middleware with config.
+// See `JWT()`.
+func JWTWithConfig(config JWTConfig) echo.MiddlewareFunc {
+	// Defaults
+	if config.Skipper == nil {
+		config.Skipper = defaultJWTConfig.Skipper
+	}
+	if len(config.Algorithms) == 0 {
+		config.Algorithms = defaultJWTConfig.Algorithms
+	}
+	if config.ClientClaim == "" {
+		config.ClientClaim = defaultJWTConfig.ClientClaim
+	}
+	if config.ClaimPrefix == "" {
+		config.ClaimPrefix = defaultJWTConfig.ClaimPrefix
+	}
+	if config.ContextKey == "" {
+		config.ContextKey = defaultJWTConfig.ContextKey
+	}
+	if config.Keys == nil {
+		panic("echo: jwt middleware requires keys")
+	}
+
+	parser := &jwt.Parser{ValidMethods: config.Algorithms, SkipClaimsValidation: true}
+	keyFunc := func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return config.Keys.Key(kid, t.Method.Alg())
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			token := bearerToken(c.Request())
+			if token == "" {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+				return echo.ErrUnauthorized
+			}
+			claims := jwt.MapClaims{}
+			if _, err := parser.ParseWithClaims(token, claims, keyFunc); err != nil {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+				return echo.ErrUnauthorized
+			}
+			if err := checkClaims(claims, config, time.Now()); err != nil {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+				return echo.ErrUnauthorized
+			}
+
+			for _, name := range config.Claims {
+				if v, ok := claims[name]; ok {
+					c.Set(config.ClaimPrefix+name, claimString(v))
+				}
+			}
+			c.Set(config.ContextKey, claims[config.ClientClaim])
+			return next(c)
+		}
+	}
+}
+
+// bearerToken returns token of Authorization header with Bearer scheme
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get(echo.HeaderAuthorization)
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// checkClaims checks time, issuer and audience claims and presence of client claim
+func checkClaims(claims jwt.MapClaims, config JWTConfig, now time.Time) error {
+	leeway := config.Leeway.Seconds()
+	unix := float64(now.Unix())
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("token has no exp claim")
+	}
+	if unix > exp+leeway {
+		return fmt.Errorf("token is expired")
+	}
+	if v, ok := claims["nbf"]; ok {
+		nbf, ok := v.(float64)
+		if !ok || unix+leeway < nbf {
+			return fmt.Errorf("token is not valid yet")
+		}
+	}
+	if config.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != config.Issuer {
+			return fmt.Errorf("token issuer %s is not accepted", iss)
+		}
+	}
+	if config.Audience != "" && !claimContains(claims["aud"], config.Audience) {
+		return fmt.Errorf("token is not intended for audience %s", config.Audience)
+	}
+	if client, _ := claims[config.ClientClaim].(string); client == "" {
+		return fmt.Errorf("token has no %s claim", config.ClientClaim)
+	}
+	return nil
+}
+
+// claimContains reports whether claim is value or list with value
+func claimContains(claim interface{}, value string) bool {
+	switch c := claim.(type) {
+	case string:
+		return c == value
+	case []interface{}:
+		for _, e := range c {
+			if s, ok := e.(string); ok && s == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// claimString formats claim for casbin parameters, items of lists are separated with spaces like OAuth scopes
+func claimString(claim interface{}) string {
+	if list, ok := claim.([]interface{}); ok {
+		items := make([]string, len(list))
+		for i, e := range list {
+			items[i] = fmt.Sprint(e)
+		}
+		return strings.Join(items, " ")
+	}
+	if f, ok := claim.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(claim)
+}
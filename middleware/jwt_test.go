@@ -0,0 +1,257 @@
+package mw
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func jwkOf(kid string, key interface{}) map[string]string {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "n": b64(k.N.Bytes()), "e": b64(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(k.X.Bytes()), "y": b64(k.Y.Bytes())}
+	case []byte:
+		return map[string]string{"kty": "oct", "kid": kid, "k": b64(k)}
+	}
+	return nil
+}
+
+func jwksDocument(keys ...map[string]string) []byte {
+	b, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	return b
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestJWT(t *testing.T) {
+	type testCase struct {
+		name   string
+		token  string
+		result int
+		client string
+		scope  string
+	}
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	f, err := ioutil.TempFile("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(jwksDocument(jwkOf("rsa", &rsaKey.PublicKey), jwkOf("ec", &ecKey.PublicKey), jwkOf("hmac", secret)))
+	f.Close()
+	keys, err := NewJWKS(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Unix()
+	claims := func(extra jwt.MapClaims) jwt.MapClaims {
+		c := jwt.MapClaims{"sub": "test-system", "iss": "https://issuer", "aud": "nprxy", "exp": now + 60, "scope": "orders:read"}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	cases := []testCase{
+		testCase{name: "rs256", token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(nil)), result: 200, client: "test-system", scope: "orders:read"},
+		testCase{name: "es256", token: signToken(t, jwt.SigningMethodES256, "ec", ecKey, claims(nil)), result: 200, client: "test-system"},
+		testCase{name: "hs256", token: signToken(t, jwt.SigningMethodHS256, "hmac", secret, claims(nil)), result: 200, client: "test-system"},
+		testCase{name: "without kid", token: signToken(t, jwt.SigningMethodRS256, "", rsaKey, claims(nil)), result: 200, client: "test-system"},
+		testCase{
+			name: "list claim", result: 200, client: "test-system", scope: "orders:read orders:write",
+			token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"scope": []string{"orders:read", "orders:write"}})),
+		},
+		testCase{name: "no token", result: 401},
+		testCase{name: "other key", token: signToken(t, jwt.SigningMethodRS256, "rsa", otherKey, claims(nil)), result: 401},
+		testCase{name: "unknown kid", token: signToken(t, jwt.SigningMethodRS256, "other", rsaKey, claims(nil)), result: 401},
+		testCase{name: "algorithm of other key", token: signToken(t, jwt.SigningMethodHS256, "rsa", secret, claims(nil)), result: 401},
+		testCase{name: "not accepted algorithm", token: signToken(t, jwt.SigningMethodRS512, "rsa", rsaKey, claims(nil)), result: 401},
+		testCase{name: "expired", token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"exp": now - 120})), result: 401},
+		testCase{
+			name: "expired within leeway", result: 200, client: "test-system",
+			token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"exp": now - 10})),
+		},
+		testCase{name: "no exp", token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"exp": nil})), result: 401},
+		testCase{name: "not before", token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"nbf": now + 120})), result: 401},
+		testCase{name: "issuer", token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"iss": "other"})), result: 401},
+		testCase{name: "audience", token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"aud": "other"})), result: 401},
+		testCase{
+			name: "audience list", result: 200, client: "test-system",
+			token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"aud": []string{"other", "nprxy"}})),
+		},
+		testCase{name: "no client", token: signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(jwt.MapClaims{"sub": ""})), result: 401},
+	}
+
+	e := echo.New()
+	h := JWTWithConfig(JWTConfig{
+		Keys:     keys,
+		Issuer:   "https://issuer",
+		Audience: "nprxy",
+		Leeway:   30 * time.Second,
+		Claims:   []string{"scope"},
+	})(func(c echo.Context) error {
+		scope, _ := c.Get("claim.scope").(string)
+		return c.String(http.StatusOK, c.Get("client").(string)+"|"+scope)
+	})
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if cs.token != "" {
+				req.Header.Set("Authorization", "Bearer "+cs.token)
+			}
+			res := httptest.NewRecorder()
+			c := e.NewContext(req, res)
+
+			err := h(c)
+			if he, ok := err.(*echo.HTTPError); ok {
+				res.Code = he.Code
+			}
+			if res.Code != cs.result {
+				t.Fatalf("expected %d, got %d", cs.result, res.Code)
+			}
+			if cs.result == 401 && res.Header().Get("WWW-Authenticate") == "" {
+				t.Error("expected WWW-Authenticate challenge")
+			}
+			if cs.client != "" && !strings.HasPrefix(res.Body.String(), cs.client+"|") {
+				t.Errorf("expected client %s, got %s", cs.client, res.Body.String())
+			}
+			if cs.scope != "" && res.Body.String() != cs.client+"|"+cs.scope {
+				t.Errorf("expected scope %s, got %s", cs.scope, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestJWKSRotation(t *testing.T) {
+	first, _ := rsa.GenerateKey(rand.Reader, 2048)
+	second, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var rotated, fetches int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		if atomic.LoadInt32(&rotated) == 0 {
+			w.Write(jwksDocument(jwkOf("1", &first.PublicKey)))
+			return
+		}
+		w.Write(jwksDocument(jwkOf("2", &second.PublicKey)))
+	}))
+	defer ts.Close()
+
+	keys, _ := NewJWKS(ts.URL)
+	keys.MinRefresh = 0
+	if _, err := keys.Key("1", "RS256"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Key("1", "RS256"); err != nil || fetches != 1 {
+		t.Fatalf("expected cached key, got %d fetches: %v", fetches, err)
+	}
+
+	atomic.StoreInt32(&rotated, 1)
+	if _, err := keys.Key("2", "RS256"); err != nil {
+		t.Fatalf("expected rotated key to be fetched: %v", err)
+	}
+	if _, err := keys.Key("1", "RS256"); err == nil {
+		t.Error("expected removed key to be rejected")
+	}
+
+	keys.MinRefresh = time.Hour
+	keys.Key("3", "RS256")
+	if n := atomic.LoadInt32(&fetches); n != 3 {
+		t.Errorf("expected unknown keys to be fetched at most once per min refresh, got %d fetches", n)
+	}
+}
+
+func TestJWKSFetchDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	var fetches int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			<-release
+		}
+		w.Write(jwksDocument(jwkOf("1", []byte("secret"))))
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	keys, _ := NewJWKS(ts.URL)
+	if _, err := keys.Key("1", "HS256"); err != nil {
+		t.Fatal(err)
+	}
+	keys.mu.Lock()
+	keys.fetched = keys.fetched.Add(-2 * keys.Refresh)
+	keys.mu.Unlock()
+
+	// Unknown keys wait for one shared fetch, known keys are served meanwhile
+	unknown := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := keys.Key("2", "HS256")
+			unknown <- err
+		}()
+	}
+	for atomic.LoadInt32(&fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	known := make(chan error)
+	go func() {
+		_, err := keys.Key("1", "HS256")
+		known <- err
+	}()
+	select {
+	case err := <-known:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected cached key to be returned while keys are fetched")
+	}
+
+	release <- struct{}{}
+	for i := 0; i < 2; i++ {
+		if err := <-unknown; err == nil {
+			t.Error("expected unknown key to be rejected")
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("expected concurrent requests to share fetch, got %d fetches", n)
+	}
+}
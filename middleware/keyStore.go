@@ -0,0 +1,284 @@
+package mw
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// KeyStore credentials of clients of keys file. File is either flat map of `client: bcrypt hash of key` or
+// `client: {key: hash, hmac: secret}` pairs, or versioned file:
+//
+//	version: 2
+//	clients:
+//	  test-system:
+//	    keys:
+//	    - id: 2024-06
+//	      hash: $2a$10$...
+//	      created: 2024-06-01T00:00:00Z
+//	      expires: 2025-06-01T00:00:00Z
+//	    hmac: secret
+//	    cidrs: [10.0.0.0/8]
+//	    scopes: [orders:read]
+//	    roles: [reader]
+type KeyStore struct {
+	// Path of keys file
+	Path string
+
+	mu      sync.RWMutex
+	clients map[string]*KeyClient
+	maxKeys int
+}
+
+// KeyClient credentials of client
+type KeyClient struct {
+	// Keys API keys of client, several keys are active during rotation
+	Keys []APIKey
+	// HMAC shared secret to sign requests
+	HMAC string
+	// Disabled client is rejected with any key
+	Disabled bool
+	// CIDRs networks client may connect from, any if empty
+	CIDRs []*net.IPNet
+	// Scopes and Roles of client for casbin policy
+	Scopes []string
+	Roles  []string
+}
+
+// APIKey bcrypt hash of API key
+type APIKey struct {
+	ID       string
+	Hash     string
+	Created  time.Time
+	Expires  time.Time
+	Disabled bool
+}
+
+// NewKeyStore loads keys file
+func NewKeyStore(path string) (*KeyStore, error) {
+	s := &KeyStore{Path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads file again and replaces all clients at once, clients are kept if file is invalid
+func (s *KeyStore) Reload() error {
+	b, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return fmt.Errorf("read keys %s: %v", s.Path, err)
+	}
+	clients, err := ParseKeys(b)
+	if err != nil {
+		return fmt.Errorf("unmarshal keys %s: %v", s.Path, err)
+	}
+	maxKeys := 0
+	for _, k := range clients {
+		if len(k.Keys) > maxKeys {
+			maxKeys = len(k.Keys)
+		}
+	}
+	s.mu.Lock()
+	s.clients = clients
+	s.maxKeys = maxKeys
+	s.mu.Unlock()
+	return nil
+}
+
+// MaxKeys returns the highest number of keys of one client
+func (s *KeyStore) MaxKeys() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.maxKeys
+}
+
+// Watch reloads file when it changes until returned watcher is closed
+func (s *KeyStore) Watch() (io.Closer, error) {
+	return watchFile(s.Path, s.Reload)
+}
+
+// Client returns credentials of client, nil if client is unknown, disabled or not allowed to connect from ip
+func (s *KeyStore) Client(name string, ip net.IP) *KeyClient {
+	s.mu.RLock()
+	k := s.clients[name]
+	s.mu.RUnlock()
+
+	if k == nil || k.Disabled {
+		return nil
+	}
+	if len(k.CIDRs) == 0 {
+		return k
+	}
+	for _, n := range k.CIDRs {
+		if ip != nil && n.Contains(ip) {
+			return k
+		}
+	}
+	return nil
+}
+
+// Active reports whether client is allowed to connect from ip and still has active key or HMAC secret with
+// fingerprint, see KeyFingerprint. It is checked without computing bcrypt.
+func (s *KeyStore) Active(name string, ip net.IP, fingerprint string, now time.Time) bool {
+	k := s.Client(name, ip)
+	if k == nil {
+		return false
+	}
+	if k.HMAC != "" && KeyFingerprint(k.HMAC) == fingerprint {
+		return true
+	}
+	for _, key := range k.ActiveKeys(now) {
+		if KeyFingerprint(key.Hash) == fingerprint {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyFingerprint returns fingerprint of hash of key or of HMAC secret, that identifies credential of client in
+// issued tokens without revealing it
+func KeyFingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+// Hashes returns hash of the first active key of each client
+func (s *KeyStore) Hashes(now time.Time) map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hashes := map[string]string{}
+	for name, k := range s.clients {
+		if keys := k.ActiveKeys(now); !k.Disabled && len(keys) > 0 {
+			hashes[name] = keys[0].Hash
+		}
+	}
+	return hashes
+}
+
+// ActiveKeys returns keys that are not disabled or expired
+func (k *KeyClient) ActiveKeys(now time.Time) []APIKey {
+	var keys []APIKey
+	for _, key := range k.Keys {
+		if key.Disabled || key.Hash == "" || (!key.Expires.IsZero() && !now.Before(key.Expires)) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// ParseKeys reads clients of flat or versioned keys file
+func ParseKeys(b []byte) (map[string]*KeyClient, error) {
+	var header struct {
+		Version interface{} `yaml:"version"`
+	}
+	if err := yaml.Unmarshal(b, &header); err != nil {
+		return nil, err
+	}
+	if _, ok := header.Version.(int); !ok {
+		return parseFlatKeys(b)
+	}
+	if header.Version != 2 {
+		return nil, fmt.Errorf("unsupported version %v", header.Version)
+	}
+
+	var f struct {
+		Version int `yaml:"version"`
+		Clients map[string]struct {
+			Keys []struct {
+				ID       string `yaml:"id"`
+				Hash     string `yaml:"hash"`
+				Created  string `yaml:"created"`
+				Expires  string `yaml:"expires"`
+				Disabled bool   `yaml:"disabled"`
+			} `yaml:"keys"`
+			HMAC     string   `yaml:"hmac"`
+			Disabled bool     `yaml:"disabled"`
+			CIDRs    []string `yaml:"cidrs"`
+			Scopes   []string `yaml:"scopes"`
+			Roles    []string `yaml:"roles"`
+		} `yaml:"clients"`
+	}
+	if err := yaml.UnmarshalStrict(b, &f); err != nil {
+		return nil, err
+	}
+
+	clients := map[string]*KeyClient{}
+	for name, c := range f.Clients {
+		k := &KeyClient{HMAC: c.HMAC, Disabled: c.Disabled, Scopes: c.Scopes, Roles: c.Roles}
+		for _, key := range c.Keys {
+			a := APIKey{ID: key.ID, Hash: key.Hash, Disabled: key.Disabled}
+			if key.Created != "" {
+				t, err := time.Parse(time.RFC3339, key.Created)
+				if err != nil {
+					return nil, fmt.Errorf("client %s: invalid created of key %s: %v", name, key.ID, err)
+				}
+				a.Created = t
+			}
+			if key.Expires != "" {
+				t, err := time.Parse(time.RFC3339, key.Expires)
+				if err != nil {
+					return nil, fmt.Errorf("client %s: invalid expires of key %s: %v", name, key.ID, err)
+				}
+				a.Expires = t
+			}
+			k.Keys = append(k.Keys, a)
+		}
+		for _, cidr := range c.CIDRs {
+			if !strings.Contains(cidr, "/") {
+				if strings.Contains(cidr, ":") {
+					cidr += "/128"
+				} else {
+					cidr += "/32"
+				}
+			}
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("client %s: %v", name, err)
+			}
+			k.CIDRs = append(k.CIDRs, n)
+		}
+		clients[name] = k
+	}
+	return clients, nil
+}
+
+// flatKey credentials of client in flat keys file, either bcrypt hash of key or map with hash and HMAC secret
+type flatKey struct {
+	Key  string `yaml:"key"`
+	HMAC string `yaml:"hmac"`
+}
+
+func (k *flatKey) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&k.Key); err == nil {
+		return nil
+	}
+	type plain flatKey
+	return unmarshal((*plain)(k))
+}
+
+func parseFlatKeys(b []byte) (map[string]*KeyClient, error) {
+	entries := map[string]flatKey{}
+	if err := yaml.Unmarshal(b, entries); err != nil {
+		return nil, err
+	}
+	clients := map[string]*KeyClient{}
+	for name, e := range entries {
+		k := &KeyClient{HMAC: e.HMAC}
+		if e.Key != "" {
+			k.Keys = []APIKey{{Hash: e.Key}}
+		}
+		clients[name] = k
+	}
+	return clients, nil
+}
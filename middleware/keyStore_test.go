@@ -0,0 +1,177 @@
+package mw
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// apiKeyHash bcrypt hash of "api-key"
+const apiKeyHash = "$2a$10$0ZYFiKcYonvy.y/P4jAzJOr79AQoeO1LGO2hyj27QS5pTx/1nyzRm"
+
+// apiKey2Hash bcrypt hash of "api-key-2"
+const apiKey2Hash = "$2a$04$Wr/aAu5Wtf8xrfhsTiiIKuQe4sqoDLBY8Sdss6D/nJ8lkG.HKpWD."
+
+const versionedKeys = `# clients of orders service
+version: 2
+clients:
+  test-system:
+    keys:
+    - id: "2024-01"
+      hash: ` + apiKeyHash + `
+      expires: 2100-01-01T00:00:00Z
+    - id: "2024-06"
+      hash: ` + apiKey2Hash + `
+    scopes: [orders:read, orders:write]
+    roles: [writer]
+  expired-system:
+    keys:
+    - hash: ` + apiKeyHash + `
+      expires: 2000-01-01T00:00:00Z
+  revoked-system:
+    keys:
+    - hash: ` + apiKeyHash + `
+      disabled: true
+  disabled-system:
+    disabled: true
+    keys:
+    - hash: ` + apiKeyHash + `
+  office-system:
+    cidrs: [10.0.0.0/8, 192.168.1.10]
+    keys:
+    - hash: ` + apiKeyHash + `
+`
+
+func TestKeyStore(t *testing.T) {
+	type testCase struct {
+		name       string
+		system     string
+		key        string
+		remoteAddr string
+		result     int
+		scope      string
+	}
+
+	cases := []testCase{
+		testCase{name: "first key", system: "test-system", key: "api-key", result: 200, scope: "orders:read orders:write|writer"},
+		testCase{name: "second key", system: "test-system", key: "api-key-2", result: 200},
+		testCase{name: "wrong key", system: "test-system", key: "api-key-3", result: 401},
+		testCase{name: "expired key", system: "expired-system", key: "api-key", result: 401},
+		testCase{name: "disabled key", system: "revoked-system", key: "api-key", result: 401},
+		testCase{name: "disabled client", system: "disabled-system", key: "api-key", result: 401},
+		testCase{name: "allowed network", system: "office-system", key: "api-key", remoteAddr: "10.1.2.3:5000", result: 200, scope: "|"},
+		testCase{name: "allowed address", system: "office-system", key: "api-key", remoteAddr: "192.168.1.10:5000", result: 200},
+		testCase{name: "other network", system: "office-system", key: "api-key", remoteAddr: "192.168.1.11:5000", result: 401},
+		testCase{name: "unknown client", system: "other-system", key: "api-key", result: 401},
+	}
+
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.yaml")
+	ioutil.WriteFile(path, []byte(versionedKeys), 0600)
+
+	store, err := NewKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	h := BCryptAPIKeyWithConfig(BCryptAPIKeyConfig{Store: store})(func(c echo.Context) error {
+		scope, _ := c.Get("claim.scope").(string)
+		roles, _ := c.Get("claim.roles").(string)
+		return c.String(http.StatusOK, scope+"|"+roles)
+	})
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Add("X-NPRXY-Client", cs.system)
+			req.Header.Add("X-NPRXY-Key", cs.key)
+			if cs.remoteAddr != "" {
+				req.RemoteAddr = cs.remoteAddr
+			}
+			res := httptest.NewRecorder()
+			c := e.NewContext(req, res)
+
+			err := h(c)
+			if he, ok := err.(*echo.HTTPError); ok {
+				res.Code = he.Code
+			}
+			if res.Code != cs.result {
+				t.Fatalf("expected %d, got %d", cs.result, res.Code)
+			}
+			if cs.scope != "" && res.Body.String() != cs.scope {
+				t.Errorf("expected %s, got %s", cs.scope, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestKeyStoreReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.yaml")
+	ioutil.WriteFile(path, []byte("test-system: "+apiKeyHash+"\n"), 0600)
+
+	store, err := NewKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := store.Watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	now := time.Now()
+	if k := store.Client("test-system", nil); k == nil || len(k.ActiveKeys(now)) != 1 {
+		t.Fatalf("expected client of flat file, got %+v", k)
+	}
+
+	// invalid file keeps loaded clients
+	ioutil.WriteFile(path, []byte("version: 2\nclients: [\n"), 0600)
+	time.Sleep(50 * time.Millisecond)
+	if store.Client("test-system", nil) == nil {
+		t.Fatal("expected clients to be kept after invalid file")
+	}
+
+	ioutil.WriteFile(path, []byte(strings.Replace(versionedKeys, "test-system", "rotated-system", 1)), 0600)
+	deadline := time.Now().Add(2 * time.Second)
+	for store.Client("rotated-system", nil) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expected keys to be reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if store.Client("test-system", nil) != nil {
+		t.Error("expected removed client to be rejected")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	invalid := map[string]string{
+		"version":   "version: 3\nclients: {}\n",
+		"expires":   "version: 2\nclients:\n  a:\n    keys: [{hash: x, expires: tomorrow}]\n",
+		"cidr":      "version: 2\nclients:\n  a:\n    cidrs: [10.0.0.0/33]\n",
+		"field":     "version: 2\nclients:\n  a:\n    key: x\n",
+		"not a map": "- a\n",
+	}
+	for name, doc := range invalid {
+		if _, err := ParseKeys([]byte(doc)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
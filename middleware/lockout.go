@@ -0,0 +1,137 @@
+package mw
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Lockout counts authentication failures by key, e.g. client name or address, and locks key out after MaxFailures
+// consecutive failures. Each next lockout of key lasts twice as long as previous one, up to MaxDuration. Key is
+// forgiven after MaxDuration without failures. When MaxEntries keys are tracked, the oldest keys that are not locked
+// out are evicted; locked out keys are never evicted, so flood of new keys does not end lockouts.
+type Lockout struct {
+	// MaxFailures failures before key is locked out
+	MaxFailures int
+
+	// Duration of the first lockout
+	Duration time.Duration
+
+	// MaxDuration max duration of lockout
+	MaxDuration time.Duration
+
+	// MaxEntries max number of tracked keys
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*lockoutEntry
+}
+
+type lockoutEntry struct {
+	failures int
+	lockouts int
+	last     time.Time
+	until    time.Time
+}
+
+// NewLockout returns lockout without failures
+func NewLockout(maxFailures int, duration, maxDuration time.Duration) *Lockout {
+	return &Lockout{MaxFailures: maxFailures, Duration: duration, MaxDuration: maxDuration, MaxEntries: 100000, entries: map[string]*lockoutEntry{}}
+}
+
+// Locked returns time left until lockout of key ends, 0 if key is not locked out
+func (l *Lockout) Locked(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, ok := l.entries[key]; ok && now.Before(e.until) {
+		return e.until.Sub(now)
+	}
+	return 0
+}
+
+// Fail counts failure of key and returns duration of lockout, if key is locked out by this failure
+func (l *Lockout) Fail(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if ok && l.forgiven(e, now) {
+		e, ok = nil, false
+	}
+	if !ok {
+		if len(l.entries) >= l.MaxEntries {
+			l.evict(now)
+		}
+		if len(l.entries) >= l.MaxEntries {
+			// every tracked key is locked out
+			return 0
+		}
+		e = &lockoutEntry{}
+		l.entries[key] = e
+	}
+	e.last = now
+	if now.Before(e.until) {
+		return 0
+	}
+
+	e.failures++
+	if e.failures < l.MaxFailures {
+		return 0
+	}
+	e.failures = 0
+	d := l.Duration
+	for i := 0; i < e.lockouts && d < l.MaxDuration; i++ {
+		d *= 2
+	}
+	if d > l.MaxDuration {
+		d = l.MaxDuration
+	}
+	e.lockouts++
+	e.until = now.Add(d)
+	return d
+}
+
+// Reset forgets failures of key. Number of lockouts is kept, so next lockout still lasts longer, until key is
+// forgiven.
+func (l *Lockout) Reset(key string) {
+	l.mu.Lock()
+	if e, ok := l.entries[key]; ok {
+		e.failures = 0
+	}
+	l.mu.Unlock()
+}
+
+func (l *Lockout) forgiven(e *lockoutEntry, now time.Time) bool {
+	return !now.Before(e.until) && now.Sub(e.last) >= l.MaxDuration
+}
+
+// evict removes forgiven keys and, if there are still too many keys, the oldest keys that are not locked out,
+// down to 90% of MaxEntries, so keys are not sorted on every new key
+func (l *Lockout) evict(now time.Time) {
+	type candidate struct {
+		key  string
+		last time.Time
+	}
+	var candidates []candidate
+	for k, e := range l.entries {
+		switch {
+		case l.forgiven(e, now):
+			delete(l.entries, k)
+		case !now.Before(e.until):
+			candidates = append(candidates, candidate{key: k, last: e.last})
+		}
+	}
+	if len(l.entries) < l.MaxEntries {
+		return
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].last.Before(candidates[j].last) })
+	keep := l.MaxEntries - l.MaxEntries/10 - 1
+	for _, c := range candidates {
+		if len(l.entries) <= keep {
+			return
+		}
+		delete(l.entries, c.key)
+	}
+}
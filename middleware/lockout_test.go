@@ -0,0 +1,224 @@
+package mw
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/labstack/echo"
+)
+
+func TestLockout(t *testing.T) {
+	type testCase struct {
+		name     string
+		failures int
+		after    time.Duration
+		lockout  time.Duration
+		locked   time.Duration
+	}
+
+	// each case continues failures of previous case
+	cases := []testCase{
+		testCase{name: "below limit", failures: 2},
+		testCase{name: "first lockout", failures: 1, lockout: time.Minute, locked: time.Minute},
+		testCase{name: "failure while locked", failures: 5, after: 30 * time.Second, locked: 30 * time.Second},
+		testCase{name: "second lockout", failures: 3, after: time.Minute, lockout: 2 * time.Minute, locked: 2 * time.Minute},
+		testCase{name: "third lockout", failures: 3, after: 2 * time.Minute, lockout: 4 * time.Minute, locked: 4 * time.Minute},
+		testCase{name: "max lockout", failures: 3, after: 4 * time.Minute, lockout: 5 * time.Minute, locked: 5 * time.Minute},
+		testCase{name: "lockout ended", after: 5 * time.Minute},
+		testCase{name: "forgiven", failures: 2, after: 5 * time.Minute},
+		testCase{name: "first lockout again", failures: 1, lockout: time.Minute, locked: time.Minute},
+	}
+
+	l := NewLockout(3, time.Minute, 5*time.Minute)
+	now := time.Now()
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			now = now.Add(cs.after)
+			var lockout time.Duration
+			for i := 0; i < cs.failures; i++ {
+				if d := l.Fail("test-system", now); d > 0 {
+					lockout = d
+				}
+			}
+			if lockout != cs.lockout {
+				t.Errorf("expected lockout %v, got %v", cs.lockout, lockout)
+			}
+			if d := l.Locked("test-system", now); d != cs.locked {
+				t.Errorf("expected locked for %v, got %v", cs.locked, d)
+			}
+			if d := l.Locked("other-system", now); d != 0 {
+				t.Errorf("expected other key not to be locked, got %v", d)
+			}
+		})
+	}
+
+	now = now.Add(time.Minute)
+	l.Fail("test-system", now)
+	l.Reset("test-system")
+	if d := l.Fail("test-system", now); d != 0 || len(l.entries) != 1 {
+		t.Errorf("expected failures of reset key to start again, got lockout %v", d)
+	}
+	if d := l.Fail("test-system", now.Add(time.Second)); d != 0 {
+		t.Errorf("expected failures of reset key to start again, got lockout %v", d)
+	}
+	if d := l.Fail("test-system", now.Add(2*time.Second)); d != 2*time.Minute {
+		t.Errorf("expected reset key to keep doubling lockouts, got lockout %v", d)
+	}
+
+	l.MaxEntries = 2
+	for _, k := range []string{"a", "b", "c"} {
+		l.Fail(k, now)
+	}
+	if len(l.entries) != 2 {
+		t.Errorf("expected lockout to be bounded, got %d entries", len(l.entries))
+	}
+
+	// locked keys survive flood of new keys, the oldest keys are evicted first
+	l = NewLockout(2, time.Hour, time.Hour)
+	l.MaxEntries = 10
+	l.Fail("locked", now)
+	l.Fail("locked", now)
+	l.Fail("old", now.Add(time.Second))
+	for i := 0; i < 100; i++ {
+		l.Fail(strings.Repeat("x", i+1), now.Add(time.Minute+time.Duration(i)*time.Millisecond))
+	}
+	if len(l.entries) > l.MaxEntries {
+		t.Errorf("expected lockout to be bounded, got %d entries", len(l.entries))
+	}
+	if d := l.Locked("locked", now); d != time.Hour {
+		t.Errorf("expected locked key not to be evicted, got %v", d)
+	}
+	if _, ok := l.entries["old"]; ok {
+		t.Error("expected the oldest key to be evicted")
+	}
+	if _, ok := l.entries[strings.Repeat("x", 100)]; !ok {
+		t.Error("expected the newest key to be tracked")
+	}
+}
+
+func TestBCryptAPIKeyLockout(t *testing.T) {
+	type testCase struct {
+		name       string
+		system     string
+		key        string
+		remoteAddr string
+		result     int
+	}
+
+	// each case continues failures of previous case
+	cases := []testCase{
+		testCase{name: "first failure", system: "test-system", key: "wrong", result: 401},
+		testCase{name: "success resets client", system: "test-system", key: "api-key-2", result: 200},
+		testCase{name: "second failure", system: "test-system", key: "wrong", result: 401},
+		testCase{name: "client locked out", system: "test-system", key: "wrong", result: 401},
+		testCase{name: "locked client", system: "test-system", key: "api-key-2", result: 429},
+		testCase{name: "client from other address", system: "test-system", key: "api-key-2", remoteAddr: "10.0.0.2:1000", result: 200},
+		testCase{name: "unknown client", system: "other-system", key: "wrong", remoteAddr: "10.0.0.2:1000", result: 401},
+		testCase{name: "unknown client locked out", system: "other-system", key: "wrong", remoteAddr: "10.0.0.2:1000", result: 401},
+		testCase{name: "locked unknown client", system: "other-system", key: "wrong", remoteAddr: "10.0.0.2:1000", result: 429},
+		testCase{name: "address locked out", system: "third-system", key: "wrong", result: 401},
+		testCase{name: "locked address", system: "fourth-system", key: "wrong", result: 429},
+		testCase{name: "locked address with valid key", system: "test-system", key: "api-key-2", result: 429},
+	}
+
+	out := &bytes.Buffer{}
+	logger := logrus.New()
+	logger.Out = out
+	logger.Formatter = &logrus.JSONFormatter{}
+
+	e := echo.New()
+	h := BCryptAPIKeyWithConfig(BCryptAPIKeyConfig{
+		Keys:              map[string]string{"test-system": /*api-key-2*/ "$2a$04$Wr/aAu5Wtf8xrfhsTiiIKuQe4sqoDLBY8Sdss6D/nJ8lkG.HKpWD."},
+		Logger:            logger,
+		MaxClientFailures: 2,
+		MaxIPFailures:     4,
+		CacheTTL:          -1,
+	})(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Add("X-NPRXY-Client", cs.system)
+			req.Header.Add("X-NPRXY-Key", cs.key)
+			req.RemoteAddr = "10.0.0.1:1000"
+			if cs.remoteAddr != "" {
+				req.RemoteAddr = cs.remoteAddr
+			}
+			res := httptest.NewRecorder()
+
+			err := h(e.NewContext(req, res))
+			if he, ok := err.(*echo.HTTPError); ok {
+				res.Code = he.Code
+			}
+			if res.Code != cs.result {
+				t.Fatalf("expected %d, got %d", cs.result, res.Code)
+			}
+			if ra := res.Header().Get("Retry-After"); (cs.result == 429) != (ra != "") {
+				t.Errorf("unexpected Retry-After %q", ra)
+			}
+		})
+	}
+
+	if n := strings.Count(out.String(), `"event":"authn_lockout"`); n != 3 || !strings.Contains(out.String(), `"lockout":"ip"`) {
+		t.Errorf("expected lockouts of 2 clients and address, got %s", out.String())
+	}
+}
+
+func TestBCryptAPIKeyUnknownClientTiming(t *testing.T) {
+	hash := /*api-key*/ "$2a$08$C7as.2lBu3IECKSxwuu7P.XQWoTW7/p6H990oxLNn1l/7VbuUtJ5S"
+	h := BCryptAPIKeyWithConfig(BCryptAPIKeyConfig{Keys: map[string]string{"test-system": hash}, MaxClientFailures: -1, MaxIPFailures: -1})(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	e := echo.New()
+	elapsed := func(system string) time.Duration {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Add("X-NPRXY-Client", system)
+		req.Header.Add("X-NPRXY-Key", "wrong")
+		start := time.Now()
+		h(e.NewContext(req, httptest.NewRecorder()))
+		return time.Since(start)
+	}
+	elapsed("other-system")
+
+	known, unknown := elapsed("test-system"), elapsed("other-system")
+	if unknown < known/4 {
+		t.Errorf("expected unknown client to be checked as long as known one, got %v and %v", unknown, known)
+	}
+
+	// failures are checked against as many hashes as client with most keys has
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys.yaml")
+	ioutil.WriteFile(path, []byte("version: 2\nclients:\n  test-system:\n    keys:\n"+
+		"    - {id: a, hash: "+hash+"}\n    - {id: b, hash: "+hash+"}\n    - {id: c, hash: "+hash+"}\n"), 0600)
+	store, err := NewKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := store.MaxKeys(); n != 3 {
+		t.Fatalf("expected 3 keys, got %d", n)
+	}
+	h = BCryptAPIKeyWithConfig(BCryptAPIKeyConfig{Store: store, MaxClientFailures: -1, MaxIPFailures: -1})(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	elapsed("other-system")
+
+	known, unknown = elapsed("test-system"), elapsed("other-system")
+	if unknown < known/2 {
+		t.Errorf("expected unknown client to be checked as long as client with several keys, got %v and %v", unknown, known)
+	}
+}
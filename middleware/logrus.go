@@ -1,7 +1,6 @@
 package mw
 
 import (
-	"fmt"
 	"strconv"
 	"time"
 
@@ -17,14 +16,18 @@ type (
 		Skipper middleware.Skipper
 
 		Logger logrus.FieldLogger
+
+		// ContextKeys values of context logged with request, if set
+		ContextKeys []string
 	}
 )
 
 var (
 	// defaultLogrusConfig is the default LogrusConfig middleware config.
 	defaultLogrusConfig = LogrusConfig{
-		Skipper: middleware.DefaultSkipper,
-		Logger:  logrus.StandardLogger(),
+		Skipper:     middleware.DefaultSkipper,
+		Logger:      logrus.StandardLogger(),
+		ContextKeys: []string{"client", "authn"},
 	}
 )
 
@@ -37,6 +40,12 @@ func LogrusWithConfig(config LogrusConfig) echo.MiddlewareFunc {
 	if config.Skipper == nil {
 		config.Skipper = defaultLogrusConfig.Skipper
 	}
+	if config.Logger == nil {
+		config.Logger = defaultLogrusConfig.Logger
+	}
+	if config.ContextKeys == nil {
+		config.ContextKeys = defaultLogrusConfig.ContextKeys
+	}
 
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
@@ -62,7 +71,7 @@ func LogrusWithConfig(config LogrusConfig) echo.MiddlewareFunc {
 				bytesIn = "0"
 			}
 
-			config.Logger.WithFields(map[string]interface{}{
+			fields := map[string]interface{}{
 				"time_rfc3339":  time.Now().Format(time.RFC3339),
 				"request_id":    res.Header().Get(echo.HeaderXRequestID),
 				"remote_ip":     c.RealIP(),
@@ -77,7 +86,13 @@ func LogrusWithConfig(config LogrusConfig) echo.MiddlewareFunc {
 				"latency_human": stop.Sub(start).String(),
 				"bytes_in":      bytesIn,
 				"bytes_out":     strconv.FormatInt(res.Size, 10),
-			}).Info("Handled request")
+			}
+			for _, k := range config.ContextKeys {
+				if v := c.Get(k); v != nil {
+					fields[k] = v
+				}
+			}
+			config.Logger.WithFields(fields).Info("Handled request")
 
 			return nil
 		}
@@ -91,14 +106,14 @@ func LogrusBodyLogger(l logrus.FieldLogger) func(c echo.Context, reqB []byte, re
 		if len(reqB) > 0 {
 			l.WithFields(map[string]interface{}{
 				"request_id": res.Header().Get(echo.HeaderXRequestID),
-				"body":       fmt.Sprintf("%.20000s", string(reqB)),
+				"body":       string(reqB),
 			}).Info("Request body")
 		}
 
 		if len(resB) > 0 {
 			l.WithFields(map[string]interface{}{
 				"request_id": res.Header().Get(echo.HeaderXRequestID),
-				"body":       fmt.Sprintf("%.20000s", string(resB)),
+				"body":       string(resB),
 			}).Info("Response body")
 		}
 	}
@@ -1,8 +1,13 @@
 package mw
 
 import (
+	"bytes"
+	"encoding/xml"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"strings"
 
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
@@ -17,16 +22,64 @@ type (
 		// ContextKey key to output client if authenticated
 		ContextKey string
 
-		// Kind switches operation resolution logic. Supported: "soap"
+		// Kind switches operation resolution logic. Supported: "soap", "grpc", "jsonrpc", "graphql", "rest"
 		Kind string
+
+		// BatchKey key to output JSON-RPC batch, for authorization of each call
+		BatchKey string
+
+		// MaxBodyBytes limits size of JSON-RPC and GraphQL request body
+		MaxBodyBytes int64
+
+		// OperationTypeKey key to output GraphQL operation type: query, mutation or subscription
+		OperationTypeKey string
+
+		// FieldsKey key to output comma separated sorted top-level fields of GraphQL operation
+		FieldsKey string
+
+		// MaxDepth max nesting of fields of GraphQL operation, 0 for no limit
+		MaxDepth int
+
+		// MaxAliases max number of aliased fields of GraphQL operation, 0 for no limit
+		MaxAliases int
+
+		// MaxComplexity max estimated complexity of GraphQL operation, 0 for no limit
+		MaxComplexity int
+
+		// Routes map method and path template to REST operation
+		Routes []RESTRoute
+
+		// PathParamPrefix prefix of keys to output path parameters of REST route: "path.id" for /orders/{id}
+		PathParamPrefix string
+
+		// SOAPSources order in which SOAP operation is looked up. Supported: "action" - SOAP 1.1 SOAPAction header,
+		// "content-type" - action parameter of SOAP 1.2 application/soap+xml content type,
+		// "envelope" - first child element of soap:Body as {namespace}LocalName
+		SOAPSources []string
+
+		// MaxEnvelopeBytes limits part of request body parsed to find first child element of soap:Body
+		MaxEnvelopeBytes int64
 	}
 )
 
+// SOAP envelope namespaces
+const (
+	SOAP11Namespace = "http://schemas.xmlsoap.org/soap/envelope/"
+	SOAP12Namespace = "http://www.w3.org/2003/05/soap-envelope"
+)
+
 var (
 	// defaultOperationResolver is the default OperationResolver middleware config.
 	defaultOperationResolver = OperationResolverConfig{
-		Skipper:    middleware.DefaultSkipper,
-		ContextKey: "operation",
+		Skipper:          middleware.DefaultSkipper,
+		ContextKey:       "operation",
+		BatchKey:         "batch",
+		MaxBodyBytes:     1 << 20,
+		SOAPSources:      []string{"action", "content-type", "envelope"},
+		MaxEnvelopeBytes: 64 << 10,
+		OperationTypeKey: "operationType",
+		FieldsKey:        "fields",
+		PathParamPrefix:  "path.",
 	}
 )
 
@@ -44,15 +97,116 @@ func OperationResolverWithConfig(config OperationResolverConfig) echo.Middleware
 	if config.Skipper == nil {
 		config.Skipper = defaultOperationResolver.Skipper
 	}
+	if config.ContextKey == "" {
+		config.ContextKey = defaultOperationResolver.ContextKey
+	}
+	if len(config.SOAPSources) == 0 {
+		config.SOAPSources = defaultOperationResolver.SOAPSources
+	}
+	if config.MaxEnvelopeBytes == 0 {
+		config.MaxEnvelopeBytes = defaultOperationResolver.MaxEnvelopeBytes
+	}
+	if config.BatchKey == "" {
+		config.BatchKey = defaultOperationResolver.BatchKey
+	}
+	if config.MaxBodyBytes == 0 {
+		config.MaxBodyBytes = defaultOperationResolver.MaxBodyBytes
+	}
+	if config.OperationTypeKey == "" {
+		config.OperationTypeKey = defaultOperationResolver.OperationTypeKey
+	}
+	if config.FieldsKey == "" {
+		config.FieldsKey = defaultOperationResolver.FieldsKey
+	}
+	if config.PathParamPrefix == "" {
+		config.PathParamPrefix = defaultOperationResolver.PathParamPrefix
+	}
 
-	resolvers := map[string]func(r *http.Request) (string, error){}
+	resolvers := map[string]func(c echo.Context) (string, error){}
 
-	resolvers["soap"] = func(r *http.Request) (string, error) {
-		if op := r.Header.Get("SOAPAction"); op != "" {
-			return op, nil
+	soapSources := map[string]func(r *http.Request) (string, error){
+		"action": func(r *http.Request) (string, error) {
+			return r.Header.Get("SOAPAction"), nil
+		},
+		"content-type": func(r *http.Request) (string, error) {
+			mt, params, err := mime.ParseMediaType(r.Header.Get(echo.HeaderContentType))
+			if err != nil || mt != "application/soap+xml" {
+				return "", nil
+			}
+			return params["action"], nil
+		},
+		"envelope": func(r *http.Request) (string, error) {
+			return soapBodyElement(r, config.MaxEnvelopeBytes)
+		},
+	}
+	for _, s := range config.SOAPSources {
+		if _, ok := soapSources[s]; !ok {
+			panic(fmt.Errorf("unsupported SOAP operation source %s", s))
+		}
+	}
+
+	resolvers["soap"] = func(c echo.Context) (string, error) {
+		for _, s := range config.SOAPSources {
+			op, err := soapSources[s](c.Request())
+			if err != nil {
+				return "", err
+			}
+			if op != "" {
+				return op, nil
+			}
 		}
 
-		return "", fmt.Errorf("SOAPAction not set")
+		return "", fmt.Errorf("SOAP operation not set")
+	}
+
+	resolvers["grpc"] = func(c echo.Context) (string, error) {
+		// gRPC method path is /package.Service/Method
+		r := c.Request()
+		if p := strings.Split(r.URL.Path, "/"); len(p) == 3 && p[0] == "" && p[1] != "" && p[2] != "" {
+			return r.URL.Path, nil
+		}
+
+		return "", fmt.Errorf("invalid gRPC method path %s", r.URL.Path)
+	}
+
+	resolvers["jsonrpc"] = func(c echo.Context) (string, error) {
+		b, err := parseJSONRPC(c.Request(), config.MaxBodyBytes)
+		if err != nil {
+			return "", err
+		}
+		if b.single {
+			return b.Calls[0].Method, nil
+		}
+
+		// Batch is authorized call by call, joined methods match no policy, if authorizer is not batch aware
+		b.operationKey = config.ContextKey
+		c.Set(config.BatchKey, b)
+		var methods []string
+		for _, call := range b.Calls {
+			methods = append(methods, call.Method)
+		}
+		return strings.Join(methods, ","), nil
+	}
+
+	resolvers["graphql"] = func(c echo.Context) (string, error) {
+		return resolveGraphQL(c, config)
+	}
+
+	if config.Kind == "rest" {
+		router, err := newRESTRouter(config.Routes)
+		if err != nil {
+			panic(err)
+		}
+		resolvers["rest"] = func(c echo.Context) (string, error) {
+			op, params, ok := router.match(c.Request())
+			if !ok {
+				return "", echo.ErrNotFound
+			}
+			for k, v := range params {
+				c.Set(config.PathParamPrefix+k, v)
+			}
+			return op, nil
+		}
 	}
 
 	if resolver, ok := resolvers[config.Kind]; ok {
@@ -62,7 +216,10 @@ func OperationResolverWithConfig(config OperationResolverConfig) echo.Middleware
 					return next(c)
 				}
 
-				op, err := resolver(c.Request())
+				op, err := resolver(c)
+				if he, ok := err.(*echo.HTTPError); ok {
+					return he
+				}
 				if err != nil {
 					return echo.ErrBadRequest
 				}
@@ -74,3 +231,57 @@ func OperationResolverWithConfig(config OperationResolverConfig) echo.Middleware
 	}
 	panic(fmt.Errorf("unsupported operation resolver kind"))
 }
+
+// soapBodyElement streams SOAP 1.1 or 1.2 envelope up to the first child element of Body and returns its name
+// as {namespace}LocalName. Consumed part of body is replayed to the next readers of request body.
+func soapBodyElement(r *http.Request, limit int64) (string, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return "", nil
+	}
+
+	consumed := &bytes.Buffer{}
+	d := xml.NewDecoder(io.TeeReader(io.LimitReader(r.Body, limit), consumed))
+	defer func() {
+		r.Body = &teeReadCloser{Reader: io.MultiReader(consumed, r.Body), Closer: r.Body}
+	}()
+
+	// Path from root: Envelope, Body, operation element
+	depth := 0
+	ns := ""
+	for {
+		t, err := d.Token()
+		if err != nil {
+			if err == io.EOF && consumed.Len() >= int(limit) {
+				return "", fmt.Errorf("SOAP body element not found within %d bytes", limit)
+			}
+			return "", err
+		}
+
+		switch e := t.(type) {
+		case xml.StartElement:
+			switch depth {
+			case 0:
+				if e.Name.Local != "Envelope" || (e.Name.Space != SOAP11Namespace && e.Name.Space != SOAP12Namespace) {
+					return "", fmt.Errorf("not a SOAP envelope")
+				}
+				ns = e.Name.Space
+			case 1:
+				if e.Name.Local == "Body" && e.Name.Space == ns {
+					depth++
+					continue
+				}
+				// Skip Header
+				if err := d.Skip(); err != nil {
+					return "", err
+				}
+				continue
+			case 2:
+				return "{" + e.Name.Space + "}" + e.Name.Local, nil
+			}
+			depth++
+		case xml.EndElement:
+			// Body or Envelope closed before operation element
+			return "", fmt.Errorf("SOAP body is empty")
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package mw
 
 import (
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/labstack/echo"
@@ -61,3 +63,126 @@ func TestOperationResolverSOAP(t *testing.T) {
 		})
 	}
 }
+
+func TestOperationResolverGRPC(t *testing.T) {
+	type testCase struct {
+		name   string
+		path   string
+		result int
+	}
+
+	cases := []testCase{
+		testCase{name: "success", path: "/helloworld.Greeter/SayHello", result: 200},
+		testCase{name: "no method", path: "/helloworld.Greeter/", result: 400},
+		testCase{name: "nested", path: "/api/helloworld.Greeter/SayHello", result: 400},
+	}
+
+	e := echo.New()
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", cs.path, nil)
+			res := httptest.NewRecorder()
+
+			c := e.NewContext(req, res)
+
+			var op string
+			h := OperationResolver("grpc")(func(c echo.Context) error {
+				op = c.Get("operation").(string)
+				return c.NoContent(http.StatusOK)
+			})
+
+			err := h(c)
+
+			if err != nil {
+				if errObj, ok := err.(*echo.HTTPError); ok {
+					if errObj.Code != cs.result {
+						t.Errorf("expected %d, got: %d", cs.result, errObj.Code)
+					}
+				} else {
+					t.Error(err)
+				}
+			} else if c.Response().Status != cs.result {
+				t.Errorf("expected %d, got: %d", cs.result, c.Response().Status)
+			} else if op != cs.path {
+				t.Errorf("expected operation to be '%s', got : '%s'", cs.path, op)
+			}
+		})
+	}
+}
+
+func TestOperationResolverSOAPSources(t *testing.T) {
+	envelope11 := `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">
+  <s:Header><h:Trace xmlns:h="urn:trace">1</h:Trace></s:Header>
+  <s:Body><m:GetOrder xmlns:m="http://tempuri.org/orders"><m:Id>1</m:Id></m:GetOrder></s:Body>
+</s:Envelope>`
+	envelope12 := `<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope"><env:Body><GetOrder xmlns="http://tempuri.org/orders"/></env:Body></env:Envelope>`
+
+	type testCase struct {
+		name    string
+		headers map[string]string
+		body    string
+		sources []string
+		limit   int64
+		result  int
+		op      string
+	}
+
+	cases := []testCase{
+		testCase{name: "soap 1.2 action", headers: map[string]string{"Content-Type": `application/soap+xml; charset=utf-8; action="http://tempuri.org/test"`}, body: envelope12, result: 200, op: "http://tempuri.org/test"},
+		testCase{name: "soap 1.1 envelope", headers: map[string]string{"Content-Type": "text/xml"}, body: envelope11, result: 200, op: "{http://tempuri.org/orders}GetOrder"},
+		testCase{name: "soap 1.2 envelope", headers: map[string]string{"Content-Type": "application/soap+xml"}, body: envelope12, result: 200, op: "{http://tempuri.org/orders}GetOrder"},
+		testCase{name: "envelope precedence", headers: map[string]string{"SOAPAction": "http://tempuri.org/test"}, body: envelope11, sources: []string{"envelope", "action"}, result: 200, op: "{http://tempuri.org/orders}GetOrder"},
+		testCase{name: "header precedence", headers: map[string]string{"SOAPAction": "http://tempuri.org/test"}, body: envelope11, result: 200, op: "http://tempuri.org/test"},
+		testCase{name: "limit", body: envelope11, limit: 64, result: 400},
+		testCase{name: "empty body", body: `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body/></s:Envelope>`, result: 400},
+		testCase{name: "not soap", body: `<Envelope><Body><GetOrder/></Body></Envelope>`, result: 400},
+	}
+
+	e := echo.New()
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(cs.body))
+			for k, v := range cs.headers {
+				req.Header.Set(k, v)
+			}
+
+			res := httptest.NewRecorder()
+
+			c := e.NewContext(req, res)
+
+			config := defaultOperationResolver
+			config.Kind = "soap"
+			config.SOAPSources = cs.sources
+			config.MaxEnvelopeBytes = cs.limit
+
+			var op, body string
+			h := OperationResolverWithConfig(config)(func(c echo.Context) error {
+				op = c.Get("operation").(string)
+				b, _ := ioutil.ReadAll(c.Request().Body)
+				body = string(b)
+				return c.NoContent(http.StatusOK)
+			})
+
+			err := h(c)
+
+			if err != nil {
+				if errObj, ok := err.(*echo.HTTPError); ok {
+					if errObj.Code != cs.result {
+						t.Errorf("expected %d, got: %d", cs.result, errObj.Code)
+					}
+				} else {
+					t.Error(err)
+				}
+			} else if c.Response().Status != cs.result {
+				t.Errorf("expected %d, got: %d", cs.result, c.Response().Status)
+			} else if op != cs.op {
+				t.Errorf("expected operation to be '%s', got : '%s'", cs.op, op)
+			} else if body != cs.body {
+				t.Errorf("expected request body to be restored, got: %s", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package mw
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/artyomturkin/nprxy/jsonschema"
+	"github.com/labstack/echo"
+)
+
+// MIMEApplicationProblemJSON content type of RFC 7807 problem details
+const MIMEApplicationProblemJSON = "application/problem+json"
+
+// Problem RFC 7807 problem details
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Errors invalid values of JSON request
+	Errors []jsonschema.Violation `json:"errors,omitempty"`
+}
+
+// ProblemJSONHandler renders error as RFC 7807 application/problem+json response.
+// Title is status text of error, message of error is used as detail when it differs from title.
+// Invalid values of JSONError are listed in errors member.
+func ProblemJSONHandler(err error, c echo.Context) {
+	code := http.StatusInternalServerError
+	msg := http.StatusText(code)
+	if he, ok := err.(*echo.HTTPError); ok {
+		code = he.Code
+		msg = fmt.Sprintf("%v", he.Message)
+	}
+
+	if c.Response().Committed {
+		return
+	}
+
+	p := Problem{Type: "about:blank", Title: http.StatusText(code), Status: code}
+	if he, ok := err.(*echo.HTTPError); ok {
+		if je, ok := he.Message.(*JSONError); ok {
+			p.Errors = je.Errors
+		}
+	}
+	if msg != p.Title {
+		p.Detail = msg
+	}
+	if c.Request().Method == http.MethodHead {
+		c.NoContent(code)
+		return
+	}
+	b, _ := json.Marshal(p)
+	c.Blob(code, MIMEApplicationProblemJSON, b)
+}
@@ -0,0 +1,27 @@
+package mw
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+func TestProblemJSONHandler(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest("GET", "/", nil)
+	res := httptest.NewRecorder()
+	c := e.NewContext(req, res)
+
+	ProblemJSONHandler(echo.NewHTTPError(400, "invalid route"), c)
+
+	if res.Code != 400 {
+		t.Errorf("expected 400, got: %d", res.Code)
+	}
+	if ct := res.Header().Get(echo.HeaderContentType); ct != MIMEApplicationProblemJSON {
+		t.Errorf("expected content type %s, got: %s", MIMEApplicationProblemJSON, ct)
+	}
+	if b := res.Body.String(); b != `{"type":"about:blank","title":"Bad Request","status":400,"detail":"invalid route"}` {
+		t.Errorf("unexpected body: %s", b)
+	}
+}
@@ -0,0 +1,319 @@
+package mw
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+	yaml "gopkg.in/yaml.v2"
+)
+
+type (
+	// ProxyTokenConfig defines the config for ProxyToken middleware and ProxyTokenEndpoint handler.
+	ProxyTokenConfig struct {
+		// Skipper defines a function to skip middleware.
+		Skipper middleware.Skipper
+
+		// Keys secrets to sign and verify tokens
+		// Required.
+		Keys *TokenKeys
+
+		// Issuer iss claim of tokens
+		Issuer string
+
+		// Audience aud claim of tokens, e.g. name of service, tokens of other audiences are rejected
+		Audience string
+
+		// TTL lifetime of issued tokens
+		TTL time.Duration
+
+		// Claims context keys copied from request to token and restored from token, e.g. scopes for casbin
+		Claims []string
+
+		// ContextKey key of authenticated client
+		ContextKey string
+
+		// Stores of clients of authentication methods. Tokens of clients authenticated by key or HMAC secret of
+		// store carry fingerprint of it and are rejected once client is disabled, not allowed to connect from
+		// address or credential is not active anymore.
+		Stores []*KeyStore
+
+		// FingerprintKey key of fingerprint of credential that authenticated client, see KeyFingerprint
+		FingerprintKey string
+	}
+)
+
+// ProxyTokenType typ header of proxy tokens, that tells them apart from tokens of other issuers
+const ProxyTokenType = "nprxy+jwt"
+
+var (
+	// defaultProxyTokenConfig is the default ProxyToken middleware config.
+	defaultProxyTokenConfig = ProxyTokenConfig{
+		Skipper:    middleware.DefaultSkipper,
+		Issuer:     "nprxy",
+		TTL:        15 * time.Minute,
+		Claims:     []string{"claim.scope", "claim.roles"},
+		ContextKey: "client",
+
+		FingerprintKey: "client.key",
+	}
+)
+
+// ProxyToken returns a ProxyToken middleware.
+//
+// Client is authenticated by `Authorization: Bearer` token issued by ProxyTokenEndpoint. Token is HS256 JWT
+// signed by one of keys, it is verified without calling other services or computing hashes of credentials.
+// Token with fingerprint of key of Stores is accepted only while that key is active.
+func ProxyToken(keys *TokenKeys) echo.MiddlewareFunc {
+	c := defaultProxyTokenConfig
+	c.Keys = keys
+	return ProxyTokenWithConfig(c)
+}
+
+// ProxyTokenWithConfig returns a ProxyToken middleware with config.
+// See `ProxyToken()`.
+func ProxyTokenWithConfig(config ProxyTokenConfig) echo.MiddlewareFunc {
+	config = proxyTokenDefaults(config)
+
+	parser := &jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Alg()}, SkipClaimsValidation: true}
+	keyFunc := func(t *jwt.Token) (interface{}, error) {
+		if typ, _ := t.Header["typ"].(string); typ != ProxyTokenType {
+			return nil, fmt.Errorf("token is not proxy token")
+		}
+		kid, _ := t.Header["kid"].(string)
+		secret, ok := config.Keys.secret(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown key %s", kid)
+		}
+		return secret, nil
+	}
+	check := JWTConfig{Issuer: config.Issuer, Audience: config.Audience, ClientClaim: "sub"}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			claims := jwt.MapClaims{}
+			if _, err := parser.ParseWithClaims(bearerToken(c.Request()), claims, keyFunc); err != nil {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+				return echo.ErrUnauthorized
+			}
+			now := time.Now()
+			if err := checkClaims(claims, check, now); err != nil {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+				return echo.ErrUnauthorized
+			}
+			if key, ok := claims["key"].(string); ok && !keyActive(config.Stores, claims["sub"], key, c.Request(), now) {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+				return echo.ErrUnauthorized
+			}
+
+			values, _ := claims["ctx"].(map[string]interface{})
+			for _, k := range config.Claims {
+				if v, ok := values[k].(string); ok {
+					c.Set(k, v)
+				}
+			}
+			c.Set(config.ContextKey, claims["sub"])
+			return next(c)
+		}
+	}
+}
+
+// ProxyTokenEndpoint returns handler, that issues token to client authenticated by preceding middlewares.
+//
+// Response has OAuth 2.0 token response fields: access_token, token_type and expires_in.
+func ProxyTokenEndpoint(config ProxyTokenConfig) echo.HandlerFunc {
+	config = proxyTokenDefaults(config)
+
+	return func(c echo.Context) error {
+		client, _ := c.Get(config.ContextKey).(string)
+		if client == "" {
+			return echo.ErrUnauthorized
+		}
+
+		now := time.Now()
+		values := map[string]string{}
+		for _, k := range config.Claims {
+			if v, ok := c.Get(k).(string); ok {
+				values[k] = v
+			}
+		}
+		claims := jwt.MapClaims{
+			"iss": config.Issuer,
+			"sub": client,
+			"iat": now.Unix(),
+			"exp": now.Add(config.TTL).Unix(),
+			"ctx": values,
+		}
+		if config.Audience != "" {
+			claims["aud"] = config.Audience
+		}
+		if key, ok := c.Get(config.FingerprintKey).(string); ok && key != "" && len(config.Stores) > 0 {
+			claims["key"] = key
+		}
+
+		kid, secret := config.Keys.signing()
+		t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		t.Header["typ"] = ProxyTokenType
+		t.Header["kid"] = kid
+		token, err := t.SignedString(secret)
+		if err != nil {
+			return err
+		}
+
+		c.Response().Header().Set("Cache-Control", "no-store")
+		c.Response().Header().Set("Pragma", "no-cache")
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"access_token": token,
+			"token_type":   "Bearer",
+			"expires_in":   int(config.TTL.Seconds()),
+		})
+	}
+}
+
+// keyActive reports whether client of token still has active key with fingerprint in one of stores
+func keyActive(stores []*KeyStore, sub interface{}, key string, r *http.Request, now time.Time) bool {
+	client, _ := sub.(string)
+	for _, s := range stores {
+		if s.Active(client, remoteIP(r), key, now) {
+			return true
+		}
+	}
+	return false
+}
+
+func proxyTokenDefaults(config ProxyTokenConfig) ProxyTokenConfig {
+	if config.Skipper == nil {
+		config.Skipper = defaultProxyTokenConfig.Skipper
+	}
+	if config.Issuer == "" {
+		config.Issuer = defaultProxyTokenConfig.Issuer
+	}
+	if config.TTL == 0 {
+		config.TTL = defaultProxyTokenConfig.TTL
+	}
+	if config.Claims == nil {
+		config.Claims = defaultProxyTokenConfig.Claims
+	}
+	if config.ContextKey == "" {
+		config.ContextKey = defaultProxyTokenConfig.ContextKey
+	}
+	if config.FingerprintKey == "" {
+		config.FingerprintKey = defaultProxyTokenConfig.FingerprintKey
+	}
+	if config.Keys == nil {
+		panic("echo: proxy token requires keys")
+	}
+	return config
+}
+
+// HasProxyToken reports whether request carries Bearer token of ProxyTokenType, signature is not checked
+func HasProxyToken(c echo.Context) bool {
+	parts := strings.Split(bearerToken(c.Request()), ".")
+	if len(parts) != 3 {
+		return false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	var header struct {
+		Typ string `json:"typ"`
+	}
+	return json.Unmarshal(b, &header) == nil && header.Typ == ProxyTokenType
+}
+
+// TokenKeys secrets that sign proxy tokens. File is list of secrets, the first one signs new tokens and all of
+// them verify tokens, so secret is rotated by adding new secret first. Removing secret revokes tokens it signed.
+//
+//   - id: 2024-06
+//     secret: at least 32 random characters
+type TokenKeys struct {
+	// Path of secrets file, secret is generated for process if empty
+	Path string
+
+	mu   sync.RWMutex
+	keys []tokenKey
+}
+
+type tokenKey struct {
+	ID     string `yaml:"id"`
+	Secret string `yaml:"secret"`
+}
+
+// NewTokenKeys loads secrets file, or generates secret if path is empty
+func NewTokenKeys(path string) (*TokenKeys, error) {
+	k := &TokenKeys{Path: path}
+	if path == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		k.keys = []tokenKey{{ID: "process", Secret: string(b)}}
+		return k, nil
+	}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload reads file again, secrets are kept if file is invalid
+func (k *TokenKeys) Reload() error {
+	b, err := ioutil.ReadFile(k.Path)
+	if err != nil {
+		return fmt.Errorf("read token secrets %s: %v", k.Path, err)
+	}
+	var keys []tokenKey
+	if err := yaml.UnmarshalStrict(b, &keys); err != nil {
+		return fmt.Errorf("unmarshal token secrets %s: %v", k.Path, err)
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("token secrets %s: no secrets", k.Path)
+	}
+	for _, key := range keys {
+		if len(key.Secret) < 32 {
+			return fmt.Errorf("token secrets %s: secret %s is shorter than 32 characters", k.Path, key.ID)
+		}
+	}
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+	return nil
+}
+
+// Watch reloads file when it changes until returned watcher is closed
+func (k *TokenKeys) Watch() (io.Closer, error) {
+	return watchFile(k.Path, k.Reload)
+}
+
+// signing returns id and secret of key that signs new tokens
+func (k *TokenKeys) signing() (string, []byte) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys[0].ID, []byte(k.keys[0].Secret)
+}
+
+func (k *TokenKeys) secret(id string) ([]byte, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if key.ID == id {
+			return []byte(key.Secret), true
+		}
+	}
+	return nil, false
+}
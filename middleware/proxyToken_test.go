@@ -0,0 +1,219 @@
+package mw
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/labstack/echo"
+)
+
+const tokenSecrets = `- id: "2024-06"
+  secret: 0123456789abcdef0123456789abcdef
+`
+
+func TestProxyToken(t *testing.T) {
+	type testCase struct {
+		name   string
+		token  func(issue func(audience string, ttl time.Duration) string) string
+		result int
+		scope  string
+	}
+
+	signed := func(kid, typ string, claims jwt.MapClaims) string {
+		tk := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tk.Header["kid"] = kid
+		tk.Header["typ"] = typ
+		s, _ := tk.SignedString([]byte("0123456789abcdef0123456789abcdef"))
+		return s
+	}
+	exp := time.Now().Add(time.Minute).Unix()
+
+	cases := []testCase{
+		testCase{name: "issued", token: func(issue func(string, time.Duration) string) string { return issue("orders", time.Minute) }, result: 200, scope: "test-system orders:read|writer"},
+		testCase{name: "other audience", token: func(issue func(string, time.Duration) string) string { return issue("billing", time.Minute) }, result: 401},
+		testCase{name: "expired", token: func(issue func(string, time.Duration) string) string { return issue("orders", -time.Minute) }, result: 401},
+		testCase{name: "not proxy token", token: func(func(string, time.Duration) string) string {
+			return signed("2024-06", "JWT", jwt.MapClaims{"iss": "nprxy", "aud": "orders", "sub": "test-system", "exp": exp})
+		}, result: 401},
+		testCase{name: "unknown key", token: func(func(string, time.Duration) string) string {
+			return signed("2023-01", ProxyTokenType, jwt.MapClaims{"iss": "nprxy", "aud": "orders", "sub": "test-system", "exp": exp})
+		}, result: 401},
+		testCase{name: "other issuer", token: func(func(string, time.Duration) string) string {
+			return signed("2024-06", ProxyTokenType, jwt.MapClaims{"iss": "other", "aud": "orders", "sub": "test-system", "exp": exp})
+		}, result: 401},
+		testCase{name: "no credentials", token: func(func(string, time.Duration) string) string { return "" }, result: 401},
+	}
+
+	dir, err := ioutil.TempDir("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "secrets.yaml")
+	ioutil.WriteFile(path, []byte(tokenSecrets), 0600)
+	keys, err := NewTokenKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	issue := func(audience string, ttl time.Duration) string {
+		h := ProxyTokenEndpoint(ProxyTokenConfig{Keys: keys, Audience: audience, TTL: ttl})
+		req := httptest.NewRequest("POST", "/.nprxy/token", nil)
+		res := httptest.NewRecorder()
+		c := e.NewContext(req, res)
+		c.Set("client", "test-system")
+		c.Set("claim.scope", "orders:read")
+		c.Set("claim.roles", "writer")
+		if err := h(c); err != nil {
+			t.Fatal(err)
+		}
+		if res.Header().Get("Cache-Control") != "no-store" {
+			t.Error("expected token response not to be cached")
+		}
+		var tr struct {
+			AccessToken string `json:"access_token"`
+		}
+		json.Unmarshal(res.Body.Bytes(), &tr)
+		return tr.AccessToken
+	}
+
+	h := ProxyTokenWithConfig(ProxyTokenConfig{Keys: keys, Audience: "orders"})(func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Get("client").(string)+" "+c.Get("claim.scope").(string)+"|"+c.Get("claim.roles").(string))
+	})
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if token := cs.token(issue); token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			res := httptest.NewRecorder()
+			c := e.NewContext(req, res)
+
+			err := h(c)
+			if he, ok := err.(*echo.HTTPError); ok {
+				res.Code = he.Code
+			}
+			if res.Code != cs.result {
+				t.Fatalf("expected %d, got %d", cs.result, res.Code)
+			}
+			if cs.scope != "" && res.Body.String() != cs.scope {
+				t.Errorf("expected %s, got %s", cs.scope, res.Body.String())
+			}
+		})
+	}
+
+	// removed secret revokes tokens it signed
+	token := issue("orders", time.Minute)
+	ioutil.WriteFile(path, []byte("- id: \"2024-12\"\n  secret: fedcba9876543210fedcba9876543210\n"), 0600)
+	if err := keys.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if !HasProxyToken(e.NewContext(req, httptest.NewRecorder())) {
+		t.Error("expected token to be recognized as proxy token")
+	}
+	if err := h(e.NewContext(req, httptest.NewRecorder())); err == nil {
+		t.Error("expected token of removed secret to be rejected")
+	}
+
+	ioutil.WriteFile(path, []byte("- id: short\n  secret: secret\n"), 0600)
+	if err := keys.Reload(); err == nil {
+		t.Error("expected short secret to be rejected")
+	}
+}
+
+func TestProxyTokenKeyStore(t *testing.T) {
+	type testCase struct {
+		name       string
+		keys       string
+		remoteAddr string
+		result     int
+	}
+
+	client := func(s string) string { return "version: 2\nclients:\n  test-system:\n" + s }
+	key := func(id, hash string) string { return "    - {id: \"" + id + "\", hash: \"" + hash + "\"}\n" }
+
+	// each case reloads keys file and checks token issued for the first key
+	cases := []testCase{
+		testCase{name: "active key", keys: client("    keys:\n" + key("2024-06", apiKey2Hash)), result: 200},
+		testCase{name: "rotated key", keys: client("    keys:\n" + key("2024-12", apiKeyHash) + key("2024-06", apiKey2Hash)), result: 200},
+		testCase{name: "allowed network", keys: client("    cidrs: [192.0.2.0/24]\n    keys:\n" + key("2024-06", apiKey2Hash)), result: 200},
+		testCase{name: "other network", keys: client("    cidrs: [192.0.2.0/24]\n    keys:\n" + key("2024-06", apiKey2Hash)), remoteAddr: "10.0.0.1:1000", result: 401},
+		testCase{name: "removed key", keys: client("    keys:\n" + key("2024-12", apiKeyHash)), result: 401},
+		testCase{name: "expired key", keys: client("    keys:\n    - {id: \"2024-06\", hash: \"" + apiKey2Hash + "\", expires: 2020-01-01T00:00:00Z}\n"), result: 401},
+		testCase{name: "disabled client", keys: client("    disabled: true\n    keys:\n" + key("2024-06", apiKey2Hash)), result: 401},
+		testCase{name: "removed client", keys: "version: 2\nclients:\n  other-system:\n    keys:\n" + key("2024-06", apiKey2Hash), result: 401},
+	}
+
+	dir, err := ioutil.TempDir("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "secrets.yaml")
+	ioutil.WriteFile(path, []byte(tokenSecrets), 0600)
+	keys, err := NewTokenKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storePath := filepath.Join(dir, "keys.yaml")
+	ioutil.WriteFile(storePath, []byte(cases[0].keys), 0600)
+	store, err := NewKeyStore(storePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	config := ProxyTokenConfig{Keys: keys, Audience: "orders", Stores: []*KeyStore{store}}
+	endpoint := BCryptAPIKeyWithConfig(BCryptAPIKeyConfig{Store: store})(ProxyTokenEndpoint(config))
+	req := httptest.NewRequest("POST", "/.nprxy/token", nil)
+	req.RemoteAddr = "192.0.2.1:1000"
+	req.Header.Add("X-NPRXY-Client", "test-system")
+	req.Header.Add("X-NPRXY-Key", "api-key-2")
+	res := httptest.NewRecorder()
+	if err := endpoint(e.NewContext(req, res)); err != nil {
+		t.Fatal(err)
+	}
+	var tr struct {
+		AccessToken string `json:"access_token"`
+	}
+	json.Unmarshal(res.Body.Bytes(), &tr)
+
+	h := ProxyTokenWithConfig(config)(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			ioutil.WriteFile(storePath, []byte(cs.keys), 0600)
+			if err := store.Reload(); err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = "192.0.2.1:1000"
+			if cs.remoteAddr != "" {
+				req.RemoteAddr = cs.remoteAddr
+			}
+			req.Header.Set("Authorization", "Bearer "+tr.AccessToken)
+			res := httptest.NewRecorder()
+
+			err := h(e.NewContext(req, res))
+			if he, ok := err.(*echo.HTTPError); ok {
+				res.Code = he.Code
+			}
+			if res.Code != cs.result {
+				t.Errorf("expected %d, got %d", cs.result, res.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package mw
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/artyomturkin/nprxy/jsonschema"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// RESTRoute maps method and path template to operation name
+type RESTRoute struct {
+	// Method HTTP method, "*" matches any method
+	Method string
+	// Path template, segments in braces match any single segment and are extracted as parameters: /orders/{id}
+	Path      string
+	Operation string
+}
+
+// ParseRESTRoute parses route in form "GET /orders/{id} getOrder"
+func ParseRESTRoute(s string) (RESTRoute, error) {
+	f := strings.Fields(s)
+	if len(f) != 3 {
+		return RESTRoute{}, fmt.Errorf("route %q must be in form: METHOD /path/{param} operation", s)
+	}
+	return RESTRoute{Method: strings.ToUpper(f[0]), Path: f[1], Operation: f[2]}, nil
+}
+
+// OpenAPIRoutes reads routes from paths of OpenAPI 3 document in YAML or JSON, operationId is used as operation name.
+// Operations without operationId are skipped.
+func OpenAPIRoutes(doc []byte) ([]RESTRoute, error) {
+	var d struct {
+		OpenAPI string                            `yaml:"openapi"`
+		Paths   map[string]map[string]interface{} `yaml:"paths"`
+	}
+	if err := yaml.Unmarshal(doc, &d); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(d.OpenAPI, "3.") {
+		return nil, fmt.Errorf("not an OpenAPI 3 document")
+	}
+
+	methods := []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+	var routes []RESTRoute
+	for path, item := range d.Paths {
+		for _, m := range methods {
+			op, ok := item[m].(map[interface{}]interface{})
+			if !ok {
+				continue
+			}
+			if id, ok := op["operationId"].(string); ok && id != "" {
+				routes = append(routes, RESTRoute{Method: strings.ToUpper(m), Path: path, Operation: id})
+			}
+		}
+	}
+	// Map iteration order is random, keep routes stable for matching of equally specific templates
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes, nil
+}
+
+// OpenAPISchemas reads JSON Schemas of JSON request bodies of OpenAPI 3 document by operationId.
+// Schemas may reference components of document.
+func OpenAPISchemas(doc []byte) (map[string]*jsonschema.Schema, error) {
+	var d struct {
+		Paths      map[string]map[string]interface{} `yaml:"paths"`
+		Components struct {
+			RequestBodies map[string]interface{} `yaml:"requestBodies"`
+		} `yaml:"components"`
+	}
+	if err := yaml.Unmarshal(doc, &d); err != nil {
+		return nil, err
+	}
+	root, err := jsonschema.Parse(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	schemas := map[string]*jsonschema.Schema{}
+	for path, item := range d.Paths {
+		for m, v := range item {
+			op, ok := v.(map[interface{}]interface{})
+			if !ok {
+				continue
+			}
+			id, _ := op["operationId"].(string)
+			body, _ := op["requestBody"].(map[interface{}]interface{})
+			if id == "" || body == nil {
+				continue
+			}
+			pointer := "/paths/" + jsonschema.Escape(path) + "/" + m + "/requestBody"
+			if ref, ok := body["$ref"].(string); ok {
+				name := strings.TrimPrefix(ref, "#/components/requestBodies/")
+				body, _ = d.Components.RequestBodies[name].(map[interface{}]interface{})
+				pointer = "/components/requestBodies/" + jsonschema.Escape(name)
+			}
+			content, _ := body["content"].(map[interface{}]interface{})
+			var types []string
+			for ct := range content {
+				if mt, ok := ct.(string); ok && (mt == "application/json" || strings.HasSuffix(mt, "+json")) {
+					types = append(types, mt)
+				}
+			}
+			if len(types) == 0 {
+				continue
+			}
+			sort.Strings(types)
+			if schemas[id], err = root.Ref(pointer + "/content/" + jsonschema.Escape(types[0]) + "/schema"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return schemas, nil
+}
+
+// restRoute compiled route template
+type restRoute struct {
+	RESTRoute
+	segments []string
+	// params names of parameters by segment index, empty for literal segments
+	params   []string
+	literals int
+}
+
+// restRouter matches request to the most specific route, the one with most literal segments
+type restRouter []restRoute
+
+func newRESTRouter(routes []RESTRoute) (restRouter, error) {
+	var r restRouter
+	for _, route := range routes {
+		if route.Method == "" || route.Operation == "" || !strings.HasPrefix(route.Path, "/") {
+			return nil, fmt.Errorf("invalid route %s %s %s", route.Method, route.Path, route.Operation)
+		}
+
+		c := restRoute{RESTRoute: route, segments: strings.Split(route.Path, "/")[1:]}
+		c.params = make([]string, len(c.segments))
+		for i, s := range c.segments {
+			if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") && len(s) > 2 {
+				c.params[i] = s[1 : len(s)-1]
+			} else {
+				c.literals++
+			}
+		}
+		r = append(r, c)
+	}
+	sort.SliceStable(r, func(i, j int) bool {
+		return r[i].literals > r[j].literals
+	})
+	return r, nil
+}
+
+// match returns operation and path parameters of request
+func (r restRouter) match(req *http.Request) (string, map[string]string, bool) {
+	segments := strings.Split(req.URL.EscapedPath(), "/")[1:]
+	for _, route := range r {
+		if route.Method != "*" && route.Method != req.Method {
+			continue
+		}
+		if len(route.segments) != len(segments) {
+			continue
+		}
+
+		params := map[string]string{}
+		matched := true
+		for i, s := range segments {
+			if route.params[i] == "" {
+				if s != route.segments[i] {
+					matched = false
+					break
+				}
+				continue
+			}
+			// Values that upstream could route as several segments would be authorized as one
+			v, err := url.PathUnescape(s)
+			if err != nil || v == "" || v == "." || v == ".." || strings.Contains(v, "/") {
+				matched = false
+				break
+			}
+			params[route.params[i]] = v
+		}
+		if matched {
+			return route.Operation, params, true
+		}
+	}
+	return "", nil, false
+}
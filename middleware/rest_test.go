@@ -0,0 +1,131 @@
+package mw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+func TestOperationResolverREST(t *testing.T) {
+	type testCase struct {
+		name   string
+		method string
+		path   string
+		result int
+		op     string
+		params map[string]interface{}
+	}
+
+	cases := []testCase{
+		testCase{name: "literal", method: "GET", path: "/orders/recent", result: 200, op: "recentOrders", params: map[string]interface{}{}},
+		testCase{name: "template", method: "GET", path: "/tenants/t1/orders/42", result: 200, op: "getOrder",
+			params: map[string]interface{}{"path.tenant": "t1", "path.id": "42"}},
+		testCase{name: "escaped", method: "DELETE", path: "/tenants/a%20b/orders/1", result: 200, op: "deleteOrder",
+			params: map[string]interface{}{"path.tenant": "a b", "path.id": "1"}},
+		testCase{name: "escaped slash", method: "GET", path: "/tenants/t1%2F..%2Ft2/orders/1", result: 404},
+		testCase{name: "dot dot", method: "GET", path: "/tenants/../orders/1", result: 404},
+		testCase{name: "escaped dot dot", method: "GET", path: "/tenants/%2E%2E/orders/1", result: 404},
+		testCase{name: "dot", method: "GET", path: "/tenants/./orders/1", result: 404},
+		testCase{name: "any method", method: "PATCH", path: "/health", result: 200, op: "health", params: map[string]interface{}{}},
+		testCase{name: "wrong method", method: "POST", path: "/orders/recent", result: 404},
+		testCase{name: "unknown path", method: "GET", path: "/tenants/t1/orders", result: 404},
+	}
+
+	e := echo.New()
+	m := OperationResolverWithConfig(OperationResolverConfig{Kind: "rest", Routes: []RESTRoute{
+		RESTRoute{Method: "GET", Path: "/tenants/{tenant}/orders/{id}", Operation: "getOrder"},
+		RESTRoute{Method: "DELETE", Path: "/tenants/{tenant}/orders/{id}", Operation: "deleteOrder"},
+		RESTRoute{Method: "GET", Path: "/orders/{id}", Operation: "getOwnOrder"},
+		RESTRoute{Method: "GET", Path: "/orders/recent", Operation: "recentOrders"},
+		RESTRoute{Method: "*", Path: "/health", Operation: "health"},
+	}})
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			req := httptest.NewRequest(cs.method, cs.path, nil)
+			res := httptest.NewRecorder()
+
+			c := e.NewContext(req, res)
+
+			var op string
+			params := map[string]interface{}{}
+			h := m(func(c echo.Context) error {
+				op = c.Get("operation").(string)
+				for _, k := range []string{"path.tenant", "path.id"} {
+					if v := c.Get(k); v != nil {
+						params[k] = v
+					}
+				}
+				return c.NoContent(http.StatusOK)
+			})
+
+			err := h(c)
+
+			if err != nil {
+				if errObj, ok := err.(*echo.HTTPError); ok {
+					if errObj.Code != cs.result {
+						t.Errorf("expected %d, got: %d", cs.result, errObj.Code)
+					}
+				} else {
+					t.Error(err)
+				}
+			} else if c.Response().Status != cs.result {
+				t.Errorf("expected %d, got: %d", cs.result, c.Response().Status)
+			} else if op != cs.op {
+				t.Errorf("expected operation to be '%s', got : '%s'", cs.op, op)
+			} else if !reflect.DeepEqual(params, cs.params) {
+				t.Errorf("expected path parameters %v, got: %v", cs.params, params)
+			}
+		})
+	}
+}
+
+func TestOpenAPIRoutes(t *testing.T) {
+	doc := `
+openapi: 3.0.1
+paths:
+  /orders/{id}:
+    parameters:
+      - name: id
+        in: path
+    get:
+      operationId: getOrder
+    delete:
+      summary: no operation id
+  /orders:
+    post:
+      operationId: createOrder
+`
+	routes, err := OpenAPIRoutes([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []RESTRoute{
+		RESTRoute{Method: "POST", Path: "/orders", Operation: "createOrder"},
+		RESTRoute{Method: "GET", Path: "/orders/{id}", Operation: "getOrder"},
+	}
+	if !reflect.DeepEqual(routes, expected) {
+		t.Errorf("expected routes %v, got: %v", expected, routes)
+	}
+
+	if _, err := OpenAPIRoutes([]byte(`swagger: "2.0"`)); err == nil {
+		t.Error("expected error for Swagger 2.0 document")
+	}
+}
+
+func TestParseRESTRoute(t *testing.T) {
+	r, err := ParseRESTRoute("get /orders/{id} getOrder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != (RESTRoute{Method: "GET", Path: "/orders/{id}", Operation: "getOrder"}) {
+		t.Errorf("unexpected route: %v", r)
+	}
+	if _, err := ParseRESTRoute("GET /orders"); err == nil {
+		t.Error("expected error for route without operation")
+	}
+}
@@ -0,0 +1,86 @@
+package mw
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// SOAPFaultNamespace namespace of SOAP 1.2 fault subcodes generated by proxy
+const SOAPFaultNamespace = "urn:nprxy:fault"
+
+// SOAPFaultHandler renders error as SOAP Fault. SOAP version is chosen by request content type:
+// application/soap+xml gets SOAP 1.2 fault, anything else gets SOAP 1.1 fault.
+//
+// SOAP 1.1 faults are sent with status 500, as required by WS-I Basic Profile, except 401 that keeps
+// authentication challenge working. faultcode is Client for 4xx errors and Server for the rest.
+// SOAP 1.2 faults keep HTTP status of error. Code is Sender for 4xx errors and Receiver for the rest,
+// Subcode names HTTP status in SOAPFaultNamespace, e.g. nprxy:Forbidden.
+func SOAPFaultHandler(err error, c echo.Context) {
+	code := http.StatusInternalServerError
+	msg := http.StatusText(code)
+	if he, ok := err.(*echo.HTTPError); ok {
+		code = he.Code
+		msg = fmt.Sprintf("%v", he.Message)
+	}
+
+	if c.Response().Committed {
+		return
+	}
+
+	client := code >= 400 && code < 500
+	b := &bytes.Buffer{}
+	if mt, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType)); mt == "application/soap+xml" {
+		value := "env:Receiver"
+		if client {
+			value = "env:Sender"
+		}
+		fmt.Fprintf(b, `<?xml version="1.0" encoding="UTF-8"?>`+
+			`<env:Envelope xmlns:env="%s" xmlns:nprxy="%s"><env:Body><env:Fault>`+
+			`<env:Code><env:Value>%s</env:Value><env:Subcode><env:Value>nprxy:%s</env:Value></env:Subcode></env:Code>`+
+			`<env:Reason><env:Text xml:lang="en">%s</env:Text></env:Reason>`+
+			`</env:Fault></env:Body></env:Envelope>`,
+			SOAP12Namespace, SOAPFaultNamespace, value, faultSubcode(code), escapeXML(msg))
+		c.Blob(code, "application/soap+xml; charset=utf-8", b.Bytes())
+		return
+	}
+
+	faultcode := "soap:Server"
+	if client {
+		faultcode = "soap:Client"
+	}
+	fmt.Fprintf(b, `<?xml version="1.0" encoding="UTF-8"?>`+
+		`<soap:Envelope xmlns:soap="%s"><soap:Body><soap:Fault>`+
+		`<faultcode>%s</faultcode><faultstring>%s</faultstring>`+
+		`</soap:Fault></soap:Body></soap:Envelope>`,
+		SOAP11Namespace, faultcode, escapeXML(msg))
+	if code != http.StatusUnauthorized {
+		code = http.StatusInternalServerError
+	}
+	c.Blob(code, echo.MIMETextXMLCharsetUTF8, b.Bytes())
+}
+
+// faultSubcode returns status text of code as XML local name: BadGateway for 502
+func faultSubcode(code int) string {
+	t := http.StatusText(code)
+	if t == "" {
+		return fmt.Sprintf("Status%d", code)
+	}
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return -1
+	}, t)
+}
+
+func escapeXML(s string) string {
+	b := &bytes.Buffer{}
+	xml.EscapeText(b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,58 @@
+package mw
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+func TestSOAPFaultHandler(t *testing.T) {
+	type testCase struct {
+		name        string
+		contentType string
+		err         error
+		status      int
+		mime        string
+		fault       []string
+	}
+
+	cases := []testCase{
+		testCase{name: "1.1 forbidden", contentType: "text/xml; charset=utf-8", err: echo.ErrForbidden, status: 500, mime: "text/xml",
+			fault: []string{SOAP11Namespace, "<faultcode>soap:Client</faultcode>", "<faultstring>Forbidden</faultstring>"}},
+		testCase{name: "1.1 unauthorized", contentType: "text/xml", err: echo.ErrUnauthorized, status: 401, mime: "text/xml",
+			fault: []string{"<faultcode>soap:Client</faultcode>"}},
+		testCase{name: "1.1 bad gateway", contentType: "text/xml", err: echo.NewHTTPError(502, "upstream <down> & out"), status: 500, mime: "text/xml",
+			fault: []string{"<faultcode>soap:Server</faultcode>", "<faultstring>upstream &lt;down&gt; &amp; out</faultstring>"}},
+		testCase{name: "1.2 forbidden", contentType: `application/soap+xml; charset=utf-8; action="urn:op"`, err: echo.ErrForbidden, status: 403, mime: "application/soap+xml",
+			fault: []string{SOAP12Namespace, "<env:Value>env:Sender</env:Value>", "<env:Value>nprxy:Forbidden</env:Value>", `<env:Text xml:lang="en">Forbidden</env:Text>`}},
+		testCase{name: "1.2 gateway timeout", contentType: "application/soap+xml", err: echo.NewHTTPError(504), status: 504, mime: "application/soap+xml",
+			fault: []string{"<env:Value>env:Receiver</env:Value>", "<env:Value>nprxy:GatewayTimeout</env:Value>"}},
+	}
+
+	e := echo.New()
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", nil)
+			req.Header.Set(echo.HeaderContentType, cs.contentType)
+			res := httptest.NewRecorder()
+
+			c := e.NewContext(req, res)
+			SOAPFaultHandler(cs.err, c)
+
+			if res.Code != cs.status {
+				t.Errorf("expected status %d, got: %d", cs.status, res.Code)
+			}
+			if ct := res.Header().Get(echo.HeaderContentType); !strings.HasPrefix(ct, cs.mime) {
+				t.Errorf("expected content type %s, got: %s", cs.mime, ct)
+			}
+			for _, f := range cs.fault {
+				if !strings.Contains(res.Body.String(), f) {
+					t.Errorf("expected fault to contain %s, got: %s", f, res.Body.String())
+				}
+			}
+		})
+	}
+}
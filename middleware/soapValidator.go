@@ -0,0 +1,243 @@
+package mw
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/artyomturkin/nprxy/wsdl"
+	"github.com/artyomturkin/nprxy/xsd"
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+type (
+	// SOAPValidatorConfig defines the config for SOAPValidator middleware.
+	SOAPValidatorConfig struct {
+		// Skipper defines a function to skip middleware.
+		Skipper middleware.Skipper
+
+		// Definitions WSDL with schemas of messages
+		// Required.
+		Definitions *wsdl.Definitions
+
+		// OperationKey key of operation set by OperationResolver
+		OperationKey string
+
+		// MaxBodyBytes limits size of validated request and response bodies.
+		// Larger requests are rejected, larger responses are passed without validation.
+		MaxBodyBytes int64
+
+		// Responses validation mode of upstream responses: "" - not validated, "log" - invalid responses are logged,
+		// "enforce" - invalid responses are replaced with 502 error
+		Responses string
+
+		// Logger for invalid responses
+		Logger logrus.FieldLogger
+	}
+)
+
+var (
+	// defaultSOAPValidatorConfig is the default SOAPValidator middleware config.
+	defaultSOAPValidatorConfig = SOAPValidatorConfig{
+		Skipper:      middleware.DefaultSkipper,
+		OperationKey: "operation",
+		MaxBodyBytes: 1 << 20,
+	}
+)
+
+// SOAPValidator returns a SOAPValidator middleware.
+//
+// Elements of SOAP Body of request are validated against schemas of WSDL,
+// for operation resolved by SOAP action body element must be input element of operation.
+// Invalid requests are rejected with "400 - Bad Request" before they reach upstream.
+// RPC style operations are not validated.
+func SOAPValidator(defs *wsdl.Definitions) echo.MiddlewareFunc {
+	c := defaultSOAPValidatorConfig
+	c.Definitions = defs
+	return SOAPValidatorWithConfig(c)
+}
+
+// SOAPValidatorWithConfig returns a SOAPValidator middleware with config.
+// See `SOAPValidator()`.
+func SOAPValidatorWithConfig(config SOAPValidatorConfig) echo.MiddlewareFunc {
+	// Defaults
+	if config.Skipper == nil {
+		config.Skipper = defaultSOAPValidatorConfig.Skipper
+	}
+	if config.OperationKey == "" {
+		config.OperationKey = defaultSOAPValidatorConfig.OperationKey
+	}
+	if config.MaxBodyBytes == 0 {
+		config.MaxBodyBytes = defaultSOAPValidatorConfig.MaxBodyBytes
+	}
+	if config.Logger == nil {
+		config.Logger = logrus.StandardLogger()
+	}
+	if config.Definitions == nil {
+		panic("echo: soap-validator middleware requires definitions")
+	}
+	if config.Responses != "" && config.Responses != "log" && config.Responses != "enforce" {
+		panic(fmt.Errorf("unsupported response validation mode %s", config.Responses))
+	}
+
+	// Operations by SOAP action and by input element, as operation is resolved from either
+	operations := map[string]*wsdl.Operation{}
+	for i := range config.Definitions.Operations {
+		op := &config.Definitions.Operations[i]
+		if op.SOAPAction != "" {
+			operations[op.SOAPAction] = op
+		}
+		if op.Input.Local != "" {
+			operations["{"+op.Input.Space+"}"+op.Input.Local] = op
+		}
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			var op *wsdl.Operation
+			if s, ok := c.Get(config.OperationKey).(string); ok {
+				op = operations[strings.Trim(s, `"`)]
+			}
+			if op != nil && op.Style == "rpc" {
+				return next(c)
+			}
+
+			req := c.Request()
+			if req.Body == nil || req.Body == http.NoBody {
+				return echo.NewHTTPError(http.StatusBadRequest, "invalid request: body is empty")
+			}
+			body, err := ioutil.ReadAll(&limitedReader{R: req.Body, N: config.MaxBodyBytes})
+			req.Body.Close()
+			if err != nil {
+				return echo.NewHTTPError(http.StatusRequestEntityTooLarge)
+			}
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			var input xml.Name
+			if op != nil {
+				input = op.Input
+			}
+			if err := validateSOAPBody(config.Definitions.Schemas, body, input); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "invalid request: "+err.Error())
+			}
+
+			if config.Responses == "" {
+				return next(c)
+			}
+
+			// Response is buffered to be validated before it is sent to client
+			req.Header.Del(echo.HeaderAcceptEncoding)
+			res := c.Response()
+			w := res.Writer
+			buf := &validatingResponseWriter{ResponseWriter: w, code: http.StatusOK, limit: config.MaxBodyBytes}
+			res.Writer = buf
+			err = next(c)
+			res.Writer = w
+			if err != nil || buf.passthrough {
+				return err
+			}
+			if !strings.Contains(w.Header().Get(echo.HeaderContentType), "xml") {
+				// Not a SOAP response, e.g. error page of upstream
+				w.WriteHeader(buf.code)
+				_, err = w.Write(buf.body.Bytes())
+				return err
+			}
+
+			var output xml.Name
+			if op != nil {
+				output = op.Output
+			}
+			verr := validateSOAPBody(config.Definitions.Schemas, buf.body.Bytes(), output)
+			if verr != nil {
+				config.Logger.WithFields(logrus.Fields{
+					"operation": c.Get(config.OperationKey),
+					"status":    buf.code,
+				}).WithError(verr).Warn("Invalid SOAP response")
+			}
+			if verr != nil && config.Responses == "enforce" {
+				w.Header().Del(echo.HeaderContentLength)
+				res.Committed = false
+				res.Status = http.StatusOK
+				return echo.NewHTTPError(http.StatusBadGateway, "invalid response of upstream")
+			}
+
+			w.WriteHeader(buf.code)
+			_, err = w.Write(buf.body.Bytes())
+			return err
+		}
+	}
+}
+
+// validateSOAPBody validates elements of Body of SOAP 1.1 or 1.2 envelope. If expected name is set, Body must contain
+// single element with that name. Fault is not validated.
+func validateSOAPBody(schemas *xsd.Set, envelope []byte, expected xml.Name) error {
+	root, err := xsd.Parse(bytes.NewReader(envelope))
+	if err != nil {
+		return err
+	}
+	ns := root.Name.Space
+	if root.Name.Local != "Envelope" || (ns != SOAP11Namespace && ns != SOAP12Namespace) {
+		return fmt.Errorf("not a SOAP envelope")
+	}
+	body := root.Element(ns, "Body")
+	if body == nil {
+		return fmt.Errorf("SOAP body is missing")
+	}
+
+	if len(body.Children) == 1 && body.Children[0].Name.Space == ns && body.Children[0].Name.Local == "Fault" {
+		return nil
+	}
+	if expected.Local != "" && (len(body.Children) != 1 || body.Children[0].Name != expected) {
+		return fmt.Errorf("SOAP body must contain single {%s}%s element", expected.Space, expected.Local)
+	}
+	for _, e := range body.Children {
+		if err := schemas.Validate(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validatingResponseWriter buffers response up to limit, larger responses are passed through
+type validatingResponseWriter struct {
+	http.ResponseWriter
+	code        int
+	body        bytes.Buffer
+	limit       int64
+	passthrough bool
+}
+
+func (w *validatingResponseWriter) WriteHeader(code int) {
+	w.code = code
+}
+
+func (w *validatingResponseWriter) Write(b []byte) (int, error) {
+	if w.passthrough {
+		return w.ResponseWriter.Write(b)
+	}
+	w.body.Write(b)
+	if int64(w.body.Len()) > w.limit {
+		w.passthrough = true
+		w.ResponseWriter.WriteHeader(w.code)
+		if _, err := w.ResponseWriter.Write(w.body.Bytes()); err != nil {
+			return 0, err
+		}
+		w.body.Reset()
+	}
+	return len(b), nil
+}
+
+func (w *validatingResponseWriter) Flush() {
+	if w.passthrough {
+		w.ResponseWriter.(http.Flusher).Flush()
+	}
+}
@@ -0,0 +1,119 @@
+package mw
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/artyomturkin/nprxy/wsdl"
+	"github.com/labstack/echo"
+)
+
+const testValidatorWSDL = `<wsdl:definitions targetNamespace="urn:orders" xmlns:tns="urn:orders"
+    xmlns:wsdl="http://schemas.xmlsoap.org/wsdl/" xmlns:soap="http://schemas.xmlsoap.org/wsdl/soap/">
+  <wsdl:types>
+    <xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" targetNamespace="urn:orders" elementFormDefault="qualified">
+      <xs:element name="GetOrder"><xs:complexType><xs:sequence><xs:element name="id" type="xs:int"/></xs:sequence></xs:complexType></xs:element>
+      <xs:element name="GetOrderResponse"><xs:complexType><xs:sequence><xs:element name="total" type="xs:decimal"/></xs:sequence></xs:complexType></xs:element>
+      <xs:element name="Ping" type="xs:string"/>
+    </xs:schema>
+  </wsdl:types>
+  <wsdl:message name="GetOrderRequest"><wsdl:part name="body" element="tns:GetOrder"/></wsdl:message>
+  <wsdl:message name="GetOrderResponse"><wsdl:part name="body" element="tns:GetOrderResponse"/></wsdl:message>
+  <wsdl:portType name="Orders">
+    <wsdl:operation name="GetOrder"><wsdl:input message="tns:GetOrderRequest"/><wsdl:output message="tns:GetOrderResponse"/></wsdl:operation>
+  </wsdl:portType>
+  <wsdl:binding name="OrdersBinding" type="tns:Orders">
+    <soap:binding transport="http://schemas.xmlsoap.org/soap/http"/>
+    <wsdl:operation name="GetOrder"><soap:operation soapAction="urn:GetOrder"/></wsdl:operation>
+  </wsdl:binding>
+  <wsdl:binding name="LegacyBinding" type="tns:Legacy">
+    <soap:binding style="rpc" transport="http://schemas.xmlsoap.org/soap/http"/>
+    <wsdl:operation name="Legacy"><soap:operation soapAction="urn:Legacy"/></wsdl:operation>
+  </wsdl:binding>
+</wsdl:definitions>`
+
+func testEnvelope(body string) string {
+	return `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:o="urn:orders"><soap:Body>` + body + `</soap:Body></soap:Envelope>`
+}
+
+func TestSOAPValidator(t *testing.T) {
+	type testCase struct {
+		name      string
+		operation string
+		body      string
+		responses string
+		response  string
+		result    int
+	}
+
+	validResponse := testEnvelope(`<o:GetOrderResponse><o:total>9.99</o:total></o:GetOrderResponse>`)
+	invalidResponse := testEnvelope(`<o:GetOrderResponse><o:total>free</o:total></o:GetOrderResponse>`)
+	cases := []testCase{
+		testCase{name: "valid", operation: `"urn:GetOrder"`, body: testEnvelope(`<o:GetOrder><o:id>1</o:id></o:GetOrder>`), result: 200},
+		testCase{name: "resolved from body", operation: "{urn:orders}GetOrder", body: testEnvelope(`<o:GetOrder><o:id>1</o:id></o:GetOrder>`), result: 200},
+		testCase{name: "unknown operation", operation: "urn:Other", body: testEnvelope(`<o:Ping>x</o:Ping>`), result: 200},
+		testCase{name: "invalid value", operation: "urn:GetOrder", body: testEnvelope(`<o:GetOrder><o:id>one</o:id></o:GetOrder>`), result: 400},
+		testCase{name: "wrong element", operation: "urn:GetOrder", body: testEnvelope(`<o:Ping>x</o:Ping>`), result: 400},
+		testCase{name: "undeclared element", operation: "urn:Other", body: testEnvelope(`<o:Drop/>`), result: 400},
+		testCase{name: "not xml", operation: "urn:GetOrder", body: `{"id":1}`, result: 400},
+		testCase{name: "rpc", operation: "urn:Legacy", body: `anything`, result: 200},
+		testCase{name: "valid response", operation: "urn:GetOrder", body: testEnvelope(`<o:GetOrder><o:id>1</o:id></o:GetOrder>`),
+			responses: "enforce", response: validResponse, result: 200},
+		testCase{name: "invalid response logged", operation: "urn:GetOrder", body: testEnvelope(`<o:GetOrder><o:id>1</o:id></o:GetOrder>`),
+			responses: "log", response: invalidResponse, result: 200},
+		testCase{name: "invalid response enforced", operation: "urn:GetOrder", body: testEnvelope(`<o:GetOrder><o:id>1</o:id></o:GetOrder>`),
+			responses: "enforce", response: invalidResponse, result: 502},
+		testCase{name: "fault response", operation: "urn:GetOrder", body: testEnvelope(`<o:GetOrder><o:id>1</o:id></o:GetOrder>`),
+			responses: "enforce", response: testEnvelope(`<soap:Fault><faultcode>soap:Server</faultcode></soap:Fault>`), result: 200},
+	}
+
+	defs, _, err := wsdl.Parse([]byte(testValidatorWSDL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := echo.New()
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(cs.body))
+			res := httptest.NewRecorder()
+
+			c := e.NewContext(req, res)
+			c.Set("operation", cs.operation)
+
+			var body string
+			h := SOAPValidatorWithConfig(SOAPValidatorConfig{Definitions: defs, Responses: cs.responses})(func(c echo.Context) error {
+				b, _ := ioutil.ReadAll(c.Request().Body)
+				body = string(b)
+				if cs.response == "" {
+					return c.NoContent(http.StatusOK)
+				}
+				return c.Blob(http.StatusOK, echo.MIMETextXMLCharsetUTF8, []byte(cs.response))
+			})
+
+			err := h(c)
+
+			if err != nil {
+				if errObj, ok := err.(*echo.HTTPError); ok {
+					if errObj.Code != cs.result {
+						t.Errorf("expected %d, got: %d (%v)", cs.result, errObj.Code, errObj.Message)
+					}
+					if errObj.Code == 502 && c.Response().Committed {
+						t.Error("expected response to be not committed for error handler")
+					}
+				} else {
+					t.Error(err)
+				}
+			} else if c.Response().Status != cs.result {
+				t.Errorf("expected %d, got: %d", cs.result, c.Response().Status)
+			} else if body != cs.body {
+				t.Errorf("expected request body to be restored, got: %s", body)
+			} else if res.Body.String() != cs.response {
+				t.Errorf("expected response to be passed, got: %s", res.Body.String())
+			}
+		})
+	}
+}
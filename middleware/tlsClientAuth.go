@@ -0,0 +1,63 @@
+package mw
+
+import (
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+type (
+	// TLSClientAuthConfig defines the config for TLSClientAuth middleware.
+	TLSClientAuthConfig struct {
+		// Skipper defines a function to skip middleware.
+		Skipper middleware.Skipper
+
+		// ContextKey key to output client if authenticated
+		ContextKey string
+	}
+)
+
+var (
+	// defaultTLSClientAuthConfig is the default TLSClientAuth middleware config.
+	defaultTLSClientAuthConfig = TLSClientAuthConfig{
+		Skipper:    middleware.DefaultSkipper,
+		ContextKey: "client",
+	}
+)
+
+// TLSClientAuth returns a TLSClientAuth middleware.
+//
+// Client is authenticated by TLS client certificate verified by listener, client is common name of certificate.
+func TLSClientAuth() echo.MiddlewareFunc {
+	return TLSClientAuthWithConfig(defaultTLSClientAuthConfig)
+}
+
+// TLSClientAuthWithConfig returns a TLSClientAuth middleware with config.
+// See `TLSClientAuth()`.
+func TLSClientAuthWithConfig(config TLSClientAuthConfig) echo.MiddlewareFunc {
+	// Defaults
+	if config.Skipper == nil {
+		config.Skipper = defaultTLSClientAuthConfig.Skipper
+	}
+	if config.ContextKey == "" {
+		config.ContextKey = defaultTLSClientAuthConfig.ContextKey
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			state := c.Request().TLS
+			if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+				return echo.ErrUnauthorized
+			}
+			client := state.VerifiedChains[0][0].Subject.CommonName
+			if client == "" {
+				return echo.ErrUnauthorized
+			}
+			c.Set(config.ContextKey, client)
+			return next(c)
+		}
+	}
+}
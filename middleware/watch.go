@@ -0,0 +1,44 @@
+package mw
+
+import (
+	"io"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchFile calls reload when file changes until returned watcher is closed. Directory of file is watched,
+// so file can be replaced by rename. Failed reloads are logged.
+func watchFile(path string, reload func() error) (io.Closer, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Add(filepath.Dir(path)); err != nil {
+		w.Close()
+		return nil, err
+	}
+	go func() {
+		for {
+			select {
+			case e, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(e.Name) != filepath.Clean(path) || e.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				if err := reload(); err != nil {
+					logrus.WithError(err).WithField("path", path).Error("reload failed")
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				logrus.WithError(err).WithField("path", path).Error("watch failed")
+			}
+		}
+	}()
+	return w, nil
+}
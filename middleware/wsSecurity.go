@@ -0,0 +1,411 @@
+package mw
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/artyomturkin/nprxy/xmldsig"
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type (
+	// WSSecurityConfig defines the config for WSSecurity middleware.
+	WSSecurityConfig struct {
+		// Skipper defines a function to skip middleware.
+		Skipper middleware.Skipper
+
+		// Keys system - hashed key pairs to check PasswordText of UsernameToken against
+		Keys map[string]string
+
+		// Secrets system - plain key pairs to check PasswordDigest of UsernameToken against,
+		// as digest can not be checked against hashed key
+		Secrets map[string]string
+
+		// Store of clients with several keys, expiry and networks, replaces Keys
+		Store *KeyStore
+
+		// SecretStore of clients with plain secrets in place of hashes of keys, replaces Secrets
+		SecretStore *KeyStore
+
+		// Certificates trusted to sign SOAP Body, certificates of clients or of CAs that issue them.
+		// Client is common name of signing certificate.
+		Certificates []*x509.Certificate
+
+		// MaxClockSkew max difference between local time and Created of tokens and timestamps
+		MaxClockSkew time.Duration
+
+		// MaxBodyBytes limits size of request body
+		MaxBodyBytes int64
+
+		// ContextKey key to output client if authenticated
+		ContextKey string
+	}
+)
+
+// WS-Security namespaces and token types
+const (
+	WSSENamespace = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd"
+	WSUNamespace  = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd"
+
+	WSSPasswordText   = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordText"
+	WSSPasswordDigest = "http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest"
+)
+
+var (
+	// defaultWSSecurityConfig is the default WSSecurity middleware config.
+	defaultWSSecurityConfig = WSSecurityConfig{
+		Skipper:      middleware.DefaultSkipper,
+		MaxClockSkew: 5 * time.Minute,
+		MaxBodyBytes: 1 << 20,
+		ContextKey:   "client",
+	}
+)
+
+// WSSecurity returns a WSSecurity middleware.
+//
+// Client is authenticated by wsse:Security header of SOAP envelope: UsernameToken with PasswordText checked
+// against hashed keys and XML Signature of Body by trusted certificate. If both are present, both must be valid
+// and identify the same client.
+func WSSecurity(keys map[string]string) echo.MiddlewareFunc {
+	c := defaultWSSecurityConfig
+	c.Keys = keys
+	return WSSecurityWithConfig(c)
+}
+
+// WSSecurityWithConfig returns a WSSecurity middleware with config.
+// See `WSSecurity()`.
+func WSSecurityWithConfig(config WSSecurityConfig) echo.MiddlewareFunc {
+	// Defaults
+	if config.Skipper == nil {
+		config.Skipper = defaultWSSecurityConfig.Skipper
+	}
+	if config.MaxClockSkew == 0 {
+		config.MaxClockSkew = defaultWSSecurityConfig.MaxClockSkew
+	}
+	if config.MaxBodyBytes == 0 {
+		config.MaxBodyBytes = defaultWSSecurityConfig.MaxBodyBytes
+	}
+	if config.ContextKey == "" {
+		config.ContextKey = defaultWSSecurityConfig.ContextKey
+	}
+
+	roots := x509.NewCertPool()
+	for _, c := range config.Certificates {
+		roots.AddCert(c)
+	}
+	// Token is accepted within skew of its Created time, nonce must be remembered for both sides of the window
+	w := &wsSecurity{config: config, roots: roots, nonces: newNonceCache(2 * config.MaxClockSkew)}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			req := c.Request()
+			if req.Body == nil || req.Body == http.NoBody {
+				return echo.ErrUnauthorized
+			}
+			body, err := ioutil.ReadAll(&limitedReader{R: req.Body, N: config.MaxBodyBytes})
+			req.Body.Close()
+			if err != nil {
+				return echo.NewHTTPError(http.StatusRequestEntityTooLarge)
+			}
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			client, err := w.authenticate(body, remoteIP(req), time.Now())
+			if err != nil {
+				return echo.ErrUnauthorized
+			}
+			c.Set(config.ContextKey, client)
+			return next(c)
+		}
+	}
+}
+
+type wsSecurity struct {
+	config WSSecurityConfig
+	roots  *x509.CertPool
+	nonces *nonceCache
+}
+
+// authenticate returns client identified by security header of envelope, sent from ip
+func (w *wsSecurity) authenticate(envelope []byte, ip net.IP, now time.Time) (string, error) {
+	root, err := xmldsig.Parse(envelope)
+	if err != nil {
+		return "", err
+	}
+	ns := root.Name.Space
+	if root.Name.Local != "Envelope" || (ns != SOAP11Namespace && ns != SOAP12Namespace) {
+		return "", fmt.Errorf("not a SOAP envelope")
+	}
+	// Envelope with other or repeated children could carry signed Body next to unsigned one read by upstream
+	soapHeaders, bodies := root.Elements(ns, "Header"), root.Elements(ns, "Body")
+	if len(soapHeaders) != 1 || len(bodies) != 1 || len(root.Children()) != 2 {
+		return "", fmt.Errorf("envelope must have single Header and Body")
+	}
+	header, body := soapHeaders[0], bodies[0]
+	// Several headers for different actors are ambiguous, only one of them would be checked
+	headers := header.Elements(WSSENamespace, "Security")
+	if len(headers) != 1 {
+		return "", fmt.Errorf("envelope must have single security header")
+	}
+	security := headers[0]
+
+	if ts := security.Element(WSUNamespace, "Timestamp"); ts != nil {
+		if err := w.checkTimestamp(ts, now); err != nil {
+			return "", err
+		}
+	}
+
+	client := ""
+	if sig := security.Element(xmldsig.Namespace, "Signature"); sig != nil {
+		if client, err = w.verifySignature(security, sig, body, now); err != nil {
+			return "", err
+		}
+	}
+	if token := security.Element(WSSENamespace, "UsernameToken"); token != nil {
+		name, err := w.verifyUsernameToken(token, ip, now)
+		if err != nil {
+			return "", err
+		}
+		if client != "" && client != name {
+			return "", fmt.Errorf("username %s does not match certificate of %s", name, client)
+		}
+		client = name
+	}
+	if client == "" {
+		return "", fmt.Errorf("no credentials in security header")
+	}
+	return client, nil
+}
+
+func (w *wsSecurity) checkTimestamp(ts *xmldsig.Element, now time.Time) error {
+	if e := ts.Element(WSUNamespace, "Created"); e != nil {
+		created, err := time.Parse(time.RFC3339Nano, e.Text())
+		if err != nil || created.After(now.Add(w.config.MaxClockSkew)) {
+			return fmt.Errorf("invalid timestamp")
+		}
+	}
+	if e := ts.Element(WSUNamespace, "Expires"); e != nil {
+		expires, err := time.Parse(time.RFC3339Nano, e.Text())
+		if err != nil || expires.Add(w.config.MaxClockSkew).Before(now) {
+			return fmt.Errorf("timestamp expired")
+		}
+	}
+	return nil
+}
+
+// verifyUsernameToken checks password of token and returns username. Tokens with nonce must have Created time,
+// each nonce is accepted once.
+func (w *wsSecurity) verifyUsernameToken(token *xmldsig.Element, ip net.IP, now time.Time) (string, error) {
+	username := token.Element(WSSENamespace, "Username")
+	password := token.Element(WSSENamespace, "Password")
+	if username == nil || password == nil {
+		return "", fmt.Errorf("username token is incomplete")
+	}
+	name := username.Text()
+
+	var created, nonce string
+	if e := token.Element(WSUNamespace, "Created"); e != nil {
+		created = e.Text()
+		t, err := time.Parse(time.RFC3339Nano, created)
+		if err != nil || t.Before(now.Add(-w.config.MaxClockSkew)) || t.After(now.Add(w.config.MaxClockSkew)) {
+			return "", fmt.Errorf("token created time is out of allowed skew")
+		}
+	}
+	if e := token.Element(WSSENamespace, "Nonce"); e != nil {
+		nonce = e.Text()
+		if created == "" {
+			return "", fmt.Errorf("token with nonce must have created time")
+		}
+	}
+
+	kind, _ := password.Attribute("", "Type")
+	switch kind {
+	case "", WSSPasswordText:
+		valid := false
+		for _, hash := range clientKeys(w.config.Store, w.config.Keys, name, ip, now) {
+			if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password.Text())) == nil {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return "", fmt.Errorf("invalid password")
+		}
+	case WSSPasswordDigest:
+		if nonce == "" {
+			return "", fmt.Errorf("invalid password")
+		}
+		raw, err := base64.StdEncoding.DecodeString(nonce)
+		if err != nil {
+			return "", fmt.Errorf("invalid nonce")
+		}
+		valid := false
+		for _, secret := range clientKeys(w.config.SecretStore, w.config.Secrets, name, ip, now) {
+			// Password_Digest = Base64(SHA-1(nonce + created + password))
+			h := sha1.New()
+			h.Write(raw)
+			h.Write([]byte(created))
+			h.Write([]byte(secret))
+			digest := base64.StdEncoding.EncodeToString(h.Sum(nil))
+			if subtle.ConstantTimeCompare([]byte(digest), []byte(password.Text())) == 1 {
+				valid = true
+			}
+		}
+		if !valid {
+			return "", fmt.Errorf("invalid password")
+		}
+	default:
+		return "", fmt.Errorf("unsupported password type %s", kind)
+	}
+
+	// Nonce is remembered only for valid tokens, so unauthenticated clients can not fill the cache
+	if nonce != "" && !w.nonces.add(name+"\x00"+nonce, now) {
+		return "", fmt.Errorf("nonce was already used")
+	}
+	return name, nil
+}
+
+// clientKeys returns active keys of client of store, or key of keys if store is nil
+func clientKeys(store *KeyStore, keys map[string]string, name string, ip net.IP, now time.Time) []string {
+	if store == nil {
+		if key, ok := keys[name]; ok {
+			return []string{key}
+		}
+		return nil
+	}
+	client := store.Client(name, ip)
+	if client == nil {
+		return nil
+	}
+	var active []string
+	for _, k := range client.ActiveKeys(now) {
+		active = append(active, k.Hash)
+	}
+	return active
+}
+
+// verifySignature checks that signature by trusted certificate covers Body and returns common name of certificate
+func (w *wsSecurity) verifySignature(security, sig, body *xmldsig.Element, now time.Time) (string, error) {
+	cert, err := signingCertificate(security, sig)
+	if err != nil {
+		return "", err
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:       w.roots,
+		CurrentTime: now,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return "", err
+	}
+
+	elements, err := xmldsig.Verify(sig, cert.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range elements {
+		if e == body {
+			return cert.Subject.CommonName, nil
+		}
+	}
+	return "", fmt.Errorf("signature does not cover body")
+}
+
+// signingCertificate returns certificate of KeyInfo: embedded X509Data or reference to BinarySecurityToken
+func signingCertificate(security, sig *xmldsig.Element) (*x509.Certificate, error) {
+	info := sig.Element(xmldsig.Namespace, "KeyInfo")
+	if info == nil {
+		return nil, fmt.Errorf("signature has no KeyInfo")
+	}
+
+	var token *xmldsig.Element
+	if data := info.Element(xmldsig.Namespace, "X509Data"); data != nil {
+		token = data.Element(xmldsig.Namespace, "X509Certificate")
+	} else if str := info.Element(WSSENamespace, "SecurityTokenReference"); str != nil {
+		ref := str.Element(WSSENamespace, "Reference")
+		if ref == nil {
+			return nil, fmt.Errorf("unsupported security token reference")
+		}
+		uri, _ := ref.Attribute("", "URI")
+		if !strings.HasPrefix(uri, "#") {
+			return nil, fmt.Errorf("unsupported security token reference %s", uri)
+		}
+		e, err := security.Root().ByID(uri[1:])
+		if err != nil {
+			return nil, err
+		}
+		if e.Parent != security || e.Name.Space != WSSENamespace || e.Name.Local != "BinarySecurityToken" {
+			return nil, fmt.Errorf("security token reference %s is not a binary security token", uri)
+		}
+		token = e
+	}
+	if token == nil {
+		return nil, fmt.Errorf("signature has no certificate")
+	}
+
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(token.Text()), ""))
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// HasWSSecurity reports whether request envelope declares WS-Security namespace within first 64KB, where
+// Security header precedes Body. Read part of body is replayed to the next readers of request body.
+func HasWSSecurity(c echo.Context) bool {
+	r := c.Request()
+	if r.Body == nil || r.Body == http.NoBody {
+		return false
+	}
+	head, _ := ioutil.ReadAll(io.LimitReader(r.Body, 64<<10))
+	r.Body = &teeReadCloser{Reader: io.MultiReader(bytes.NewReader(head), r.Body), Closer: r.Body}
+	return bytes.Contains(head, []byte(WSSENamespace))
+}
+
+// nonceCache remembers nonces until they expire
+type nonceCache struct {
+	sync.Mutex
+	ttl    time.Duration
+	nonces map[string]time.Time
+	// purge time of next removal of expired nonces
+	purge time.Time
+}
+
+func newNonceCache(ttl time.Duration) *nonceCache {
+	return &nonceCache{ttl: ttl, nonces: map[string]time.Time{}}
+}
+
+// add remembers nonce, false if nonce was already seen
+func (n *nonceCache) add(nonce string, now time.Time) bool {
+	n.Lock()
+	defer n.Unlock()
+
+	if now.After(n.purge) {
+		for k, exp := range n.nonces {
+			if now.After(exp) {
+				delete(n.nonces, k)
+			}
+		}
+		n.purge = now.Add(n.ttl)
+	}
+	if exp, ok := n.nonces[nonce]; ok && !now.After(exp) {
+		return false
+	}
+	n.nonces[nonce] = now.Add(n.ttl)
+	return true
+}
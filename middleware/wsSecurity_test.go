@@ -0,0 +1,277 @@
+package mw
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/artyomturkin/nprxy/xmldsig"
+	"github.com/labstack/echo"
+)
+
+const wsSecurityTemplate = `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" ` +
+	`xmlns:wsse="` + WSSENamespace + `" xmlns:wsu="` + WSUNamespace + `"><soap:Header><wsse:Security>{security}` +
+	`</wsse:Security></soap:Header><soap:Body wsu:Id="body"><m:GetOrder xmlns:m="urn:orders"><id>1</id></m:GetOrder>` +
+	`</soap:Body></soap:Envelope>`
+
+const wsSignatureTemplate = `<wsse:BinarySecurityToken wsu:Id="cert" EncodingType="` +
+	`http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-soap-message-security-1.0#Base64Binary">{cert}` +
+	`</wsse:BinarySecurityToken><ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#"><ds:SignedInfo>` +
+	`<ds:CanonicalizationMethod Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"/>` +
+	`<ds:SignatureMethod Algorithm="http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"/><ds:Reference URI="#body">` +
+	`<ds:Transforms><ds:Transform Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"/></ds:Transforms>` +
+	`<ds:DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"/><ds:DigestValue>{digest}</ds:DigestValue>` +
+	`</ds:Reference></ds:SignedInfo><ds:SignatureValue>{signature}</ds:SignatureValue><ds:KeyInfo>` +
+	`<wsse:SecurityTokenReference><wsse:Reference URI="#cert"/></wsse:SecurityTokenReference></ds:KeyInfo></ds:Signature>`
+
+func usernameToken(name, password, kind, nonce, created string) string {
+	s := `<wsse:UsernameToken><wsse:Username>` + name + `</wsse:Username><wsse:Password Type="` + kind + `">` +
+		password + `</wsse:Password>`
+	if nonce != "" {
+		s += `<wsse:Nonce>` + nonce + `</wsse:Nonce>`
+	}
+	if created != "" {
+		s += `<wsu:Created>` + created + `</wsu:Created>`
+	}
+	return strings.Replace(wsSecurityTemplate, "{security}", s+`</wsse:UsernameToken>`, 1)
+}
+
+func passwordDigest(nonce, created, password string) string {
+	raw, _ := base64.StdEncoding.DecodeString(nonce)
+	d := sha1.Sum(append(append(raw, created...), password...))
+	return base64.StdEncoding.EncodeToString(d[:])
+}
+
+func selfSignedCertificate(t *testing.T, name string) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+// signedEnvelope returns envelope with body signed by key of certificate
+func signedEnvelope(t *testing.T, cert *x509.Certificate, key *rsa.PrivateKey) string {
+	doc := strings.Replace(wsSecurityTemplate, "{security}", wsSignatureTemplate, 1)
+	doc = strings.Replace(doc, "{cert}", base64.StdEncoding.EncodeToString(cert.Raw), 1)
+
+	root, err := xmldsig.Parse([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := root.ByID("body")
+	digest := sha256.Sum256(xmldsig.Canonicalize(body, nil))
+	doc = strings.Replace(doc, "{digest}", base64.StdEncoding.EncodeToString(digest[:]), 1)
+
+	root, _ = xmldsig.Parse([]byte(doc))
+	signedInfo := root.Element(SOAP11Namespace, "Header").Element(WSSENamespace, "Security").
+		Element(xmldsig.Namespace, "Signature").Element(xmldsig.Namespace, "SignedInfo")
+	h := sha256.Sum256(xmldsig.Canonicalize(signedInfo, nil))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Replace(doc, "{signature}", base64.StdEncoding.EncodeToString(sig), 1)
+}
+
+func TestWSSecurity(t *testing.T) {
+	type testCase struct {
+		name   string
+		body   string
+		result int
+		client string
+	}
+
+	now := time.Now().UTC()
+	created := now.Format(time.RFC3339)
+	nonce := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	digest := usernameToken("test-system", passwordDigest(nonce, created, "secret"), WSSPasswordDigest, nonce, created)
+	stale := now.Add(-time.Hour).Format(time.RFC3339)
+
+	trusted, key := selfSignedCertificate(t, "signer")
+	untrusted, untrustedKey := selfSignedCertificate(t, "signer")
+	signed := signedEnvelope(t, trusted, key)
+
+	cases := []testCase{
+		testCase{name: "password text", body: usernameToken("test-system", "api-key", "", "", ""), result: 200, client: "test-system"},
+		testCase{name: "wrong password", body: usernameToken("test-system", "api-key-2", WSSPasswordText, "", ""), result: 401},
+		testCase{name: "unknown client", body: usernameToken("other", "api-key", WSSPasswordText, "", ""), result: 401},
+		testCase{name: "password digest", body: digest, result: 200, client: "test-system"},
+		testCase{name: "replayed nonce", body: digest, result: 401},
+		testCase{
+			name: "stale created", result: 401,
+			body: usernameToken("test-system", passwordDigest(nonce, stale, "secret"), WSSPasswordDigest, nonce, stale),
+		},
+		testCase{
+			name: "digest without nonce", result: 401,
+			body: usernameToken("test-system", passwordDigest("", created, "secret"), WSSPasswordDigest, "", created),
+		},
+		testCase{name: "signature", body: signed, result: 200, client: "signer"},
+		testCase{name: "untrusted certificate", body: signedEnvelope(t, untrusted, untrustedKey), result: 401},
+		testCase{name: "tampered body", body: strings.Replace(signed, "<id>1</id>", "<id>2</id>", 1), result: 401},
+		testCase{
+			name: "duplicated body", result: 401,
+			body: strings.Replace(signed, "</soap:Envelope>", `<soap:Body><m:DeleteOrder xmlns:m="urn:orders"><id>1</id></m:DeleteOrder></soap:Body></soap:Envelope>`, 1),
+		},
+		testCase{
+			name: "duplicated header", result: 401,
+			body: strings.Replace(signed, "<soap:Body", "<soap:Header/><soap:Body", 1),
+		},
+		testCase{
+			name: "other envelope child", result: 401,
+			body: strings.Replace(signed, "</soap:Envelope>", `<m:Extra xmlns:m="urn:orders"/></soap:Envelope>`, 1),
+		},
+		testCase{name: "no security header", body: strings.Replace(wsSecurityTemplate, "{security}", "", 1), result: 401},
+		testCase{name: "not xml", body: "client=test-system", result: 401},
+	}
+
+	e := echo.New()
+	h := WSSecurityWithConfig(WSSecurityConfig{
+		Keys: map[string]string{
+			"test-system": /*api-key*/ "$2a$10$0ZYFiKcYonvy.y/P4jAzJOr79AQoeO1LGO2hyj27QS5pTx/1nyzRm",
+		},
+		Secrets:      map[string]string{"test-system": "secret"},
+		Certificates: []*x509.Certificate{trusted},
+	})(func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Get("client").(string))
+	})
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(cs.body))
+			res := httptest.NewRecorder()
+			c := e.NewContext(req, res)
+
+			err := h(c)
+			if he, ok := err.(*echo.HTTPError); ok {
+				res.Code = he.Code
+			}
+			if res.Code != cs.result {
+				t.Fatalf("expected %d, got %d", cs.result, res.Code)
+			}
+			if cs.client != "" && res.Body.String() != cs.client {
+				t.Errorf("expected client %s, got %s", cs.client, res.Body.String())
+			}
+		})
+	}
+}
+
+func TestWSSecurityKeyStore(t *testing.T) {
+	type testCase struct {
+		name       string
+		file       string
+		body       string
+		remoteAddr string
+		result     int
+	}
+
+	now := time.Now().UTC()
+	created := now.Format(time.RFC3339)
+	nonce := func(n string) string { return base64.StdEncoding.EncodeToString([]byte("0123456789abcde" + n)) }
+	digest := func(n, secret string) string {
+		return usernameToken("test-system", passwordDigest(nonce(n), created, secret), WSSPasswordDigest, nonce(n), created)
+	}
+	keys := `version: 2
+clients:
+  test-system:
+    cidrs: [192.0.2.0/24]
+    keys:
+    - {id: new, hash: "$2a$04$Wr/aAu5Wtf8xrfhsTiiIKuQe4sqoDLBY8Sdss6D/nJ8lkG.HKpWD."}
+    - {id: old, hash: "$2a$10$0ZYFiKcYonvy.y/P4jAzJOr79AQoeO1LGO2hyj27QS5pTx/1nyzRm"}
+    - {id: expired, hash: "$2a$04$Wr/aAu5Wtf8xrfhsTiiIKuQe4sqoDLBY8Sdss6D/nJ8lkG.HKpWD.", expires: 2000-01-01T00:00:00Z}
+`
+	secrets := "version: 2\nclients:\n  test-system:\n    keys: [{id: new, hash: secret-2}, {id: old, hash: secret}]\n"
+
+	// each case continues with files of previous case
+	cases := []testCase{
+		testCase{name: "new key", file: keys, body: usernameToken("test-system", "api-key-2", "", "", ""), result: 200},
+		testCase{name: "old key during rotation", body: usernameToken("test-system", "api-key", "", "", ""), result: 200},
+		testCase{name: "new secret", body: digest("1", "secret-2"), result: 200},
+		testCase{name: "old secret during rotation", body: digest("2", "secret"), result: 200},
+		testCase{name: "other network", body: usernameToken("test-system", "api-key-2", "", "", ""), remoteAddr: "10.0.0.1:1000", result: 401},
+		testCase{
+			name:   "old key expired",
+			file:   strings.Replace(keys, "{id: old,", "{id: old, expires: 2000-01-01T00:00:00Z,", 1),
+			body:   usernameToken("test-system", "api-key", "", "", ""),
+			result: 401,
+		},
+		testCase{
+			name:   "client disabled",
+			file:   strings.Replace(keys, "    cidrs:", "    disabled: true\n    cidrs:", 1),
+			body:   usernameToken("test-system", "api-key-2", "", "", ""),
+			result: 401,
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "wssecurity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keysPath, secretsPath := filepath.Join(dir, "keys.yaml"), filepath.Join(dir, "secrets.yaml")
+	ioutil.WriteFile(keysPath, []byte(keys), 0600)
+	ioutil.WriteFile(secretsPath, []byte(secrets), 0600)
+	store, err := NewKeyStore(keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretStore, err := NewKeyStore(secretsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	h := WSSecurityWithConfig(WSSecurityConfig{Store: store, SecretStore: secretStore})(func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Get("client").(string))
+	})
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			if cs.file != "" {
+				ioutil.WriteFile(keysPath, []byte(cs.file), 0600)
+				if err := store.Reload(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			req := httptest.NewRequest("POST", "/", strings.NewReader(cs.body))
+			req.RemoteAddr = "192.0.2.1:1000"
+			if cs.remoteAddr != "" {
+				req.RemoteAddr = cs.remoteAddr
+			}
+			res := httptest.NewRecorder()
+
+			err := h(e.NewContext(req, res))
+			if he, ok := err.(*echo.HTTPError); ok {
+				res.Code = he.Code
+			}
+			if res.Code != cs.result {
+				t.Errorf("expected %d, got %d", cs.result, res.Code)
+			}
+		})
+	}
+}
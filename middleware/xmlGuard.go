@@ -0,0 +1,266 @@
+package mw
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/labstack/echo/middleware"
+)
+
+type (
+	// XMLGuardConfig defines the config for XMLGuard middleware.
+	XMLGuardConfig struct {
+		// Skipper defines a function to skip middleware.
+		Skipper middleware.Skipper
+
+		// MaxBytes max size of document
+		MaxBytes int64
+
+		// MaxDepth max nesting of elements
+		MaxDepth int
+
+		// MaxAttributes max number of attributes of element, namespace declarations are not counted
+		MaxAttributes int
+
+		// MaxNamespaces max number of namespace declarations of element
+		MaxNamespaces int
+
+		// MaxTextBytes max size of text node or attribute value
+		MaxTextBytes int
+	}
+)
+
+var (
+	// defaultXMLGuardConfig is the default XMLGuard middleware config.
+	defaultXMLGuardConfig = XMLGuardConfig{
+		Skipper:       middleware.DefaultSkipper,
+		MaxBytes:      4 << 20,
+		MaxDepth:      64,
+		MaxAttributes: 64,
+		MaxNamespaces: 32,
+		MaxTextBytes:  1 << 20,
+	}
+)
+
+// XMLGuard returns a XMLGuard middleware.
+//
+// Request body is checked to be well-formed UTF-8 XML document within limits before it reaches upstream.
+// Documents with DTD are rejected, so entities can not be declared or expanded. Requests without body
+// are not checked. Of multipart requests, like MTOM, root part is checked: part with Content-ID of `start`
+// parameter, or the first part. MaxBytes limits the whole multipart body.
+func XMLGuard() echo.MiddlewareFunc {
+	return XMLGuardWithConfig(defaultXMLGuardConfig)
+}
+
+// XMLGuardWithConfig returns a XMLGuard middleware with config.
+// See `XMLGuard()`.
+func XMLGuardWithConfig(config XMLGuardConfig) echo.MiddlewareFunc {
+	// Defaults
+	if config.Skipper == nil {
+		config.Skipper = defaultXMLGuardConfig.Skipper
+	}
+	if config.MaxBytes == 0 {
+		config.MaxBytes = defaultXMLGuardConfig.MaxBytes
+	}
+	if config.MaxDepth == 0 {
+		config.MaxDepth = defaultXMLGuardConfig.MaxDepth
+	}
+	if config.MaxAttributes == 0 {
+		config.MaxAttributes = defaultXMLGuardConfig.MaxAttributes
+	}
+	if config.MaxNamespaces == 0 {
+		config.MaxNamespaces = defaultXMLGuardConfig.MaxNamespaces
+	}
+	if config.MaxTextBytes == 0 {
+		config.MaxTextBytes = defaultXMLGuardConfig.MaxTextBytes
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			req := c.Request()
+			if req.Body == nil || req.Body == http.NoBody {
+				return next(c)
+			}
+			body, err := ioutil.ReadAll(&limitedReader{R: req.Body, N: config.MaxBytes})
+			req.Body.Close()
+			if err != nil {
+				return echo.NewHTTPError(http.StatusRequestEntityTooLarge)
+			}
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+			doc := body
+			if mt, params, _ := mime.ParseMediaType(req.Header.Get(echo.HeaderContentType)); strings.HasPrefix(mt, "multipart/") {
+				root, ct, err := multipartRoot(body, params)
+				if err != nil {
+					return echo.NewHTTPError(http.StatusBadRequest, "invalid multipart body: "+err.Error())
+				}
+				if !isXMLMediaType(ct) {
+					return echo.NewHTTPError(http.StatusUnsupportedMediaType, "root part of multipart body is not XML")
+				}
+				doc = root
+			}
+			if len(bytes.TrimSpace(doc)) == 0 {
+				return next(c)
+			}
+
+			if err := checkXML(doc, config); err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "invalid XML: "+err.Error())
+			}
+			return next(c)
+		}
+	}
+}
+
+// multipartRoot returns content and media type of root part of multipart body: part with Content-ID of
+// start parameter, or the first part
+func multipartRoot(body []byte, params map[string]string) ([]byte, string, error) {
+	if params["boundary"] == "" {
+		return nil, "", fmt.Errorf("boundary is missing")
+	}
+	start := strings.Trim(params["start"], "<>")
+	r := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			return nil, "", fmt.Errorf("root part %s not found", params["start"])
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if start != "" && strings.Trim(p.Header.Get("Content-ID"), "<>") != start {
+			continue
+		}
+		root, err := ioutil.ReadAll(p)
+		if err != nil {
+			return nil, "", err
+		}
+		mt, _, _ := mime.ParseMediaType(p.Header.Get(echo.HeaderContentType))
+		return root, mt, nil
+	}
+}
+
+// isXMLMediaType reports whether media type is XML, including XOP package of MTOM and SOAP 1.2 type
+func isXMLMediaType(mt string) bool {
+	switch mt {
+	case "text/xml", "application/xml", "application/soap+xml", "application/xop+xml":
+		return true
+	}
+	return strings.HasSuffix(mt, "+xml")
+}
+
+// checkXML checks that document is well-formed and within limits of config
+func checkXML(doc []byte, config XMLGuardConfig) error {
+	type element struct {
+		name     xml.Name
+		prefixes []string
+	}
+	var stack []element
+	declared := func(prefix string) bool {
+		if prefix == "" || prefix == "xml" {
+			return true
+		}
+		for i := len(stack) - 1; i >= 0; i-- {
+			for _, p := range stack[i].prefixes {
+				if p == prefix {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(doc))
+	root, text := false, 0
+	for {
+		t, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			if len(stack) == 0 && root {
+				return fmt.Errorf("document has multiple root elements")
+			}
+			root, text = true, 0
+			if len(stack)+1 > config.MaxDepth {
+				return fmt.Errorf("depth of elements exceeds %d", config.MaxDepth)
+			}
+
+			e := element{name: t.Name}
+			attrs := 0
+			for i, a := range t.Attr {
+				for _, b := range t.Attr[:i] {
+					if a.Name == b.Name {
+						return fmt.Errorf("attribute %s is duplicated", a.Name.Local)
+					}
+				}
+				switch {
+				case a.Name.Space == "xmlns":
+					e.prefixes = append(e.prefixes, a.Name.Local)
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+				default:
+					attrs++
+					if len(a.Value) > config.MaxTextBytes {
+						return fmt.Errorf("attribute %s value exceeds %d bytes", a.Name.Local, config.MaxTextBytes)
+					}
+				}
+			}
+			if attrs > config.MaxAttributes {
+				return fmt.Errorf("element %s has more than %d attributes", t.Name.Local, config.MaxAttributes)
+			}
+			if len(t.Attr)-attrs > config.MaxNamespaces {
+				return fmt.Errorf("element %s has more than %d namespace declarations", t.Name.Local, config.MaxNamespaces)
+			}
+
+			stack = append(stack, e)
+			if !declared(t.Name.Space) {
+				return fmt.Errorf("namespace prefix %s is not declared", t.Name.Space)
+			}
+			for _, a := range t.Attr {
+				if a.Name.Space != "xmlns" && !declared(a.Name.Space) {
+					return fmt.Errorf("namespace prefix %s is not declared", a.Name.Space)
+				}
+			}
+		case xml.EndElement:
+			if len(stack) == 0 || stack[len(stack)-1].name != t.Name {
+				return fmt.Errorf("unexpected end element %s", t.Name.Local)
+			}
+			stack = stack[:len(stack)-1]
+			text = 0
+		case xml.CharData:
+			if len(stack) == 0 {
+				if len(bytes.TrimSpace(t)) > 0 {
+					return fmt.Errorf("text outside of root element")
+				}
+				continue
+			}
+			// Text and CDATA sections are separate tokens of the same text node
+			text += len(t)
+			if text > config.MaxTextBytes {
+				return fmt.Errorf("text exceeds %d bytes", config.MaxTextBytes)
+			}
+		case xml.Directive:
+			return fmt.Errorf("DTD is not allowed")
+		}
+	}
+	if !root || len(stack) > 0 {
+		return fmt.Errorf("document is incomplete")
+	}
+	return nil
+}
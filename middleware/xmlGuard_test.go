@@ -0,0 +1,104 @@
+package mw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+)
+
+func TestXMLGuard(t *testing.T) {
+	type testCase struct {
+		name        string
+		contentType string
+		body        string
+		result      int
+	}
+
+	envelope := `<?xml version="1.0" encoding="UTF-8"?><soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">` +
+		`<soap:Body>%s</soap:Body></soap:Envelope>`
+	body := func(s string) string { return strings.Replace(envelope, "%s", s, 1) }
+	mtom := `multipart/related; boundary=b; type="application/xop+xml"`
+	part := func(id, contentType, content string) string {
+		return "--b\r\nContent-ID: <" + id + ">\r\nContent-Type: " + contentType + "\r\n\r\n" + content + "\r\n"
+	}
+
+	cases := []testCase{
+		testCase{name: "valid", body: body(`<m:Get xmlns:m="urn:m" a="1"><![CDATA[<x>]]>text</m:Get>`), result: 200},
+		testCase{name: "no body", result: 200},
+		testCase{name: "mtom", contentType: mtom, body: part("root", "application/xop+xml", `<r/>`) + part("att", "application/octet-stream", "<x") + "--b--\r\n", result: 200},
+		testCase{name: "mtom start", contentType: mtom + `; start="<root>"`, body: part("att", "application/octet-stream", "<x") + part("root", "application/xop+xml", `<r/>`) + "--b--\r\n", result: 200},
+		testCase{
+			name:        "mtom with DTD",
+			contentType: mtom,
+			body:        part("root", "application/xop+xml", `<!DOCTYPE r [<!ENTITY a "aaaaaaaaaa">]><r>&a;</r>`) + "--b--\r\n",
+			result:      400,
+		},
+		testCase{
+			name:        "mtom start with DTD",
+			contentType: mtom + `; start="<root>"`,
+			body:        part("att", "application/xop+xml", `<r/>`) + part("root", "text/xml", `<!DOCTYPE r><r/>`) + "--b--\r\n",
+			result:      400,
+		},
+		testCase{name: "mtom root not found", contentType: mtom + `; start="<other>"`, body: part("root", "text/xml", `<r/>`) + "--b--\r\n", result: 400},
+		testCase{name: "mtom root not xml", contentType: mtom, body: part("root", "text/plain", `<r/>`) + "--b--\r\n", result: 415},
+		testCase{name: "mtom without boundary", contentType: "multipart/related", body: part("root", "text/xml", `<r/>`), result: 400},
+		testCase{name: "mtom too large", contentType: mtom, body: part("root", "text/xml", `<r/>`) + part("att", "application/octet-stream", strings.Repeat("x", 300)), result: 413},
+		testCase{
+			name:   "entity expansion",
+			body:   `<!DOCTYPE r [<!ENTITY a "aaaaaaaaaa"><!ENTITY b "&a;&a;&a;&a;&a;&a;&a;&a;">]><r>&b;</r>`,
+			result: 400,
+		},
+		testCase{name: "external entity", body: `<!DOCTYPE r [<!ENTITY x SYSTEM "file:///etc/passwd">]><r>&x;</r>`, result: 400},
+		testCase{name: "undeclared entity", body: `<r>&x;</r>`, result: 400},
+		testCase{name: "depth", body: body(strings.Repeat("<a>", 5) + strings.Repeat("</a>", 5)), result: 400},
+		testCase{name: "attributes", body: body(`<a a="1" b="2" c="3" d="4"/>`), result: 400},
+		testCase{name: "duplicate attributes", body: body(`<a a="1" a="2"/>`), result: 400},
+		testCase{name: "namespaces", body: body(`<a xmlns:a="1" xmlns:b="2" xmlns:c="3" xmlns:d="4"/>`), result: 400},
+		testCase{name: "text", body: body(`<a>` + strings.Repeat("x", 17) + `</a>`), result: 400},
+		testCase{name: "text with cdata", body: body(`<a>` + strings.Repeat("x", 10) + `<![CDATA[0123456789]]></a>`), result: 400},
+		testCase{name: "attribute value", body: body(`<a v="` + strings.Repeat("x", 17) + `"/>`), result: 400},
+		testCase{name: "mismatched end", body: body(`<a></b>`), result: 400},
+		testCase{name: "incomplete", body: `<a><b></b>`, result: 400},
+		testCase{name: "multiple roots", body: `<a/><b/>`, result: 400},
+		testCase{name: "text outside root", body: `<a/>text`, result: 400},
+		testCase{name: "undeclared prefix", body: body(`<x:a/>`), result: 400},
+		testCase{name: "too large", body: body(strings.Repeat("<a/>", 100)), result: 413},
+	}
+
+	e := echo.New()
+	h := XMLGuardWithConfig(XMLGuardConfig{
+		MaxBytes:      300,
+		MaxDepth:      6,
+		MaxAttributes: 3,
+		MaxNamespaces: 3,
+		MaxTextBytes:  16,
+	})(func(c echo.Context) error {
+		return c.String(http.StatusOK, "test")
+	})
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(cs.body))
+			if cs.body == "" {
+				req = httptest.NewRequest("GET", "/?wsdl", nil)
+			}
+			req.Header.Set(echo.HeaderContentType, "text/xml")
+			if cs.contentType != "" {
+				req.Header.Set(echo.HeaderContentType, cs.contentType)
+			}
+			res := httptest.NewRecorder()
+			c := e.NewContext(req, res)
+
+			err := h(c)
+			if he, ok := err.(*echo.HTTPError); ok {
+				res.Code = he.Code
+			}
+			if res.Code != cs.result {
+				t.Errorf("expected %d, got %d: %v", cs.result, res.Code, err)
+			}
+		})
+	}
+}
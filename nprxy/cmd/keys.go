@@ -0,0 +1,303 @@
+// Copyright © 2018 Artyom Turkin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	mw "github.com/artyomturkin/nprxy/middleware"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// keysCmd represents the keys command
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage API keys of keys file of api-key authn",
+	Long: `Generate, add, rotate, revoke, list and verify API keys of keys file, that is used by api-key, hmac and ws-security authn.
+Files are edited in place, comments and order of entries are kept. Changed file is written atomically, so running proxy reloads complete file.`,
+}
+
+var keysGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Print random key and its bcrypt hash",
+	Args:  cobra.NoArgs,
+	RunE:  keysGenerate,
+}
+
+var keysAddCmd = &cobra.Command{
+	Use:   "add <client>",
+	Short: "Add client with random key and print the key",
+	Long:  `Add client with random key to keys file. Key is printed once, only its bcrypt hash is stored.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  keysAdd,
+}
+
+var keysRotateCmd = &cobra.Command{
+	Use:   "rotate <client>",
+	Short: "Add new random key to client and print the key",
+	Long: `Add new random key as the first key of client of versioned keys file. Key is printed once, only its bcrypt hash is stored.
+Active keys of client expire after grace period, so client can switch to the new key without downtime.`,
+	Args: cobra.ExactArgs(1),
+	RunE: keysRotate,
+}
+
+var keysRevokeCmd = &cobra.Command{
+	Use:   "revoke <client>",
+	Short: "Disable client or one of its keys",
+	Long:  `Disable client, or key of client with --id, of versioned keys file. Client of flat keys file is removed.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  keysRevoke,
+}
+
+var keysListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List clients with age and expiry of their keys",
+	Args:  cobra.NoArgs,
+	RunE:  keysList,
+}
+
+var keysVerifyCmd = &cobra.Command{
+	Use:   "verify <client>",
+	Short: "Check key of client, key is read from stdin",
+	Args:  cobra.ExactArgs(1),
+	RunE:  keysVerify,
+}
+
+var keysFlags struct {
+	file    string
+	cost    int
+	id      string
+	expires time.Duration
+	grace   time.Duration
+}
+
+func init() {
+	rootCmd.AddCommand(keysCmd)
+	keysCmd.AddCommand(keysGenerateCmd, keysAddCmd, keysRotateCmd, keysRevokeCmd, keysListCmd, keysVerifyCmd)
+
+	keysCmd.PersistentFlags().StringVar(&keysFlags.file, "file", "keys.yaml", "path of keys file")
+	for _, c := range []*cobra.Command{keysGenerateCmd, keysAddCmd, keysRotateCmd} {
+		c.Flags().IntVar(&keysFlags.cost, "cost", bcrypt.DefaultCost, "bcrypt cost of key hash")
+	}
+	for _, c := range []*cobra.Command{keysAddCmd, keysRotateCmd} {
+		c.Flags().StringVar(&keysFlags.id, "id", "", "id of new key, defaults to creation time")
+		c.Flags().DurationVar(&keysFlags.expires, "expires", 0, "lifetime of new key, keys do not expire if 0")
+	}
+	keysRotateCmd.Flags().DurationVar(&keysFlags.grace, "grace", 24*time.Hour, "time previous keys stay active")
+	keysRevokeCmd.Flags().StringVar(&keysFlags.id, "id", "", "id of key to disable instead of client")
+}
+
+// generateKey returns random key and its bcrypt hash
+func generateKey(cost int) (string, string, error) {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return "", "", fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key := base64.RawURLEncoding.EncodeToString(b)
+	hash, err := bcrypt.GenerateFromPassword([]byte(key), cost)
+	if err != nil {
+		return "", "", err
+	}
+	return key, string(hash), nil
+}
+
+// newAPIKey generates key and returns it with entry of keys file
+func newAPIKey(now time.Time) (string, mw.APIKey, error) {
+	key, hash, err := generateKey(keysFlags.cost)
+	if err != nil {
+		return "", mw.APIKey{}, err
+	}
+	now = now.UTC().Truncate(time.Second)
+	a := mw.APIKey{ID: keysFlags.id, Hash: hash, Created: now}
+	if a.ID == "" {
+		a.ID = now.Format("20060102T150405Z")
+	}
+	if keysFlags.expires > 0 {
+		a.Expires = now.Add(keysFlags.expires)
+	}
+	return key, a, nil
+}
+
+func keysGenerate(cmd *cobra.Command, args []string) error {
+	key, hash, err := generateKey(keysFlags.cost)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "key:  %s\nhash: %s\n", key, hash)
+	return nil
+}
+
+func keysAdd(cmd *cobra.Command, args []string) error {
+	f, err := readKeysFile(keysFlags.file)
+	if err != nil {
+		return err
+	}
+	key, a, err := newAPIKey(time.Now())
+	if err != nil {
+		return err
+	}
+	if err := f.addClient(args[0], a); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Added client %s to %s. Key is shown once:\n", args[0], keysFlags.file)
+	fmt.Fprintln(cmd.OutOrStdout(), key)
+	return nil
+}
+
+func keysRotate(cmd *cobra.Command, args []string) error {
+	f, err := readKeysFile(keysFlags.file)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	key, a, err := newAPIKey(now)
+	if err != nil {
+		return err
+	}
+	deadline := now.UTC().Truncate(time.Second).Add(keysFlags.grace)
+	if err := f.rotateKey(args[0], a, deadline); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Added key %s of client %s to %s, previous keys expire at %s. Key is shown once:\n",
+		a.ID, args[0], keysFlags.file, deadline.Format(time.RFC3339))
+	fmt.Fprintln(cmd.OutOrStdout(), key)
+	return nil
+}
+
+func keysRevoke(cmd *cobra.Command, args []string) error {
+	f, err := readKeysFile(keysFlags.file)
+	if err != nil {
+		return err
+	}
+	return f.revoke(args[0], keysFlags.id)
+}
+
+func keysList(cmd *cobra.Command, args []string) error {
+	f, err := readKeysFile(keysFlags.file)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for name := range f.clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := time.Now()
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CLIENT\tKEY\tAGE\tEXPIRES\tSTATUS")
+	for _, name := range names {
+		k := f.clients[name]
+		if len(k.Keys) == 0 {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t%s\n", name, keyStatus(k, mw.APIKey{}, now))
+		}
+		for _, key := range k.Keys {
+			id, age, expires := key.ID, "-", "-"
+			if id == "" {
+				id = "-"
+			}
+			if !key.Created.IsZero() {
+				age = formatAge(now.Sub(key.Created))
+			}
+			if !key.Expires.IsZero() {
+				expires = key.Expires.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", name, id, age, expires, keyStatus(k, key, now))
+		}
+	}
+	return w.Flush()
+}
+
+// keyStatus describes whether key of client is accepted
+func keyStatus(k *mw.KeyClient, key mw.APIKey, now time.Time) string {
+	switch {
+	case k.Disabled:
+		return "client disabled"
+	case key.Hash == "":
+		return "no key"
+	case key.Disabled:
+		return "disabled"
+	case !key.Expires.IsZero() && !now.Before(key.Expires):
+		return "expired"
+	}
+	return "active"
+}
+
+// formatAge formats duration in days, hours or minutes
+func formatAge(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	}
+	return fmt.Sprintf("%dm", d/time.Minute)
+}
+
+func keysVerify(cmd *cobra.Command, args []string) error {
+	b, err := ioutil.ReadFile(keysFlags.file)
+	if err != nil {
+		return err
+	}
+	clients, err := mw.ParseKeys(b)
+	if err != nil {
+		return fmt.Errorf("keys %s: %v", keysFlags.file, err)
+	}
+	k, ok := clients[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown client %s", args[0])
+	}
+
+	key, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if key = strings.TrimRight(key, "\r\n"); key == "" && err != nil {
+		return fmt.Errorf("read key from stdin: %v", err)
+	}
+
+	now := time.Now()
+	for _, a := range k.Keys {
+		if bcrypt.CompareHashAndPassword([]byte(a.Hash), []byte(key)) != nil {
+			continue
+		}
+		name := "key"
+		if a.ID != "" {
+			name += " " + a.ID
+		}
+		if status := keyStatus(k, a, now); status != "active" {
+			return fmt.Errorf("%s of client %s matches, but is %s", name, args[0], status)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%s of client %s is valid\n", name, args[0])
+		return nil
+	}
+	return fmt.Errorf("key does not match keys of client %s", args[0])
+}
@@ -0,0 +1,411 @@
+// Copyright © 2018 Artyom Turkin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	mw "github.com/artyomturkin/nprxy/middleware"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// keysFile keys file of api-key authn, edited line by line, so comments and order of entries are kept.
+// Edits support block style files as written by nprxy keys and shown in README.
+type keysFile struct {
+	path      string
+	mode      os.FileMode
+	lines     []string
+	versioned bool
+	clients   map[string]*mw.KeyClient
+}
+
+var clientName = regexp.MustCompile(`^[A-Za-z0-9._@-]+$`)
+
+// readKeysFile loads keys file, file that does not exist is empty versioned file
+func readKeysFile(path string) (*keysFile, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		b = []byte("version: 2\nclients:\n")
+	} else if err != nil {
+		return nil, err
+	}
+	f := &keysFile{path: path, mode: 0600}
+	if fi, err := os.Stat(path); err == nil {
+		f.mode = fi.Mode().Perm()
+	}
+	if err := f.parse(b); err != nil {
+		return nil, fmt.Errorf("keys %s: %v", path, err)
+	}
+	if s := strings.TrimSuffix(string(b), "\n"); s != "" {
+		f.lines = strings.Split(s, "\n")
+	}
+	return f, nil
+}
+
+func (f *keysFile) parse(b []byte) error {
+	clients, err := mw.ParseKeys(b)
+	if err != nil {
+		return err
+	}
+	var header struct {
+		Version interface{} `yaml:"version"`
+	}
+	yaml.Unmarshal(b, &header)
+	_, f.versioned = header.Version.(int)
+	f.clients = clients
+	return nil
+}
+
+func (f *keysFile) bytes() []byte {
+	return []byte(strings.Join(f.lines, "\n") + "\n")
+}
+
+// save checks that edited file is still valid and has expected content, then replaces file atomically
+func (f *keysFile) save(check func(map[string]*mw.KeyClient) bool) error {
+	b := f.bytes()
+	if err := f.parse(b); err != nil || !check(f.clients) {
+		return fmt.Errorf("keys %s: layout of file is not supported, edit it manually", f.path)
+	}
+	return writeFileAtomic(f.path, b, f.mode)
+}
+
+// writeFileAtomic writes file next to path and renames it, so readers never see partially written file
+func writeFileAtomic(path string, b []byte, mode os.FileMode) error {
+	t, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(t.Name())
+	if _, err := t.Write(b); err != nil {
+		t.Close()
+		return err
+	}
+	if err := t.Sync(); err != nil {
+		t.Close()
+		return err
+	}
+	if err := t.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(t.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(t.Name(), path)
+}
+
+// addClient adds client with key to the end of file
+func (f *keysFile) addClient(name string, key mw.APIKey) error {
+	if !clientName.MatchString(name) {
+		return fmt.Errorf("invalid client name %q", name)
+	}
+	if _, ok := f.clients[name]; ok {
+		return fmt.Errorf("client %s already exists, rotate its key", name)
+	}
+
+	if !f.versioned {
+		f.lines = append(f.lines, name+": "+key.Hash)
+	} else {
+		c := f.find(0, len(f.lines), 0, "clients")
+		if c < 0 {
+			f.lines = append(f.lines, "clients:")
+			c = len(f.lines) - 1
+		} else if value(f.lines[c]) != "" {
+			return fmt.Errorf("keys %s: clients must be block mapping", f.path)
+		}
+		end := f.blockEnd(c)
+		unit := 2
+		if i := f.next(c+1, end); i >= 0 {
+			unit = indent(f.lines[i])
+		}
+		pad := strings.Repeat(" ", unit)
+		lines := append([]string{pad + name + ":", pad + pad + "keys:"}, keyLines(key, pad+pad)...)
+		f.insert(end, lines...)
+	}
+
+	return f.save(func(clients map[string]*mw.KeyClient) bool {
+		k := clients[name]
+		return k != nil && len(k.Keys) == 1 && k.Keys[0].Hash == key.Hash
+	})
+}
+
+// rotateKey adds key as the first key of client, active keys without expiry or expiring later expire at deadline
+func (f *keysFile) rotateKey(name string, key mw.APIKey, deadline time.Time) error {
+	if !f.versioned {
+		return fmt.Errorf("keys %s: rotation requires versioned file, see README", f.path)
+	}
+	client, c, end, err := f.client(name)
+	if err != nil {
+		return err
+	}
+	unit := f.childIndent(c, end) - indent(f.lines[c])
+
+	k := f.find(c+1, end, indent(f.lines[c])+unit, "keys")
+	if k < 0 {
+		f.insert(c+1, strings.Repeat(" ", indent(f.lines[c])+unit)+"keys:")
+		k, end = c+1, end+1
+	} else if value(f.lines[k]) != "" {
+		return fmt.Errorf("keys %s: keys of client %s must be block sequence", f.path, name)
+	}
+
+	entries := f.entries(k, end)
+	if len(entries) != len(client.Keys) {
+		return fmt.Errorf("keys %s: keys of client %s must be block sequence", f.path, name)
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		old := client.Keys[i]
+		if old.Disabled || (!old.Expires.IsZero() && !old.Expires.After(deadline)) {
+			continue
+		}
+		f.setField(entries[i], f.blockEnd(entries[i]), indent(f.lines[entries[i]])+2, "expires", deadline.Format(time.RFC3339))
+	}
+	dash := indent(f.lines[k])
+	if len(entries) > 0 {
+		dash = indent(f.lines[entries[0]])
+	}
+	f.insert(k+1, keyLines(key, strings.Repeat(" ", dash))...)
+
+	previous := client.Keys
+	return f.save(func(clients map[string]*mw.KeyClient) bool {
+		k := clients[name]
+		if k == nil || len(k.Keys) != len(previous)+1 || k.Keys[0].Hash != key.Hash {
+			return false
+		}
+		for i, old := range k.Keys[1:] {
+			if old.Hash != previous[i].Hash || (!old.Disabled && (old.Expires.IsZero() || old.Expires.After(deadline))) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// revoke disables client or its key with id, client of flat file is removed
+func (f *keysFile) revoke(name, id string) error {
+	client, c, end, err := f.client(name)
+	if err != nil {
+		return err
+	}
+	if !f.versioned {
+		if id != "" {
+			return fmt.Errorf("keys %s: keys of flat file have no ids, revoke client", f.path)
+		}
+		f.lines = append(f.lines[:c], f.lines[end:]...)
+		return f.save(func(clients map[string]*mw.KeyClient) bool {
+			return clients[name] == nil
+		})
+	}
+
+	if id == "" {
+		f.setField(c, end, f.childIndent(c, end), "disabled", "true")
+		return f.save(func(clients map[string]*mw.KeyClient) bool {
+			return clients[name] != nil && clients[name].Disabled
+		})
+	}
+
+	k := f.find(c+1, end, f.childIndent(c, end), "keys")
+	entries := f.entries(k, end)
+	if k < 0 || len(entries) != len(client.Keys) {
+		return fmt.Errorf("keys %s: keys of client %s must be block sequence", f.path, name)
+	}
+	n := -1
+	for i, key := range client.Keys {
+		if key.ID == id {
+			n = i
+		}
+	}
+	if n < 0 {
+		return fmt.Errorf("client %s has no key %s", name, id)
+	}
+	f.setField(entries[n], f.blockEnd(entries[n]), indent(f.lines[entries[n]])+2, "disabled", "true")
+	return f.save(func(clients map[string]*mw.KeyClient) bool {
+		k := clients[name]
+		return k != nil && len(k.Keys) == len(client.Keys) && k.Keys[n].Disabled
+	})
+}
+
+// client returns client and lines of its block
+func (f *keysFile) client(name string) (*mw.KeyClient, int, int, error) {
+	client, ok := f.clients[name]
+	if !ok {
+		return nil, 0, 0, fmt.Errorf("unknown client %s", name)
+	}
+	if !f.versioned {
+		c := f.find(0, len(f.lines), 0, name)
+		if c < 0 {
+			return nil, 0, 0, fmt.Errorf("keys %s: client %s not found", f.path, name)
+		}
+		return client, c, f.blockEnd(c), nil
+	}
+	s := f.find(0, len(f.lines), 0, "clients")
+	if s < 0 {
+		return nil, 0, 0, fmt.Errorf("keys %s: clients not found", f.path)
+	}
+	e := f.blockEnd(s)
+	c := f.find(s+1, e, f.childIndent(s, e), name)
+	if c < 0 || value(f.lines[c]) != "" {
+		return nil, 0, 0, fmt.Errorf("keys %s: client %s must be block mapping", f.path, name)
+	}
+	return client, c, f.blockEnd(c), nil
+}
+
+// keyLines returns entry of key sequence with dash at pad
+func keyLines(key mw.APIKey, pad string) []string {
+	field := pad + strings.Repeat(" ", 2)
+	lines := []string{pad + "- id: " + strconv.Quote(key.ID), field + "hash: " + key.Hash}
+	if !key.Created.IsZero() {
+		lines = append(lines, field+"created: "+key.Created.Format(time.RFC3339))
+	}
+	if !key.Expires.IsZero() {
+		lines = append(lines, field+"expires: "+key.Expires.Format(time.RFC3339))
+	}
+	return lines
+}
+
+// setField replaces value of mapping key at indent within lines, or adds it after the last line
+func (f *keysFile) setField(start, end, ind int, key, v string) {
+	for i := start; i < end; i++ {
+		l := f.lines[i]
+		n := indent(l)
+		if i == start && strings.HasPrefix(strings.TrimSpace(l), "- ") {
+			l, n = strings.Repeat(" ", n+2)+strings.TrimSpace(l)[2:], n+2
+		}
+		if n == ind && keyOf(l) == key {
+			f.lines[i] = f.lines[i][:strings.Index(f.lines[i], key+":")] + key + ": " + v
+			return
+		}
+	}
+	f.insert(end, strings.Repeat(" ", ind)+key+": "+v)
+}
+
+// find returns first line in [start, end) with mapping key at indent, -1 if there is none
+func (f *keysFile) find(start, end, ind int, key string) int {
+	for i := start; i < end; i++ {
+		if indent(f.lines[i]) == ind && keyOf(f.lines[i]) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// next returns first line with content in [start, end), -1 if there is none
+func (f *keysFile) next(start, end int) int {
+	for i := start; i < end; i++ {
+		if indent(f.lines[i]) >= 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// childIndent returns indent of first child of block of line start
+func (f *keysFile) childIndent(start, end int) int {
+	if i := f.next(start+1, end); i >= 0 {
+		return indent(f.lines[i])
+	}
+	return indent(f.lines[start]) + 2
+}
+
+// blockEnd returns line after last line, that is indented deeper than line start
+func (f *keysFile) blockEnd(start int) int {
+	ind, end := indent(f.lines[start]), start+1
+	for i := start + 1; i < len(f.lines); i++ {
+		if n := indent(f.lines[i]); n >= 0 {
+			if n <= ind {
+				break
+			}
+			end = i + 1
+		}
+	}
+	return end
+}
+
+// entries returns first lines of entries of sequence of key at line k, dashes may be at the indent of key
+func (f *keysFile) entries(k, end int) []int {
+	if k < 0 {
+		return nil
+	}
+	var entries []int
+	ind, dash := indent(f.lines[k]), -1
+	for i := k + 1; i < end; i++ {
+		n := indent(f.lines[i])
+		if n < 0 {
+			continue
+		}
+		isDash := strings.HasPrefix(strings.TrimSpace(f.lines[i]), "- ") || strings.TrimSpace(f.lines[i]) == "-"
+		if n < ind || (n == ind && !isDash) {
+			break
+		}
+		if dash < 0 {
+			dash = n
+		}
+		if n == dash && isDash {
+			entries = append(entries, i)
+		}
+	}
+	return entries
+}
+
+func (f *keysFile) insert(at int, lines ...string) {
+	f.lines = append(f.lines[:at], append(lines, f.lines[at:]...)...)
+}
+
+// indent returns number of leading spaces, -1 for empty and comment lines
+func indent(l string) int {
+	t := strings.TrimLeft(l, " ")
+	if t == "" || strings.HasPrefix(t, "#") {
+		return -1
+	}
+	return len(l) - len(t)
+}
+
+// keyOf returns unquoted mapping key of line
+func keyOf(l string) string {
+	t := strings.TrimSpace(l) + " "
+	i := strings.Index(t, ": ")
+	if i <= 0 {
+		return ""
+	}
+	if k, err := strconv.Unquote(t[:i]); err == nil {
+		return k
+	}
+	return strings.Trim(t[:i], "'")
+}
+
+// value returns value of mapping key of line without comment
+func value(l string) string {
+	t := strings.TrimSpace(l) + " "
+	i := strings.Index(t, ": ")
+	if i <= 0 {
+		return ""
+	}
+	v := " " + t[i+2:]
+	if j := strings.Index(v, " #"); j >= 0 {
+		v = v[:j]
+	}
+	return strings.TrimSpace(v)
+}
@@ -0,0 +1,269 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mw "github.com/artyomturkin/nprxy/middleware"
+)
+
+const keysHash = "$2a$04$Wr/aAu5Wtf8xrfhsTiiIKuQe4sqoDLBY8Sdss6D/nJ8lkG.HKpWD."
+
+const testKeys = `# clients of orders service
+version: 2
+clients:
+  # team A
+  test-system:
+    keys:
+    - id: "old"
+      hash: ` + keysHash + `
+    scopes: [orders:read]
+  "legacy-system":
+      keys:
+        - hash: ` + keysHash + ` # first key
+          expires: 2000-01-01T00:00:00Z
+
+# end of clients
+`
+
+func TestKeysFile(t *testing.T) {
+	type testCase struct {
+		name     string
+		file     string
+		edit     func(f *keysFile) error
+		expected string
+	}
+
+	created := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	key := mw.APIKey{ID: "new", Hash: keysHash, Created: created}
+
+	cases := []testCase{
+		testCase{
+			name: "add",
+			file: testKeys,
+			edit: func(f *keysFile) error { return f.addClient("new-system", key) },
+			expected: `# clients of orders service
+version: 2
+clients:
+  # team A
+  test-system:
+    keys:
+    - id: "old"
+      hash: ` + keysHash + `
+    scopes: [orders:read]
+  "legacy-system":
+      keys:
+        - hash: ` + keysHash + ` # first key
+          expires: 2000-01-01T00:00:00Z
+  new-system:
+    keys:
+    - id: "new"
+      hash: ` + keysHash + `
+      created: 2024-06-01T00:00:00Z
+
+# end of clients
+`,
+		},
+		testCase{
+			name:     "add to flat file",
+			file:     "# flat\ntest-system: " + keysHash + "\n",
+			edit:     func(f *keysFile) error { return f.addClient("new-system", key) },
+			expected: "# flat\ntest-system: " + keysHash + "\nnew-system: " + keysHash + "\n",
+		},
+		testCase{
+			name: "add to missing file",
+			edit: func(f *keysFile) error { return f.addClient("new-system", key) },
+			expected: `version: 2
+clients:
+  new-system:
+    keys:
+    - id: "new"
+      hash: ` + keysHash + `
+      created: 2024-06-01T00:00:00Z
+`,
+		},
+		testCase{
+			name: "add existing",
+			file: testKeys,
+			edit: func(f *keysFile) error { return f.addClient("test-system", key) },
+		},
+		testCase{
+			name: "add invalid name",
+			file: testKeys,
+			edit: func(f *keysFile) error { return f.addClient("a: b", key) },
+		},
+		testCase{
+			name: "rotate",
+			file: testKeys,
+			edit: func(f *keysFile) error { return f.rotateKey("test-system", key, created.Add(time.Hour)) },
+			expected: `# clients of orders service
+version: 2
+clients:
+  # team A
+  test-system:
+    keys:
+    - id: "new"
+      hash: ` + keysHash + `
+      created: 2024-06-01T00:00:00Z
+    - id: "old"
+      hash: ` + keysHash + `
+      expires: 2024-06-01T01:00:00Z
+    scopes: [orders:read]
+  "legacy-system":
+      keys:
+        - hash: ` + keysHash + ` # first key
+          expires: 2000-01-01T00:00:00Z
+
+# end of clients
+`,
+		},
+		testCase{
+			name: "rotate expired",
+			file: testKeys,
+			edit: func(f *keysFile) error { return f.rotateKey("legacy-system", key, created.Add(time.Hour)) },
+			expected: `# clients of orders service
+version: 2
+clients:
+  # team A
+  test-system:
+    keys:
+    - id: "old"
+      hash: ` + keysHash + `
+    scopes: [orders:read]
+  "legacy-system":
+      keys:
+        - id: "new"
+          hash: ` + keysHash + `
+          created: 2024-06-01T00:00:00Z
+        - hash: ` + keysHash + ` # first key
+          expires: 2000-01-01T00:00:00Z
+
+# end of clients
+`,
+		},
+		testCase{
+			name: "rotate flat file",
+			file: "test-system: " + keysHash + "\n",
+			edit: func(f *keysFile) error { return f.rotateKey("test-system", key, created) },
+		},
+		testCase{
+			name: "rotate flow keys",
+			file: "version: 2\nclients:\n  test-system:\n    keys: [{hash: " + keysHash + "}]\n",
+			edit: func(f *keysFile) error { return f.rotateKey("test-system", key, created) },
+		},
+		testCase{
+			name: "rotate unknown",
+			file: testKeys,
+			edit: func(f *keysFile) error { return f.rotateKey("other-system", key, created) },
+		},
+		testCase{
+			name: "revoke client",
+			file: testKeys,
+			edit: func(f *keysFile) error { return f.revoke("legacy-system", "") },
+			expected: `# clients of orders service
+version: 2
+clients:
+  # team A
+  test-system:
+    keys:
+    - id: "old"
+      hash: ` + keysHash + `
+    scopes: [orders:read]
+  "legacy-system":
+      keys:
+        - hash: ` + keysHash + ` # first key
+          expires: 2000-01-01T00:00:00Z
+      disabled: true
+
+# end of clients
+`,
+		},
+		testCase{
+			name:     "revoke disabled client",
+			file:     "version: 2\nclients:\n  test-system:\n    disabled: false # until review\n",
+			edit:     func(f *keysFile) error { return f.revoke("test-system", "") },
+			expected: "version: 2\nclients:\n  test-system:\n    disabled: true\n",
+		},
+		testCase{
+			name: "revoke key",
+			file: testKeys,
+			edit: func(f *keysFile) error { return f.revoke("test-system", "old") },
+			expected: `# clients of orders service
+version: 2
+clients:
+  # team A
+  test-system:
+    keys:
+    - id: "old"
+      hash: ` + keysHash + `
+      disabled: true
+    scopes: [orders:read]
+  "legacy-system":
+      keys:
+        - hash: ` + keysHash + ` # first key
+          expires: 2000-01-01T00:00:00Z
+
+# end of clients
+`,
+		},
+		testCase{
+			name: "revoke unknown key",
+			file: testKeys,
+			edit: func(f *keysFile) error { return f.revoke("test-system", "other") },
+		},
+		testCase{
+			name:     "revoke flat client",
+			file:     "a: " + keysHash + "\nb:\n  key: " + keysHash + "\n  hmac: secret\nc: " + keysHash + "\n",
+			edit:     func(f *keysFile) error { return f.revoke("b", "") },
+			expected: "a: " + keysHash + "\nc: " + keysHash + "\n",
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			path := filepath.Join(dir, "keys.yaml")
+			os.Remove(path)
+			if cs.file != "" {
+				ioutil.WriteFile(path, []byte(cs.file), 0640)
+			}
+
+			f, err := readKeysFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = cs.edit(f)
+			if cs.expected == "" {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if b, _ := ioutil.ReadFile(path); string(b) != cs.file {
+					t.Errorf("expected file to be unchanged, got\n%s", b)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, _ := ioutil.ReadFile(path)
+			if string(b) != cs.expected {
+				t.Errorf("expected\n%s\ngot\n%s", cs.expected, b)
+			}
+			if fi, _ := os.Stat(path); cs.file != "" && fi.Mode().Perm() != 0640 {
+				t.Errorf("expected mode to be kept, got %v", fi.Mode())
+			}
+			if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+				t.Errorf("expected temporary file to be removed, got %d files", len(files))
+			}
+		})
+	}
+}
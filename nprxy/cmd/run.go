@@ -39,7 +39,10 @@ var runCmd = &cobra.Command{
 	Use:   "run",
 	Short: "Start nprxy",
 	Long:  `Proxy requests to the target service and from the service to upstream dependencies.`,
-	RunE:  run,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		initConfig()
+	},
+	RunE: run,
 }
 
 func init() {
@@ -79,15 +82,15 @@ func run(cmd *cobra.Command, args []string) error {
 	}
 
 	for _, service := range c.Services {
-		go func(s nprxy.ServiceConfig) {
-			logrus.Infof("Starting %s", s.Name)
-			err := nprxy.ProxyService(ctx, s)
-			if err != http.ErrServerClosed {
-				fmt.Printf("Proxy service %s failed: %v\n", s.Name, err)
-				os.Exit(2)
-			}
-		}(service)
+		logrus.Infof("Starting %s", service.Name)
 	}
+	go func() {
+		err := nprxy.ProxyServices(ctx, c.Services)
+		if err != http.ErrServerClosed {
+			fmt.Printf("%v\n", err)
+			os.Exit(2)
+		}
+	}()
 
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, os.Interrupt)
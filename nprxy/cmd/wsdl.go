@@ -0,0 +1,159 @@
+// Copyright © 2018 Artyom Turkin
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"text/template"
+
+	"github.com/artyomturkin/nprxy/wsdl"
+	"github.com/spf13/cobra"
+)
+
+// wsdlCmd represents the wsdl command
+var wsdlCmd = &cobra.Command{
+	Use:   "wsdl",
+	Short: "Work with WSDL documents",
+}
+
+// wsdlImportCmd represents the wsdl import command
+var wsdlImportCmd = &cobra.Command{
+	Use:   "import <wsdl url or file>",
+	Short: "Generate service config and casbin policy from WSDL",
+	Long: `Read WSDL document with its imports and print config of soap service, that proxies its first SOAP port.
+Casbin policy skeleton, that allows system every SOAPAction of the document and input element of operations
+without SOAPAction, is written to policy file.`,
+	Args: cobra.ExactArgs(1),
+	RunE: wsdlImport,
+}
+
+var wsdlImportFlags struct {
+	name   string
+	listen string
+	system string
+	keys   string
+	model  string
+	policy string
+}
+
+func init() {
+	rootCmd.AddCommand(wsdlCmd)
+	wsdlCmd.AddCommand(wsdlImportCmd)
+
+	f := wsdlImportCmd.Flags()
+	f.StringVar(&wsdlImportFlags.name, "name", "", "service name, defaults to name of WSDL service")
+	f.StringVar(&wsdlImportFlags.listen, "listen", ":8080", "address to listen on")
+	f.StringVar(&wsdlImportFlags.system, "system", "client", "system allowed in policy skeleton")
+	f.StringVar(&wsdlImportFlags.keys, "keys", "keys.yaml", "path of api keys file in config")
+	f.StringVar(&wsdlImportFlags.model, "model", "model.conf", "path of casbin model in config")
+	f.StringVar(&wsdlImportFlags.policy, "policy", "", "path of casbin policy file to write, defaults to <name>_policy.csv")
+}
+
+var serviceTemplate = template.Must(template.New("service").Parse(`services:
+- name: {{.Name}}
+  listen:
+    address: {{.Listen}}
+  upstream: {{.Upstream}}
+  http:
+    kind: soap
+    authn:
+      kind: api-key
+      params:
+        path: {{.Keys}}
+    authz:
+      kind: casbin
+      params:
+        model: {{.Model}}
+        policy: {{.Policy}}
+        parameters: [client, operation]
+`))
+
+func wsdlImport(cmd *cobra.Command, args []string) error {
+	defs, err := wsdl.Load(args[0])
+	if err != nil {
+		return err
+	}
+
+	var port *wsdl.Port
+	name := wsdlImportFlags.name
+	for _, s := range defs.Services {
+		if len(s.Ports) > 0 {
+			port = &s.Ports[0]
+			if name == "" {
+				name = s.Name
+			}
+			break
+		}
+	}
+	if port == nil {
+		return fmt.Errorf("WSDL has no SOAP ports")
+	}
+	u, err := url.Parse(port.Address)
+	if err != nil || !u.IsAbs() {
+		return fmt.Errorf("invalid address %s of port %s", port.Address, port.Name)
+	}
+
+	policy := wsdlImportFlags.policy
+	if policy == "" {
+		policy = name + "_policy.csv"
+	}
+	if _, err := os.Stat(policy); err == nil {
+		return fmt.Errorf("policy file %s already exists", policy)
+	}
+
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "# Policy of %s generated from %s\n", name, args[0])
+	for _, action := range defs.SOAPActions() {
+		fmt.Fprintf(b, "p, %s, %s\n", wsdlImportFlags.system, action)
+	}
+	// Operations without soapAction are resolved from SOAP body element, {namespace}local
+	seen := map[string]bool{}
+	for _, op := range defs.Operations {
+		if op.SOAPAction != "" {
+			continue
+		}
+		if op.Input.Local == "" {
+			fmt.Fprintf(b, "# %s/%s has no soapAction and no input element, it is resolved from SOAP body element\n", op.Binding, op.Name)
+			continue
+		}
+		element := "{" + op.Input.Space + "}" + op.Input.Local
+		if !seen[element] {
+			seen[element] = true
+			fmt.Fprintf(b, "p, %s, %s\n", wsdlImportFlags.system, element)
+		}
+	}
+	if err := ioutil.WriteFile(policy, b.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	return serviceTemplate.Execute(os.Stdout, map[string]string{
+		"Name":     name,
+		"Listen":   wsdlImportFlags.listen,
+		"Upstream": u.Scheme + "://" + u.Host,
+		"Keys":     wsdlImportFlags.keys,
+		"Model":    wsdlImportFlags.model,
+		"Policy":   policy,
+	})
+}
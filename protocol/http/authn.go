@@ -0,0 +1,380 @@
+package http
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	gohttp "net/http"
+	"strings"
+	"sync"
+
+	"github.com/artyomturkin/nprxy"
+	"github.com/artyomturkin/nprxy/middleware"
+	"github.com/labstack/echo"
+)
+
+// authenticators builders of authentication middlewares by authn kind
+var authenticators = map[string]func(params map[string]interface{}) (echo.MiddlewareFunc, error){
+	"api-key":              buildAPIKeyAuthn,
+	"ws-security":          buildWSSecurityAuthn,
+	"jwt":                  buildJWTAuthn,
+	"oauth2-introspection": buildIntrospectionAuthn,
+	"basic":                buildBasicAuthn,
+	"hmac":                 buildHMACAuthn,
+	"mtls":                 buildMTLSAuthn,
+}
+
+// credentials report whether request carries credentials of authn kind
+var credentials = map[string]func(c echo.Context) bool{
+	"api-key": func(c echo.Context) bool {
+		h := c.Request().Header
+		return h.Get("X-NPRXY-Client") != "" || h.Get("X-NPRXY-Key") != ""
+	},
+	"ws-security":          mw.HasWSSecurity,
+	"jwt":                  hasAuthorization("Bearer"),
+	"oauth2-introspection": hasAuthorization("Bearer"),
+	"basic":                hasAuthorization("Basic"),
+	"hmac":                 hasAuthorization(mw.HMACScheme),
+	"mtls": func(c echo.Context) bool {
+		tls := c.Request().TLS
+		return tls != nil && len(tls.PeerCertificates) > 0
+	},
+}
+
+// hasAuthorization returns check of Authorization header with scheme
+func hasAuthorization(scheme string) func(c echo.Context) bool {
+	return func(c echo.Context) bool {
+		auth := c.Request().Header.Get(echo.HeaderAuthorization)
+		return len(auth) > len(scheme) && strings.EqualFold(auth[:len(scheme)+1], scheme+" ")
+	}
+}
+
+// buildAuthn creates authentication middleware of service with methods of AuthnChain, or of Authn, nil if service
+// has no authentication. With token endpoint enabled, handler of endpoint is returned as well: it issues tokens
+// to clients authenticated by methods of service, and issued tokens are accepted before other methods.
+func buildAuthn(name string, listen nprxy.ListenerConfig, c nprxy.HTTPConfig) (echo.MiddlewareFunc, echo.HandlerFunc, error) {
+	chain := c.AuthnChain
+	if len(chain) == 0 && c.Authn != nil {
+		chain = []nprxy.Parameters{*c.Authn}
+	}
+	if len(chain) == 0 {
+		if len(c.AnonymousOperations) > 0 {
+			return nil, nil, fmt.Errorf("anonymous operations require authn")
+		}
+		if c.Token.Enabled {
+			return nil, nil, fmt.Errorf("token endpoint requires authn")
+		}
+		return nil, nil, nil
+	}
+
+	config := mw.AuthnChainConfig{Anonymous: c.AnonymousOperations}
+	if c.Kind == "soap" {
+		// WSDL and XSD requests are authenticated like other requests, unless service publishes them, e.g. for
+		// clients of ws-security, whose credentials can not be sent with GET
+		public, err := paramBool(c.KindParams, "publicWSDL")
+		if err != nil {
+			return nil, nil, err
+		}
+		if public {
+			config.Skipper = skipWSDL
+		}
+	}
+	for _, p := range chain {
+		build, ok := authenticators[p.Kind]
+		if !ok {
+			return nil, nil, fmt.Errorf("unsupported authn kind %s", p.Kind)
+		}
+		// Without client CA listener does not request certificates, so mtls would never authenticate anyone
+		if p.Kind == "mtls" && (listen.Kind != "tls" || listen.TLSClientCA == "") {
+			return nil, nil, fmt.Errorf("mtls: requires tls listener with tlsClientCA")
+		}
+		m, err := build(p.Params)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", p.Kind, err)
+		}
+		config.Methods = append(config.Methods, mw.AuthnMethod{Name: p.Kind, Present: credentials[p.Kind], Middleware: m})
+	}
+	if !c.Token.Enabled {
+		return mw.AuthnChainWithConfig(config), nil, nil
+	}
+
+	keys, err := mw.NewTokenKeys(c.Token.Secrets)
+	if err != nil {
+		return nil, nil, fmt.Errorf("token: %v", err)
+	}
+	if c.Token.Secrets != "" {
+		if _, err := keys.Watch(); err != nil {
+			return nil, nil, fmt.Errorf("token: watch %s: %v", c.Token.Secrets, err)
+		}
+	}
+	tc := mw.ProxyTokenConfig{Keys: keys, Audience: name, TTL: c.Token.TTL}
+	for _, p := range chain {
+		if p.Kind != "api-key" && p.Kind != "hmac" {
+			continue
+		}
+		s, err := loadKeyStore(p.Params)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", p.Kind, err)
+		}
+		tc.Stores = append(tc.Stores, s)
+	}
+
+	// Tokens are not renewed with tokens. Tokens of api-key and hmac clients are checked against their keys files,
+	// so disabled clients and keys stop working at once; credentials of other methods once their tokens expire.
+	endpoint := mw.AuthnChainWithConfig(mw.AuthnChainConfig{Methods: config.Methods})(mw.ProxyTokenEndpoint(tc))
+	token := mw.AuthnMethod{Name: "token", Present: mw.HasProxyToken, Middleware: mw.ProxyTokenWithConfig(tc)}
+	config.Methods = append([]mw.AuthnMethod{token}, config.Methods...)
+	return mw.AuthnChainWithConfig(config), endpoint, nil
+}
+
+func buildAPIKeyAuthn(params map[string]interface{}) (echo.MiddlewareFunc, error) {
+	c := mw.BCryptAPIKeyConfig{}
+
+	var err error
+	if c.Store, err = loadKeyStore(params); err != nil {
+		return nil, err
+	}
+	if c.CacheTTL, err = paramDuration(params, "cacheTTL"); err != nil {
+		return nil, err
+	}
+	size, err := paramInt(params, "cacheSize")
+	if err != nil {
+		return nil, err
+	}
+	c.CacheSize = int(size)
+	failures, err := paramInt(params, "maxClientFailures")
+	if err != nil {
+		return nil, err
+	}
+	c.MaxClientFailures = int(failures)
+	if failures, err = paramInt(params, "maxIPFailures"); err != nil {
+		return nil, err
+	}
+	c.MaxIPFailures = int(failures)
+	if c.LockoutDuration, err = paramDuration(params, "lockout"); err != nil {
+		return nil, err
+	}
+	if c.MaxLockoutDuration, err = paramDuration(params, "maxLockout"); err != nil {
+		return nil, err
+	}
+	return mw.BCryptAPIKeyWithConfig(c), nil
+}
+
+func buildWSSecurityAuthn(params map[string]interface{}) (echo.MiddlewareFunc, error) {
+	c := mw.WSSecurityConfig{}
+
+	var err error
+	if c.Store, err = watchKeyStore(params, "path"); err != nil {
+		return nil, err
+	}
+	if c.SecretStore, err = watchKeyStore(params, "secrets"); err != nil {
+		return nil, err
+	}
+	if c.Certificates, err = loadCertificates(params, "certificates"); err != nil {
+		return nil, err
+	}
+	if c.MaxClockSkew, err = paramDuration(params, "maxClockSkew"); err != nil {
+		return nil, err
+	}
+	if c.MaxBodyBytes, err = paramInt(params, "maxBodyBytes"); err != nil {
+		return nil, err
+	}
+	return mw.WSSecurityWithConfig(c), nil
+}
+
+func buildJWTAuthn(params map[string]interface{}) (echo.MiddlewareFunc, error) {
+	c := mw.JWTConfig{}
+
+	location, err := paramString(params, "jwks")
+	if err != nil {
+		return nil, err
+	}
+	if location == "" {
+		return nil, fmt.Errorf("jwks is required")
+	}
+	if c.Keys, err = mw.NewJWKS(location); err != nil {
+		return nil, err
+	}
+	refresh, err := paramDuration(params, "refresh")
+	if err != nil {
+		return nil, err
+	}
+	if refresh > 0 {
+		c.Keys.Refresh = refresh
+	}
+	if c.Algorithms, err = paramStrings(params, "algorithms"); err != nil {
+		return nil, err
+	}
+	if c.Issuer, err = paramString(params, "issuer"); err != nil {
+		return nil, err
+	}
+	if c.Audience, err = paramString(params, "audience"); err != nil {
+		return nil, err
+	}
+	if c.Leeway, err = paramDuration(params, "leeway"); err != nil {
+		return nil, err
+	}
+	if c.ClientClaim, err = paramString(params, "clientClaim"); err != nil {
+		return nil, err
+	}
+	if c.Claims, err = paramStrings(params, "claims"); err != nil {
+		return nil, err
+	}
+	return mw.JWTWithConfig(c), nil
+}
+
+func buildIntrospectionAuthn(params map[string]interface{}) (echo.MiddlewareFunc, error) {
+	c := mw.IntrospectionConfig{}
+
+	var err error
+	if c.Endpoint, err = paramString(params, "endpoint"); err != nil {
+		return nil, err
+	}
+	if c.Endpoint == "" {
+		return nil, fmt.Errorf("endpoint is required")
+	}
+	if c.ClientID, err = paramString(params, "clientID"); err != nil {
+		return nil, err
+	}
+	if c.ClientSecret, err = paramString(params, "clientSecret"); err != nil {
+		return nil, err
+	}
+	if c.CacheTTL, err = paramDuration(params, "cacheTTL"); err != nil {
+		return nil, err
+	}
+	if c.NegativeCacheTTL, err = paramDuration(params, "negativeCacheTTL"); err != nil {
+		return nil, err
+	}
+	size, err := paramInt(params, "cacheSize")
+	if err != nil {
+		return nil, err
+	}
+	c.CacheSize = int(size)
+	timeout, err := paramDuration(params, "timeout")
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		c.Client = &gohttp.Client{Timeout: timeout}
+	}
+	return mw.IntrospectionWithConfig(c), nil
+}
+
+func buildBasicAuthn(params map[string]interface{}) (echo.MiddlewareFunc, error) {
+	c := mw.BasicAuthConfig{}
+
+	path, err := paramString(params, "path")
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+	if c.Users, err = mw.NewHtpasswd(path); err != nil {
+		return nil, err
+	}
+	// users are watched for lifetime of proxy
+	if _, err := c.Users.Watch(); err != nil {
+		return nil, fmt.Errorf("watch %s: %v", path, err)
+	}
+	if c.Realm, err = paramString(params, "realm"); err != nil {
+		return nil, err
+	}
+	return mw.BasicAuthWithConfig(c), nil
+}
+
+func buildHMACAuthn(params map[string]interface{}) (echo.MiddlewareFunc, error) {
+	c := mw.HMACAuthConfig{}
+
+	var err error
+	if c.Store, err = loadKeyStore(params); err != nil {
+		return nil, err
+	}
+	if c.RequiredHeaders, err = paramStrings(params, "signedHeaders"); err != nil {
+		return nil, err
+	}
+	if c.MaxClockSkew, err = paramDuration(params, "maxClockSkew"); err != nil {
+		return nil, err
+	}
+	if c.MaxBodyBytes, err = paramInt(params, "maxBodyBytes"); err != nil {
+		return nil, err
+	}
+	return mw.HMACAuthWithConfig(c), nil
+}
+
+func buildMTLSAuthn(params map[string]interface{}) (echo.MiddlewareFunc, error) {
+	return mw.TLSClientAuth(), nil
+}
+
+// loadKeyStore loads keys file of path parameter and watches it for lifetime of proxy
+func loadKeyStore(params map[string]interface{}) (*mw.KeyStore, error) {
+	s, err := watchKeyStore(params, "path")
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, fmt.Errorf("path is required")
+	}
+	return s, nil
+}
+
+// keyStores keys files by path, shared by authn methods and token checks, so each file is watched once
+var (
+	keyStoresMu sync.Mutex
+	keyStores   = map[string]*mw.KeyStore{}
+)
+
+// watchKeyStore loads keys file of parameter and watches it for lifetime of proxy, nil if parameter is not set
+func watchKeyStore(params map[string]interface{}, key string) (*mw.KeyStore, error) {
+	path, err := paramString(params, key)
+	if err != nil || path == "" {
+		return nil, err
+	}
+	keyStoresMu.Lock()
+	defer keyStoresMu.Unlock()
+	if s, ok := keyStores[path]; ok {
+		return s, nil
+	}
+	s, err := mw.NewKeyStore(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.Watch(); err != nil {
+		return nil, fmt.Errorf("watch %s: %v", path, err)
+	}
+	keyStores[path] = s
+	return s, nil
+}
+
+// loadCertificates reads PEM encoded certificates from path parameter
+func loadCertificates(params map[string]interface{}, key string) ([]*x509.Certificate, error) {
+	path, err := paramString(params, key)
+	if err != nil || path == "" {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read certificates %s: %v", path, err)
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse certificates %s: %v", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates in %s", path)
+	}
+	return certs, nil
+}
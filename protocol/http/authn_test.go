@@ -0,0 +1,30 @@
+package http
+
+import (
+	"testing"
+
+	"github.com/artyomturkin/nprxy"
+)
+
+func TestBuildAuthnMTLS(t *testing.T) {
+	type testCase struct {
+		name   string
+		listen nprxy.ListenerConfig
+		valid  bool
+	}
+
+	cases := []testCase{
+		testCase{name: "client CA", listen: nprxy.ListenerConfig{Kind: "tls", TLSClientCA: "ca.pem"}, valid: true},
+		testCase{name: "no client CA", listen: nprxy.ListenerConfig{Kind: "tls"}},
+		testCase{name: "plain listener", listen: nprxy.ListenerConfig{TLSClientCA: "ca.pem"}},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			_, _, err := buildAuthn("orders", cs.listen, nprxy.HTTPConfig{Authn: &nprxy.Parameters{Kind: "mtls"}})
+			if cs.valid != (err == nil) {
+				t.Errorf("expected valid %v, got %v", cs.valid, err)
+			}
+		})
+	}
+}
@@ -2,20 +2,23 @@ package http
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
 	gohttp "net/http"
 	"net/http/httputil"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/artyomturkin/nprxy"
+	"github.com/artyomturkin/nprxy/jsonschema"
 	"github.com/artyomturkin/nprxy/middleware"
+	"github.com/artyomturkin/nprxy/wsdl"
 	"github.com/casbin/casbin"
 	"github.com/labstack/echo/middleware"
-	yaml "gopkg.in/yaml.v2"
 
 	"github.com/labstack/echo"
 )
@@ -32,16 +35,40 @@ func buildHTTPProxy(c nprxy.ServiceConfig) (nprxy.Proxy, error) {
 	u, _ := url.Parse(c.Upstream)
 
 	h := &httpProxy{
-		Upstream:   u,
-		Grace:      c.Grace,
-		Timeout:    c.Timeout,
-		DisableLog: c.DisableLog,
+		Upstream:      u,
+		Grace:         c.Grace,
+		Timeout:       c.Timeout,
+		DisableLog:    c.DisableLog,
+		HTTP2:         c.HTTP.HTTP2,
+		UpstreamHTTP2: c.HTTP.UpstreamHTTP2,
+		WebSocket:     c.HTTP.WebSocket,
+		FlushInterval: c.HTTP.FlushInterval,
+		IdleTimeout:   c.HTTP.IdleTimeout,
+		RewriteWSDL:   c.HTTP.Kind == "soap",
+	}
+	if c.HTTP.PublicURL != "" {
+		pu, err := url.Parse(c.HTTP.PublicURL)
+		if err != nil || !pu.IsAbs() {
+			return nil, fmt.Errorf("invalid public URL %s of service %s", c.HTTP.PublicURL, c.Name)
+		}
+		h.PublicURL = pu
 	}
 	if !h.DisableLog {
+		h.Logger = l
 		h.Middlewares = append(h.Middlewares, middleware.RequestID(), mw.LogrusWithConfig(mw.LogrusConfig{Logger: l}))
+		// Bodies of token endpoint carry tokens and are not logged
+		h.TokenMiddlewares = h.Middlewares
 	}
 	if !h.DisableLog && c.HTTP.LogBody {
-		h.Middlewares = append(h.Middlewares, middleware.BodyDump(mw.LogrusBodyLogger(l)))
+		h.Middlewares = append(h.Middlewares, mw.BodyLogWithConfig(mw.BodyLogConfig{
+			// WebSocket messages are logged by relay
+			Skipper: func(c echo.Context) bool { return isWebSocketUpgrade(c.Request()) },
+			Limit:   c.HTTP.LogBodyLimit,
+			Handler: mw.LogrusBodyLogger(l),
+		}))
+	}
+	if h.WebSocket.LogMessages && h.WebSocket.LogMessageLimit == 0 {
+		h.WebSocket.LogMessageLimit = 20000 // Same limit as for logged bodies
 	}
 	if h.Grace == 0 {
 		h.Grace = 5 * time.Second // Set default grace period for shutdown
@@ -49,23 +76,60 @@ func buildHTTPProxy(c nprxy.ServiceConfig) (nprxy.Proxy, error) {
 	if h.Timeout == 0 {
 		h.Timeout = 5 * time.Second // Set default timeout
 	}
+	if c.HTTP.Kind == "grpc" {
+		// gRPC requires HTTP/2 on both sides, streams messages and reports errors as gRPC status
+		if !c.HTTP.HTTP2.Enabled && !c.HTTP.HTTP2.Cleartext {
+			return nil, fmt.Errorf("grpc service %s requires HTTP/2 from clients", c.Name)
+		}
+		// Without TLS HTTP/2 is used only with prior knowledge, ALPN of Enabled needs https upstream
+		up := c.HTTP.UpstreamHTTP2
+		if !up.Cleartext && (!up.Enabled || u.Scheme != "https") {
+			return nil, fmt.Errorf("grpc service %s requires HTTP/2 to upstream: upstreamHTTP2.cleartext, or enabled with https upstream", c.Name)
+		}
+		if h.FlushInterval == 0 {
+			h.FlushInterval = -1
+		}
+	}
+	format := c.HTTP.Errors
+	if format == "" {
+		format = kindErrors[c.HTTP.Kind]
+	}
+	if format == "" {
+		format = "json"
+	}
+	errorHandler, ok := errorHandlers[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported errors format %s of service %s", format, c.Name)
+	}
+	h.ErrorHandler = errorHandler
+	if c.HTTP.Kind == "soap" {
+		// Guard runs first, so nothing parses hostile documents before it
+		guard, err := buildXMLGuard(c.HTTP.KindParams)
+		if err != nil {
+			return nil, err
+		}
+		h.Middlewares = append(h.Middlewares, guard)
+	}
 	if c.HTTP.Kind != "" {
-		h.Middlewares = append(h.Middlewares, mw.OperationResolver(c.HTTP.Kind))
+		resolver, err := buildOperationResolver(c.HTTP.Kind, c.HTTP.KindParams)
+		if err != nil {
+			return nil, err
+		}
+		h.Middlewares = append(h.Middlewares, resolver)
 	}
-	if c.HTTP.Authn != nil {
-		if c.HTTP.Authn.Kind == "api-key" {
-			yamlFile, err := ioutil.ReadFile(c.HTTP.Authn.Params["path"].(string))
-			if err != nil {
-				panic(fmt.Errorf("yamlFile.Get err   #%v ", err))
-			}
-			keys := map[string]string{}
-			err = yaml.Unmarshal(yamlFile, keys)
-			if err != nil {
-				panic(fmt.Errorf("Unmarshal keys: %v", err))
-			}
-
-			h.Middlewares = append(h.Middlewares, mw.BCryptAPIKey(keys))
+	authn, token, err := buildAuthn(c.Name, c.Listen, c.HTTP)
+	if err != nil {
+		return nil, fmt.Errorf("authn of service %s: %v", c.Name, err)
+	}
+	if authn != nil {
+		h.Middlewares = append(h.Middlewares, authn)
+	}
+	if token != nil {
+		h.TokenPath = c.HTTP.Token.Path
+		if h.TokenPath == "" {
+			h.TokenPath = "/.nprxy/token"
 		}
+		h.TokenHandler = token
 	}
 	if c.HTTP.Authz != nil {
 		if c.HTTP.Authz.Kind == "casbin" {
@@ -74,12 +138,232 @@ func buildHTTPProxy(c nprxy.ServiceConfig) (nprxy.Proxy, error) {
 			for _, v := range c.HTTP.Authz.Params["parameters"].([]interface{}) {
 				p = append(p, mw.ValueFromContext(v.(string)))
 			}
-			h.Middlewares = append(h.Middlewares, mw.CasbinEnforcer(ce, p...))
+			config := mw.CasbinEnforcerConfig{Enforcer: ce, ParamGetters: p}
+			if c.HTTP.Kind == "soap" {
+				config.Skipper = skipWSDL
+			}
+			h.Middlewares = append(h.Middlewares, mw.CasbinEnforcerWithConfig(config))
 		}
 	}
+	if c.HTTP.Kind == "soap" {
+		// Validation runs after authorization, so only authorized clients learn about schema of service
+		validator, err := buildSOAPValidator(c.HTTP.KindParams, l)
+		if err != nil {
+			return nil, err
+		}
+		if validator != nil {
+			h.Middlewares = append(h.Middlewares, validator)
+		}
+	}
+	if c.HTTP.Kind == "rest" {
+		guard, err := buildJSONGuard(c.HTTP.KindParams)
+		if err != nil {
+			return nil, err
+		}
+		h.Middlewares = append(h.Middlewares, guard)
+	}
 	return h, nil
 }
 
+// errorHandlers renderers of errors generated by proxy by format, nil is echo default JSON
+var errorHandlers = map[string]echo.HTTPErrorHandler{
+	"json":    nil,
+	"soap":    mw.SOAPFaultHandler,
+	"problem": mw.ProblemJSONHandler,
+	"grpc":    mw.GRPCErrorHandler,
+	"graphql": mw.GraphQLErrorHandler,
+}
+
+// kindErrors default errors format of service kind, other kinds use json
+var kindErrors = map[string]string{
+	"soap":    "soap",
+	"grpc":    "grpc",
+	"graphql": "graphql",
+}
+
+// skipWSDL skips middlewares of SOAP requests for WSDL and XSD requests, that carry neither envelope nor operation
+func skipWSDL(c echo.Context) bool {
+	return isWSDLRequest(c.Request())
+}
+
+// buildOperationResolver creates OperationResolver middleware of kind with parameters
+func buildOperationResolver(kind string, params map[string]interface{}) (echo.MiddlewareFunc, error) {
+	c := mw.OperationResolverConfig{Kind: kind}
+	if kind == "soap" {
+		c.Skipper = skipWSDL
+	}
+
+	var err error
+	if c.SOAPSources, err = paramStrings(params, "sources"); err != nil {
+		return nil, err
+	}
+	if c.MaxEnvelopeBytes, err = paramInt(params, "maxEnvelopeBytes"); err != nil {
+		return nil, err
+	}
+	if c.MaxBodyBytes, err = paramInt(params, "maxBodyBytes"); err != nil {
+		return nil, err
+	}
+	if kind == "rest" {
+		if c.Routes, err = buildRESTRoutes(params); err != nil {
+			return nil, err
+		}
+	}
+	limits := map[string]*int{"maxDepth": &c.MaxDepth, "maxAliases": &c.MaxAliases, "maxComplexity": &c.MaxComplexity}
+	for k, v := range limits {
+		i, err := paramInt(params, k)
+		if err != nil {
+			return nil, err
+		}
+		*v = int(i)
+	}
+
+	return mw.OperationResolverWithConfig(c), nil
+}
+
+// buildXMLGuard creates XMLGuard middleware with limits of parameters, unset limits use defaults
+func buildXMLGuard(params map[string]interface{}) (echo.MiddlewareFunc, error) {
+	c := mw.XMLGuardConfig{Skipper: skipWSDL}
+
+	var err error
+	if c.MaxBytes, err = paramInt(params, "maxXMLBytes"); err != nil {
+		return nil, err
+	}
+	limits := map[string]*int{
+		"maxXMLDepth":      &c.MaxDepth,
+		"maxXMLAttributes": &c.MaxAttributes,
+		"maxXMLNamespaces": &c.MaxNamespaces,
+		"maxXMLTextBytes":  &c.MaxTextBytes,
+	}
+	for k, v := range limits {
+		i, err := paramInt(params, k)
+		if err != nil {
+			return nil, err
+		}
+		*v = int(i)
+	}
+	return mw.XMLGuardWithConfig(c), nil
+}
+
+// buildJSONGuard creates JSONGuard middleware with limits of parameters and schemas of operations
+// listed as "operation path" and of request bodies of OpenAPI document
+func buildJSONGuard(params map[string]interface{}) (echo.MiddlewareFunc, error) {
+	c := mw.JSONGuardConfig{Schemas: map[string]*jsonschema.Schema{}}
+
+	var err error
+	if c.MaxBytes, err = paramInt(params, "maxJSONBytes"); err != nil {
+		return nil, err
+	}
+	limits := map[string]*int{
+		"maxJSONDepth":        &c.MaxDepth,
+		"maxJSONArrayLength":  &c.MaxArrayLength,
+		"maxJSONStringLength": &c.MaxStringLength,
+		"maxJSONKeys":         &c.MaxKeys,
+	}
+	for k, v := range limits {
+		i, err := paramInt(params, k)
+		if err != nil {
+			return nil, err
+		}
+		*v = int(i)
+	}
+
+	if c.UncheckedMediaTypes, err = paramStrings(params, "uncheckedContentTypes"); err != nil {
+		return nil, err
+	}
+
+	path, err := paramString(params, "openapi")
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		doc, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if c.Schemas, err = mw.OpenAPISchemas(doc); err != nil {
+			return nil, fmt.Errorf("failed to load schemas of OpenAPI document %s: %v", path, err)
+		}
+	}
+
+	schemas, err := paramStrings(params, "schemas")
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range schemas {
+		f := strings.Fields(s)
+		if len(f) != 2 {
+			return nil, fmt.Errorf("schema %q must be in form: operation path", s)
+		}
+		doc, err := ioutil.ReadFile(f[1])
+		if err != nil {
+			return nil, err
+		}
+		if c.Schemas[f[0]], err = jsonschema.Parse(doc); err != nil {
+			return nil, fmt.Errorf("failed to load schema %s: %v", f[1], err)
+		}
+	}
+	return mw.JSONGuardWithConfig(c), nil
+}
+
+// buildSOAPValidator creates SOAPValidator middleware with schemas of WSDL, if wsdl parameter is set
+func buildSOAPValidator(params map[string]interface{}, l logrus.FieldLogger) (echo.MiddlewareFunc, error) {
+	location, err := paramString(params, "wsdl")
+	if err != nil || location == "" {
+		return nil, err
+	}
+	c := mw.SOAPValidatorConfig{Skipper: skipWSDL, Logger: l}
+	if c.Responses, err = paramString(params, "responseValidation"); err != nil {
+		return nil, err
+	}
+	if c.Responses != "" && c.Responses != "log" && c.Responses != "enforce" {
+		return nil, fmt.Errorf("unsupported response validation mode %s", c.Responses)
+	}
+	if c.MaxBodyBytes, err = paramInt(params, "maxBodyBytes"); err != nil {
+		return nil, err
+	}
+	if c.Definitions, err = wsdl.Load(location); err != nil {
+		return nil, fmt.Errorf("failed to load WSDL %s: %v", location, err)
+	}
+	return mw.SOAPValidatorWithConfig(c), nil
+}
+
+// buildRESTRoutes reads routes listed in parameters and routes of OpenAPI document
+func buildRESTRoutes(params map[string]interface{}) ([]mw.RESTRoute, error) {
+	var routes []mw.RESTRoute
+	rs, err := paramStrings(params, "routes")
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rs {
+		route, err := mw.ParseRESTRoute(r)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+
+	path, err := paramString(params, "openapi")
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		doc, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		r, err := mw.OpenAPIRoutes(doc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load OpenAPI document %s: %v", path, err)
+		}
+		routes = append(routes, r...)
+	}
+
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("rest operation resolver requires routes or openapi parameter")
+	}
+	return routes, nil
+}
+
 // httpProxy forwards HTTP requests to upstream service
 type httpProxy struct {
 	Upstream    *url.URL
@@ -87,40 +371,92 @@ type httpProxy struct {
 	Timeout     time.Duration
 	Middlewares []echo.MiddlewareFunc
 	DisableLog  bool
+
+	HTTP2         nprxy.HTTP2Config
+	UpstreamHTTP2 nprxy.HTTP2Config
+
+	// FlushInterval of response body to client, negative value flushes after each write
+	FlushInterval time.Duration
+	// IdleTimeout max time without reading data from client or upstream, zero disables timeout
+	IdleTimeout time.Duration
+	// ErrorHandler renders errors of middlewares, echo default handler is used if nil
+	ErrorHandler echo.HTTPErrorHandler
+	// RewriteWSDL rewrites endpoint addresses and imports of WSDL and XSD responses to public URL
+	RewriteWSDL bool
+	// PublicURL of proxy, built from request if nil
+	PublicURL *url.URL
+
+	WebSocket nprxy.WebSocketConfig
+	// TokenPath of endpoint served by TokenHandler with TokenMiddlewares, instead of proxying
+	TokenPath        string
+	TokenHandler     echo.HandlerFunc
+	TokenMiddlewares []echo.MiddlewareFunc
+	// Logger for events outside of request logging, nil disables logging
+	Logger logrus.FieldLogger
 }
 
 // Serve starts http server on listener, that uses connection from DialUpstream func to connect to upstream service and routes requests and response to and from upstream service
 func (h *httpProxy) Serve(ctx context.Context, Listener net.Listener, DialUpstream nprxy.DialUpstream) error {
 	r := httputil.NewSingleHostReverseProxy(h.Upstream)
+	// DialTLS is not set: DialUpstream returns plain connection, so transport dials TLS itself and negotiates
+	// HTTP/2 with ALPN
 	t := &gohttp.Transport{
-		Dial:    DialUpstream,
-		DialTLS: DialUpstream,
-		Proxy:   gohttp.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
+		Dial:  DialUpstream,
+		Proxy: gohttp.ProxyFromEnvironment,
+		DialContext: idleDialContext((&net.Dialer{
 			Timeout:   h.Timeout,
 			KeepAlive: 30 * time.Second,
 			DualStack: true,
-		}).DialContext,
+		}).DialContext, h.IdleTimeout),
 		MaxIdleConns:          100,
 		IdleConnTimeout:       90 * time.Second,
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
+		Protocols:             upstreamProtocols(h.UpstreamHTTP2),
+		HTTP2:                 http2Config(h.UpstreamHTTP2),
 	}
 	r.Transport = t
+	r.FlushInterval = h.FlushInterval
+	if h.RewriteWSDL {
+		r.ModifyResponse = h.rewriteWSDL
+	}
 
 	rewriteHost := func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
+			if h.RewriteWSDL && isWSDLRequest(c.Request()) {
+				c.SetRequest(h.withPublicURL(c.Request()))
+			}
 			c.Request().Host = h.Upstream.Host
 			return next(c)
 		}
 	}
 
 	e := echo.New()
+	if h.ErrorHandler != nil {
+		e.HTTPErrorHandler = h.ErrorHandler
+	}
+	handler := h.proxyHandler(r)
+	if h.WebSocket.Enabled {
+		proxy := handler
+		handler = func(c echo.Context) error {
+			if isWebSocketUpgrade(c.Request()) {
+				return h.serveWebSocket(c, DialUpstream)
+			}
+			return proxy(c)
+		}
+	}
+
+	if h.TokenHandler != nil {
+		e.POST(h.TokenPath, h.TokenHandler, append(h.TokenMiddlewares[:len(h.TokenMiddlewares):len(h.TokenMiddlewares)], middleware.Secure())...)
+	}
 	mws := append(h.Middlewares, middleware.Secure(), rewriteHost)
-	e.Any("/*", echo.WrapHandler(r), mws...)
+	e.Any("/*", handler, mws...)
 
 	s := gohttp.Server{
-		Handler: e,
+		Handler:           idleBodyHandler(e, h.IdleTimeout),
+		ReadHeaderTimeout: h.IdleTimeout,
+		Protocols:         serverProtocols(h.HTTP2),
+		HTTP2:             http2Config(h.HTTP2),
 	}
 
 	go func() {
@@ -133,3 +469,67 @@ func (h *httpProxy) Serve(ctx context.Context, Listener net.Listener, DialUpstre
 
 	return s.Serve(Listener)
 }
+
+// upstreamErrorKey request context key of slot for error of reverse proxy
+type upstreamErrorKey struct{}
+
+// proxyHandler serves request with reverse proxy. Failures to reach upstream are returned as echo errors,
+// so they are rendered by error handler of service as any other error generated by proxy.
+func (h *httpProxy) proxyHandler(r *httputil.ReverseProxy) echo.HandlerFunc {
+	r.ErrorHandler = func(w gohttp.ResponseWriter, req *gohttp.Request, err error) {
+		if p, ok := req.Context().Value(upstreamErrorKey{}).(*error); ok {
+			*p = err
+		}
+	}
+
+	return func(c echo.Context) error {
+		var err error
+		req := c.Request()
+		r.ServeHTTP(c.Response(), req.WithContext(context.WithValue(req.Context(), upstreamErrorKey{}, &err)))
+		if err == nil {
+			return nil
+		}
+
+		if h.Logger != nil {
+			h.Logger.WithError(err).Warn("Upstream request failed")
+		}
+		var ne net.Error
+		if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+			return echo.NewHTTPError(gohttp.StatusGatewayTimeout)
+		}
+		return echo.NewHTTPError(gohttp.StatusBadGateway)
+	}
+}
+
+// serverProtocols returns protocols accepted from clients
+func serverProtocols(c nprxy.HTTP2Config) *gohttp.Protocols {
+	p := &gohttp.Protocols{}
+	p.SetHTTP1(true)
+	p.SetHTTP2(c.Enabled)
+	p.SetUnencryptedHTTP2(c.Cleartext)
+	return p
+}
+
+// upstreamProtocols returns protocols used to connect to upstream. Cleartext HTTP/2 uses prior knowledge,
+// so HTTP/1 is disabled and upstream is expected to speak only HTTP/2.
+func upstreamProtocols(c nprxy.HTTP2Config) *gohttp.Protocols {
+	p := &gohttp.Protocols{}
+	p.SetHTTP1(!c.Cleartext)
+	p.SetHTTP2(c.Enabled || c.Cleartext)
+	p.SetUnencryptedHTTP2(c.Cleartext)
+	return p
+}
+
+// defaultMaxConcurrentStreams limits streams per client connection if not configured
+const defaultMaxConcurrentStreams = 100
+
+func http2Config(c nprxy.HTTP2Config) *gohttp.HTTP2Config {
+	if c.MaxConcurrentStreams == 0 {
+		c.MaxConcurrentStreams = defaultMaxConcurrentStreams
+	}
+	return &gohttp.HTTP2Config{
+		MaxConcurrentStreams:          c.MaxConcurrentStreams,
+		MaxReceiveBufferPerConnection: c.ConnectionWindow,
+		MaxReceiveBufferPerStream:     c.StreamWindow,
+	}
+}
@@ -1,16 +1,25 @@
 package http
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"io"
 	"io/ioutil"
 	"net"
 	gohttp "net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/artyomturkin/nprxy"
+	"github.com/artyomturkin/nprxy/middleware"
+	"github.com/labstack/echo"
 )
 
 func TestHTTPProxy(t *testing.T) {
@@ -93,3 +102,782 @@ func BenchmarkHTTPProxy(b *testing.B) {
 	cancel()
 	wg.Wait()
 }
+
+func TestHTTPProxyH2C(t *testing.T) {
+	handler := func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		io.WriteString(w, r.Proto)
+	}
+	ts := httptest.NewUnstartedServer(gohttp.HandlerFunc(handler))
+	ts.Config.Protocols = &gohttp.Protocols{}
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	defer ts.Close()
+
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	pu := "http://" + l.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	u, _ := url.Parse(ts.URL)
+
+	p := &httpProxy{
+		Upstream:      u,
+		Grace:         time.Second * 30,
+		DisableLog:    true,
+		HTTP2:         nprxy.HTTP2Config{Cleartext: true, MaxConcurrentStreams: 10},
+		UpstreamHTTP2: nprxy.HTTP2Config{Cleartext: true},
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	var err error
+	go func() {
+		err = p.Serve(ctx, l, net.Dial)
+		wg.Done()
+	}()
+
+	protocols := &gohttp.Protocols{}
+	protocols.SetUnencryptedHTTP2(true)
+	client := &gohttp.Client{Transport: &gohttp.Transport{Protocols: protocols}}
+
+	resp, rerr := client.Get(pu + "/api")
+	if rerr != nil {
+		t.Fatalf("request failed: %v", rerr)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	cancel()
+
+	if resp.ProtoMajor != 2 {
+		t.Errorf("Wrong client protocol: %s, expected HTTP/2.0", resp.Proto)
+	}
+	if string(body) != "HTTP/2.0" {
+		t.Errorf("Wrong upstream protocol: %s, expected: HTTP/2.0", string(body))
+	}
+
+	wg.Wait()
+	if err != gohttp.ErrServerClosed {
+		t.Errorf("Serve failed: %v", err)
+	}
+}
+
+func TestHTTPProxyGRPC(t *testing.T) {
+	handler := func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.WriteHeader(200)
+
+		// Echo request messages back as they arrive
+		buf := make([]byte, 5)
+		for {
+			n, err := io.ReadFull(r.Body, buf)
+			if n > 0 {
+				w.Write(buf[:n])
+				w.(gohttp.Flusher).Flush()
+			}
+			if err != nil {
+				break
+			}
+		}
+		w.Header().Set("Grpc-Status", "0")
+	}
+	ts := httptest.NewUnstartedServer(gohttp.HandlerFunc(handler))
+	ts.Config.Protocols = &gohttp.Protocols{}
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	defer ts.Close()
+
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	pu := "http://" + l.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	u, _ := url.Parse(ts.URL)
+
+	deny := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if c.Get("operation") == "/helloworld.Greeter/Denied" {
+				return echo.ErrForbidden
+			}
+			return next(c)
+		}
+	}
+
+	p := &httpProxy{
+		Upstream:      u,
+		Grace:         time.Second * 30,
+		DisableLog:    true,
+		HTTP2:         nprxy.HTTP2Config{Cleartext: true},
+		UpstreamHTTP2: nprxy.HTTP2Config{Cleartext: true},
+		FlushInterval: -1,
+		ErrorHandler:  mw.GRPCErrorHandler,
+		Middlewares:   []echo.MiddlewareFunc{mw.OperationResolver("grpc"), deny},
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	var err error
+	go func() {
+		err = p.Serve(ctx, l, net.Dial)
+		wg.Done()
+	}()
+
+	protocols := &gohttp.Protocols{}
+	protocols.SetUnencryptedHTTP2(true)
+	client := &gohttp.Client{Transport: &gohttp.Transport{Protocols: protocols}}
+
+	// Stream: response message must arrive before request body is closed
+	pr, pw := io.Pipe()
+	req, _ := gohttp.NewRequest("POST", pu+"/helloworld.Greeter/SayHello", pr)
+	req.Header.Set("Content-Type", "application/grpc")
+	go pw.Write([]byte("hello"))
+
+	resp, rerr := client.Do(req)
+	if rerr != nil {
+		t.Fatalf("request failed: %v", rerr)
+	}
+	msg := make([]byte, 5)
+	if _, rerr := io.ReadFull(resp.Body, msg); rerr != nil || string(msg) != "hello" {
+		t.Errorf("Wrong streamed message: %s, %v", string(msg), rerr)
+	}
+	pw.Close()
+	ioutil.ReadAll(resp.Body)
+	if s := resp.Trailer.Get("Grpc-Status"); s != "0" {
+		t.Errorf("Wrong grpc-status trailer: '%s', expected 0", s)
+	}
+
+	// Denied method gets gRPC status instead of HTTP 403
+	req, _ = gohttp.NewRequest("POST", pu+"/helloworld.Greeter/Denied", nil)
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, rerr = client.Do(req)
+	if rerr != nil {
+		t.Fatalf("request failed: %v", rerr)
+	}
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		t.Errorf("Wrong status code: %d, expected 200", resp.StatusCode)
+	}
+	if s := resp.Header.Get("Grpc-Status"); s != "7" {
+		t.Errorf("Wrong grpc-status: '%s', expected 7", s)
+	}
+
+	cancel()
+	wg.Wait()
+	if err != gohttp.ErrServerClosed {
+		t.Errorf("Serve failed: %v", err)
+	}
+}
+
+// writeTestFrame writes single frame WebSocket message, masked as client would send it
+func writeTestFrame(w io.Writer, opcode byte, payload []byte, masked bool) {
+	h := []byte{0x80 | opcode, byte(len(payload))}
+	if masked {
+		mask := []byte{1, 2, 3, 4}
+		h[1] |= 0x80
+		h = append(h, mask...)
+		payload = unmaskWS(payload, mask, 0)
+	}
+	w.Write(append(h, payload...))
+}
+
+// readTestFrame reads frame and returns its opcode and unmasked payload
+func readTestFrame(r io.Reader) (byte, []byte, error) {
+	f, err := readWSFrame(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	p := make([]byte, f.length)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return 0, nil, err
+	}
+	return f.opcode, unmaskWS(p, f.mask, 0), nil
+}
+
+func TestBuildHTTPProxyGRPC(t *testing.T) {
+	type testCase struct {
+		name     string
+		upstream string
+		http2    nprxy.HTTP2Config
+		valid    bool
+	}
+
+	cases := []testCase{
+		testCase{name: "cleartext", upstream: "http://localhost:50052", http2: nprxy.HTTP2Config{Cleartext: true}, valid: true},
+		testCase{name: "tls", upstream: "https://localhost:50052", http2: nprxy.HTTP2Config{Enabled: true}, valid: true},
+		testCase{name: "cleartext over tls", upstream: "https://localhost:50052", http2: nprxy.HTTP2Config{Cleartext: true}, valid: true},
+		testCase{name: "enabled without tls", upstream: "http://localhost:50052", http2: nprxy.HTTP2Config{Enabled: true}},
+		testCase{name: "http/1", upstream: "https://localhost:50052"},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			_, err := buildHTTPProxy(nprxy.ServiceConfig{
+				Name:       "greeter",
+				Upstream:   cs.upstream,
+				DisableLog: true,
+				HTTP: nprxy.HTTPConfig{
+					Kind:          "grpc",
+					HTTP2:         nprxy.HTTP2Config{Cleartext: true},
+					UpstreamHTTP2: cs.http2,
+				},
+			})
+			if cs.valid != (err == nil) {
+				t.Errorf("expected valid %v, got %v", cs.valid, err)
+			}
+		})
+	}
+}
+
+func TestHTTPProxyWebSocket(t *testing.T) {
+	// Upstream echoes messages back
+	handler := func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		if !isWebSocketUpgrade(r) {
+			w.WriteHeader(400)
+			return
+		}
+		conn, rw, _ := w.(gohttp.Hijacker).Hijack()
+		defer conn.Close()
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		for {
+			op, p, err := readTestFrame(rw.Reader)
+			if err != nil || op == wsClose {
+				return
+			}
+			writeTestFrame(conn, op, p, false)
+		}
+	}
+	ts := httptest.NewServer(gohttp.HandlerFunc(handler))
+	defer ts.Close()
+
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	ctx, cancel := context.WithCancel(context.Background())
+	u, _ := url.Parse(ts.URL)
+
+	auth := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if c.Request().Header.Get("X-NPRXY-Client") == "" {
+				return echo.ErrUnauthorized
+			}
+			return next(c)
+		}
+	}
+
+	p := &httpProxy{
+		Upstream:    u,
+		Grace:       time.Second * 30,
+		Timeout:     time.Second,
+		DisableLog:  true,
+		Middlewares: []echo.MiddlewareFunc{auth},
+		WebSocket: nprxy.WebSocketConfig{
+			Enabled:      true,
+			PingInterval: 50 * time.Millisecond,
+			IdleTimeout:  time.Second,
+		},
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	var err error
+	go func() {
+		err = p.Serve(ctx, l, net.Dial)
+		wg.Done()
+	}()
+
+	upgrade := func(client string) (net.Conn, *bufio.Reader, *gohttp.Response) {
+		conn, derr := net.Dial("tcp", l.Addr().String())
+		if derr != nil {
+			t.Fatalf("dial failed: %v", derr)
+		}
+		req, _ := gohttp.NewRequest("GET", "http://"+l.Addr().String()+"/ws", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		if client != "" {
+			req.Header.Set("X-NPRXY-Client", client)
+		}
+		req.Write(conn)
+		br := bufio.NewReader(conn)
+		resp, rerr := gohttp.ReadResponse(br, req)
+		if rerr != nil {
+			t.Fatalf("upgrade failed: %v", rerr)
+		}
+		return conn, br, resp
+	}
+
+	// Upgrade is authenticated by middlewares
+	conn, _, resp := upgrade("")
+	conn.Close()
+	if resp.StatusCode != 401 {
+		t.Errorf("Wrong status code: %d, expected 401", resp.StatusCode)
+	}
+
+	conn, br, resp := upgrade("test-system")
+	defer conn.Close()
+	if resp.StatusCode != 101 {
+		t.Fatalf("Wrong status code: %d, expected 101", resp.StatusCode)
+	}
+
+	writeTestFrame(conn, wsText, []byte("hello"), true)
+	pinged := false
+	for {
+		op, payload, rerr := readTestFrame(br)
+		if rerr != nil {
+			t.Fatalf("read failed: %v", rerr)
+		}
+		if op == wsPing {
+			pinged = true
+			writeTestFrame(conn, wsPong, payload, true)
+			continue
+		}
+		if op != wsText || string(payload) != "hello" {
+			t.Errorf("Wrong message: %d %s, expected echoed hello", op, string(payload))
+		}
+		break
+	}
+
+	// Wait for ping, when connection is quiet
+	if !pinged {
+		op, payload, rerr := readTestFrame(br)
+		if rerr != nil || op != wsPing {
+			t.Errorf("Expected ping, got: %d, %v", op, rerr)
+		}
+		writeTestFrame(conn, wsPong, payload, true)
+	}
+
+	cancel()
+	wg.Wait()
+	if err != gohttp.ErrServerClosed {
+		t.Errorf("Serve failed: %v", err)
+	}
+}
+
+func TestHTTPProxyWebSocketUpgradeTimeout(t *testing.T) {
+	// Upstream accepts connections and never answers upgrade
+	ul, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer ul.Close()
+	go func() {
+		for {
+			conn, err := ul.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	u, _ := url.Parse("http://" + ul.Addr().String())
+
+	p := &httpProxy{
+		Upstream:   u,
+		Grace:      time.Second,
+		Timeout:    100 * time.Millisecond,
+		DisableLog: true,
+		WebSocket:  nprxy.WebSocketConfig{Enabled: true},
+	}
+	go p.Serve(ctx, l, net.Dial)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: proxy\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+
+	resp, err := gohttp.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("expected proxy to answer after upgrade timeout: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != gohttp.StatusBadGateway {
+		t.Errorf("Wrong status code: %d, expected 502", resp.StatusCode)
+	}
+}
+
+func TestHTTPProxyWebSocketIdleTimeout(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	u, _ := net.Pipe()
+
+	r := &wsRelay{
+		config:    nprxy.WebSocketConfig{IdleTimeout: 50 * time.Millisecond},
+		client:    a,
+		clientR:   bufio.NewReader(a),
+		upstream:  u,
+		upstreamR: bufio.NewReader(u),
+	}
+
+	done := make(chan struct{})
+	go func() {
+		r.run()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("relay was not closed by idle timeout")
+	}
+	if r.reason != "idle timeout" {
+		t.Errorf("Wrong close reason: %s, expected: idle timeout", r.reason)
+	}
+}
+
+func TestHTTPProxyServerSentEvents(t *testing.T) {
+	release := make(chan struct{})
+	handler := func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: first\n\n")
+		w.(gohttp.Flusher).Flush()
+		<-release
+		io.WriteString(w, "data: second\n\n")
+	}
+	ts := httptest.NewServer(gohttp.HandlerFunc(handler))
+	defer ts.Close()
+
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	pu := "http://" + l.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	u, _ := url.Parse(ts.URL)
+
+	var logged []byte
+	p := &httpProxy{
+		Upstream:    u,
+		Grace:       time.Second * 30,
+		DisableLog:  true,
+		IdleTimeout: time.Second,
+		Middlewares: []echo.MiddlewareFunc{mw.BodyLog(5, func(c echo.Context, reqB []byte, resB []byte) {
+			logged = resB
+		})},
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	var err error
+	go func() {
+		err = p.Serve(ctx, l, net.Dial)
+		wg.Done()
+	}()
+
+	resp, rerr := gohttp.Get(pu + "/events")
+	if rerr != nil {
+		t.Fatalf("request failed: %v", rerr)
+	}
+	br := bufio.NewReader(resp.Body)
+	if line, _ := br.ReadString('\n'); line != "data: first\n" {
+		t.Errorf("Wrong first event: %s, expected it before upstream finished", line)
+	}
+	close(release)
+	rest, _ := ioutil.ReadAll(br)
+	if string(rest) != "\ndata: second\n\n" {
+		t.Errorf("Wrong rest of stream: %q", string(rest))
+	}
+
+	cancel()
+	wg.Wait()
+	if err != gohttp.ErrServerClosed {
+		t.Errorf("Serve failed: %v", err)
+	}
+	if string(logged) != "data:" {
+		t.Errorf("Wrong logged body: %q, expected first 5 bytes", string(logged))
+	}
+}
+
+func TestHTTPProxyIdleTimeout(t *testing.T) {
+	release := make(chan struct{})
+	handler := func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		io.WriteString(w, "start")
+		w.(gohttp.Flusher).Flush()
+		<-release
+		io.WriteString(w, "end")
+	}
+	ts := httptest.NewServer(gohttp.HandlerFunc(handler))
+	defer ts.Close()
+	defer close(release)
+
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	pu := "http://" + l.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	u, _ := url.Parse(ts.URL)
+
+	p := &httpProxy{
+		Upstream:    u,
+		Grace:       time.Second,
+		DisableLog:  true,
+		IdleTimeout: 100 * time.Millisecond,
+	}
+	go p.Serve(ctx, l, net.Dial)
+
+	resp, rerr := gohttp.Get(pu + "/download")
+	if rerr != nil {
+		t.Fatalf("request failed: %v", rerr)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "start" {
+		t.Errorf("Wrong body: %s, expected download to be cut after idle timeout", string(body))
+	}
+}
+
+func TestHTTPProxyUpstreamErrors(t *testing.T) {
+	handler := func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		time.Sleep(time.Second)
+	}
+	ts := httptest.NewServer(gohttp.HandlerFunc(handler))
+	defer ts.Close()
+
+	// Address, that refuses connections
+	cl, _ := net.Listen("tcp", "127.0.0.1:0")
+	cl.Close()
+
+	type testCase struct {
+		name         string
+		upstream     string
+		errorHandler echo.HTTPErrorHandler
+		contentType  string
+		status       int
+		body         string
+	}
+
+	cases := []testCase{
+		testCase{name: "refused soap 1.2", upstream: "http://" + cl.Addr().String(), errorHandler: mw.SOAPFaultHandler,
+			contentType: "application/soap+xml", status: 502, body: "<env:Value>nprxy:BadGateway</env:Value>"},
+		testCase{name: "refused soap 1.1", upstream: "http://" + cl.Addr().String(), errorHandler: mw.SOAPFaultHandler,
+			contentType: "text/xml", status: 500, body: "<faultcode>soap:Server</faultcode><faultstring>Bad Gateway</faultstring>"},
+		testCase{name: "timeout problem", upstream: ts.URL, errorHandler: mw.ProblemJSONHandler,
+			contentType: "application/json", status: 504, body: `"title":"Gateway Timeout"`},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			l, _ := net.Listen("tcp", "127.0.0.1:0")
+			pu := "http://" + l.Addr().String()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			u, _ := url.Parse(cs.upstream)
+
+			p := &httpProxy{
+				Upstream:     u,
+				Grace:        time.Second,
+				Timeout:      time.Second,
+				IdleTimeout:  200 * time.Millisecond,
+				DisableLog:   true,
+				ErrorHandler: cs.errorHandler,
+			}
+			go p.Serve(ctx, l, net.Dial)
+
+			resp, err := gohttp.Post(pu+"/service", cs.contentType, strings.NewReader("<request/>"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode != cs.status {
+				t.Errorf("Wrong status code: %d, expected %d", resp.StatusCode, cs.status)
+			}
+			if !strings.Contains(string(body), cs.body) {
+				t.Errorf("Wrong body: %s, expected it to contain: %s", body, cs.body)
+			}
+		})
+	}
+}
+
+func TestHTTPProxyWSDL(t *testing.T) {
+	var upstream string
+	handler := func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		io.WriteString(w, `<wsdl:definitions xmlns:wsdl="http://schemas.xmlsoap.org/wsdl/" xmlns:soap="http://schemas.xmlsoap.org/wsdl/soap/" xmlns:xsd="http://www.w3.org/2001/XMLSchema">`+
+			`<wsdl:types><xsd:schema><xsd:import schemaLocation="`+upstream+`/ws/orders?xsd=1"/></xsd:schema></wsdl:types>`+
+			`<wsdl:service name="S"><wsdl:port name="P"><soap:address location="`+upstream+`/ws/orders"/></wsdl:port></wsdl:service>`+
+			`</wsdl:definitions>`)
+	}
+	ts := httptest.NewServer(gohttp.HandlerFunc(handler))
+	defer ts.Close()
+	upstream = ts.URL
+
+	dir, err := ioutil.TempDir("", "wsdl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keys := filepath.Join(dir, "keys.yaml")
+	ioutil.WriteFile(keys, []byte("test-system: $2a$04$Wr/aAu5Wtf8xrfhsTiiIKuQe4sqoDLBY8Sdss6D/nJ8lkG.HKpWD.\n"), 0600)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	serve := func(public bool) string {
+		p, err := buildHTTPProxy(nprxy.ServiceConfig{
+			Name:       "orders",
+			Upstream:   ts.URL + "/ws",
+			DisableLog: true,
+			HTTP: nprxy.HTTPConfig{
+				Kind:       "soap",
+				KindParams: map[string]interface{}{"publicWSDL": public},
+				Authn:      &nprxy.Parameters{Kind: "api-key", Params: map[string]interface{}{"path": keys}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		go p.Serve(ctx, l, net.Dial)
+		return "http://" + l.Addr().String()
+	}
+	pu, public := serve(false), serve(true)
+
+	get := func(u string, headers map[string]string) (*gohttp.Response, string) {
+		req, _ := gohttp.NewRequest("GET", u, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := gohttp.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, string(body)
+	}
+	apiKey := map[string]string{"X-NPRXY-Client": "test-system", "X-NPRXY-Key": "api-key-2"}
+
+	type testCase struct {
+		name    string
+		proxy   string
+		query   string
+		headers map[string]string
+	}
+
+	cases := []testCase{
+		testCase{name: "wsdl", proxy: pu, query: "?wsdl", headers: apiKey},
+		testCase{name: "xsd", proxy: pu, query: "?xsd=1", headers: apiKey},
+		testCase{name: "public wsdl", proxy: public, query: "?wsdl"},
+		testCase{name: "public xsd", proxy: public, query: "?xsd=1"},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			resp, body := get(cs.proxy+"/orders"+cs.query, cs.headers)
+			if resp.StatusCode != 200 {
+				t.Fatalf("expected 200, got %d: %s", resp.StatusCode, body)
+			}
+			if strings.Contains(body, ts.URL) {
+				t.Errorf("Upstream address left: %s", body)
+			}
+			for _, loc := range []string{`location="` + cs.proxy + `/orders"`, `schemaLocation="` + cs.proxy + `/orders?xsd=1"`} {
+				if !strings.Contains(body, loc) {
+					t.Errorf("Expected %s in %s", loc, body)
+				}
+			}
+			if resp.ContentLength != int64(len(body)) {
+				t.Errorf("Wrong content length %d, expected %d", resp.ContentLength, len(body))
+			}
+			if resp.Header.Get("Cache-Control") != "no-store" {
+				t.Errorf("Expected WSDL rewritten with Host header not to be cached, got %q", resp.Header.Get("Cache-Control"))
+			}
+		})
+	}
+
+	// WSDL of service is authenticated unless it is public
+	if resp, body := get(pu+"/orders?wsdl", nil); resp.StatusCode != 401 || strings.Contains(body, "definitions") {
+		t.Errorf("expected WSDL without credentials to be rejected, got %d %s", resp.StatusCode, body)
+	}
+	// Other requests go through SOAP middlewares
+	if resp, body := get(public+"/orders", apiKey); resp.StatusCode == 200 || strings.Contains(body, "definitions") {
+		t.Errorf("expected request without envelope to be rejected, got %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestHTTPProxyToken(t *testing.T) {
+	ts := httptest.NewServer(gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		io.WriteString(w, "upstream")
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keys := filepath.Join(dir, "keys.yaml")
+	ioutil.WriteFile(keys, []byte("test-system: $2a$04$Wr/aAu5Wtf8xrfhsTiiIKuQe4sqoDLBY8Sdss6D/nJ8lkG.HKpWD.\n"), 0600)
+
+	p, err := buildHTTPProxy(nprxy.ServiceConfig{
+		Name:       "orders",
+		Upstream:   ts.URL,
+		DisableLog: true,
+		HTTP: nprxy.HTTPConfig{
+			Authn: &nprxy.Parameters{Kind: "api-key", Params: map[string]interface{}{"path": keys}},
+			Token: nprxy.TokenConfig{Enabled: true, TTL: time.Minute},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	pu := "http://" + l.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Serve(ctx, l, net.Dial)
+
+	do := func(method, path string, headers map[string]string) (int, string) {
+		req, _ := gohttp.NewRequest(method, pu+path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := gohttp.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	apiKey := map[string]string{"X-NPRXY-Client": "test-system", "X-NPRXY-Key": "api-key-2"}
+	code, body := do("POST", "/.nprxy/token", apiKey)
+	var tr struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal([]byte(body), &tr); code != 200 || err != nil || tr.TokenType != "Bearer" || tr.ExpiresIn != 60 {
+		t.Fatalf("expected token, got %d %s", code, body)
+	}
+	bearer := map[string]string{"Authorization": "Bearer " + tr.AccessToken}
+
+	type testCase struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		result  int
+	}
+
+	cases := []testCase{
+		testCase{name: "token", method: "GET", path: "/api", headers: bearer, result: 200},
+		testCase{name: "api key", method: "GET", path: "/api", headers: apiKey, result: 200},
+		testCase{name: "tampered token", method: "GET", path: "/api", headers: map[string]string{"Authorization": "Bearer " + tr.AccessToken[:strings.LastIndex(tr.AccessToken, ".")+1] + "c2lnbmF0dXJl"}, result: 401},
+		testCase{name: "no credentials", method: "GET", path: "/api", result: 401},
+		testCase{name: "token renewal", method: "POST", path: "/.nprxy/token", headers: bearer, result: 401},
+		testCase{name: "wrong key", method: "POST", path: "/.nprxy/token", headers: map[string]string{"X-NPRXY-Client": "test-system", "X-NPRXY-Key": "api-key"}, result: 401},
+		testCase{name: "not proxied", method: "GET", path: "/.nprxy/token", headers: bearer, result: 405},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			if code, body := do(cs.method, cs.path, cs.headers); code != cs.result {
+				t.Errorf("expected %d, got %d %s", cs.result, code, body)
+			}
+		})
+	}
+
+	// issued token is rejected as soon as client is disabled in keys file
+	ioutil.WriteFile(keys, []byte("version: 2\nclients:\n  test-system:\n    disabled: true\n    keys:\n"+
+		"    - id: \"2024-06\"\n      hash: $2a$04$Wr/aAu5Wtf8xrfhsTiiIKuQe4sqoDLBY8Sdss6D/nJ8lkG.HKpWD.\n"), 0600)
+	if err := keyStores[keys].Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if code, body := do("GET", "/api", bearer); code != 401 {
+		t.Errorf("expected token of disabled client to be rejected, got %d %s", code, body)
+	}
+}
@@ -0,0 +1,106 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net"
+	gohttp "net/http"
+	"sync"
+	"time"
+)
+
+// idleDialContext wraps connections to upstream, so that reads fail when upstream sends nothing for timeout.
+// Zero timeout returns dial as is.
+func idleDialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error), timeout time.Duration) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if timeout == 0 {
+		return dial
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &idleConn{Conn: c, timeout: timeout}, nil
+	}
+}
+
+// idleConn extends read deadline on every read and write. Extending on write keeps pooled connections,
+// that wait for response in background, from timing out right after request is sent.
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(b)
+}
+
+func (c *idleConn) Write(b []byte) (int, error) {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(b)
+}
+
+// idleBodyHandler fails reads of request body, when client sends nothing for timeout. Zero timeout returns handler as is.
+func idleBodyHandler(h gohttp.Handler, timeout time.Duration) gohttp.Handler {
+	if timeout == 0 {
+		return h
+	}
+
+	return gohttp.HandlerFunc(func(w gohttp.ResponseWriter, r *gohttp.Request) {
+		if r.Body == nil || r.Body == gohttp.NoBody {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		b := &idleBody{ReadCloser: r.Body, rc: gohttp.NewResponseController(w), timeout: timeout}
+		r.Body = b
+		h.ServeHTTP(w, r)
+
+		// Connection must not be touched after handler returns
+		b.mu.Lock()
+		b.done = true
+		b.mu.Unlock()
+	})
+}
+
+// idleBody extends read deadline of client connection on every read. Deadline is cleared once body is read or closed,
+// so that server does not treat idle client as disconnected while response is streamed.
+type idleBody struct {
+	io.ReadCloser
+	rc      *gohttp.ResponseController
+	timeout time.Duration
+
+	mu   sync.Mutex
+	done bool
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	if !b.done {
+		b.rc.SetReadDeadline(time.Now().Add(b.timeout))
+	}
+	b.mu.Unlock()
+
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.clear()
+	}
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	b.clear()
+	return b.ReadCloser.Close()
+}
+
+func (b *idleBody) clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.done {
+		b.rc.SetReadDeadline(time.Time{})
+		b.done = true
+	}
+}
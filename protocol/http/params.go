@@ -0,0 +1,96 @@
+package http
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// param looks up parameter by key ignoring case, as config loaders lowercase nested keys
+func param(p map[string]interface{}, key string) (interface{}, bool) {
+	if v, ok := p[key]; ok {
+		return v, true
+	}
+	for k, v := range p {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func paramString(p map[string]interface{}, key string) (string, error) {
+	v, ok := param(p, key)
+	if !ok {
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("parameter %s must be a string", key)
+	}
+	return s, nil
+}
+
+func paramStrings(p map[string]interface{}, key string) ([]string, error) {
+	v, ok := param(p, key)
+	if !ok {
+		return nil, nil
+	}
+	switch vs := v.(type) {
+	case []string:
+		return vs, nil
+	case []interface{}:
+		var r []string
+		for _, e := range vs {
+			s, ok := e.(string)
+			if !ok {
+				return nil, fmt.Errorf("parameter %s must be a list of strings", key)
+			}
+			r = append(r, s)
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("parameter %s must be a list of strings", key)
+}
+
+func paramInt(p map[string]interface{}, key string) (int64, error) {
+	v, ok := param(p, key)
+	if !ok {
+		return 0, nil
+	}
+	switch n := v.(type) {
+	case int:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case float64:
+		return int64(n), nil
+	}
+	return 0, fmt.Errorf("parameter %s must be a number", key)
+}
+
+func paramBool(p map[string]interface{}, key string) (bool, error) {
+	v, ok := param(p, key)
+	if !ok {
+		return false, nil
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("parameter %s must be a boolean", key)
+	}
+	return b, nil
+}
+
+func paramDuration(p map[string]interface{}, key string) (time.Duration, error) {
+	v, ok := param(p, key)
+	if !ok {
+		return 0, nil
+	}
+	switch d := v.(type) {
+	case string:
+		return time.ParseDuration(d)
+	case time.Duration:
+		return d, nil
+	}
+	return 0, fmt.Errorf("parameter %s must be a duration", key)
+}
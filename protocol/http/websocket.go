@@ -0,0 +1,485 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	gohttp "net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/artyomturkin/nprxy"
+	"github.com/labstack/echo"
+)
+
+// WebSocket frame opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// wsPingPayload identifies pings sent by proxy, pongs to them are not relayed to upstream
+var wsPingPayload = []byte("nprxy")
+
+// isWebSocketUpgrade checks if request asks to switch protocol to WebSocket
+func isWebSocketUpgrade(r *gohttp.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// headerContains checks if comma separated header values contain token
+func headerContains(h gohttp.Header, name, token string) bool {
+	for _, v := range h[gohttp.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serveWebSocket forwards upgrade request to upstream and relays frames, when upstream switches protocols.
+// Other upstream responses are returned to client as is.
+func (h *httpProxy) serveWebSocket(c echo.Context, dial nprxy.DialUpstream) error {
+	req := c.Request()
+	if _, ok := c.Response().Writer.(gohttp.Hijacker); !ok {
+		return echo.NewHTTPError(gohttp.StatusBadRequest, "WebSocket upgrade is not supported on this connection")
+	}
+
+	uc, err := h.dialWebSocketUpstream(dial)
+	if err != nil {
+		return echo.NewHTTPError(gohttp.StatusBadGateway, err.Error())
+	}
+
+	outreq := req.Clone(req.Context())
+	outreq.RequestURI = ""
+	outreq.URL.Scheme = h.Upstream.Scheme
+	outreq.URL.Host = h.Upstream.Host
+	outreq.URL.Path = joinURLPath(h.Upstream.Path, req.URL.Path)
+	if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		if prior := req.Header.Get("X-Forwarded-For"); prior != "" {
+			ip = prior + ", " + ip
+		}
+		outreq.Header.Set("X-Forwarded-For", ip)
+	}
+
+	// Upstream must answer upgrade within timeout, deadline is cleared once it switches protocols
+	uc.SetDeadline(time.Now().Add(h.Timeout))
+	if err := outreq.Write(uc); err != nil {
+		uc.Close()
+		return echo.NewHTTPError(gohttp.StatusBadGateway, err.Error())
+	}
+	ur := bufio.NewReader(uc)
+	resp, err := gohttp.ReadResponse(ur, outreq)
+	if err != nil {
+		uc.Close()
+		return echo.NewHTTPError(gohttp.StatusBadGateway, err.Error())
+	}
+
+	if resp.StatusCode != gohttp.StatusSwitchingProtocols {
+		defer uc.Close()
+		defer resp.Body.Close()
+
+		for k, v := range resp.Header {
+			c.Response().Header()[k] = v
+		}
+		c.Response().WriteHeader(resp.StatusCode)
+		_, err := io.Copy(c.Response(), resp.Body)
+		return err
+	}
+
+	uc.SetDeadline(time.Time{})
+
+	cc, crw, err := c.Response().Hijack()
+	if err != nil {
+		uc.Close()
+		return err
+	}
+	c.Response().Status = resp.StatusCode
+	c.Response().Committed = true
+
+	head := fmt.Sprintf("HTTP/1.1 %s\r\n", resp.Status)
+	if _, err := io.WriteString(cc, head); err != nil {
+		cc.Close()
+		uc.Close()
+		return nil
+	}
+	resp.Header.Write(cc)
+	io.WriteString(cc, "\r\n")
+
+	r := &wsRelay{
+		config:    h.WebSocket,
+		logger:    h.Logger,
+		requestID: c.Response().Header().Get(echo.HeaderXRequestID),
+		client:    cc,
+		clientR:   crw.Reader,
+		upstream:  uc,
+		upstreamR: ur,
+	}
+	r.run()
+	c.Response().Size = r.in.bytes + r.out.bytes
+
+	return nil
+}
+
+// dialWebSocketUpstream connects to upstream, TLS is used for https upstream
+func (h *httpProxy) dialWebSocketUpstream(dial nprxy.DialUpstream) (net.Conn, error) {
+	host := h.Upstream.Hostname()
+	port := h.Upstream.Port()
+	if port == "" {
+		port = "80"
+		if h.Upstream.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	conn, err := dial("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+	if h.Upstream.Scheme != "https" {
+		return conn, nil
+	}
+
+	tc := tls.Client(conn, &tls.Config{ServerName: host})
+	tc.SetDeadline(time.Now().Add(h.Timeout))
+	if err := tc.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tc.SetDeadline(time.Time{})
+	return tc, nil
+}
+
+// joinURLPath joins upstream base path and request path with single slash
+func joinURLPath(a, b string) string {
+	switch {
+	case strings.HasSuffix(a, "/") && strings.HasPrefix(b, "/"):
+		return a + b[1:]
+	case !strings.HasSuffix(a, "/") && !strings.HasPrefix(b, "/"):
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// wsCounters counts messages and payload bytes relayed in one direction
+type wsCounters struct {
+	messages int64
+	bytes    int64
+}
+
+// wsRelay relays frames between client and upstream, counts and logs messages, enforces idle and ping timeouts
+type wsRelay struct {
+	config    nprxy.WebSocketConfig
+	logger    logrus.FieldLogger
+	requestID string
+
+	client    net.Conn
+	clientR   *bufio.Reader
+	upstream  net.Conn
+	upstreamR *bufio.Reader
+
+	// clientMu guards writes to client, that are shared by relayed frames and pings
+	clientMu   sync.Mutex
+	upstreamMu sync.Mutex
+
+	in  wsCounters
+	out wsCounters
+
+	// activity is unix time in nanoseconds of last relayed message
+	activity int64
+	// ping is unix time in nanoseconds of outstanding ping, 0 if pong was received
+	ping int64
+
+	closeOnce sync.Once
+	reason    string
+}
+
+// run relays frames until either side closes connection
+func (r *wsRelay) run() {
+	start := time.Now()
+	atomic.StoreInt64(&r.activity, start.UnixNano())
+
+	done := make(chan struct{})
+	if r.config.PingInterval > 0 {
+		go r.pingClient(done)
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		err := r.pump("in", r.clientR, r.client, r.upstream, &r.upstreamMu, &r.in)
+		r.close(err)
+		wg.Done()
+	}()
+	go func() {
+		err := r.pump("out", r.upstreamR, r.upstream, r.client, &r.clientMu, &r.out)
+		r.close(err)
+		wg.Done()
+	}()
+	wg.Wait()
+	close(done)
+
+	if r.logger != nil {
+		r.logger.WithFields(map[string]interface{}{
+			"request_id":   r.requestID,
+			"messages_in":  r.in.messages,
+			"messages_out": r.out.messages,
+			"bytes_in":     r.in.bytes,
+			"bytes_out":    r.out.bytes,
+			"duration":     time.Since(start).String(),
+			"reason":       r.reason,
+		}).Info("WebSocket closed")
+	}
+}
+
+// close closes both connections, recording reason of the first failure
+func (r *wsRelay) close(err error) {
+	r.closeOnce.Do(func() {
+		if err == nil || err == io.EOF {
+			r.reason = "closed"
+		} else {
+			r.reason = err.Error()
+		}
+		r.client.Close()
+		r.upstream.Close()
+	})
+}
+
+// pingClient sends pings to client and closes connection, if pong is not received in time
+func (r *wsRelay) pingClient(done chan struct{}) {
+	timeout := r.config.PongTimeout
+	if timeout == 0 {
+		timeout = r.config.PingInterval
+	}
+
+	t := time.NewTicker(r.config.PingInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+
+		if atomic.LoadInt64(&r.ping) != 0 {
+			continue
+		}
+		sent := time.Now().UnixNano()
+		atomic.StoreInt64(&r.ping, sent)
+
+		r.clientMu.Lock()
+		_, err := r.client.Write(append([]byte{0x80 | wsPing, byte(len(wsPingPayload))}, wsPingPayload...))
+		r.clientMu.Unlock()
+		if err != nil {
+			r.close(err)
+			return
+		}
+
+		time.AfterFunc(timeout, func() {
+			if atomic.LoadInt64(&r.ping) == sent {
+				r.close(fmt.Errorf("pong timeout"))
+			}
+		})
+	}
+}
+
+// pump relays frames from src to dst. Data frames are streamed without buffering, control frames are relayed whole.
+func (r *wsRelay) pump(dir string, src *bufio.Reader, srcConn net.Conn, dst net.Conn, dstMu *sync.Mutex, cnt *wsCounters) error {
+	var (
+		opcode byte
+		size   int64
+		logged []byte
+	)
+
+	for {
+		if err := r.waitFrame(src, srcConn); err != nil {
+			return err
+		}
+		f, err := readWSFrame(src)
+		if err != nil {
+			return err
+		}
+
+		if f.opcode >= wsClose {
+			payload := make([]byte, f.length)
+			if _, err := io.ReadFull(src, payload); err != nil {
+				return err
+			}
+			if f.opcode == wsPong && dir == "in" && bytes.Equal(unmaskWS(payload, f.mask, 0), wsPingPayload) {
+				atomic.StoreInt64(&r.ping, 0)
+				continue
+			}
+
+			dstMu.Lock()
+			_, err := dst.Write(append(f.header, payload...))
+			dstMu.Unlock()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if f.opcode != wsContinuation {
+			opcode, size, logged = f.opcode, 0, logged[:0]
+		}
+
+		var body io.Reader = io.LimitReader(src, f.length)
+		if r.logger != nil && r.config.LogMessages {
+			body = io.TeeReader(body, &wsMessageLog{buf: &logged, limit: r.config.LogMessageLimit, mask: f.mask})
+		}
+
+		dstMu.Lock()
+		_, err = dst.Write(f.header)
+		if err == nil {
+			_, err = io.CopyN(dst, body, f.length)
+		}
+		dstMu.Unlock()
+		if err != nil {
+			return err
+		}
+
+		size += f.length
+		cnt.bytes += f.length
+		atomic.StoreInt64(&r.activity, time.Now().UnixNano())
+
+		if !f.fin {
+			continue
+		}
+		cnt.messages++
+		if r.logger != nil && r.config.LogMessages {
+			kind := "binary"
+			if opcode == wsText {
+				kind = "text"
+			}
+			r.logger.WithFields(map[string]interface{}{
+				"request_id": r.requestID,
+				"direction":  dir,
+				"kind":       kind,
+				"size":       size,
+				"body":       string(logged),
+			}).Info("WebSocket message")
+		}
+	}
+}
+
+// waitFrame blocks until next frame starts arriving or connection is idle for too long
+func (r *wsRelay) waitFrame(src *bufio.Reader, srcConn net.Conn) error {
+	if r.config.IdleTimeout == 0 {
+		_, err := src.Peek(1)
+		return err
+	}
+
+	for {
+		last := time.Unix(0, atomic.LoadInt64(&r.activity))
+		if time.Since(last) >= r.config.IdleTimeout {
+			return fmt.Errorf("idle timeout")
+		}
+
+		srcConn.SetReadDeadline(last.Add(r.config.IdleTimeout))
+		_, err := src.Peek(1)
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			// Other direction may have relayed messages meanwhile
+			continue
+		}
+		srcConn.SetReadDeadline(time.Time{})
+		return err
+	}
+}
+
+// wsFrame header of WebSocket frame
+type wsFrame struct {
+	header []byte
+	fin    bool
+	opcode byte
+	mask   []byte
+	length int64
+}
+
+// readWSFrame reads frame header, leaving payload in reader
+func readWSFrame(r io.Reader) (*wsFrame, error) {
+	h := make([]byte, 2, 14)
+	if _, err := io.ReadFull(r, h); err != nil {
+		return nil, err
+	}
+
+	f := &wsFrame{fin: h[0]&0x80 != 0, opcode: h[0] & 0x0f}
+	n := 0
+	switch h[1] & 0x7f {
+	case 126:
+		n = 2
+	case 127:
+		n = 8
+	default:
+		f.length = int64(h[1] & 0x7f)
+	}
+	if h[1]&0x80 != 0 {
+		n += 4
+	}
+
+	h = h[:2+n]
+	if _, err := io.ReadFull(r, h[2:]); err != nil {
+		return nil, err
+	}
+
+	ext := h[2:]
+	switch h[1] & 0x7f {
+	case 126:
+		f.length = int64(binary.BigEndian.Uint16(ext))
+		ext = ext[2:]
+	case 127:
+		f.length = int64(binary.BigEndian.Uint64(ext) & (1<<63 - 1))
+		ext = ext[8:]
+	}
+	if h[1]&0x80 != 0 {
+		f.mask = ext[:4]
+	}
+	if f.opcode >= wsClose && f.length > 125 {
+		return nil, fmt.Errorf("control frame too long")
+	}
+
+	f.header = h
+	return f, nil
+}
+
+// unmaskWS returns unmasked copy of payload starting at offset pos of frame payload
+func unmaskWS(p []byte, mask []byte, pos int64) []byte {
+	if mask == nil {
+		return p
+	}
+	b := make([]byte, len(p))
+	for i := range p {
+		b[i] = p[i] ^ mask[(pos+int64(i))%4]
+	}
+	return b
+}
+
+// wsMessageLog collects up to limit bytes of unmasked message payload
+type wsMessageLog struct {
+	buf   *[]byte
+	limit int
+	mask  []byte
+	pos   int64
+}
+
+func (l *wsMessageLog) Write(p []byte) (int, error) {
+	if rest := l.limit - len(*l.buf); rest > 0 {
+		if len(p) < rest {
+			rest = len(p)
+		}
+		*l.buf = append(*l.buf, unmaskWS(p[:rest], l.mask, l.pos)...)
+	}
+	l.pos += int64(len(p))
+	return len(p), nil
+}
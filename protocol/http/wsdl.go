@@ -0,0 +1,111 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	gohttp "net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/artyomturkin/nprxy/wsdl"
+)
+
+// maxWSDLBytes limits size of WSDL and XSD documents rewritten by proxy, larger documents are passed as is
+const maxWSDLBytes = 16 << 20
+
+// publicURLKey request context key of public URL of proxy, set for WSDL and XSD requests
+type publicURLKey struct{}
+
+// isWSDLRequest reports whether request fetches WSDL or XSD document: ?wsdl, ?singleWsdl, ?xsd=name,
+// or path with .wsdl or .xsd extension
+func isWSDLRequest(r *gohttp.Request) bool {
+	if r.Method != gohttp.MethodGet {
+		return false
+	}
+	for k := range r.URL.Query() {
+		switch strings.ToLower(k) {
+		case "wsdl", "singlewsdl", "xsd":
+			return true
+		}
+	}
+	p := strings.ToLower(r.URL.Path)
+	return strings.HasSuffix(p, ".wsdl") || strings.HasSuffix(p, ".xsd")
+}
+
+// withPublicURL marks WSDL request for rewriting with public URL of proxy, configured or built from
+// host and scheme used by client. Compression is disabled, so upstream response can be rewritten.
+func (h *httpProxy) withPublicURL(r *gohttp.Request) *gohttp.Request {
+	public := h.PublicURL
+	if public == nil {
+		public = &url.URL{Scheme: "http", Host: r.Host}
+		if r.TLS != nil {
+			public.Scheme = "https"
+		}
+	}
+	r.Header.Del("Accept-Encoding")
+	return r.WithContext(context.WithValue(r.Context(), publicURLKey{}, public))
+}
+
+// rewriteWSDL points endpoint addresses and imports of upstream in WSDL and XSD response to public URL of proxy
+func (h *httpProxy) rewriteWSDL(resp *gohttp.Response) error {
+	public, ok := resp.Request.Context().Value(publicURLKey{}).(*url.URL)
+	if !ok || resp.StatusCode != gohttp.StatusOK || resp.Header.Get("Content-Encoding") != "" ||
+		!strings.Contains(resp.Header.Get("Content-Type"), "xml") {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxWSDLBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxWSDLBytes {
+		resp.Body = &readCloser{Reader: io.MultiReader(bytes.NewReader(body), resp.Body), Closer: resp.Body}
+		return nil
+	}
+	resp.Body.Close()
+
+	upstreamPath := strings.TrimSuffix(h.Upstream.Path, "/")
+	rewritten, err := wsdl.RewriteLocations(body, func(kind wsdl.LocationKind, location string) string {
+		u, err := url.Parse(location)
+		if err != nil || !u.IsAbs() {
+			return location
+		}
+		// Imports of other hosts, e.g. standard schemas, are kept
+		if kind == wsdl.ImportLocation && !strings.EqualFold(u.Host, h.Upstream.Host) {
+			return location
+		}
+		if upstreamPath != "" && strings.HasPrefix(u.Path, upstreamPath+"/") {
+			u.Path = strings.TrimPrefix(u.Path, upstreamPath)
+			u.RawPath = ""
+		}
+		u.Scheme = public.Scheme
+		u.Host = public.Host
+		u.Path = strings.TrimSuffix(public.Path, "/") + u.Path
+		u.RawPath = ""
+		return u.String()
+	})
+	if err != nil {
+		// Not a well-formed XML document, pass it as is
+		if h.Logger != nil {
+			h.Logger.WithError(err).Warn("Failed to rewrite WSDL locations")
+		}
+		rewritten = body
+	}
+
+	if h.PublicURL == nil {
+		// Locations are built from Host header of client, response must not be reused for other clients
+		resp.Header.Set("Cache-Control", "no-store")
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(rewritten))
+	resp.ContentLength = int64(len(rewritten))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(rewritten)))
+	return nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/http"
 	"net/url"
+	"strings"
 )
 
 // DialUpstream func to create conn to upstream service
@@ -15,55 +17,161 @@ type Proxy interface {
 	Serve(ctx context.Context, Listener net.Listener, DialUpstream DialUpstream) error
 }
 
+// HostResolver reads virtual host name from accepted connection. Returned conn must be used instead of the accepted one.
+type HostResolver func(net.Conn) (string, net.Conn, error)
+
 // Proxy, Listener and Upstream factory
 var (
 	ProxyFactory        = map[string]func(ServiceConfig) (Proxy, error){}
 	ListenerFactory     = map[string]func(ServiceConfig) (net.Listener, error){}
 	UpstreamDialFactory = map[string]func(ServiceConfig) (DialUpstream, error){}
+
+	// VirtualHostFactory creates listener shared by services and resolver of virtual host for its connections
+	VirtualHostFactory = map[string]func([]ServiceConfig) (net.Listener, HostResolver, error){}
 )
 
 // ProxyService create proxy and forward traffic
 func ProxyService(ctx context.Context, c ServiceConfig) error {
-	u, err := url.Parse(c.Upstream)
+	p, ud, err := buildService(c)
 	if err != nil {
-		return fmt.Errorf("failed to parse Upstream: %v", err)
+		return err
 	}
 
-	// Create proxy with factory
-	pf, ok := ProxyFactory[u.Scheme]
+	// Create listener with factory
+	lf, ok := ListenerFactory[listenerKind(c)]
 	if !ok {
-		return fmt.Errorf("unsupported upstream scheme %s", u.Scheme)
+		return fmt.Errorf("unsupported listener type %s", c.Listen.Kind)
 	}
 
-	p, err := pf(c)
+	l, err := lf(c)
 	if err != nil {
-		return fmt.Errorf("failed to create proxy: %v", err)
+		return fmt.Errorf("failed to create listener: %v", err)
 	}
 
-	// Create listener with factory
-	if c.Listen.Kind == "" {
-		c.Listen.Kind = "plain"
+	return p.Serve(ctx, l, ud)
+}
+
+// ProxyServices create proxies for all services and forward traffic. Services with the same listen address share
+// one listener, that dispatches connections to services by virtual host. Returns first error returned by any service.
+func ProxyServices(ctx context.Context, cs []ServiceConfig) error {
+	var order []string
+	groups := map[string][]ServiceConfig{}
+	for _, c := range cs {
+		if _, ok := groups[c.Listen.Address]; !ok {
+			order = append(order, c.Listen.Address)
+		}
+		groups[c.Listen.Address] = append(groups[c.Listen.Address], c)
+	}
+
+	errs := make(chan error, len(order))
+	for _, a := range order {
+		go func(g []ServiceConfig) {
+			if len(g) == 1 {
+				errs <- wrapServiceError(g, ProxyService(ctx, g[0]))
+				return
+			}
+			errs <- wrapServiceError(g, proxyVirtualHosts(ctx, g))
+		}(groups[a])
+	}
+
+	return <-errs
+}
+
+// proxyVirtualHosts serves services from shared listener
+func proxyVirtualHosts(ctx context.Context, cs []ServiceConfig) error {
+	kind := listenerKind(cs[0])
+	for _, c := range cs[1:] {
+		if listenerKind(c) != kind {
+			return fmt.Errorf("services sharing address %s must use same listener type", c.Listen.Address)
+		}
 	}
-	lf, ok := ListenerFactory[c.Listen.Kind]
+
+	ps := make([]Proxy, len(cs))
+	uds := make([]DialUpstream, len(cs))
+	for i, c := range cs {
+		p, ud, err := buildService(c)
+		if err != nil {
+			return err
+		}
+		ps[i] = p
+		uds[i] = ud
+	}
+
+	vf, ok := VirtualHostFactory[kind]
 	if !ok {
-		return fmt.Errorf("unsupported listener type %s", c.Listen.Kind)
+		return fmt.Errorf("listener type %s does not support virtual hosts", kind)
 	}
 
-	l, err := lf(c)
+	l, resolve, err := vf(cs)
 	if err != nil {
 		return fmt.Errorf("failed to create listener: %v", err)
 	}
 
+	m, err := newHostMux(l, resolve, cs)
+	if err != nil {
+		l.Close()
+		return err
+	}
+	go m.serve()
+
+	errs := make(chan error, len(cs))
+	for i := range cs {
+		go func(i int) {
+			errs <- ps[i].Serve(ctx, m.listeners[i], uds[i])
+		}(i)
+	}
+
+	return <-errs
+}
+
+// buildService creates proxy and upstream dialer with factories
+func buildService(c ServiceConfig) (Proxy, DialUpstream, error) {
+	u, err := url.Parse(c.Upstream)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse Upstream: %v", err)
+	}
+
+	// Create proxy with factory
+	pf, ok := ProxyFactory[u.Scheme]
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported upstream scheme %s", u.Scheme)
+	}
+
+	p, err := pf(c)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create proxy: %v", err)
+	}
+
 	// Create upstream with factory
 	udf, ok := UpstreamDialFactory["plain"]
 	if !ok {
-		return fmt.Errorf("unsupported Upstream dialer type %s", "TODO")
+		return nil, nil, fmt.Errorf("unsupported Upstream dialer type %s", "TODO")
 	}
 
 	ud, err := udf(c)
 	if err != nil {
-		return fmt.Errorf("failed to create upstream dialer: %v", err)
+		return nil, nil, fmt.Errorf("failed to create upstream dialer: %v", err)
 	}
 
-	return p.Serve(ctx, l, ud)
+	return p, ud, nil
+}
+
+func listenerKind(c ServiceConfig) string {
+	if c.Listen.Kind == "" {
+		return "plain"
+	}
+	return c.Listen.Kind
+}
+
+// wrapServiceError adds service names to failures, keeping errors of graceful shutdown intact
+func wrapServiceError(cs []ServiceConfig, err error) error {
+	if err == nil || err == http.ErrServerClosed {
+		return err
+	}
+
+	var names []string
+	for _, c := range cs {
+		names = append(names, c.Name)
+	}
+	return fmt.Errorf("proxy service %s failed: %v", strings.Join(names, ", "), err)
 }
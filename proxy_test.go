@@ -11,6 +11,7 @@ import (
 	"io"
 	"io/ioutil"
 	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"sync"
@@ -30,6 +31,19 @@ func testServer() *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(handler))
 }
 
+// waitForProxy blocks until proxy accepts connections on address
+func waitForProxy(t tbHandler, addr string) {
+	for i := 0; i < 100; i++ {
+		c, err := net.Dial("tcp", addr)
+		if err == nil {
+			c.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("proxy is not listening on %s", addr)
+}
+
 // Test and benchmark plain listener, http proxy and plain upstream
 func TestPlainProxy(t *testing.T) {
 	ts := testServer()
@@ -53,6 +67,7 @@ func TestPlainProxy(t *testing.T) {
 		err = nprxy.ProxyService(ctx, service)
 		wg.Done()
 	}()
+	waitForProxy(t, "127.0.0.1:59010")
 
 	resp, _ := http.Get("http://127.0.0.1:59010/api")
 	body, _ := ioutil.ReadAll(resp.Body)
@@ -96,6 +111,7 @@ func BenchmarkPlainProxy(b *testing.B) {
 		nprxy.ProxyService(ctx, service)
 		wg.Done()
 	}()
+	waitForProxy(b, "127.0.0.1:59010")
 
 	b.Run("native", func(bb *testing.B) {
 		for n := 0; n < bb.N; n++ {
@@ -196,6 +212,7 @@ func TestTLSProxy(t *testing.T) {
 		err = nprxy.ProxyService(ctx, service)
 		wg.Done()
 	}()
+	waitForProxy(t, "127.0.0.1:59010")
 
 	resp, _ := http.Get("https://127.0.0.1:59010/api")
 	body, _ := ioutil.ReadAll(resp.Body)
@@ -246,6 +263,7 @@ func BenchmarkTLSProxy(b *testing.B) {
 		nprxy.ProxyService(ctx, service)
 		wg.Done()
 	}()
+	waitForProxy(b, "127.0.0.1:59010")
 
 	b.Run("native", func(bb *testing.B) {
 		for n := 0; n < bb.N; n++ {
@@ -305,6 +323,7 @@ func TestPlainSOAPProxy(t *testing.T) {
 		err = nprxy.ProxyService(ctx, service)
 		wg.Done()
 	}()
+	waitForProxy(t, "127.0.0.1:59010")
 
 	req, _ := http.NewRequest("GET", "http://127.0.0.1:59010/api", nil)
 	req.Header.Set("SOAPAction", "http://tempuri.org/test")
@@ -374,6 +393,7 @@ func BenchmarkPlainSOAPProxy(b *testing.B) {
 		nprxy.ProxyService(ctx, service)
 		wg.Done()
 	}()
+	waitForProxy(b, "127.0.0.1:59010")
 
 	b.Run("native", func(bb *testing.B) {
 		for n := 0; n < bb.N; n++ {
@@ -457,6 +477,7 @@ func BenchmarkTLSSOAPProxy(b *testing.B) {
 		nprxy.ProxyService(ctx, service)
 		wg.Done()
 	}()
+	waitForProxy(b, "127.0.0.1:59010")
 
 	b.Run("native", func(bb *testing.B) {
 		for n := 0; n < bb.N; n++ {
@@ -493,3 +514,167 @@ func BenchmarkTLSSOAPProxy(b *testing.B) {
 	cancel()
 	wg.Wait()
 }
+
+// Test virtual hosts sharing plain and tls listeners
+func namedServer(name string) *httptest.Server {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name)
+	}
+	return httptest.NewServer(http.HandlerFunc(handler))
+}
+
+func TestVirtualHostProxy(t *testing.T) {
+	tsA := namedServer("a")
+	defer tsA.Close()
+	tsB := namedServer("b")
+	defer tsB.Close()
+	tsDefault := namedServer("default")
+	defer tsDefault.Close()
+
+	services := []nprxy.ServiceConfig{
+		{Name: "a", DisableLog: true, Listen: nprxy.ListenerConfig{Address: "127.0.0.1:59011", Hosts: []string{"a.example.com"}}, Upstream: tsA.URL},
+		{Name: "b", DisableLog: true, Listen: nprxy.ListenerConfig{Address: "127.0.0.1:59011", Hosts: []string{"*.b.example.com"}}, Upstream: tsB.URL},
+		{Name: "default", DisableLog: true, Listen: nprxy.ListenerConfig{Address: "127.0.0.1:59011"}, Upstream: tsDefault.URL},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+
+	var err error
+	go func() {
+		err = nprxy.ProxyServices(ctx, services)
+		wg.Done()
+	}()
+	waitForProxy(t, "127.0.0.1:59011")
+
+	cases := map[string]string{
+		"a.example.com":      "a",
+		"A.example.com:8080": "a",
+		"x.b.example.com":    "b",
+		"b.example.com":      "default",
+		"other.com":          "default",
+	}
+	for host, expected := range cases {
+		req, _ := http.NewRequest("GET", "http://127.0.0.1:59011/api", nil)
+		req.Host = host
+		req.Close = true
+
+		resp, rerr := http.DefaultClient.Do(req)
+		if rerr != nil {
+			t.Fatalf("request for %s failed: %v", host, rerr)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		if string(body) != expected {
+			t.Errorf("Wrong service for host %s: %s, expected: %s", host, string(body), expected)
+		}
+	}
+
+	cancel()
+	wg.Wait()
+	if err != http.ErrServerClosed {
+		t.Errorf("Serve failed: %v", err)
+	}
+}
+
+func TestSNIVirtualHostProxy(t *testing.T) {
+	tsA := namedServer("a")
+	defer tsA.Close()
+	tsB := namedServer("b")
+	defer tsB.Close()
+
+	cert, key := createCertKey(t)
+
+	services := []nprxy.ServiceConfig{
+		{Name: "a", DisableLog: true, Listen: nprxy.ListenerConfig{Address: "127.0.0.1:59012", Kind: "tls", TLSCert: cert, TLSKey: key, Hosts: []string{"a.example.com"}}, Upstream: tsA.URL},
+		{Name: "b", DisableLog: true, Listen: nprxy.ListenerConfig{Address: "127.0.0.1:59012", Kind: "tls", TLSCert: cert, TLSKey: key, Hosts: []string{"b.example.com"}}, Upstream: tsB.URL},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+
+	var err error
+	go func() {
+		err = nprxy.ProxyServices(ctx, services)
+		wg.Done()
+	}()
+	waitForProxy(t, "127.0.0.1:59012")
+
+	for _, name := range []string{"a", "b"} {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true, ServerName: name + ".example.com"},
+		}}
+		resp, rerr := client.Get("https://127.0.0.1:59012/api")
+		if rerr != nil {
+			t.Fatalf("request for %s failed: %v", name, rerr)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		if string(body) != name {
+			t.Errorf("Wrong service for SNI %s.example.com: %s, expected: %s", name, string(body), name)
+		}
+	}
+
+	cancel()
+	wg.Wait()
+	if err != http.ErrServerClosed {
+		t.Errorf("Serve failed: %v", err)
+	}
+}
+
+func TestTLSHTTP2Proxy(t *testing.T) {
+	ts := testServer()
+	defer ts.Close()
+
+	cert, key := createCertKey(t)
+
+	service := nprxy.ServiceConfig{
+		Name:       "test",
+		DisableLog: true,
+		Listen: nprxy.ListenerConfig{
+			Address: "127.0.0.1:59013",
+			Kind:    "tls",
+			TLSCert: cert,
+			TLSKey:  key,
+		},
+		Upstream: ts.URL,
+		HTTP: nprxy.HTTPConfig{
+			HTTP2: nprxy.HTTP2Config{Enabled: true},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+
+	var err error
+	go func() {
+		err = nprxy.ProxyService(ctx, service)
+		wg.Done()
+	}()
+	waitForProxy(t, "127.0.0.1:59013")
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, rerr := client.Get("https://127.0.0.1:59013/api")
+	if rerr != nil {
+		t.Fatalf("request failed: %v", rerr)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	cancel()
+
+	if resp.ProtoMajor != 2 {
+		t.Errorf("Wrong protocol: %s, expected HTTP/2.0", resp.Proto)
+	}
+	if string(body) != "<html><body>Hello World!</body></html>" {
+		t.Errorf("Wrong body: %s, expected: <html><body>Hello World!</body></html>", string(body))
+	}
+
+	wg.Wait()
+	if err != http.ErrServerClosed {
+		t.Errorf("Serve failed: %v", err)
+	}
+}
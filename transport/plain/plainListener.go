@@ -1,15 +1,63 @@
 package plain
 
 import (
+	"bufio"
+	"bytes"
+	"io"
 	"net"
+	"net/http"
 
 	"github.com/artyomturkin/nprxy"
 )
 
+// maxHeaderBytes limits size of request head read to resolve virtual host
+const maxHeaderBytes = 64 << 10
+
 func init() {
 	nprxy.ListenerFactory["plain"] = buildPlainListener
+	nprxy.VirtualHostFactory["plain"] = buildHostListener
 }
 
 func buildPlainListener(c nprxy.ServiceConfig) (net.Listener, error) {
 	return net.Listen("tcp", c.Listen.Address)
 }
+
+// buildHostListener creates listener shared by services, that dispatches connections by Host header of the first HTTP request
+func buildHostListener(cs []nprxy.ServiceConfig) (net.Listener, nprxy.HostResolver, error) {
+	l, err := net.Listen("tcp", cs[0].Listen.Address)
+	return l, resolveHost, err
+}
+
+// resolveHost reads head of the first request on connection and returns its Host. Read data is replayed by returned conn.
+// Connections that do not start with HTTP/1 request resolve to empty host.
+func resolveHost(c net.Conn) (string, net.Conn, error) {
+	var head []byte
+	buf := make([]byte, 4096)
+	for !bytes.Contains(head, []byte("\r\n\r\n")) && len(head) < maxHeaderBytes {
+		n, err := c.Read(buf)
+		head = append(head, buf[:n]...)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return "", c, err
+		}
+	}
+
+	rc := &replayConn{Conn: c, r: io.MultiReader(bytes.NewReader(head), c)}
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(head)))
+	if err != nil {
+		return "", rc, nil
+	}
+	return req.Host, rc, nil
+}
+
+// replayConn reads already consumed data before reading from connection
+type replayConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
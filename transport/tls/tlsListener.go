@@ -2,6 +2,9 @@ package tls
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
 	"net"
 
 	"github.com/artyomturkin/nprxy"
@@ -9,13 +12,79 @@ import (
 
 func init() {
 	nprxy.ListenerFactory["tls"] = buildTLSListener
+	nprxy.VirtualHostFactory["tls"] = buildSNIListener
 }
 
 func buildTLSListener(c nprxy.ServiceConfig) (net.Listener, error) {
+	tc, err := serviceTLSConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Listen("tcp", c.Listen.Address, tc)
+}
+
+// buildSNIListener creates TLS listener shared by services. TLS config is selected by SNI from configs of all services,
+// and connections are dispatched by SNI server name.
+func buildSNIListener(cs []nprxy.ServiceConfig) (net.Listener, nprxy.HostResolver, error) {
+	var tcs []*tls.Config
+	for _, c := range cs {
+		tc, err := serviceTLSConfig(c)
+		if err != nil {
+			return nil, nil, err
+		}
+		tcs = append(tcs, tc)
+	}
+
+	tc := &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			i := nprxy.MatchVirtualHost(cs, hello.ServerName)
+			if i < 0 {
+				return nil, fmt.Errorf("no service for server name %s", hello.ServerName)
+			}
+			return tcs[i], nil
+		},
+	}
+
+	l, err := tls.Listen("tcp", cs[0].Listen.Address, tc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return l, resolveSNI, nil
+}
+
+// serviceTLSConfig loads certificate of service, CAs of client certificates and advertises HTTP/2 with ALPN,
+// if it is enabled
+func serviceTLSConfig(c nprxy.ServiceConfig) (*tls.Config, error) {
 	cer, err := tls.LoadX509KeyPair(c.Listen.TLSCert, c.Listen.TLSKey)
 	if err != nil {
 		return nil, err
 	}
 	tc := &tls.Config{Certificates: []tls.Certificate{cer}}
-	return tls.Listen("tcp", c.Listen.Address, tc)
+	if c.Listen.TLSClientCA != "" {
+		b, err := ioutil.ReadFile(c.Listen.TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+		tc.ClientCAs = x509.NewCertPool()
+		if !tc.ClientCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates in %s", c.Listen.TLSClientCA)
+		}
+		// Certificates are optional on TLS level, so services can accept other credentials too
+		tc.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if c.HTTP.HTTP2.Enabled {
+		tc.NextProtos = []string{"h2", "http/1.1"}
+	}
+	return tc, nil
+}
+
+func resolveSNI(c net.Conn) (string, net.Conn, error) {
+	tc, ok := c.(*tls.Conn)
+	if !ok {
+		return "", c, fmt.Errorf("not a TLS connection")
+	}
+	if err := tc.Handshake(); err != nil {
+		return "", c, err
+	}
+	return tc.ConnectionState().ServerName, c, nil
 }
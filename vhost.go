@@ -0,0 +1,174 @@
+package nprxy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resolveTimeout limits time spent reading virtual host name from new connection
+var resolveTimeout = 10 * time.Second
+
+// MatchVirtualHost returns index of service that serves host: by exact host name, then by wildcard,
+// then service without hosts. Returns -1 if no service matches.
+func MatchVirtualHost(cs []ServiceConfig, host string) int {
+	host = strings.ToLower(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	fallback, wildcard, suffix := -1, -1, ""
+	for i, c := range cs {
+		if len(c.Listen.Hosts) == 0 {
+			fallback = i
+		}
+		for _, h := range c.Listen.Hosts {
+			h = strings.ToLower(h)
+			if h == host {
+				return i
+			}
+			// Longest wildcard suffix wins
+			if strings.HasPrefix(h, "*.") && strings.HasSuffix(host, h[1:]) && len(h) > len(suffix) {
+				wildcard, suffix = i, h
+			}
+		}
+	}
+
+	if wildcard >= 0 {
+		return wildcard
+	}
+	return fallback
+}
+
+// hostMux accepts connections on shared listener and dispatches them to virtual listeners of services
+type hostMux struct {
+	listener  net.Listener
+	resolve   HostResolver
+	services  []ServiceConfig
+	listeners []*virtualListener
+
+	open int
+	mu   sync.Mutex
+}
+
+func newHostMux(l net.Listener, resolve HostResolver, cs []ServiceConfig) (*hostMux, error) {
+	m := &hostMux{
+		listener: l,
+		resolve:  resolve,
+		services: cs,
+	}
+
+	hosts := map[string]bool{}
+	fallback := false
+	for _, c := range cs {
+		m.listeners = append(m.listeners, &virtualListener{
+			mux:    m,
+			conns:  make(chan net.Conn),
+			closed: make(chan struct{}),
+		})
+
+		if len(c.Listen.Hosts) == 0 {
+			if fallback {
+				return nil, fmt.Errorf("only one service on address %s may omit hosts", c.Listen.Address)
+			}
+			fallback = true
+		}
+		for _, h := range c.Listen.Hosts {
+			h = strings.ToLower(h)
+			if hosts[h] {
+				return nil, fmt.Errorf("host %s is used by several services on address %s", h, c.Listen.Address)
+			}
+			hosts[h] = true
+		}
+	}
+	m.open = len(m.listeners)
+
+	return m, nil
+}
+
+// serve accepts connections until shared listener is closed
+func (m *hostMux) serve() {
+	for {
+		c, err := m.listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			for _, vl := range m.listeners {
+				vl.Close()
+			}
+			return
+		}
+		go m.dispatch(c)
+	}
+}
+
+func (m *hostMux) dispatch(c net.Conn) {
+	c.SetReadDeadline(time.Now().Add(resolveTimeout))
+	host, rc, err := m.resolve(c)
+	if err != nil {
+		c.Close()
+		return
+	}
+	c.SetReadDeadline(time.Time{})
+	c = rc
+
+	i := MatchVirtualHost(m.services, host)
+	if i < 0 {
+		c.Close()
+		return
+	}
+
+	vl := m.listeners[i]
+	select {
+	case vl.conns <- c:
+	case <-vl.closed:
+		c.Close()
+	}
+}
+
+// release closes shared listener, when all virtual listeners are closed
+func (m *hostMux) release() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.open--
+	if m.open == 0 {
+		m.listener.Close()
+	}
+}
+
+// virtualListener receives connections dispatched to a single service
+type virtualListener struct {
+	mux    *hostMux
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+// Accept waits for and returns the next connection dispatched to the service
+func (l *virtualListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, fmt.Errorf("virtual listener closed")
+	}
+}
+
+// Close stops accepting connections for the service
+func (l *virtualListener) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+		l.mux.release()
+	})
+	return nil
+}
+
+// Addr returns address of shared listener
+func (l *virtualListener) Addr() net.Addr {
+	return l.mux.listener.Addr()
+}
@@ -0,0 +1,117 @@
+package wsdl
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"regexp"
+)
+
+// WSDL 1.1 and XML Schema namespaces
+const (
+	Namespace              = "http://schemas.xmlsoap.org/wsdl/"
+	SOAP11BindingNamespace = "http://schemas.xmlsoap.org/wsdl/soap/"
+	SOAP12BindingNamespace = "http://schemas.xmlsoap.org/wsdl/soap12/"
+	HTTPBindingNamespace   = "http://schemas.xmlsoap.org/wsdl/http/"
+	XSDNamespace           = "http://www.w3.org/2001/XMLSchema"
+)
+
+// LocationKind kind of location found in WSDL or XSD document
+type LocationKind int
+
+const (
+	// AddressLocation endpoint address of port: soap:address, soap12:address or http:address location
+	AddressLocation LocationKind = iota
+	// ImportLocation location of imported document: wsdl:import location, xsd:import, xsd:include
+	// or xsd:redefine schemaLocation
+	ImportLocation
+)
+
+var (
+	locationAttr       = regexp.MustCompile(`\slocation\s*=\s*("[^"]*"|'[^']*')`)
+	schemaLocationAttr = regexp.MustCompile(`\sschemaLocation\s*=\s*("[^"]*"|'[^']*')`)
+)
+
+// RewriteLocations replaces endpoint addresses and import locations of WSDL or XSD document with result of rewrite.
+// Only rewritten attribute values are changed, the rest of document is kept byte to byte.
+func RewriteLocations(doc []byte, rewrite func(kind LocationKind, location string) string) ([]byte, error) {
+	type edit struct {
+		start, end int
+		value      string
+	}
+	var edits []edit
+
+	d := xml.NewDecoder(bytes.NewReader(doc))
+	for {
+		start := d.InputOffset()
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		e, ok := t.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		var kind LocationKind
+		var attr string
+		var re *regexp.Regexp
+		switch {
+		case e.Name.Local == "address" && (e.Name.Space == SOAP11BindingNamespace || e.Name.Space == SOAP12BindingNamespace || e.Name.Space == HTTPBindingNamespace):
+			kind, attr, re = AddressLocation, "location", locationAttr
+		case e.Name.Local == "import" && e.Name.Space == Namespace:
+			kind, attr, re = ImportLocation, "location", locationAttr
+		case (e.Name.Local == "import" || e.Name.Local == "include" || e.Name.Local == "redefine") && e.Name.Space == XSDNamespace:
+			kind, attr, re = ImportLocation, "schemaLocation", schemaLocationAttr
+		default:
+			continue
+		}
+
+		value, ok := attrValue(e, attr)
+		if !ok {
+			continue
+		}
+		rewritten := rewrite(kind, value)
+		if rewritten == value {
+			continue
+		}
+
+		raw := doc[start:d.InputOffset()]
+		m := re.FindSubmatchIndex(raw)
+		if m == nil {
+			continue
+		}
+		b := &bytes.Buffer{}
+		b.WriteByte('"')
+		xml.EscapeText(b, []byte(rewritten))
+		b.WriteByte('"')
+		edits = append(edits, edit{start: int(start) + m[2], end: int(start) + m[3], value: b.String()})
+	}
+
+	if len(edits) == 0 {
+		return doc, nil
+	}
+	out := &bytes.Buffer{}
+	last := 0
+	for _, e := range edits {
+		out.Write(doc[last:e.start])
+		out.WriteString(e.value)
+		last = e.end
+	}
+	out.Write(doc[last:])
+	return out.Bytes(), nil
+}
+
+// attrValue returns value of unqualified attribute of element
+func attrValue(e xml.StartElement, name string) (string, bool) {
+	for _, a := range e.Attr {
+		if a.Name.Space == "" && a.Name.Local == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,286 @@
+// Package wsdl reads WSDL 1.1 documents and rewrites locations in WSDL and XSD documents.
+package wsdl
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+
+	"github.com/artyomturkin/nprxy/xsd"
+)
+
+type (
+	// Definitions services and SOAP operations of WSDL document and documents imported by it
+	Definitions struct {
+		Name            string
+		TargetNamespace string
+		Services        []Service
+		Operations      []Operation
+		// Schemas of types section and imported schema documents
+		Schemas *xsd.Set
+	}
+
+	// Service WSDL service with its ports
+	Service struct {
+		Name  string
+		Ports []Port
+	}
+
+	// Port endpoint of service
+	Port struct {
+		Name    string
+		Binding string
+		Address string
+		SOAP12  bool
+	}
+
+	// Operation SOAP operation of binding
+	Operation struct {
+		Binding    string
+		Name       string
+		SOAPAction string
+		SOAP12     bool
+		// Style document or rpc
+		Style string
+		// Input and Output body elements of document style operation
+		Input, Output xml.Name
+	}
+)
+
+// Parse reads single WSDL 1.1 or XSD document, imports are not followed. Inline schemas and schema documents
+// are added to Schemas. Locations of wsdl:import and xsd:import and xsd:include are returned.
+func Parse(doc []byte) (*Definitions, []string, error) {
+	defs := &Definitions{Schemas: xsd.NewSet()}
+	imports, err := defs.add(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return defs, imports, nil
+}
+
+// add merges WSDL or XSD document into definitions
+func (d *Definitions) add(doc []byte) ([]string, error) {
+	root, err := xsd.Parse(bytes.NewReader(doc))
+	if err != nil {
+		return nil, err
+	}
+
+	if root.Name.Space == xsd.Namespace && root.Name.Local == "schema" {
+		return schemaImports(root), d.Schemas.Add(root)
+	}
+	if root.Name.Space != Namespace || root.Name.Local != "definitions" {
+		return nil, fmt.Errorf("%s is not a WSDL or XSD document", root.Name.Local)
+	}
+
+	if d.Name == "" && d.TargetNamespace == "" {
+		d.Name, _ = root.Attribute("", "name")
+		d.TargetNamespace, _ = root.Attribute("", "targetNamespace")
+	}
+
+	var imports []string
+	for _, i := range root.Elements(Namespace, "import") {
+		if l, _ := i.Attribute("", "location"); l != "" {
+			imports = append(imports, l)
+		}
+	}
+	for _, t := range root.Elements(Namespace, "types") {
+		for _, schema := range t.Elements(xsd.Namespace, "schema") {
+			if err := d.Schemas.Add(schema); err != nil {
+				return nil, err
+			}
+			imports = append(imports, schemaImports(schema)...)
+		}
+	}
+
+	// Body element of document style operation is the element of the only part of message
+	messages := map[xml.Name]xml.Name{}
+	tns, _ := root.Attribute("", "targetNamespace")
+	for _, m := range root.Elements(Namespace, "message") {
+		name, _ := m.Attribute("", "name")
+		if parts := m.Elements(Namespace, "part"); len(parts) == 1 {
+			if e, ok := parts[0].Attribute("", "element"); ok {
+				messages[xml.Name{Space: tns, Local: name}] = parts[0].ResolveQName(e)
+			}
+		}
+	}
+	type portTypeOperation struct{ input, output xml.Name }
+	portTypes := map[xml.Name]map[string]portTypeOperation{}
+	for _, pt := range root.Elements(Namespace, "portType") {
+		name, _ := pt.Attribute("", "name")
+		ops := map[string]portTypeOperation{}
+		for _, o := range pt.Elements(Namespace, "operation") {
+			var op portTypeOperation
+			if i := o.Element(Namespace, "input"); i != nil {
+				m, _ := i.Attribute("", "message")
+				op.input = messages[i.ResolveQName(m)]
+			}
+			if out := o.Element(Namespace, "output"); out != nil {
+				m, _ := out.Attribute("", "message")
+				op.output = messages[out.ResolveQName(m)]
+			}
+			on, _ := o.Attribute("", "name")
+			ops[on] = op
+		}
+		portTypes[xml.Name{Space: tns, Local: name}] = ops
+	}
+
+	for _, b := range root.Elements(Namespace, "binding") {
+		name, _ := b.Attribute("", "name")
+		typ, _ := b.Attribute("", "type")
+		ops := portTypes[b.ResolveQName(typ)]
+
+		style, soap12 := "document", false
+		sb := b.Element(SOAP11BindingNamespace, "binding")
+		if sb == nil {
+			sb, soap12 = b.Element(SOAP12BindingNamespace, "binding"), true
+		}
+		if sb == nil {
+			// Not a SOAP binding
+			continue
+		}
+		if s, _ := sb.Attribute("", "style"); s != "" {
+			style = s
+		}
+
+		for _, o := range b.Elements(Namespace, "operation") {
+			on, _ := o.Attribute("", "name")
+			op := Operation{Binding: name, Name: on, Style: style, SOAP12: soap12,
+				Input: ops[on].input, Output: ops[on].output}
+			so := o.Element(SOAP11BindingNamespace, "operation")
+			if soap12 {
+				so = o.Element(SOAP12BindingNamespace, "operation")
+			}
+			if so != nil {
+				op.SOAPAction, _ = so.Attribute("", "soapAction")
+				if s, _ := so.Attribute("", "style"); s != "" {
+					op.Style = s
+				}
+			}
+			if op.Style != "document" {
+				op.Input, op.Output = xml.Name{}, xml.Name{}
+			}
+			d.Operations = append(d.Operations, op)
+		}
+	}
+
+	for _, s := range root.Elements(Namespace, "service") {
+		name, _ := s.Attribute("", "name")
+		service := Service{Name: name}
+		for _, p := range s.Elements(Namespace, "port") {
+			pn, _ := p.Attribute("", "name")
+			binding, _ := p.Attribute("", "binding")
+			port := Port{Name: pn, Binding: p.ResolveQName(binding).Local}
+			if a := p.Element(SOAP12BindingNamespace, "address"); a != nil {
+				port.Address, _ = a.Attribute("", "location")
+				port.SOAP12 = true
+			} else if a := p.Element(SOAP11BindingNamespace, "address"); a != nil {
+				port.Address, _ = a.Attribute("", "location")
+			} else {
+				// Not a SOAP port
+				continue
+			}
+			service.Ports = append(service.Ports, port)
+		}
+		d.Services = append(d.Services, service)
+	}
+	return imports, nil
+}
+
+// schemaImports returns locations of schemas imported or included by schema
+func schemaImports(schema *xsd.Node) []string {
+	var r []string
+	for _, c := range schema.Children {
+		if c.Name.Space != xsd.Namespace || (c.Name.Local != "import" && c.Name.Local != "include" && c.Name.Local != "redefine") {
+			continue
+		}
+		if l, _ := c.Attribute("", "schemaLocation"); l != "" {
+			r = append(r, l)
+		}
+	}
+	return r
+}
+
+// Load reads WSDL document from URL or file path and follows its wsdl:import, xsd:import and xsd:include elements.
+// Services, operations and schemas of imported documents are merged into result.
+func Load(location string) (*Definitions, error) {
+	defs := &Definitions{Schemas: xsd.NewSet()}
+	visited := map[string]bool{}
+
+	var load func(location string) error
+	load = func(location string) error {
+		if visited[location] {
+			return nil
+		}
+		visited[location] = true
+
+		doc, err := read(location)
+		if err != nil {
+			return err
+		}
+		imports, err := defs.add(doc)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %v", location, err)
+		}
+		for _, i := range imports {
+			if err := load(resolve(location, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := load(location); err != nil {
+		return nil, err
+	}
+	return defs, nil
+}
+
+// SOAPActions returns sorted unique non-empty SOAP actions of operations
+func (d *Definitions) SOAPActions() []string {
+	seen := map[string]bool{}
+	var actions []string
+	for _, op := range d.Operations {
+		if op.SOAPAction != "" && !seen[op.SOAPAction] {
+			seen[op.SOAPAction] = true
+			actions = append(actions, op.SOAPAction)
+		}
+	}
+	sort.Strings(actions)
+	return actions
+}
+
+func read(location string) ([]byte, error) {
+	if u, err := url.Parse(location); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		resp, err := http.Get(location)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to get %s: %s", location, resp.Status)
+		}
+		return ioutil.ReadAll(resp.Body)
+	}
+	return ioutil.ReadFile(location)
+}
+
+// resolve resolves location of import relative to location of importing document
+func resolve(base, location string) string {
+	if u, err := url.Parse(location); err == nil && u.IsAbs() {
+		return location
+	}
+	if b, err := url.Parse(base); err == nil && (b.Scheme == "http" || b.Scheme == "https") {
+		if u, err := b.Parse(location); err == nil {
+			return u.String()
+		}
+	}
+	if filepath.IsAbs(location) {
+		return location
+	}
+	return filepath.Join(filepath.Dir(base), location)
+}
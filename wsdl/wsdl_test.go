@@ -0,0 +1,136 @@
+package wsdl
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testWSDL = `<?xml version="1.0" encoding="UTF-8"?>
+<wsdl:definitions name="Orders" targetNamespace="http://tempuri.org/"
+    xmlns:wsdl="http://schemas.xmlsoap.org/wsdl/"
+    xmlns:soap="http://schemas.xmlsoap.org/wsdl/soap/"
+    xmlns:xsd="http://www.w3.org/2001/XMLSchema"
+    xmlns:tns="http://tempuri.org/">
+  <wsdl:import namespace="http://tempuri.org/admin" location="admin.wsdl"/>
+  <wsdl:types>
+    <xsd:schema>
+      <xsd:import namespace="http://tempuri.org/types" schemaLocation='http://backend:8080/ws/orders?xsd=1'/>
+      <xsd:import namespace="http://www.w3.org/XML/1998/namespace" schemaLocation="http://www.w3.org/2001/xml.xsd"/>
+    </xsd:schema>
+  </wsdl:types>
+  <wsdl:message name="GetOrderRequest"><wsdl:part name="body" element="types:GetOrder" xmlns:types="http://tempuri.org/types"/></wsdl:message>
+  <wsdl:message name="GetOrderResponse"><wsdl:part name="body" element="tns:GetOrderResponse"/></wsdl:message>
+  <wsdl:portType name="Orders">
+    <wsdl:operation name="GetOrder">
+      <wsdl:input message="tns:GetOrderRequest"/>
+      <wsdl:output message="tns:GetOrderResponse"/>
+    </wsdl:operation>
+  </wsdl:portType>
+  <wsdl:binding name="OrdersBinding" type="tns:Orders">
+    <soap:binding transport="http://schemas.xmlsoap.org/soap/http"/>
+    <wsdl:operation name="GetOrder">
+      <soap:operation soapAction="http://tempuri.org/GetOrder"/>
+    </wsdl:operation>
+    <wsdl:operation name="ListOrders">
+      <soap:operation soapAction=""/>
+    </wsdl:operation>
+  </wsdl:binding>
+  <wsdl:service name="OrderService">
+    <wsdl:port name="OrdersPort" binding="tns:OrdersBinding">
+      <soap:address location="http://backend:8080/ws/orders?a=1&amp;b=2"/>
+    </wsdl:port>
+  </wsdl:service>
+</wsdl:definitions>`
+
+const testAdminWSDL = `<definitions xmlns="http://schemas.xmlsoap.org/wsdl/" xmlns:soap12="http://schemas.xmlsoap.org/wsdl/soap12/">
+  <import location="orders.wsdl"/>
+  <binding name="AdminBinding">
+    <soap12:binding style="rpc" transport="http://schemas.xmlsoap.org/soap/http"/>
+    <operation name="DeleteOrder">
+      <soap12:operation soapAction="http://tempuri.org/admin/DeleteOrder"/>
+    </operation>
+  </binding>
+</definitions>`
+
+const testXSD = `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" targetNamespace="http://tempuri.org/types">
+  <xs:element name="GetOrder" type="xs:int"/>
+</xs:schema>`
+
+func TestRewriteLocations(t *testing.T) {
+	var found []string
+	out, err := RewriteLocations([]byte(testWSDL), func(kind LocationKind, location string) string {
+		found = append(found, location)
+		if strings.HasPrefix(location, "http://backend:8080/ws/") {
+			return "https://proxy/" + strings.TrimPrefix(location, "http://backend:8080/ws/")
+		}
+		return location
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"admin.wsdl", "http://backend:8080/ws/orders?xsd=1", "http://www.w3.org/2001/xml.xsd", "http://backend:8080/ws/orders?a=1&b=2"}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("expected locations %v, got: %v", expected, found)
+	}
+
+	want := strings.Replace(testWSDL, `schemaLocation='http://backend:8080/ws/orders?xsd=1'`, `schemaLocation="https://proxy/orders?xsd=1"`, 1)
+	want = strings.Replace(want, `location="http://backend:8080/ws/orders?a=1&amp;b=2"`, `location="https://proxy/orders?a=1&amp;b=2"`, 1)
+	if string(out) != want {
+		t.Errorf("unexpected rewritten document:\n%s", out)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/wsdl/orders.wsdl":
+			doc := strings.Replace(testWSDL, "http://backend:8080/ws/orders?xsd=1", "types.xsd", 1)
+			io.WriteString(w, strings.Replace(doc, "http://www.w3.org/2001/xml.xsd", "", 1))
+		case "/wsdl/types.xsd":
+			io.WriteString(w, testXSD)
+		case "/wsdl/admin.wsdl":
+			io.WriteString(w, testAdminWSDL)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	defs, err := Load(ts.URL + "/wsdl/orders.wsdl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if defs.Name != "Orders" || defs.TargetNamespace != "http://tempuri.org/" {
+		t.Errorf("unexpected definitions: %s %s", defs.Name, defs.TargetNamespace)
+	}
+	services := []Service{Service{Name: "OrderService", Ports: []Port{
+		Port{Name: "OrdersPort", Binding: "OrdersBinding", Address: "http://backend:8080/ws/orders?a=1&b=2"},
+	}}}
+	if !reflect.DeepEqual(defs.Services, services) {
+		t.Errorf("expected services %v, got: %v", services, defs.Services)
+	}
+	operations := []Operation{
+		Operation{Binding: "OrdersBinding", Name: "GetOrder", SOAPAction: "http://tempuri.org/GetOrder", Style: "document",
+			Input:  xml.Name{Space: "http://tempuri.org/types", Local: "GetOrder"},
+			Output: xml.Name{Space: "http://tempuri.org/", Local: "GetOrderResponse"}},
+		Operation{Binding: "OrdersBinding", Name: "ListOrders", Style: "document"},
+		Operation{Binding: "AdminBinding", Name: "DeleteOrder", SOAPAction: "http://tempuri.org/admin/DeleteOrder", SOAP12: true, Style: "rpc"},
+	}
+	if !reflect.DeepEqual(defs.Operations, operations) {
+		t.Errorf("expected operations %v, got: %v", operations, defs.Operations)
+	}
+	if _, ok := defs.Schemas.Element(xml.Name{Space: "http://tempuri.org/types", Local: "GetOrder"}); !ok {
+		t.Error("expected imported schema to be loaded")
+	}
+	actions := []string{"http://tempuri.org/GetOrder", "http://tempuri.org/admin/DeleteOrder"}
+	if !reflect.DeepEqual(defs.SOAPActions(), actions) {
+		t.Errorf("expected actions %v, got: %v", actions, defs.SOAPActions())
+	}
+}
@@ -0,0 +1,130 @@
+package xmldsig
+
+import (
+	"bytes"
+	"encoding/xml"
+	"sort"
+	"strings"
+)
+
+// Canonicalize returns exclusive XML canonicalization of element subtree without comments.
+// Prefixes of inclusive list are rendered whenever they are in scope, "#default" stands for default namespace.
+func Canonicalize(e *Element, inclusive []string) []byte {
+	c := &canonicalizer{inclusive: inclusive}
+	c.element(e, map[string]string{})
+	return c.buf.Bytes()
+}
+
+type canonicalizer struct {
+	buf       bytes.Buffer
+	inclusive []string
+	// exclude element omitted from output, enveloped signature
+	exclude *Element
+}
+
+// element writes element, rendered are namespaces declared by output ancestors
+func (c *canonicalizer) element(e *Element, rendered map[string]string) {
+	// Namespaces visibly utilized by element and its attributes
+	used := map[string]bool{e.Prefix: true}
+	for _, a := range e.Attr {
+		if a.Prefix != "" {
+			used[a.Prefix] = true
+		}
+	}
+	for _, p := range c.inclusive {
+		if p == "#default" {
+			p = ""
+		}
+		used[p] = true
+	}
+
+	var prefixes []string
+	for p := range used {
+		if p == "xml" {
+			continue
+		}
+		ns, ok := e.lookup(p)
+		if !ok {
+			continue
+		}
+		// Empty default namespace is rendered only to undeclare default namespace of output ancestor
+		if r, ok := rendered[p]; (ok && r != ns) || (!ok && ns != "") {
+			prefixes = append(prefixes, p)
+		}
+	}
+	sort.Strings(prefixes)
+	if len(prefixes) > 0 {
+		scope := make(map[string]string, len(rendered)+len(prefixes))
+		for p, ns := range rendered {
+			scope[p] = ns
+		}
+		for _, p := range prefixes {
+			scope[p], _ = e.lookup(p)
+		}
+		rendered = scope
+	}
+
+	attrs := make([]Attr, len(e.Attr))
+	copy(attrs, e.Attr)
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].Name.Space != attrs[j].Name.Space {
+			return attrs[i].Name.Space < attrs[j].Name.Space
+		}
+		return attrs[i].Name.Local < attrs[j].Name.Local
+	})
+
+	c.buf.WriteByte('<')
+	c.qname(e.Prefix, e.Name.Local)
+	for _, p := range prefixes {
+		if p == "" {
+			c.buf.WriteString(` xmlns="`)
+		} else {
+			c.buf.WriteString(` xmlns:` + p + `="`)
+		}
+		c.buf.WriteString(escapeAttr(rendered[p]))
+		c.buf.WriteByte('"')
+	}
+	for _, a := range attrs {
+		c.buf.WriteByte(' ')
+		c.qname(a.Prefix, a.Name.Local)
+		c.buf.WriteString(`="` + escapeAttr(a.Value) + `"`)
+	}
+	c.buf.WriteByte('>')
+
+	for _, n := range e.nodes {
+		switch n := n.(type) {
+		case *Element:
+			if n != c.exclude {
+				c.element(n, rendered)
+			}
+		case string:
+			c.buf.WriteString(escapeText(n))
+		case xml.ProcInst:
+			c.buf.WriteString("<?" + n.Target)
+			if inst := bytes.TrimLeft(n.Inst, " \t\n"); len(inst) > 0 {
+				c.buf.WriteString(" " + string(inst))
+			}
+			c.buf.WriteString("?>")
+		}
+	}
+
+	c.buf.WriteString("</")
+	c.qname(e.Prefix, e.Name.Local)
+	c.buf.WriteByte('>')
+}
+
+func (c *canonicalizer) qname(prefix, local string) {
+	if prefix != "" {
+		c.buf.WriteString(prefix + ":")
+	}
+	c.buf.WriteString(local)
+}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func escapeText(s string) string { return textEscaper.Replace(s) }
+
+func escapeAttr(s string) string { return attrEscaper.Replace(s) }
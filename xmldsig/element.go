@@ -0,0 +1,220 @@
+// Package xmldsig verifies XML Signatures with exclusive canonicalization, as used by WS-Security.
+package xmldsig
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// XMLNamespace namespace bound to xml prefix
+const XMLNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// Element of XML document, that keeps prefixes and namespace declarations as written, as canonical form depends on them
+type Element struct {
+	// Name resolved namespace and local name
+	Name   xml.Name
+	Prefix string
+	// Attr attributes without namespace declarations
+	Attr   []Attr
+	Parent *Element
+
+	// nodes child elements, text and processing instructions in document order
+	nodes []interface{}
+	// namespaces declared on element by prefix, empty prefix for default namespace
+	namespaces map[string]string
+}
+
+// Attr attribute of element
+type Attr struct {
+	// Name resolved namespace and local name
+	Name   xml.Name
+	Prefix string
+	Value  string
+}
+
+// Parse reads XML document and returns its root element. Documents with DTD are rejected.
+func Parse(doc []byte) (*Element, error) {
+	d := xml.NewDecoder(bytes.NewReader(doc))
+	var root, cur *Element
+	for {
+		t, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			if cur == nil && root != nil {
+				return nil, fmt.Errorf("document has multiple root elements")
+			}
+			e := &Element{Name: xml.Name{Local: t.Name.Local}, Prefix: t.Name.Space, Parent: cur}
+			for _, a := range t.Attr {
+				switch {
+				case a.Name.Space == "xmlns":
+					e.declare(a.Name.Local, a.Value)
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+					e.declare("", a.Value)
+				default:
+					e.Attr = append(e.Attr, Attr{Name: xml.Name{Local: a.Name.Local}, Prefix: a.Name.Space, Value: a.Value})
+				}
+			}
+			ns, ok := e.lookup(e.Prefix)
+			if !ok {
+				return nil, fmt.Errorf("namespace prefix %s is not declared", e.Prefix)
+			}
+			e.Name.Space = ns
+			for i, a := range e.Attr {
+				if a.Prefix == "" {
+					continue
+				}
+				if e.Attr[i].Name.Space, ok = e.lookup(a.Prefix); !ok {
+					return nil, fmt.Errorf("namespace prefix %s is not declared", a.Prefix)
+				}
+			}
+
+			if cur == nil {
+				root = e
+			} else {
+				cur.nodes = append(cur.nodes, e)
+			}
+			cur = e
+		case xml.EndElement:
+			if cur == nil || t.Name.Space != cur.Prefix || t.Name.Local != cur.Name.Local {
+				return nil, fmt.Errorf("unexpected end element %s", t.Name.Local)
+			}
+			cur = cur.Parent
+		case xml.CharData:
+			if cur != nil {
+				cur.nodes = append(cur.nodes, string(t))
+			}
+		case xml.ProcInst:
+			if cur != nil {
+				cur.nodes = append(cur.nodes, t.Copy())
+			}
+		case xml.Directive:
+			return nil, fmt.Errorf("DTD is not allowed")
+		}
+	}
+	if root == nil || cur != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return root, nil
+}
+
+func (e *Element) declare(prefix, ns string) {
+	if e.namespaces == nil {
+		e.namespaces = map[string]string{}
+	}
+	e.namespaces[prefix] = ns
+}
+
+// lookup resolves prefix with namespaces in scope of element, default namespace is empty if not declared
+func (e *Element) lookup(prefix string) (string, bool) {
+	if prefix == "xml" {
+		return XMLNamespace, true
+	}
+	for n := e; n != nil; n = n.Parent {
+		if ns, ok := n.namespaces[prefix]; ok {
+			return ns, true
+		}
+	}
+	return "", prefix == ""
+}
+
+// Attribute returns value of attribute
+func (e *Element) Attribute(space, local string) (string, bool) {
+	for _, a := range e.Attr {
+		if a.Name.Space == space && a.Name.Local == local {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// Elements returns child elements with name
+func (e *Element) Elements(space, local string) []*Element {
+	var r []*Element
+	for _, n := range e.nodes {
+		if c, ok := n.(*Element); ok && c.Name.Space == space && c.Name.Local == local {
+			r = append(r, c)
+		}
+	}
+	return r
+}
+
+// Children returns child elements
+func (e *Element) Children() []*Element {
+	var r []*Element
+	for _, n := range e.nodes {
+		if c, ok := n.(*Element); ok {
+			r = append(r, c)
+		}
+	}
+	return r
+}
+
+// Element returns first child element with name or nil
+func (e *Element) Element(space, local string) *Element {
+	if r := e.Elements(space, local); len(r) > 0 {
+		return r[0]
+	}
+	return nil
+}
+
+// Text returns concatenated character data of element with surrounding whitespace removed
+func (e *Element) Text() string {
+	var b strings.Builder
+	for _, n := range e.nodes {
+		if s, ok := n.(string); ok {
+			b.WriteString(s)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// Root returns root element of document
+func (e *Element) Root() *Element {
+	for e.Parent != nil {
+		e = e.Parent
+	}
+	return e
+}
+
+// ByID returns element of subtree with ID attribute: Id of any namespace, like wsu:Id, ID or xml:id.
+// IDs must be unique, as duplicates allow to substitute signed element.
+func (e *Element) ByID(id string) (*Element, error) {
+	var found *Element
+	count := 0
+	e.walk(func(n *Element) {
+		for _, a := range n.Attr {
+			if a.Value == id && (a.Name.Local == "Id" || (a.Name.Local == "ID" && a.Name.Space == "") ||
+				(a.Name.Local == "id" && a.Name.Space == XMLNamespace)) {
+				found = n
+				count++
+				return
+			}
+		}
+	})
+	if count > 1 {
+		return nil, fmt.Errorf("ID %s is not unique", id)
+	}
+	if found == nil {
+		return nil, fmt.Errorf("element with ID %s not found", id)
+	}
+	return found, nil
+}
+
+func (e *Element) walk(f func(*Element)) {
+	f(e)
+	for _, n := range e.nodes {
+		if c, ok := n.(*Element); ok {
+			c.walk(f)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package xmldsig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strings"
+
+	// Hash functions of supported algorithms
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// Namespaces and algorithm identifiers of XML Signature
+const (
+	Namespace                 = "http://www.w3.org/2000/09/xmldsig#"
+	ExclusiveC14N             = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	ExclusiveC14NWithComments = ExclusiveC14N + "WithComments"
+	EnvelopedSignature        = Namespace + "enveloped-signature"
+
+	RSASHA1     = Namespace + "rsa-sha1"
+	RSASHA256   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	RSASHA512   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	ECDSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+
+	SHA1   = Namespace + "sha1"
+	SHA256 = "http://www.w3.org/2001/04/xmlenc#sha256"
+	SHA512 = "http://www.w3.org/2001/04/xmlenc#sha512"
+)
+
+// signatureMethods hash functions of signature algorithms
+var signatureMethods = map[string]crypto.Hash{
+	RSASHA1:     crypto.SHA1,
+	RSASHA256:   crypto.SHA256,
+	RSASHA512:   crypto.SHA512,
+	ECDSASHA256: crypto.SHA256,
+}
+
+// digestMethods hash functions of digest algorithms
+var digestMethods = map[string]crypto.Hash{
+	SHA1:   crypto.SHA1,
+	SHA256: crypto.SHA256,
+	SHA512: crypto.SHA512,
+}
+
+// Verify checks value of signature with public key and digests of all its references. References must point to
+// elements of the same document by ID. It returns referenced elements, callers must check that elements they rely on
+// are among them.
+func Verify(signature *Element, key crypto.PublicKey) ([]*Element, error) {
+	if signature.Name.Space != Namespace || signature.Name.Local != "Signature" {
+		return nil, fmt.Errorf("not a signature element")
+	}
+	signedInfo := signature.Element(Namespace, "SignedInfo")
+	if signedInfo == nil {
+		return nil, fmt.Errorf("signature has no SignedInfo")
+	}
+
+	method := signedInfo.Element(Namespace, "CanonicalizationMethod")
+	if method == nil {
+		return nil, fmt.Errorf("signature has no CanonicalizationMethod")
+	}
+	inclusive, err := canonicalization(method)
+	if err != nil {
+		return nil, err
+	}
+
+	method = signedInfo.Element(Namespace, "SignatureMethod")
+	if method == nil {
+		return nil, fmt.Errorf("signature has no SignatureMethod")
+	}
+	algorithm, _ := method.Attribute("", "Algorithm")
+	hash, ok := signatureMethods[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported signature method %s", algorithm)
+	}
+	value := signature.Element(Namespace, "SignatureValue")
+	if value == nil {
+		return nil, fmt.Errorf("signature has no SignatureValue")
+	}
+	sig, err := decodeBase64(value.Text())
+	if err != nil {
+		return nil, fmt.Errorf("invalid SignatureValue: %v", err)
+	}
+
+	h := hash.New()
+	h.Write(Canonicalize(signedInfo, inclusive))
+	if err := verifySignature(key, algorithm, hash, h.Sum(nil), sig); err != nil {
+		return nil, err
+	}
+
+	references := signedInfo.Elements(Namespace, "Reference")
+	if len(references) == 0 {
+		return nil, fmt.Errorf("signature has no references")
+	}
+	var elements []*Element
+	for _, ref := range references {
+		e, err := verifyReference(signature, ref)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, e)
+	}
+	return elements, nil
+}
+
+// canonicalization returns inclusive prefixes of exclusive canonicalization method or transform
+func canonicalization(method *Element) ([]string, error) {
+	algorithm, _ := method.Attribute("", "Algorithm")
+	if algorithm != ExclusiveC14N && algorithm != ExclusiveC14NWithComments {
+		return nil, fmt.Errorf("unsupported canonicalization method %s", algorithm)
+	}
+	if ns := method.Element(ExclusiveC14N, "InclusiveNamespaces"); ns != nil {
+		list, _ := ns.Attribute("", "PrefixList")
+		return strings.Fields(list), nil
+	}
+	return nil, nil
+}
+
+// verifyReference checks digest of referenced element. Comments are never part of same-document references,
+// so both variants of exclusive canonicalization produce the same output.
+func verifyReference(signature, ref *Element) (*Element, error) {
+	uri, _ := ref.Attribute("", "URI")
+	if !strings.HasPrefix(uri, "#") || len(uri) == 1 {
+		return nil, fmt.Errorf("unsupported reference %q", uri)
+	}
+	e, err := signature.Root().ByID(uri[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	c := &canonicalizer{}
+	canonicalized := false
+	if transforms := ref.Element(Namespace, "Transforms"); transforms != nil {
+		for _, t := range transforms.Elements(Namespace, "Transform") {
+			algorithm, _ := t.Attribute("", "Algorithm")
+			if algorithm == EnvelopedSignature {
+				c.exclude = signature
+				continue
+			}
+			if c.inclusive, err = canonicalization(t); err != nil {
+				return nil, err
+			}
+			canonicalized = true
+		}
+	}
+	if !canonicalized {
+		return nil, fmt.Errorf("reference %s must use exclusive canonicalization", uri)
+	}
+
+	method := ref.Element(Namespace, "DigestMethod")
+	value := ref.Element(Namespace, "DigestValue")
+	if method == nil || value == nil {
+		return nil, fmt.Errorf("reference %s has no digest", uri)
+	}
+	algorithm, _ := method.Attribute("", "Algorithm")
+	hash, ok := digestMethods[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported digest method %s", algorithm)
+	}
+	expected, err := decodeBase64(value.Text())
+	if err != nil {
+		return nil, fmt.Errorf("invalid DigestValue of reference %s: %v", uri, err)
+	}
+
+	c.element(e, map[string]string{})
+	h := hash.New()
+	h.Write(c.buf.Bytes())
+	if !bytes.Equal(h.Sum(nil), expected) {
+		return nil, fmt.Errorf("digest of reference %s does not match", uri)
+	}
+	return e, nil
+}
+
+func verifySignature(key crypto.PublicKey, algorithm string, hash crypto.Hash, digest, sig []byte) error {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if algorithm == ECDSASHA256 {
+			break
+		}
+		if err := rsa.VerifyPKCS1v15(k, hash, digest, sig); err != nil {
+			return fmt.Errorf("invalid signature: %v", err)
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if algorithm != ECDSASHA256 {
+			break
+		}
+		// Value is concatenation of r and s of equal length
+		if len(sig) == 0 || len(sig)%2 != 0 {
+			return fmt.Errorf("invalid signature")
+		}
+		r := new(big.Int).SetBytes(sig[:len(sig)/2])
+		s := new(big.Int).SetBytes(sig[len(sig)/2:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("signature method %s does not match key %T", algorithm, key)
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+}
@@ -0,0 +1,174 @@
+package xmldsig
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	type testCase struct {
+		name      string
+		doc       string
+		id        string
+		inclusive []string
+		result    string
+	}
+
+	body := `<soap:Envelope xmlns:soap="urn:s" xmlns:a="urn:a" xmlns:unused="urn:u"><soap:Body a:z="1" b="2" ` +
+		`xmlns:wsu="urn:w" wsu:Id="b"><a:x>t &amp; &lt; &gt; "q"<![CDATA[<c>]]></a:x><?pi  data?><y xmlns="urn:d" ` +
+		`attr="v&#10;&quot;"/></soap:Body></soap:Envelope>`
+	cases := []testCase{
+		testCase{
+			name: "exclusive", doc: body, id: "b",
+			result: `<soap:Body xmlns:a="urn:a" xmlns:soap="urn:s" xmlns:wsu="urn:w" b="2" a:z="1" wsu:Id="b">` +
+				`<a:x>t &amp; &lt; &gt; "q"&lt;c&gt;</a:x><?pi data?><y xmlns="urn:d" attr="v&#xA;&quot;"></y></soap:Body>`,
+		},
+		testCase{
+			name: "inclusive prefixes", doc: body, id: "b", inclusive: []string{"unused", "missing"},
+			result: `<soap:Body xmlns:a="urn:a" xmlns:soap="urn:s" xmlns:unused="urn:u" xmlns:wsu="urn:w" b="2" a:z="1" wsu:Id="b">` +
+				`<a:x>t &amp; &lt; &gt; "q"&lt;c&gt;</a:x><?pi data?><y xmlns="urn:d" attr="v&#xA;&quot;"></y></soap:Body>`,
+		},
+		testCase{
+			name: "undeclared default", doc: `<r xmlns="urn:d" Id="r"><c xmlns="" Id="c"><d xmlns=""/></c></r>`, id: "r",
+			result: `<r xmlns="urn:d" Id="r"><c xmlns="" Id="c"><d></d></c></r>`,
+		},
+		testCase{
+			name: "empty default of apex", doc: `<r xmlns="urn:d" Id="r"><c xmlns="" Id="c"><d xmlns=""/></c></r>`, id: "c",
+			result: `<c Id="c"><d></d></c>`,
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			root, err := Parse([]byte(cs.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			e, err := root.ByID(cs.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r := string(Canonicalize(e, cs.inclusive)); r != cs.result {
+				t.Errorf("expected\n%s\ngot\n%s", cs.result, r)
+			}
+		})
+	}
+}
+
+func TestParseDTD(t *testing.T) {
+	_, err := Parse([]byte(`<!DOCTYPE r [<!ENTITY a "aaaa">]><r>&a;</r>`))
+	if err == nil {
+		t.Error("expected document with DTD to be rejected")
+	}
+}
+
+const signedTemplate = `<s:Envelope xmlns:s="urn:s" xmlns:wsu="urn:w"><s:Header>` +
+	`<ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#"><ds:SignedInfo>` +
+	`<ds:CanonicalizationMethod Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"/>` +
+	`<ds:SignatureMethod Algorithm="{method}"/><ds:Reference URI="#body"><ds:Transforms>` +
+	`<ds:Transform Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"/></ds:Transforms>` +
+	`<ds:DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"/><ds:DigestValue>{digest}</ds:DigestValue>` +
+	`</ds:Reference></ds:SignedInfo><ds:SignatureValue>{signature}</ds:SignatureValue></ds:Signature>` +
+	`</s:Header><s:Body wsu:Id="body"><m:Get xmlns:m="urn:m">1</m:Get></s:Body></s:Envelope>`
+
+// sign fills digest of body and signature value of template
+func sign(t *testing.T, method string, key crypto.Signer) string {
+	doc := strings.Replace(signedTemplate, "{method}", method, 1)
+	root, err := Parse([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := root.ByID("body")
+	digest := sha256.Sum256(Canonicalize(body, nil))
+	doc = strings.Replace(doc, "{digest}", base64.StdEncoding.EncodeToString(digest[:]), 1)
+
+	root, _ = Parse([]byte(doc))
+	signedInfo := root.Element("urn:s", "Header").Element(Namespace, "Signature").Element(Namespace, "SignedInfo")
+	h := crypto.SHA256
+	if method == RSASHA1 {
+		h = crypto.SHA1
+	}
+	hash := h.New()
+	hash.Write(Canonicalize(signedInfo, nil))
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, h, hash.Sum(nil))
+	case *ecdsa.PrivateKey:
+		r, s, e := ecdsa.Sign(rand.Reader, k, hash.Sum(nil))
+		err = e
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Replace(doc, "{signature}", base64.StdEncoding.EncodeToString(sig), 1)
+}
+
+func TestVerify(t *testing.T) {
+	type testCase struct {
+		name   string
+		doc    string
+		key    crypto.PublicKey
+		result string
+	}
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	signed := sign(t, RSASHA256, rsaKey)
+
+	cases := []testCase{
+		testCase{name: "rsa-sha256", doc: signed, key: &rsaKey.PublicKey},
+		testCase{name: "rsa-sha1", doc: sign(t, RSASHA1, rsaKey), key: &rsaKey.PublicKey},
+		testCase{name: "ecdsa-sha256", doc: sign(t, ECDSASHA256, ecKey), key: &ecKey.PublicKey},
+		testCase{name: "other key", doc: signed, key: &otherKey.PublicKey, result: "invalid signature"},
+		testCase{name: "key of other type", doc: signed, key: &ecKey.PublicKey, result: "does not match key"},
+		testCase{
+			name: "tampered body", doc: strings.Replace(signed, ">1<", ">2<", 1), key: &rsaKey.PublicKey,
+			result: "digest of reference #body does not match",
+		},
+		testCase{
+			name: "wrapped body", key: &rsaKey.PublicKey, result: "not unique",
+			doc: strings.Replace(signed, "<s:Header>", `<s:Header><w wsu:Id="body"/>`, 1),
+		},
+		testCase{
+			name: "inclusive canonicalization", key: &rsaKey.PublicKey, result: "unsupported canonicalization",
+			doc: strings.Replace(signed, "http://www.w3.org/2001/10/xml-exc-c14n#\"/><ds:SignatureMethod",
+				"http://www.w3.org/TR/2001/REC-xml-c14n-20010315\"/><ds:SignatureMethod", 1),
+		},
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			root, err := Parse([]byte(cs.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sig := root.Element("urn:s", "Header").Element(Namespace, "Signature")
+			refs, err := Verify(sig, cs.key)
+			if cs.result == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(refs) != 1 || refs[0] != root.Element("urn:s", "Body") {
+					t.Errorf("expected body to be referenced, got %v", refs)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), cs.result) {
+				t.Errorf("expected error %q, got %v", cs.result, err)
+			}
+		})
+	}
+}
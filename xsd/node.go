@@ -0,0 +1,141 @@
+// Package xsd validates XML documents against subset of XML Schema 1.0 commonly used in WSDL documents.
+package xsd
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// Namespaces of XML Schema and schema instance attributes
+const (
+	Namespace         = "http://www.w3.org/2001/XMLSchema"
+	InstanceNamespace = "http://www.w3.org/2001/XMLSchema-instance"
+	xmlnsNamespace    = "xmlns"
+)
+
+// Node element of XML document, that keeps namespace declarations in scope to resolve QName values
+type Node struct {
+	Name     xml.Name
+	Attr     []xml.Attr
+	Children []*Node
+	// Text concatenated character data of element
+	Text   string
+	Parent *Node
+
+	// namespaces declared on element by prefix, empty prefix for default namespace
+	namespaces map[string]string
+}
+
+// Parse reads XML document into tree of nodes and returns its root element
+func Parse(r io.Reader) (*Node, error) {
+	d := xml.NewDecoder(r)
+	var root, cur *Node
+	text := &strings.Builder{}
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			n := &Node{Name: t.Name, Parent: cur}
+			for _, a := range t.Attr {
+				switch {
+				case a.Name.Space == xmlnsNamespace:
+					n.declare(a.Name.Local, a.Value)
+				case a.Name.Space == "" && a.Name.Local == xmlnsNamespace:
+					n.declare("", a.Value)
+				default:
+					n.Attr = append(n.Attr, a)
+				}
+			}
+			if cur == nil {
+				root = n
+			} else {
+				cur.Text += text.String()
+				cur.Children = append(cur.Children, n)
+			}
+			text.Reset()
+			cur = n
+		case xml.EndElement:
+			cur.Text += text.String()
+			text.Reset()
+			cur = cur.Parent
+		case xml.CharData:
+			if cur != nil {
+				text.Write(t)
+			}
+		}
+	}
+	if root == nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return root, nil
+}
+
+func (n *Node) declare(prefix, ns string) {
+	if n.namespaces == nil {
+		n.namespaces = map[string]string{}
+	}
+	n.namespaces[prefix] = ns
+}
+
+// Attribute returns value of attribute
+func (n *Node) Attribute(space, local string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Name.Space == space && a.Name.Local == local {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// attr returns value of unqualified attribute, or empty string
+func (n *Node) attr(local string) string {
+	v, _ := n.Attribute("", local)
+	return v
+}
+
+// ResolveQName resolves prefix of QName value with namespaces in scope of element
+func (n *Node) ResolveQName(qname string) xml.Name {
+	qname = strings.TrimSpace(qname)
+	prefix, local := "", qname
+	if i := strings.IndexByte(qname, ':'); i >= 0 {
+		prefix, local = qname[:i], qname[i+1:]
+	}
+	for e := n; e != nil; e = e.Parent {
+		if ns, ok := e.namespaces[prefix]; ok {
+			return xml.Name{Space: ns, Local: local}
+		}
+	}
+	if prefix == "xml" {
+		return xml.Name{Space: "http://www.w3.org/XML/1998/namespace", Local: local}
+	}
+	return xml.Name{Space: prefix, Local: local}
+}
+
+// Elements returns child elements with name
+func (n *Node) Elements(space, local string) []*Node {
+	var r []*Node
+	for _, c := range n.Children {
+		if c.Name.Space == space && c.Name.Local == local {
+			r = append(r, c)
+		}
+	}
+	return r
+}
+
+// Element returns first child element with name or nil
+func (n *Node) Element(space, local string) *Node {
+	for _, c := range n.Children {
+		if c.Name.Space == space && c.Name.Local == local {
+			return c
+		}
+	}
+	return nil
+}
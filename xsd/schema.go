@@ -0,0 +1,96 @@
+package xsd
+
+import (
+	"encoding/xml"
+	"fmt"
+)
+
+// Set global declarations of schemas, documents are validated against
+type Set struct {
+	elements        map[xml.Name]*Node
+	types           map[xml.Name]*Node
+	groups          map[xml.Name]*Node
+	attributeGroups map[xml.Name]*Node
+	attributes      map[xml.Name]*Node
+}
+
+// NewSet creates empty schema set
+func NewSet() *Set {
+	return &Set{
+		elements:        map[xml.Name]*Node{},
+		types:           map[xml.Name]*Node{},
+		groups:          map[xml.Name]*Node{},
+		attributeGroups: map[xml.Name]*Node{},
+		attributes:      map[xml.Name]*Node{},
+	}
+}
+
+// Add adds global declarations of xsd:schema element to set. Imports and includes are not followed,
+// their schemas have to be added separately.
+func (s *Set) Add(schema *Node) error {
+	if schema.Name.Space != Namespace || schema.Name.Local != "schema" {
+		return fmt.Errorf("%s is not an XML schema", schema.Name.Local)
+	}
+
+	tns := schema.attr("targetNamespace")
+	for _, c := range schema.Children {
+		if c.Name.Space != Namespace {
+			continue
+		}
+		var m map[xml.Name]*Node
+		switch c.Name.Local {
+		case "element":
+			m = s.elements
+		case "complexType", "simpleType":
+			m = s.types
+		case "group":
+			m = s.groups
+		case "attributeGroup":
+			m = s.attributeGroups
+		case "attribute":
+			m = s.attributes
+		default:
+			continue
+		}
+		name := xml.Name{Space: tns, Local: c.attr("name")}
+		if _, ok := m[name]; ok {
+			return fmt.Errorf("%s {%s}%s is declared more than once", c.Name.Local, name.Space, name.Local)
+		}
+		m[name] = c
+	}
+	return nil
+}
+
+// Element returns global element declaration
+func (s *Set) Element(name xml.Name) (*Node, bool) {
+	e, ok := s.elements[name]
+	return e, ok
+}
+
+// schemaOf returns xsd:schema element, that contains declaration
+func schemaOf(n *Node) *Node {
+	for ; n != nil; n = n.Parent {
+		if n.Name.Space == Namespace && n.Name.Local == "schema" {
+			return n
+		}
+	}
+	return &Node{}
+}
+
+// occurs returns minOccurs and maxOccurs of particle, -1 for unbounded
+func occurs(p *Node) (int, int, error) {
+	min, max := 1, 1
+	if v := p.attr("minOccurs"); v != "" {
+		if _, err := fmt.Sscan(v, &min); err != nil {
+			return 0, 0, fmt.Errorf("invalid minOccurs %s", v)
+		}
+	}
+	if v := p.attr("maxOccurs"); v == "unbounded" {
+		max = -1
+	} else if v != "" {
+		if _, err := fmt.Sscan(v, &max); err != nil {
+			return 0, 0, fmt.Errorf("invalid maxOccurs %s", v)
+		}
+	}
+	return min, max, nil
+}
@@ -0,0 +1,274 @@
+package xsd
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	decimalPattern  = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)$`)
+	integerPattern  = regexp.MustCompile(`^[+-]?\d+$`)
+	datePattern     = regexp.MustCompile(`^(-?\d{4,})-(\d{2})-(\d{2})(Z|[+-]\d{2}:\d{2})?$`)
+	timePattern     = regexp.MustCompile(`^(\d{2}):(\d{2}):(\d{2})(\.\d+)?(Z|[+-]\d{2}:\d{2})?$`)
+	durationPattern = regexp.MustCompile(`^-?P(\d+Y)?(\d+M)?(\d+D)?(T(\d+H)?(\d+M)?(\d+(\.\d+)?S)?)?$`)
+)
+
+// integerRanges bounds of built-in integer types, nil bound is unlimited
+var integerRanges = map[string][2]*big.Int{
+	"integer":            {nil, nil},
+	"long":               {big.NewInt(-1 << 63), big.NewInt(1<<63 - 1)},
+	"int":                {big.NewInt(-1 << 31), big.NewInt(1<<31 - 1)},
+	"short":              {big.NewInt(-1 << 15), big.NewInt(1<<15 - 1)},
+	"byte":               {big.NewInt(-1 << 7), big.NewInt(1<<7 - 1)},
+	"nonNegativeInteger": {big.NewInt(0), nil},
+	"positiveInteger":    {big.NewInt(1), nil},
+	"nonPositiveInteger": {nil, big.NewInt(0)},
+	"negativeInteger":    {nil, big.NewInt(-1)},
+	"unsignedLong":       {big.NewInt(0), new(big.Int).SetUint64(1<<64 - 1)},
+	"unsignedInt":        {big.NewInt(0), big.NewInt(1<<32 - 1)},
+	"unsignedShort":      {big.NewInt(0), big.NewInt(1<<16 - 1)},
+	"unsignedByte":       {big.NewInt(0), big.NewInt(1<<8 - 1)},
+}
+
+// validateSimple validates value against simple type, facets of restrictions are checked from base to derived type
+func (s *Set) validateSimple(t typeDef, value string) error {
+	return s.simple(t, value, 0)
+}
+
+func (s *Set) simple(t typeDef, value string, depth int) error {
+	if depth > 32 {
+		return fmt.Errorf("type derivation is too deep")
+	}
+	if t.node == nil {
+		return builtin(t.builtin, value)
+	}
+	if t.node.Name.Local != "simpleType" {
+		return fmt.Errorf("complex type used for simple content")
+	}
+
+	for _, c := range t.node.Children {
+		if c.Name.Space != Namespace {
+			continue
+		}
+		switch c.Name.Local {
+		case "restriction":
+			base := typeDef{builtin: "anySimpleType"}
+			if b := c.attr("base"); b != "" {
+				base = s.resolveType(c, b)
+			} else if st := c.Element(Namespace, "simpleType"); st != nil {
+				base = typeDef{node: st}
+			}
+			if err := s.simple(base, value, depth+1); err != nil {
+				return err
+			}
+			return facets(c, value, s.isList(base))
+		case "list":
+			item := typeDef{builtin: "anySimpleType"}
+			if it := c.attr("itemType"); it != "" {
+				item = s.resolveType(c, it)
+			} else if st := c.Element(Namespace, "simpleType"); st != nil {
+				item = typeDef{node: st}
+			}
+			for _, v := range strings.Fields(value) {
+				if err := s.simple(item, v, depth+1); err != nil {
+					return err
+				}
+			}
+			return nil
+		case "union":
+			var members []typeDef
+			for _, m := range strings.Fields(c.attr("memberTypes")) {
+				members = append(members, s.resolveType(c, m))
+			}
+			for _, st := range c.Elements(Namespace, "simpleType") {
+				members = append(members, typeDef{node: st})
+			}
+			for _, m := range members {
+				if s.simple(m, value, depth+1) == nil {
+					return nil
+				}
+			}
+			return fmt.Errorf("value %q does not match any member of union", value)
+		}
+	}
+	return nil
+}
+
+// isList reports whether simple type is list type, its length facets count items
+func (s *Set) isList(t typeDef) bool {
+	for i := 0; t.node != nil && i < 32; i++ {
+		if t.node.Element(Namespace, "list") != nil {
+			return true
+		}
+		r := t.node.Element(Namespace, "restriction")
+		if r == nil {
+			return false
+		}
+		if b := r.attr("base"); b != "" {
+			t = s.resolveType(r, b)
+		} else if st := r.Element(Namespace, "simpleType"); st != nil {
+			t = typeDef{node: st}
+		} else {
+			return false
+		}
+	}
+	return false
+}
+
+// facets checks value against constraining facets of restriction
+func facets(r *Node, value string, list bool) error {
+	var enumeration []string
+	collapsed := strings.Join(strings.Fields(value), " ")
+	length := utf8.RuneCountInString(value)
+	if list {
+		length = len(strings.Fields(value))
+	}
+
+	for _, f := range r.Children {
+		if f.Name.Space != Namespace {
+			continue
+		}
+		v := f.attr("value")
+		switch f.Name.Local {
+		case "enumeration":
+			enumeration = append(enumeration, v)
+		case "pattern":
+			// XML Schema regular expressions are implicitly anchored. Patterns using constructs
+			// Go does not support, like \i and \c, are not checked.
+			if re, err := regexp.Compile(`^(?:` + v + `)$`); err == nil && !re.MatchString(value) {
+				return fmt.Errorf("value %q does not match pattern %s", value, v)
+			}
+		case "length", "minLength", "maxLength":
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s facet %s", f.Name.Local, v)
+			}
+			if (f.Name.Local == "length" && length != n) || (f.Name.Local == "minLength" && length < n) ||
+				(f.Name.Local == "maxLength" && length > n) {
+				return fmt.Errorf("length of value %q violates %s %d", value, f.Name.Local, n)
+			}
+		case "minInclusive", "maxInclusive", "minExclusive", "maxExclusive":
+			c, ok := compare(collapsed, v)
+			if !ok {
+				continue
+			}
+			if (f.Name.Local == "minInclusive" && c < 0) || (f.Name.Local == "maxInclusive" && c > 0) ||
+				(f.Name.Local == "minExclusive" && c <= 0) || (f.Name.Local == "maxExclusive" && c >= 0) {
+				return fmt.Errorf("value %q violates %s %s", value, f.Name.Local, v)
+			}
+		case "totalDigits", "fractionDigits":
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s facet %s", f.Name.Local, v)
+			}
+			total, fraction := digits(collapsed)
+			if (f.Name.Local == "totalDigits" && total > n) || (f.Name.Local == "fractionDigits" && fraction > n) {
+				return fmt.Errorf("value %q violates %s %d", value, f.Name.Local, n)
+			}
+		}
+	}
+
+	if len(enumeration) > 0 {
+		for _, e := range enumeration {
+			if e == value || e == collapsed {
+				return nil
+			}
+		}
+		return fmt.Errorf("value %q is not one of enumeration", value)
+	}
+	return nil
+}
+
+// compare compares values numerically, or lexically for dates and times of the same format
+func compare(a, b string) (int, bool) {
+	x, okx := new(big.Float).SetString(a)
+	y, oky := new(big.Float).SetString(b)
+	if okx && oky {
+		return x.Cmp(y), true
+	}
+	if len(a) == len(b) && (datePattern.MatchString(b) || timePattern.MatchString(b) || strings.Contains(b, "T")) {
+		return strings.Compare(a, b), true
+	}
+	return 0, false
+}
+
+// digits counts total and fraction digits of decimal, ignoring leading and trailing zeros
+func digits(v string) (int, int) {
+	v = strings.TrimLeft(v, "+-")
+	intPart, frac := v, ""
+	if i := strings.IndexByte(v, '.'); i >= 0 {
+		intPart, frac = v[:i], v[i+1:]
+	}
+	intPart = strings.TrimLeft(intPart, "0")
+	frac = strings.TrimRight(frac, "0")
+	return len(intPart) + len(frac), len(frac)
+}
+
+// builtin validates value of built-in simple type, unknown types accept any value
+func builtin(t, value string) error {
+	v := strings.TrimSpace(value)
+	ok := true
+	switch t {
+	case "boolean":
+		ok = v == "true" || v == "false" || v == "1" || v == "0"
+	case "decimal":
+		ok = decimalPattern.MatchString(v)
+	case "float", "double":
+		_, err := strconv.ParseFloat(v, 64)
+		ok = v == "INF" || v == "-INF" || v == "NaN" || (err == nil && !strings.ContainsAny(v, "xXpP_") &&
+			!strings.EqualFold(strings.TrimLeft(v, "+-"), "inf") && !strings.EqualFold(strings.TrimLeft(v, "+-"), "infinity"))
+	case "date":
+		ok = validDate(datePattern.FindStringSubmatch(v))
+	case "dateTime":
+		i := strings.IndexByte(v, 'T')
+		ok = i > 0 && validDate(datePattern.FindStringSubmatch(v[:i])) && validTime(v[i+1:])
+	case "time":
+		ok = validTime(v)
+	case "duration":
+		ok = durationPattern.MatchString(v) && v != "P" && v != "-P" && !strings.HasSuffix(v, "T")
+	case "base64Binary":
+		_, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(v), ""))
+		ok = err == nil
+	case "hexBinary":
+		_, err := hex.DecodeString(v)
+		ok = err == nil
+	default:
+		r, integer := integerRanges[t]
+		if !integer {
+			return nil
+		}
+		n, valid := new(big.Int).SetString(strings.TrimPrefix(v, "+"), 10)
+		ok = valid && integerPattern.MatchString(v) && (r[0] == nil || n.Cmp(r[0]) >= 0) && (r[1] == nil || n.Cmp(r[1]) <= 0)
+	}
+	if !ok {
+		return fmt.Errorf("value %q is not a valid %s", value, t)
+	}
+	return nil
+}
+
+func validDate(m []string) bool {
+	if m == nil || len(m[1]) != 4 {
+		return m != nil
+	}
+	_, err := time.Parse("2006-01-02", m[1]+"-"+m[2]+"-"+m[3])
+	return err == nil
+}
+
+func validTime(v string) bool {
+	m := timePattern.FindStringSubmatch(v)
+	if m == nil {
+		return false
+	}
+	if m[1] == "24" && m[2] == "00" && m[3] == "00" {
+		return true
+	}
+	_, err := time.Parse("15:04:05", m[1]+":"+m[2]+":"+m[3])
+	return err == nil
+}
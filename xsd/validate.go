@@ -0,0 +1,531 @@
+package xsd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// typeDef definition of type: schema node of complexType or simpleType, or built-in type by local name
+type typeDef struct {
+	node    *Node
+	builtin string
+}
+
+var anyType = typeDef{builtin: "anyType"}
+
+// Validate validates element against its global declaration
+func (s *Set) Validate(n *Node) error {
+	decl, ok := s.elements[n.Name]
+	if !ok {
+		return fmt.Errorf("element %s is not declared", name(n.Name))
+	}
+	return s.validateElement(n, decl)
+}
+
+func (s *Set) validateElement(n, decl *Node) error {
+	if ref := decl.attr("ref"); ref != "" {
+		global, ok := s.elements[decl.ResolveQName(ref)]
+		if !ok {
+			return fmt.Errorf("element %s is not declared", ref)
+		}
+		decl = global
+	}
+
+	if v, ok := n.Attribute(InstanceNamespace, "nil"); ok && (v == "true" || v == "1") {
+		if decl.attr("nillable") != "true" {
+			return fmt.Errorf("element %s is not nillable", name(n.Name))
+		}
+		if len(n.Children) > 0 || strings.TrimSpace(n.Text) != "" {
+			return fmt.Errorf("nil element %s must be empty", name(n.Name))
+		}
+		return nil
+	}
+
+	t := s.elementType(decl)
+	if v, ok := n.Attribute(InstanceNamespace, "type"); ok {
+		t = s.resolveType(n, v)
+	}
+	if err := s.validateType(n, t); err != nil {
+		return fmt.Errorf("%s: %v", n.Name.Local, err)
+	}
+	return nil
+}
+
+// elementType returns type of element declaration, named or anonymous
+func (s *Set) elementType(decl *Node) typeDef {
+	if t := decl.attr("type"); t != "" {
+		return s.resolveType(decl, t)
+	}
+	for _, c := range decl.Children {
+		if c.Name.Space == Namespace && (c.Name.Local == "complexType" || c.Name.Local == "simpleType") {
+			return typeDef{node: c}
+		}
+	}
+	return anyType
+}
+
+// resolveType resolves type QName in scope of node. Unknown types are treated as anyType.
+func (s *Set) resolveType(n *Node, qname string) typeDef {
+	name := n.ResolveQName(qname)
+	if name.Space == Namespace {
+		return typeDef{builtin: name.Local}
+	}
+	if t, ok := s.types[name]; ok {
+		return typeDef{node: t}
+	}
+	return anyType
+}
+
+func (s *Set) validateType(n *Node, t typeDef) error {
+	if t.builtin == "anyType" {
+		return nil
+	}
+	if t.node == nil || t.node.Name.Local == "simpleType" {
+		if len(n.Children) > 0 {
+			return fmt.Errorf("unexpected element %s in simple content", name(n.Children[0].Name))
+		}
+		if err := s.validateAttributes(n, nil); err != nil {
+			return err
+		}
+		return s.validateSimple(t, n.Text)
+	}
+	return s.validateComplex(n, t.node)
+}
+
+// complexModel content model of complex type with derivation resolved
+type complexModel struct {
+	// attributes declarations, attribute groups and anyAttribute of type and its bases
+	attributes []*Node
+	// particles content particles of type and its bases in order
+	particles []*Node
+	// simple type of simple content
+	simple *typeDef
+	mixed  bool
+}
+
+func (s *Set) model(t *Node, m *complexModel, depth int) error {
+	if depth > 32 {
+		return fmt.Errorf("type derivation is too deep")
+	}
+	if t.attr("mixed") == "true" {
+		m.mixed = true
+	}
+
+	for _, c := range t.Children {
+		if c.Name.Space != Namespace {
+			continue
+		}
+		switch c.Name.Local {
+		case "sequence", "choice", "all", "group":
+			m.particles = append(m.particles, c)
+		case "attribute", "attributeGroup", "anyAttribute":
+			m.attributes = append(m.attributes, c)
+		case "complexContent", "simpleContent":
+			if c.attr("mixed") == "true" {
+				m.mixed = true
+			}
+			for _, d := range c.Children {
+				if d.Name.Space != Namespace || (d.Name.Local != "extension" && d.Name.Local != "restriction") {
+					continue
+				}
+				base := s.resolveType(d, d.attr("base"))
+				switch {
+				case c.Name.Local == "simpleContent" && base.node != nil && base.node.Name.Local == "complexType":
+					// Simple content derived from complex type with simple content
+					if err := s.model(base.node, m, depth+1); err != nil {
+						return err
+					}
+					m.particles = nil
+				case c.Name.Local == "simpleContent":
+					m.simple = &base
+				case d.Name.Local == "extension" && base.node != nil:
+					if err := s.model(base.node, m, depth+1); err != nil {
+						return err
+					}
+				}
+				if err := s.model(d, m, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Set) validateComplex(n *Node, t *Node) error {
+	m := &complexModel{}
+	if err := s.model(t, m, 0); err != nil {
+		return err
+	}
+
+	if err := s.validateAttributes(n, m.attributes); err != nil {
+		return err
+	}
+
+	if m.simple != nil {
+		if len(n.Children) > 0 {
+			return fmt.Errorf("unexpected element %s in simple content", name(n.Children[0].Name))
+		}
+		return s.validateSimple(*m.simple, n.Text)
+	}
+	if !m.mixed && strings.TrimSpace(n.Text) != "" {
+		return fmt.Errorf("unexpected text in element only content")
+	}
+
+	mt := &matcher{set: s, children: n.Children, decls: map[int]*Node{}, lax: map[int]bool{}}
+	ends := []int{0}
+	for _, p := range m.particles {
+		var err error
+		if ends, err = mt.particle(p, ends); err != nil {
+			return err
+		}
+	}
+
+	complete := false
+	for _, e := range ends {
+		complete = complete || e == len(n.Children)
+	}
+	if !complete {
+		if mt.furthest < len(n.Children) {
+			return fmt.Errorf("unexpected element %s", name(n.Children[mt.furthest].Name))
+		}
+		return fmt.Errorf("required element is missing")
+	}
+
+	for i, c := range n.Children {
+		if decl, ok := mt.decls[i]; ok {
+			if err := s.validateElement(c, decl); err != nil {
+				return err
+			}
+		} else if mt.lax[i] {
+			if decl, ok := s.elements[c.Name]; ok {
+				if err := s.validateElement(c, decl); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// matcher matches child elements against particles of content model. Each step maps set of start positions
+// to set of end positions, declarations of matched elements are recorded by position.
+// Schemas obey Unique Particle Attribution, so child is matched by the same declaration on every path.
+type matcher struct {
+	set      *Set
+	children []*Node
+	decls    map[int]*Node
+	lax      map[int]bool
+	furthest int
+}
+
+func (m *matcher) particle(p *Node, from []int) ([]int, error) {
+	min, max, err := occurs(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var ends []int
+	if min == 0 {
+		ends = from
+	}
+	cur := from
+	for i := 1; max < 0 || i <= max; i++ {
+		next, err := m.once(p, cur)
+		if err != nil {
+			return nil, err
+		}
+		if len(next) == 0 || (i > min && same(next, cur)) {
+			break
+		}
+		cur = next
+		if i >= min {
+			ends = union(ends, cur)
+		}
+		// Unbounded particle can not consume more than all children, after that only empty matches repeat
+		if max < 0 && i > min && i > len(m.children)+1 {
+			break
+		}
+	}
+	return ends, nil
+}
+
+func (m *matcher) once(p *Node, from []int) ([]int, error) {
+	var ends []int
+	switch p.Name.Local {
+	case "element":
+		name, decl := m.elementName(p)
+		for _, pos := range from {
+			if pos < len(m.children) && m.children[pos].Name == name {
+				m.decls[pos] = decl
+				ends = union(ends, []int{pos + 1})
+			}
+		}
+	case "any":
+		for _, pos := range from {
+			if pos < len(m.children) && matchesNamespace(p, m.children[pos].Name.Space) {
+				if p.attr("processContents") != "skip" {
+					m.lax[pos] = true
+				}
+				ends = union(ends, []int{pos + 1})
+			}
+		}
+	case "sequence":
+		ends = from
+		for _, c := range p.Children {
+			if !isParticle(c) {
+				continue
+			}
+			var err error
+			if ends, err = m.particle(c, ends); err != nil {
+				return nil, err
+			}
+			if len(ends) == 0 {
+				break
+			}
+		}
+	case "choice":
+		for _, c := range p.Children {
+			if !isParticle(c) {
+				continue
+			}
+			e, err := m.particle(c, from)
+			if err != nil {
+				return nil, err
+			}
+			ends = union(ends, e)
+		}
+	case "all":
+		for _, pos := range from {
+			if end, ok := m.all(p, pos); ok {
+				ends = union(ends, []int{end})
+			}
+		}
+	case "group":
+		g, ok := m.set.groups[p.ResolveQName(p.attr("ref"))]
+		if !ok {
+			return nil, fmt.Errorf("group %s is not declared", p.attr("ref"))
+		}
+		for _, c := range g.Children {
+			if isParticle(c) {
+				// Occurrence of group is set by reference
+				return m.once(c, from)
+			}
+		}
+		ends = from
+	}
+
+	for _, e := range ends {
+		if e > m.furthest {
+			m.furthest = e
+		}
+	}
+	return ends, nil
+}
+
+// all matches elements of xsd:all in any order, each at most once
+func (m *matcher) all(p *Node, pos int) (int, bool) {
+	used := map[*Node]bool{}
+	for ; pos < len(m.children); pos++ {
+		found := false
+		for _, c := range p.Children {
+			if c.Name.Space != Namespace || c.Name.Local != "element" || used[c] {
+				continue
+			}
+			if name, decl := m.elementName(c); name == m.children[pos].Name {
+				used[c] = true
+				m.decls[pos] = decl
+				found = true
+				break
+			}
+		}
+		if !found {
+			break
+		}
+	}
+	for _, c := range p.Children {
+		if c.Name.Space == Namespace && c.Name.Local == "element" && !used[c] {
+			if min, _, _ := occurs(c); min > 0 {
+				return 0, false
+			}
+		}
+	}
+	return pos, true
+}
+
+// elementName returns name of elements matched by element particle and declaration to validate them with
+func (m *matcher) elementName(p *Node) (xml.Name, *Node) {
+	if ref := p.attr("ref"); ref != "" {
+		return p.ResolveQName(ref), p
+	}
+
+	schema := schemaOf(p)
+	form := p.attr("form")
+	if form == "" {
+		form = schema.attr("elementFormDefault")
+	}
+	// Global declarations are always qualified
+	if form == "qualified" || p.Parent == schema {
+		return xml.Name{Space: schema.attr("targetNamespace"), Local: p.attr("name")}, p
+	}
+	return xml.Name{Local: p.attr("name")}, p
+}
+
+func (s *Set) validateAttributes(n *Node, decls []*Node) error {
+	allowed := map[xml.Name]*Node{}
+	anyAttribute := false
+
+	var collect func(decls []*Node, depth int) error
+	collect = func(decls []*Node, depth int) error {
+		if depth > 32 {
+			return fmt.Errorf("attribute groups are nested too deep")
+		}
+		for _, d := range decls {
+			switch d.Name.Local {
+			case "anyAttribute":
+				anyAttribute = true
+			case "attributeGroup":
+				g, ok := s.attributeGroups[d.ResolveQName(d.attr("ref"))]
+				if !ok {
+					return fmt.Errorf("attribute group %s is not declared", d.attr("ref"))
+				}
+				if err := collect(g.Children, depth+1); err != nil {
+					return err
+				}
+			case "attribute":
+				if d.attr("use") == "prohibited" {
+					continue
+				}
+				allowed[s.attributeName(d)] = d
+			}
+		}
+		return nil
+	}
+	if err := collect(decls, 0); err != nil {
+		return err
+	}
+
+	for _, a := range n.Attr {
+		if a.Name.Space == InstanceNamespace {
+			continue
+		}
+		d, ok := allowed[a.Name]
+		if !ok {
+			if anyAttribute {
+				continue
+			}
+			return fmt.Errorf("unexpected attribute %s", name(a.Name))
+		}
+		if err := s.validateSimple(s.attributeType(d), a.Value); err != nil {
+			return fmt.Errorf("attribute %s: %v", a.Name.Local, err)
+		}
+	}
+	for an, d := range allowed {
+		if d.attr("use") != "required" {
+			continue
+		}
+		if _, ok := n.Attribute(an.Space, an.Local); !ok {
+			return fmt.Errorf("required attribute %s is missing", an.Local)
+		}
+	}
+	return nil
+}
+
+func (s *Set) attributeName(d *Node) xml.Name {
+	if ref := d.attr("ref"); ref != "" {
+		return d.ResolveQName(ref)
+	}
+	schema := schemaOf(d)
+	form := d.attr("form")
+	if form == "" {
+		form = schema.attr("attributeFormDefault")
+	}
+	if form == "qualified" || d.Parent == schema {
+		return xml.Name{Space: schema.attr("targetNamespace"), Local: d.attr("name")}
+	}
+	return xml.Name{Local: d.attr("name")}
+}
+
+func (s *Set) attributeType(d *Node) typeDef {
+	if ref := d.attr("ref"); ref != "" {
+		if g, ok := s.attributes[d.ResolveQName(ref)]; ok {
+			d = g
+		}
+	}
+	if t := d.attr("type"); t != "" {
+		return s.resolveType(d, t)
+	}
+	if st := d.Element(Namespace, "simpleType"); st != nil {
+		return typeDef{node: st}
+	}
+	return typeDef{builtin: "anySimpleType"}
+}
+
+// matchesNamespace checks namespace of element against namespace constraint of xsd:any
+func matchesNamespace(p *Node, ns string) bool {
+	tns := schemaOf(p).attr("targetNamespace")
+	constraint := p.attr("namespace")
+	if constraint == "" {
+		constraint = "##any"
+	}
+	for _, c := range strings.Fields(constraint) {
+		switch c {
+		case "##any":
+			return true
+		case "##other":
+			return ns != tns && ns != ""
+		case "##targetNamespace":
+			if ns == tns {
+				return true
+			}
+		case "##local":
+			if ns == "" {
+				return true
+			}
+		default:
+			if ns == c {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isParticle(n *Node) bool {
+	if n.Name.Space != Namespace {
+		return false
+	}
+	switch n.Name.Local {
+	case "element", "any", "sequence", "choice", "group", "all":
+		return true
+	}
+	return false
+}
+
+func union(a, b []int) []int {
+	for _, v := range b {
+		found := false
+		for _, w := range a {
+			if v == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			a = append(a[:len(a):len(a)], v)
+		}
+	}
+	return a
+}
+
+func same(a, b []int) bool {
+	return len(union(a, b)) == len(a) && len(a) == len(b)
+}
+
+func name(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return "{" + n.Space + "}" + n.Local
+}
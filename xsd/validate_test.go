@@ -0,0 +1,177 @@
+package xsd
+
+import (
+	"strings"
+	"testing"
+)
+
+const testSchema = `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:tns="urn:orders"
+    targetNamespace="urn:orders" elementFormDefault="qualified">
+  <xs:simpleType name="Status">
+    <xs:restriction base="xs:string">
+      <xs:enumeration value="open"/>
+      <xs:enumeration value="closed"/>
+    </xs:restriction>
+  </xs:simpleType>
+  <xs:simpleType name="Code">
+    <xs:restriction base="xs:string">
+      <xs:pattern value="[A-Z]{3}-\d+"/>
+      <xs:maxLength value="10"/>
+    </xs:restriction>
+  </xs:simpleType>
+  <xs:complexType name="Item">
+    <xs:sequence>
+      <xs:element name="code" type="tns:Code"/>
+      <xs:element name="quantity">
+        <xs:simpleType>
+          <xs:restriction base="xs:int">
+            <xs:minInclusive value="1"/>
+            <xs:maxInclusive value="100"/>
+          </xs:restriction>
+        </xs:simpleType>
+      </xs:element>
+    </xs:sequence>
+    <xs:attribute name="gift" type="xs:boolean"/>
+  </xs:complexType>
+  <xs:complexType name="SpecialItem">
+    <xs:complexContent>
+      <xs:extension base="tns:Item">
+        <xs:sequence>
+          <xs:element name="note" type="xs:string" minOccurs="0"/>
+        </xs:sequence>
+      </xs:extension>
+    </xs:complexContent>
+  </xs:complexType>
+  <xs:complexType name="Amount">
+    <xs:simpleContent>
+      <xs:extension base="xs:decimal">
+        <xs:attribute name="currency" type="xs:string" use="required"/>
+      </xs:extension>
+    </xs:simpleContent>
+  </xs:complexType>
+  <xs:element name="CreateOrder">
+    <xs:complexType>
+      <xs:sequence>
+        <xs:element name="id" type="xs:long"/>
+        <xs:choice>
+          <xs:element name="customerId" type="xs:string"/>
+          <xs:element name="guest" type="xs:boolean"/>
+        </xs:choice>
+        <xs:element name="status" type="tns:Status"/>
+        <xs:element name="created" type="xs:dateTime" minOccurs="0"/>
+        <xs:element name="item" type="tns:Item" maxOccurs="unbounded"/>
+        <xs:element name="total" type="tns:Amount"/>
+        <xs:element name="comment" type="xs:string" nillable="true"/>
+        <xs:any namespace="##other" processContents="lax" minOccurs="0"/>
+      </xs:sequence>
+    </xs:complexType>
+  </xs:element>
+</xs:schema>`
+
+func TestValidate(t *testing.T) {
+	type testCase struct {
+		name string
+		doc  string
+		err  string
+	}
+
+	valid := `<o:CreateOrder xmlns:o="urn:orders" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <o:id>42</o:id>
+  <o:customerId>c1</o:customerId>
+  <o:status>open</o:status>
+  <o:created>2024-02-29T10:00:00Z</o:created>
+  <o:item gift="true"><o:code>ABC-1</o:code><o:quantity>5</o:quantity></o:item>
+  <o:item xsi:type="o:SpecialItem"><o:code>XYZ-22</o:code><o:quantity>1</o:quantity><o:note>fragile</o:note></o:item>
+  <o:total currency="EUR">10.50</o:total>
+  <o:comment xsi:nil="true"/>
+  <ext:trace xmlns:ext="urn:ext">anything</ext:trace>
+</o:CreateOrder>`
+
+	cases := []testCase{
+		testCase{name: "valid", doc: valid},
+		testCase{name: "undeclared root", doc: `<o:DeleteOrder xmlns:o="urn:orders"/>`, err: "not declared"},
+		testCase{name: "bad enumeration", doc: strings.Replace(valid, ">open<", ">pending<", 1), err: "enumeration"},
+		testCase{name: "bad pattern", doc: strings.Replace(valid, ">ABC-1<", ">abc-1<", 1), err: "pattern"},
+		testCase{name: "out of range", doc: strings.Replace(valid, "<o:quantity>5<", "<o:quantity>500<", 1), err: "maxInclusive"},
+		testCase{name: "bad long", doc: strings.Replace(valid, ">42<", ">4x2<", 1), err: "valid long"},
+		testCase{name: "bad date", doc: strings.Replace(valid, "2024-02-29", "2023-02-29", 1), err: "dateTime"},
+		testCase{name: "missing element", doc: strings.Replace(valid, "<o:status>open</o:status>", "", 1), err: "unexpected element"},
+		testCase{name: "both choices", doc: strings.Replace(valid, "<o:customerId>c1</o:customerId>", "<o:customerId>c1</o:customerId><o:guest>true</o:guest>", 1), err: "unexpected element"},
+		testCase{name: "unknown element", doc: strings.Replace(valid, "<o:note>fragile</o:note>", "<o:discount>1</o:discount>", 1), err: "unexpected element"},
+		testCase{name: "extension element without xsi:type", doc: strings.Replace(valid, ` xsi:type="o:SpecialItem"`, "", 1), err: "unexpected element"},
+		testCase{name: "missing attribute", doc: strings.Replace(valid, ` currency="EUR"`, "", 1), err: "required attribute"},
+		testCase{name: "unknown attribute", doc: strings.Replace(valid, ` gift="true"`, ` gift="true" vip="1"`, 1), err: "unexpected attribute"},
+		testCase{name: "bad attribute", doc: strings.Replace(valid, ` gift="true"`, ` gift="yes"`, 1), err: "boolean"},
+		testCase{name: "not nillable", doc: strings.Replace(valid, "<o:id>42</o:id>", `<o:id xsi:nil="true"/>`, 1), err: "not nillable"},
+		testCase{name: "unqualified child", doc: strings.Replace(valid, "<o:id>42</o:id>", `<id>42</id>`, 1), err: "unexpected element"},
+		testCase{name: "text in element content", doc: strings.Replace(valid, "<o:id>42</o:id>", `<o:id>42</o:id>text`, 1), err: "unexpected text"},
+		testCase{name: "missing item", doc: strings.Replace(strings.Replace(valid, `<o:item gift="true"><o:code>ABC-1</o:code><o:quantity>5</o:quantity></o:item>`, "", 1),
+			`<o:item xsi:type="o:SpecialItem"><o:code>XYZ-22</o:code><o:quantity>1</o:quantity><o:note>fragile</o:note></o:item>`, "", 1), err: "unexpected element"},
+	}
+
+	schema, err := Parse(strings.NewReader(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSet()
+	if err := s.Add(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cs := range cases {
+		t.Run(cs.name, func(t *testing.T) {
+			doc, err := Parse(strings.NewReader(cs.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = s.Validate(doc)
+			if cs.err == "" && err != nil {
+				t.Errorf("expected document to be valid, got: %v", err)
+			}
+			if cs.err != "" && (err == nil || !strings.Contains(err.Error(), cs.err)) {
+				t.Errorf("expected error containing '%s', got: %v", cs.err, err)
+			}
+		})
+	}
+}
+
+func TestBuiltin(t *testing.T) {
+	type testCase struct {
+		typ   string
+		value string
+		valid bool
+	}
+
+	cases := []testCase{
+		testCase{typ: "int", value: "2147483647", valid: true},
+		testCase{typ: "int", value: "2147483648"},
+		testCase{typ: "unsignedByte", value: "-1"},
+		testCase{typ: "decimal", value: "-1.50", valid: true},
+		testCase{typ: "decimal", value: "1e3"},
+		testCase{typ: "double", value: "1e3", valid: true},
+		testCase{typ: "double", value: "INF", valid: true},
+		testCase{typ: "double", value: "0x10"},
+		testCase{typ: "date", value: "2024-12-31+03:00", valid: true},
+		testCase{typ: "date", value: "2024-13-01"},
+		testCase{typ: "time", value: "23:59:59.5Z", valid: true},
+		testCase{typ: "time", value: "25:00:00"},
+		testCase{typ: "duration", value: "P1DT2H", valid: true},
+		testCase{typ: "duration", value: "P1DT"},
+		testCase{typ: "base64Binary", value: "aGVs bG8=", valid: true},
+		testCase{typ: "base64Binary", value: "a"},
+		testCase{typ: "hexBinary", value: "0aFF", valid: true},
+		testCase{typ: "boolean", value: " true ", valid: true},
+		testCase{typ: "string", value: "anything", valid: true},
+	}
+
+	for _, cs := range cases {
+		err := builtin(cs.typ, cs.value)
+		if cs.valid && err != nil {
+			t.Errorf("expected %s %q to be valid, got: %v", cs.typ, cs.value, err)
+		}
+		if !cs.valid && err == nil {
+			t.Errorf("expected %s %q to be invalid", cs.typ, cs.value)
+		}
+	}
+}